@@ -9,11 +9,14 @@
 //   - depset() - for creating depsets
 //   - Label() - for creating labels
 //   - attr module - for defining rule attributes
+//   - module_extension() and tag_class() - for defining module extensions
+//   - visibility() - for restricting who can load the .bzl file
 //
 // Reference: bazel/src/main/java/com/google/devtools/build/lib/analysis/starlark/StarlarkGlobalsImpl.java
 package builtins
 
 import (
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
 	"github.com/albertocavalcante/starlark-go-bazel/types"
 	"go.starlark.net/starlark"
 )
@@ -33,6 +36,13 @@ func Predeclared() starlark.StringDict {
 		"depset": starlark.NewBuiltin("depset", DepsetBuiltin),
 		"Label":  starlark.NewBuiltin("Label", types.LabelBuiltin),
 
+		// Module extensions
+		"module_extension": starlark.NewBuiltin("module_extension", ModuleExtensionBuiltin),
+		"tag_class":        starlark.NewBuiltin("tag_class", TagClassBuiltin),
+
+		// Load visibility
+		"visibility": starlark.NewBuiltin("visibility", loader.VisibilityBuiltin),
+
 		// Modules
 		"attr": AttrModule(),
 	}
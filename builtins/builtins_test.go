@@ -19,6 +19,8 @@ func TestPredeclared(t *testing.T) {
 		"depset",
 		"Label",
 		"attr",
+		"module_extension",
+		"tag_class",
 	}
 
 	for _, name := range expectedBuiltins {
@@ -297,3 +299,44 @@ my_aspect = aspect(
 		t.Errorf("aspect attr_aspects = %v, want [deps]", a.AttrAspects())
 	}
 }
+
+// TestModuleExtension verifies module_extension() and tag_class().
+func TestModuleExtension(t *testing.T) {
+	thread := &starlark.Thread{Name: "test"}
+	code := `
+def _impl(ctx):
+    pass
+
+install = tag_class(attrs = {"artifacts": attr.string_list(mandatory = True)}, doc = "Installs.")
+maven = module_extension(
+    implementation = _impl,
+    tag_classes = {"install": install, "override": tag_class()},
+    environ = ["JAVA_HOME"],
+    os_dependent = True,
+)
+`
+	globals, err := starlark.ExecFile(thread, "ext.bzl", code, Predeclared())
+	if err != nil {
+		t.Fatalf("ExecFile failed: %v", err)
+	}
+
+	ext, ok := globals["maven"].(*ModuleExtension)
+	if !ok {
+		t.Fatalf("expected *ModuleExtension, got %T", globals["maven"])
+	}
+	if names := ext.TagClassNames(); len(names) != 2 || names[0] != "install" || names[1] != "override" {
+		t.Errorf("TagClassNames() = %v", names)
+	}
+	if !ext.IsOSDependent() || ext.IsArchDependent() || len(ext.Environ()) != 1 {
+		t.Errorf("unexpected extension flags: %+v", ext)
+	}
+	install := ext.TagClasses()["install"]
+	if install.Doc() != "Installs." || !install.Attrs()["artifacts"].IsMandatory() {
+		t.Errorf("unexpected tag class: %+v", install)
+	}
+
+	_, err = starlark.ExecFile(thread, "bad.bzl", `tag_class(attrs = {"x": 1})`, Predeclared())
+	if err == nil {
+		t.Error("expected error for non-attr tag class attribute")
+	}
+}
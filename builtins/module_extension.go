@@ -0,0 +1,242 @@
+package builtins
+
+import (
+	"fmt"
+	"sort"
+
+	"go.starlark.net/starlark"
+)
+
+// AttrSchema is an attribute definition as created by the attr module. It
+// is implemented by *AttrDescriptor and by the attributes of the minimal
+// attr module used by the eval package.
+type AttrSchema interface {
+	starlark.Value
+	AttrType() string
+	DefaultValue() starlark.Value
+	IsMandatory() bool
+}
+
+var _ AttrSchema = (*AttrDescriptor)(nil)
+
+// AttrTypeDefault returns the implicit default of an attribute type, used
+// when an attribute has no explicit default.
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/Type.java getDefaultValue()
+func AttrTypeDefault(attrType string) starlark.Value {
+	switch attrType {
+	case "string":
+		return starlark.String("")
+	case "int":
+		return starlark.MakeInt(0)
+	case "bool":
+		return starlark.False
+	case "string_list", "label_list", "int_list", "output_list":
+		return starlark.NewList(nil)
+	case "string_dict", "string_list_dict", "label_keyed_string_dict":
+		return starlark.NewDict(0)
+	}
+	return starlark.None
+}
+
+// TagClass is the schema of a module extension tag, created by tag_class().
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/TagClass.java
+type TagClass struct {
+	attrs  map[string]AttrSchema
+	doc    string
+	frozen bool
+}
+
+var _ starlark.Value = (*TagClass)(nil)
+
+// String returns the Starlark representation.
+func (t *TagClass) String() string { return "<tag_class>" }
+
+// Type returns "tag_class".
+func (t *TagClass) Type() string { return "tag_class" }
+
+// Freeze marks the tag class as frozen.
+func (t *TagClass) Freeze() { t.frozen = true }
+
+// Truth returns true.
+func (t *TagClass) Truth() starlark.Bool { return true }
+
+// Hash returns an error (tag classes are not hashable).
+func (t *TagClass) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: tag_class")
+}
+
+// Attrs returns the tag class's attribute schemas.
+func (t *TagClass) Attrs() map[string]AttrSchema { return t.attrs }
+
+// Doc returns the documentation string.
+func (t *TagClass) Doc() string { return t.doc }
+
+// TagClassBuiltin is the Starlark tag_class() builtin function.
+//
+// Signature:
+//
+//	tag_class(attrs = {}, *, doc = None)
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleExtensionGlobals.java
+func TagClassBuiltin(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		attrs *starlark.Dict
+		doc   starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "attrs?", &attrs, "doc?", &doc); err != nil {
+		return nil, err
+	}
+
+	t := &TagClass{attrs: make(map[string]AttrSchema)}
+	if s, ok := doc.(starlark.String); ok {
+		t.doc = string(s)
+	}
+	if attrs != nil {
+		for _, item := range attrs.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("tag_class: attrs keys must be strings, got %s", item[0].Type())
+			}
+			name := string(key)
+			if !isValidAttrName(name) {
+				return nil, fmt.Errorf("tag_class: attribute name %q is not a valid identifier", name)
+			}
+			schema, ok := item[1].(AttrSchema)
+			if !ok {
+				return nil, fmt.Errorf("tag_class: attrs values must be attr objects, got %s for %q", item[1].Type(), name)
+			}
+			t.attrs[name] = schema
+		}
+	}
+	return t, nil
+}
+
+// ModuleExtension is a module extension created by module_extension().
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleExtension.java
+type ModuleExtension struct {
+	implementation starlark.Callable
+	tagClasses     map[string]*TagClass
+	doc            string
+	environ        []string
+	osDependent    bool
+	archDependent  bool
+	frozen         bool
+}
+
+var _ starlark.Value = (*ModuleExtension)(nil)
+
+// String returns the Starlark representation.
+func (e *ModuleExtension) String() string { return "<module_extension>" }
+
+// Type returns "module_extension".
+func (e *ModuleExtension) Type() string { return "module_extension" }
+
+// Freeze marks the extension as frozen.
+func (e *ModuleExtension) Freeze() { e.frozen = true }
+
+// Truth returns true.
+func (e *ModuleExtension) Truth() starlark.Bool { return true }
+
+// Hash returns an error (module extensions are not hashable).
+func (e *ModuleExtension) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: module_extension")
+}
+
+// Implementation returns the extension's implementation function.
+func (e *ModuleExtension) Implementation() starlark.Callable { return e.implementation }
+
+// TagClasses returns the extension's tag classes keyed by name.
+func (e *ModuleExtension) TagClasses() map[string]*TagClass { return e.tagClasses }
+
+// TagClassNames returns the sorted tag class names.
+func (e *ModuleExtension) TagClassNames() []string {
+	names := make([]string, 0, len(e.tagClasses))
+	for name := range e.tagClasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Doc returns the documentation string.
+func (e *ModuleExtension) Doc() string { return e.doc }
+
+// Environ returns the environment variables the extension depends on.
+func (e *ModuleExtension) Environ() []string { return e.environ }
+
+// IsOSDependent reports whether the extension's result depends on the host OS.
+func (e *ModuleExtension) IsOSDependent() bool { return e.osDependent }
+
+// IsArchDependent reports whether the extension's result depends on the host architecture.
+func (e *ModuleExtension) IsArchDependent() bool { return e.archDependent }
+
+// ModuleExtensionBuiltin is the Starlark module_extension() builtin function.
+//
+// Signature:
+//
+//	module_extension(
+//	    implementation,
+//	    *,
+//	    tag_classes = {},
+//	    doc = None,
+//	    environ = [],
+//	    os_dependent = False,
+//	    arch_dependent = False,
+//	)
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleExtensionGlobals.java
+func ModuleExtensionBuiltin(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		implementation starlark.Callable
+		tagClasses     *starlark.Dict
+		doc            starlark.Value = starlark.None
+		environ        *starlark.List
+		osDependent    bool
+		archDependent  bool
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"implementation", &implementation,
+		"tag_classes?", &tagClasses,
+		"doc?", &doc,
+		"environ?", &environ,
+		"os_dependent?", &osDependent,
+		"arch_dependent?", &archDependent,
+	); err != nil {
+		return nil, err
+	}
+
+	e := &ModuleExtension{
+		implementation: implementation,
+		tagClasses:     make(map[string]*TagClass),
+		osDependent:    osDependent,
+		archDependent:  archDependent,
+	}
+	if s, ok := doc.(starlark.String); ok {
+		e.doc = string(s)
+	}
+	if tagClasses != nil {
+		for _, item := range tagClasses.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("module_extension: tag_classes keys must be strings, got %s", item[0].Type())
+			}
+			tc, ok := item[1].(*TagClass)
+			if !ok {
+				return nil, fmt.Errorf("module_extension: tag_classes values must be tag_class objects, got %s for %q", item[1].Type(), string(key))
+			}
+			e.tagClasses[string(key)] = tc
+		}
+	}
+	if environ != nil {
+		for i := 0; i < environ.Len(); i++ {
+			s, ok := environ.Index(i).(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("module_extension: environ must be strings, got %s", environ.Index(i).Type())
+			}
+			e.environ = append(e.environ, string(s))
+		}
+	}
+	return e, nil
+}
@@ -24,6 +24,12 @@ var (
 	_ starlark.HasBinary = (*SelectorValue)(nil)
 )
 
+// NewSelectorValue creates a selector from its conditions, keyed by
+// condition label, and custom no-match error.
+func NewSelectorValue(conditions map[string]starlark.Value, noMatchError string) *SelectorValue {
+	return &SelectorValue{conditions: conditions, noMatchError: noMatchError}
+}
+
 // String returns the Starlark representation.
 func (s *SelectorValue) String() string {
 	var sb strings.Builder
@@ -97,6 +103,12 @@ var (
 	_ starlark.HasBinary = (*SelectorList)(nil)
 )
 
+// NewSelectorList creates the concatenation of elements, which are plain
+// values and *SelectorValue.
+func NewSelectorList(elements []starlark.Value) *SelectorList {
+	return &SelectorList{elements: elements}
+}
+
 // String returns the Starlark representation.
 func (sl *SelectorList) String() string {
 	if len(sl.elements) == 0 {
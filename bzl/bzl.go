@@ -3,7 +3,9 @@ package bzl
 
 import (
 	"strings"
+	"sync"
 
+	"github.com/albertocavalcante/starlark-go-bazel/bzlmod"
 	"github.com/albertocavalcante/starlark-go-bazel/eval"
 	"github.com/albertocavalcante/starlark-go-bazel/loader"
 	"github.com/albertocavalcante/starlark-go-bazel/types"
@@ -12,9 +14,13 @@ import (
 
 // Interpreter is the main entry point for evaluating Bazel Starlark.
 type Interpreter struct {
+	// mu serializes evaluations with the invalidations made by Watch.
+	mu        sync.Mutex
 	evaluator *eval.Evaluator
 	options   Options
 	fsLoader  *loader.FileSystemLoader
+	bzlLoader *loader.BzlFileLoader
+	locator   *loader.PackageLocator
 }
 
 // New creates a new Interpreter with the given options.
@@ -24,24 +30,45 @@ func New(opts Options) *Interpreter {
 	}
 
 	fsLoader := loader.NewFileSystemLoader(opts.FileSystem)
+	programs := loader.NewProgramCache(opts.ProgramCacheDir)
+	if opts.ShareCompiledPrograms {
+		programs = loader.SharedProgramCache(opts.ProgramCacheDir)
+	}
+	locator := loader.NewPackageLocator(opts.FileSystem, opts.WorkspaceRoot, opts.PackagePath, opts.DeletedPackages)
 
-	// Create a BzlFileLoader for loading .bzl files
+	// Create a BzlFileLoader for loading .bzl files. Repository rules can be
+	// defined, but only called from module extensions.
+	predeclared := eval.BzlPredeclared()
+	predeclared["repository_rule"] = starlark.NewBuiltin("repository_rule", bzlmod.RepositoryRuleBuiltin)
 	bzlLoader := loader.NewBzlFileLoader(
 		opts.FileSystem,
 		opts.WorkspaceRoot,
-		loader.WithRepoMapping(opts.ExternalRepos),
+		append(repoOptions(opts),
+			loader.WithPredeclared(predeclared),
+			loader.WithBuiltinModule(bzlmod.LocalRepoRulesLabel, bzlmod.LocalRepoRules()),
+			loader.WithProgramCache(programs),
+			loader.WithPackageLocator(locator),
+		)...,
 	)
 
 	evalOpts := eval.Options{
 		BzlLoader:    bzlLoader,
 		FileLoader:   fsLoader,
 		PrintHandler: opts.PrintHandler,
+
+		DisableBuildSyntaxCheck: opts.DisableBuildSyntaxCheck,
+		PackageCacheDir:         opts.PackageCacheDir,
+		WorkspaceRoot:           opts.WorkspaceRoot,
+		PackageLocator:          locator,
+		ProgramCache:            programs,
 	}
 
 	return &Interpreter{
 		evaluator: eval.New(evalOpts),
 		options:   opts,
 		fsLoader:  fsLoader,
+		bzlLoader: bzlLoader,
+		locator:   locator,
 	}
 }
 
@@ -51,10 +78,17 @@ type Result struct {
 	Globals starlark.StringDict
 	// Targets contains declared targets (for BUILD files)
 	Targets map[string]*types.RuleInstance
+	// Files contains the file targets of BUILD files, keyed by name
+	Files map[string]*eval.FileTarget
+	// Module describes the evaluated .bzl file: its label, direct loads,
+	// repo mapping and transitive digest
+	Module *eval.BzlModuleContext
 }
 
 // EvalFile evaluates a Starlark file (auto-detects .bzl vs BUILD).
 func (i *Interpreter) EvalFile(path string) (*Result, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	if i.isBuildFile(path) {
 		buildResult, err := i.evaluator.EvalBuildFile(path)
 		if err != nil {
@@ -63,6 +97,7 @@ func (i *Interpreter) EvalFile(path string) (*Result, error) {
 		return &Result{
 			Globals: buildResult.Globals,
 			Targets: buildResult.Targets,
+			Files:   buildResult.Files,
 		}, nil
 	}
 
@@ -73,11 +108,14 @@ func (i *Interpreter) EvalFile(path string) (*Result, error) {
 	return &Result{
 		Globals: bzlResult.Globals,
 		Targets: nil,
+		Module:  bzlResult.Module,
 	}, nil
 }
 
 // Eval evaluates Starlark source code.
 func (i *Interpreter) Eval(filename string, source []byte) (*Result, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	if i.isBuildFile(filename) {
 		buildResult, err := i.evaluator.EvalBuild(filename, source)
 		if err != nil {
@@ -86,6 +124,7 @@ func (i *Interpreter) Eval(filename string, source []byte) (*Result, error) {
 		return &Result{
 			Globals: buildResult.Globals,
 			Targets: buildResult.Targets,
+			Files:   buildResult.Files,
 		}, nil
 	}
 
@@ -96,9 +135,67 @@ func (i *Interpreter) Eval(filename string, source []byte) (*Result, error) {
 	return &Result{
 		Globals: bzlResult.Globals,
 		Targets: nil,
+		Module:  bzlResult.Module,
 	}, nil
 }
 
+// repoOptions configures the loader's repositories: those of the module
+// graph, each with its own repo mapping, and the external repos, which are
+// also made visible from the main repository and take precedence.
+func repoOptions(opts Options) []loader.BzlFileLoaderOption {
+	external := externalRepos(opts)
+	g := opts.ModuleGraph
+	if g == nil {
+		return []loader.BzlFileLoaderOption{loader.WithRepoMapping(external)}
+	}
+	mappings := g.RepoMappings()
+	for name := range external {
+		mappings[""][name] = name
+	}
+	return []loader.BzlFileLoaderOption{
+		loader.WithRepoRoots(g.RepoRoots()),
+		loader.WithRepoMappings(mappings),
+		loader.WithRepoMapping(external),
+	}
+}
+
+// externalRepos returns opts.ExternalRepos plus the repositories of
+// opts.Workspace.
+func externalRepos(opts Options) map[string]string {
+	ws := opts.Workspace
+	if ws == nil {
+		return opts.ExternalRepos
+	}
+	repos := make(map[string]string, len(ws.RepoRoots)+len(opts.ExternalRepos)+1)
+	for name, root := range ws.RepoRoots {
+		repos[name] = root
+	}
+	if ws.Name != "" {
+		repos[ws.Name] = opts.WorkspaceRoot
+	}
+	for name, root := range opts.ExternalRepos {
+		repos[name] = root
+	}
+	return repos
+}
+
+// Module returns the metadata of a module loaded during evaluation, keyed
+// by canonical label (e.g. "//pkg:defs.bzl" or "@@repo+//pkg:defs.bzl").
+func (i *Interpreter) Module(label string) (*eval.BzlModuleContext, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.evaluator.Module(label)
+}
+
+// Invalidate evicts the cached modules and packages that depend on any of
+// changedPaths, which were modified, created or deleted since they were
+// evaluated. Only those are evaluated again, on their next use.
+func (i *Interpreter) Invalidate(changedPaths []string) *eval.Invalidation {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.evaluator.Invalidate(changedPaths)
+}
+
 // Options returns the interpreter's options.
 func (i *Interpreter) Options() Options {
 	return i.options
@@ -1,11 +1,50 @@
 package bzl
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/albertocavalcante/starlark-go-bazel/bzlmod"
+	"github.com/albertocavalcante/starlark-go-bazel/eval"
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
 	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
 )
 
+// newTestWorkspace returns a file system holding files, keyed by path
+// relative to the workspace root /ws.
+func newTestWorkspace(files map[string]string) *loader.MemoryFileSystem {
+	fs := loader.NewMemoryFileSystem()
+	for name, content := range files {
+		fs.AddFile("/ws/"+name, []byte(content))
+	}
+	return fs
+}
+
+// ruleDef returns a .bzl statement defining a rule with an empty
+// implementation and the given attributes, such as
+// `"srcs": attr.label_list()`.
+func ruleDef(name string, attrs ...string) string {
+	return fmt.Sprintf("%s = rule(\n    implementation = lambda ctx: [],\n    attrs = {%s},\n)\n", name, strings.Join(attrs, ", "))
+}
+
+// writeFiles writes files, keyed by slash separated path, under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestBasicEval(t *testing.T) {
 	interp := New(Options{})
 	result, err := interp.Eval("test.bzl", []byte(`
@@ -172,3 +211,196 @@ d = depset(["a", "b", "c"])
 		t.Errorf("expected 3 elements, got %d", len(list))
 	}
 }
+
+func TestModuleGraphRepos(t *testing.T) {
+	fs := loader.NewMemoryFileSystem()
+	for name, module := range map[string]string{
+		"rules_foo": `module(name = "rules_foo", version = "1.0")
+bazel_dep(name = "rules_bar", version = "1.0", repo_name = "bar")`,
+		"rules_bar": `module(name = "rules_bar", version = "1.0")`,
+	} {
+		fs.AddFile("/registry/modules/"+name+"/1.0/MODULE.bazel", []byte(module))
+		fs.AddFile("/registry/modules/"+name+"/1.0/source.json", []byte(`{"type": "local_path", "path": "`+name+`"}`))
+	}
+	fs.AddFile("/registry/rules_foo/defs.bzl", []byte(`
+load("@bar//:bar.bzl", "BAR")
+FOO = "foo" + BAR
+FOO_LABEL = Label("//pkg:x")
+BAR_LABEL = Label("@bar")
+`))
+	fs.AddFile("/registry/rules_bar/bar.bzl", []byte(`BAR = "bar"`))
+	fs.AddFile("/ws/MODULE.bazel", []byte(`bazel_dep(name = "rules_foo", version = "1.0", repo_name = "foo")`))
+
+	graph, err := bzlmod.Resolve("/ws", bzlmod.ResolveOptions{
+		FileSystem: fs,
+		Registries: []bzlmod.Registry{bzlmod.NewLocalRegistry(fs, "/registry")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interp := New(Options{WorkspaceRoot: "/ws", FileSystem: fs, ModuleGraph: graph})
+	result, err := interp.Eval("test.bzl", []byte(`
+load("@foo//:defs.bzl", "FOO", "FOO_LABEL", "BAR_LABEL")
+value = FOO
+foo = str(FOO_LABEL)
+bar = str(BAR_LABEL)
+repo = FOO_LABEL.repo_name
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"value": "foobar",
+		"foo":   "@@rules_foo+//pkg:x",
+		"bar":   "@@rules_bar+//:bar",
+		"repo":  "rules_foo+",
+	}
+	for name, want := range expected {
+		if got := result.Globals[name]; got != starlark.String(want) {
+			t.Errorf("expected %s = %q, got %v", name, want, got)
+		}
+	}
+
+	// rules_bar is not visible from the main repository.
+	_, err = interp.Eval("other.bzl", []byte(`load("@bar//:bar.bzl", "BAR")`))
+	if err == nil || !strings.Contains(err.Error(), "No repository visible as '@bar' from main repository") {
+		t.Errorf("expected visibility error, got %v", err)
+	}
+	if _, err := interp.Eval("canonical.bzl", []byte(`load("@@rules_bar+//:bar.bzl", "BAR")`)); err != nil {
+		t.Errorf("expected canonical label to load, got %v", err)
+	}
+}
+
+func TestLabelMethods(t *testing.T) {
+	interp := New(Options{})
+	result, err := interp.Eval("pkg/test.bzl", []byte(`
+l = Label("@repo//pkg/sub:target")
+same = str(l.same_package_label("other"))
+rel = str(l.relative(":sibling"))
+short = str(Label("@repo"))
+relative_to_file = str(Label(":local"))
+root = l.workspace_root
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"same":             "@repo//pkg/sub:other",
+		"rel":              "@repo//pkg/sub:sibling",
+		"short":            "@repo//:repo",
+		"relative_to_file": "//pkg:local",
+		"root":             "external/repo",
+	}
+	for name, want := range expected {
+		if got := result.Globals[name]; got != starlark.String(want) {
+			t.Errorf("expected %s = %q, got %v", name, want, got)
+		}
+	}
+
+	label, _ := types.ParseLabel("@@rules_foo+//pkg:pkg")
+	mapping := types.NewRepoMapping("", map[string]string{"foo": "rules_foo+"})
+	if got := label.DisplayForm(mapping); got != "@foo//pkg:pkg" {
+		t.Errorf("expected display form @foo//pkg:pkg, got %s", got)
+	}
+	if got := label.ShorthandDisplayForm(mapping); got != "@foo//pkg" {
+		t.Errorf("expected shorthand display form @foo//pkg, got %s", got)
+	}
+	if got := label.DisplayForm(types.NewRepoMapping("", nil)); got != "@@rules_foo+//pkg:pkg" {
+		t.Errorf("expected canonical display form, got %s", got)
+	}
+}
+
+func TestWorkspaceRepos(t *testing.T) {
+	ws := t.TempDir()
+	for name, content := range map[string]string{
+		"WORKSPACE":                "workspace(name = \"app\")\nlocal_repository(name = \"foo\", path = \"third_party/foo\")",
+		"third_party/foo/defs.bzl": `FOO = "foo"`,
+		"third_party/foo/BUILD":    "",
+		"lib.bzl":                  `LIB = "lib"`,
+	} {
+		p := filepath.Join(ws, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	workspace, err := bzlmod.EvalWorkspace(bzlmod.WorkspaceOptions{WorkspaceRoot: ws, OutputBase: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interp := New(Options{WorkspaceRoot: ws, Workspace: workspace})
+	result, err := interp.Eval("test.bzl", []byte(`
+load("@foo//:defs.bzl", "FOO")
+load("@app//:lib.bzl", "LIB")
+value = FOO + LIB
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Globals["value"]; got != starlark.String("foolib") {
+		t.Errorf("expected value = \"foolib\", got %v", got)
+	}
+}
+
+func TestModuleMetadata(t *testing.T) {
+	newInterp := func(b string) *Interpreter {
+		fs := loader.NewMemoryFileSystem()
+		fs.AddFile("/ws/lib/a.bzl", []byte(`load(":b.bzl", "B")
+A = B`))
+		fs.AddFile("/ws/lib/b.bzl", []byte(b))
+		return New(Options{WorkspaceRoot: "/ws", FileSystem: fs})
+	}
+	source := []byte(`load("//lib:a.bzl", "A")
+load("//lib:b.bzl", "B")
+X = A + B`)
+
+	interp := newInterp(`B = 1`)
+	result, err := interp.Eval("app/defs.bzl", source)
+	if err != nil {
+		t.Fatalf("Eval failed: %v", err)
+	}
+	m := result.Module
+	if m.Label != "//app:defs.bzl" {
+		t.Errorf("Label = %q, want //app:defs.bzl", m.Label)
+	}
+	if got := strings.Join(m.Loads, " "); got != "//lib:a.bzl //lib:b.bzl" {
+		t.Errorf("Loads = %q", got)
+	}
+	if len(m.TransitiveDigest) != 32 {
+		t.Errorf("TransitiveDigest has %d bytes, want 32", len(m.TransitiveDigest))
+	}
+	a, ok := interp.Module("//lib:a.bzl")
+	if !ok {
+		t.Fatal("//lib:a.bzl not loaded")
+	}
+	if got := strings.Join(a.Loads, " "); got != "//lib:b.bzl" {
+		t.Errorf("a.bzl Loads = %q", got)
+	}
+
+	// Digests only change with the sources of the module or its loads.
+	again, _ := newInterp(`B = 1`).Eval("app/defs.bzl", source)
+	changed, _ := newInterp(`B = 2`).Eval("app/defs.bzl", source)
+	if string(again.Module.TransitiveDigest) != string(m.TransitiveDigest) {
+		t.Error("digest differs for identical sources")
+	}
+	if string(changed.Module.TransitiveDigest) == string(m.TransitiveDigest) {
+		t.Error("digest unchanged after a transitive load changed")
+	}
+	if _, ok := newInterp(`B = 1`).Module("//lib:a.bzl"); ok {
+		t.Error("module reported before being loaded")
+	}
+
+	loads, err := eval.ExtractLoads([]byte(`load("//pkg:foo.bzl", "a", b = "c")
+load("//pkg:foo.bzl", "d")
+load(":bar.bzl", "e")`))
+	if err != nil {
+		t.Fatalf("ExtractLoads failed: %v", err)
+	}
+	if got := strings.Join(loads["//pkg:foo.bzl"], " "); got != "a c d" || len(loads) != 2 {
+		t.Errorf("ExtractLoads = %v", loads)
+	}
+}
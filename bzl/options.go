@@ -1,19 +1,69 @@
 // Package bzl provides the main user-facing API for evaluating Bazel Starlark files.
 package bzl
 
-import "github.com/albertocavalcante/starlark-go-bazel/loader"
+import (
+	"github.com/albertocavalcante/starlark-go-bazel/bzlmod"
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+)
 
 // Options configures the interpreter.
 type Options struct {
-	// WorkspaceRoot is the root of the Bazel workspace.
+	// WorkspaceRoot is the root of the Bazel workspace. Use
+	// loader.FindWorkspaceRoot to discover it from any path inside.
 	WorkspaceRoot string
 
+	// PackagePath lists the directories where packages are looked up, in
+	// order, like Bazel's --package_path. Entries may use "%workspace%" for
+	// WorkspaceRoot. The default is WorkspaceRoot alone.
+	PackagePath []string
+
+	// DeletedPackages are packages treated as if they did not exist, like
+	// Bazel's --deleted_packages: their BUILD files are ignored and their
+	// files belong to the enclosing package.
+	DeletedPackages []string
+
 	// FileSystem for loading files (default: OS filesystem).
 	FileSystem loader.FileSystem
 
 	// ExternalRepos maps repository names to paths.
 	ExternalRepos map[string]string
 
+	// ModuleGraph is the resolved MODULE.bazel dependency graph. Its locally
+	// available repos are loadable, each resolving apparent names through its
+	// own repo mapping. ExternalRepos entries take precedence.
+	ModuleGraph *bzlmod.ModuleGraph
+
+	// Workspace is the evaluated WORKSPACE file. Its repositories, and the
+	// main repository under the workspace name, are added to ExternalRepos
+	// unless already present there.
+	Workspace *bzlmod.Workspace
+
 	// PrintHandler handles print() output.
 	PrintHandler func(msg string)
+
+	// DisableBuildSyntaxCheck evaluates BUILD files with full Starlark,
+	// allowing def and for statements and *args/**kwargs, which Bazel
+	// rejects in BUILD files.
+	DisableBuildSyntaxCheck bool
+
+	// PackageCacheDir, if set, is a directory where evaluated packages are
+	// stored, keyed by the digests of their BUILD files and loaded modules,
+	// so that unchanged packages are not evaluated again by later processes.
+	PackageCacheDir string
+
+	// ProgramCacheDir, if set, is a directory where the compiled programs of
+	// .bzl files are stored, so that later processes do not compile them
+	// again.
+	ProgramCacheDir string
+
+	// ShareCompiledPrograms makes the interpreter share compiled programs in
+	// memory with the other interpreters of the process that set it, through
+	// loader.SharedProgramCache. By default each interpreter has its own.
+	ShareCompiledPrograms bool
+
+	// NoImplicitFileExport makes source files not declared by exports_files
+	// private to their package when checking visibility, like Bazel's
+	// --incompatible_no_implicit_file_export. By default they have their
+	// package's default visibility, or are public.
+	NoImplicitFileExport bool
 }
@@ -0,0 +1,82 @@
+package bzl
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/albertocavalcante/starlark-go-bazel/eval"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+)
+
+// Target is a target of a package: a rule, or an input or output file.
+type Target struct {
+	// Label is the target's canonical label.
+	Label *types.Label
+
+	// Rule is set for rule targets.
+	Rule *types.RuleInstance
+
+	// File is set for file targets.
+	File *eval.FileTarget
+}
+
+// Kind returns the target kind as printed by `bazel query --output
+// label_kind`: "<rule class> rule", "source file" or "generated file".
+func (t *Target) Kind() string {
+	if t.Rule != nil {
+		return t.Rule.TargetKind()
+	}
+	return t.File.TargetKind()
+}
+
+// Artifact returns the file of a file target as seen by rule
+// implementations, or nil for rules.
+func (t *Target) Artifact() *types.File {
+	if t.File == nil {
+		return nil
+	}
+	return t.File.Artifact()
+}
+
+// Target resolves a label of the main repository to the rule or file it
+// names, evaluating its package. Labels of files that exist but are neither
+// exported nor referenced by a rule of the package name no target.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/Package.java getTarget()
+func (i *Interpreter) Target(label string) (*Target, error) {
+	l, err := types.ParseLabelInContext(label, types.LabelContext{RepoMapping: i.bzlLoader.RepoMapping("")})
+	if err != nil {
+		return nil, err
+	}
+	if l.Repo() != "" {
+		return nil, fmt.Errorf("no such target '%s': only targets of the main repository can be resolved", l)
+	}
+	if i.locator.IsDeleted(l.Pkg()) {
+		return nil, fmt.Errorf("no such package '%s': Package is considered deleted due to --deleted_packages", l.Pkg())
+	}
+	buildFile, ok := i.locator.BuildFile(l.Pkg())
+	if !ok {
+		return nil, fmt.Errorf("no such package '%s': BUILD file not found in any of the following directories. Add a BUILD file to a directory to mark it as a package.", l.Pkg())
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	result, err := i.evaluator.EvalBuildFile(buildFile)
+	if err != nil {
+		return nil, err
+	}
+	if rule, ok := result.Targets[l.Name()]; ok {
+		return &Target{Label: l, Rule: rule}, nil
+	}
+	if f, ok := result.Files[l.Name()]; ok {
+		return &Target{Label: l, File: f}, nil
+	}
+
+	msg := fmt.Sprintf("no such target '%s': target '%s' not declared in package '%s'", l, l.Name(), l.Pkg())
+	root, _ := i.locator.PackageRoot(l.Pkg())
+	fsys := i.options.FileSystem
+	if info, err := fsys.Stat(fsys.Join(root, l.Pkg(), l.Name())); err == nil && !info.IsDir() {
+		msg += fmt.Sprintf("; however, a source file of this name exists. (Perhaps add 'exports_files([\"%s\"])' to %s/BUILD?)", l.Name(), l.Pkg())
+	}
+	return nil, errors.New(msg)
+}
@@ -0,0 +1,367 @@
+package bzl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+
+	"github.com/albertocavalcante/starlark-go-bazel/builtins"
+	"github.com/albertocavalcante/starlark-go-bazel/bzlmod"
+	"github.com/albertocavalcante/starlark-go-bazel/eval"
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// TargetHashes maps the canonical labels of a workspace's targets to
+// hex-encoded hashes of everything that can affect them.
+type TargetHashes map[string]string
+
+// TargetHashes evaluates every package of the workspace and hashes its
+// targets. A target's hash covers its rule class and the transitive digest of
+// the .bzl file that exported it, its attribute values with labels in
+// canonical form, the contents of the source files it references and the
+// hashes of the targets it depends on, so that a change to any of them
+// changes the hash of the target and of every target depending on it.
+//
+// Dependencies whose content cannot be hashed, such as targets of local
+// repositories, are hashed by their label only (see depHash).
+//
+// Packages are found along the package path, skipping deleted packages. The
+// interpreter's FileSystem must implement loader.DirFileSystem.
+func (i *Interpreter) TargetHashes() (TargetHashes, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	pkgs, err := i.locator.Packages()
+	if err != nil {
+		return nil, fmt.Errorf("hashing targets: %w", err)
+	}
+
+	h := &targetHasher{
+		fsys:     i.options.FileSystem,
+		mapping:  i.bzlLoader.RepoMapping(""),
+		dirs:     make(map[string]string),
+		targets:  make(map[string]*types.RuleInstance),
+		outputs:  make(map[string]string),
+		repos:    versionedRepos(i.options.ModuleGraph),
+		hashes:   make(map[string][]byte),
+		visiting: make(map[string]bool),
+	}
+	for _, pkg := range pkgs {
+		path, _ := i.locator.BuildFile(pkg)
+		result, err := i.evaluator.EvalBuildFile(path)
+		if err != nil {
+			return nil, err
+		}
+		h.dirs[result.Package] = filepath.Dir(path)
+		h.add(result)
+	}
+
+	hashes := make(TargetHashes, len(h.targets))
+	for label := range h.targets {
+		sum, err := h.hash(label)
+		if err != nil {
+			return nil, err
+		}
+		hashes[label] = hex.EncodeToString(sum)
+	}
+	return hashes, nil
+}
+
+// ChangedTargets invalidates changedPaths, which were modified, created or
+// deleted since before was computed, hashes the workspace again and returns
+// the targets affected by the changes along with the new hashes.
+func (i *Interpreter) ChangedTargets(before TargetHashes, changedPaths []string) ([]string, TargetHashes, error) {
+	i.Invalidate(changedPaths)
+	after, err := i.TargetHashes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return AffectedTargets(before, after), after, nil
+}
+
+// AffectedTargets returns the sorted labels of the targets that were added,
+// removed or changed between two snapshots of a workspace. Since hashes
+// include those of dependencies, the reverse dependencies of a changed target
+// are affected too.
+func AffectedTargets(before, after TargetHashes) []string {
+	var affected []string
+	for label, hash := range after {
+		if before[label] != hash {
+			affected = append(affected, label)
+		}
+	}
+	for label := range before {
+		if _, ok := after[label]; !ok {
+			affected = append(affected, label)
+		}
+	}
+	sort.Strings(affected)
+	return affected
+}
+
+// targetHasher computes target hashes over the packages of a workspace.
+type targetHasher struct {
+	fsys    loader.FileSystem
+	mapping *types.RepoMapping
+
+	dirs    map[string]string              // package -> directory
+	targets map[string]*types.RuleInstance // label -> target
+	outputs map[string]string              // output file label -> generating target
+
+	repos map[string]string // canonical repo -> version
+
+	hashes   map[string][]byte
+	visiting map[string]bool
+}
+
+// labelRole tells how a string in an attribute value is hashed.
+type labelRole int
+
+const (
+	notLabel labelRole = iota
+	depLabel
+	outputLabel
+)
+
+func attrRole(attr *types.AttrDescriptor) labelRole {
+	if attr == nil {
+		return notLabel
+	}
+	switch attr.Type {
+	case types.AttrTypeLabel, types.AttrTypeLabelList:
+		return depLabel
+	case types.AttrTypeOutput, types.AttrTypeOutputList:
+		return outputLabel
+	}
+	return notLabel
+}
+
+// add registers the targets of an evaluated package and the rules
+// generating its output files.
+func (h *targetHasher) add(result *eval.BuildResult) {
+	for _, target := range result.Targets {
+		h.targets[ruleLabel(result.Package, target)] = target
+	}
+	for _, f := range result.Files {
+		if f.Kind == eval.OutputFile {
+			h.outputs[f.Label.String()] = types.NewLabel(f.Label.Repo(), f.Label.Pkg(), f.Generator).String()
+		}
+	}
+}
+
+// ruleLabel returns the label of a rule of pkg.
+func ruleLabel(pkg string, target *types.RuleInstance) string {
+	if l := target.Label(); l != nil {
+		return l.String()
+	}
+	return fmt.Sprintf("//%s:%s", pkg, target.Name())
+}
+
+// hash returns the hash of the target with the given label.
+func (h *targetHasher) hash(label string) ([]byte, error) {
+	if sum, ok := h.hashes[label]; ok {
+		return sum, nil
+	}
+	if h.visiting[label] {
+		return nil, fmt.Errorf("hashing targets: cycle in dependency graph at %s", label)
+	}
+	h.visiting[label] = true
+	defer delete(h.visiting, label)
+
+	target := h.targets[label]
+	rc := target.RuleClass()
+	d := sha256.New()
+	fmt.Fprintf(d, "rule %s\x00%s\x00", rc.Name(), rc.DefinitionLabel())
+	d.Write(rc.DefinitionDigest())
+
+	values := target.AttrValues()
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var labels targetLabels
+	for _, name := range names {
+		attr, _ := rc.GetAttr(name)
+		v := h.canonical(target, values[name], attrRole(attr), &labels)
+		fmt.Fprintf(d, "attr %s\x00%s\x00", name, v.String())
+	}
+
+	deps := labels.deps
+	sort.Strings(deps)
+	for i, dep := range deps {
+		if i > 0 && dep == deps[i-1] {
+			continue
+		}
+		sum, err := h.depHash(dep)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(d, "dep %s\x00", dep)
+		d.Write(sum)
+	}
+
+	sum := d.Sum(nil)
+	h.hashes[label] = sum
+	return sum, nil
+}
+
+// depHash returns the hash of a dependency: a target's, that of the target
+// generating an output file, or the digest of a source file. A missing
+// source file hashes to a fixed marker, so creating it changes the hash;
+// other read errors are returned.
+//
+// Targets of other repositories are hashed by the version of the registry
+// module providing the repository. Dependencies that cannot be hashed,
+// such as those on other repositories, on packages that are not part of
+// the workspace or on directories, hash to a fixed marker and their label
+// (see unknownHash): changes to their content go unnoticed, but hashes are
+// stable across calls and processes.
+func (h *targetHasher) depHash(label string) ([]byte, error) {
+	if _, ok := h.targets[label]; ok {
+		return h.hash(label)
+	}
+	if gen, ok := h.outputs[label]; ok {
+		return h.hash(gen)
+	}
+	l, err := types.ParseLabel(label)
+	if err != nil {
+		return nil, fmt.Errorf("hashing targets: %w", err)
+	}
+	if l.Repo() != "" {
+		if version, ok := h.repos[l.Repo()]; ok {
+			sum := sha256.Sum256([]byte("repo " + version))
+			return sum[:], nil
+		}
+		return unknownHash(label), nil
+	}
+	dir, ok := h.dirs[l.Pkg()]
+	if !ok {
+		return unknownHash(label), nil
+	}
+	path := h.fsys.Join(dir, l.Name())
+	content, err := h.fsys.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []byte("missing"), nil
+	}
+	if err != nil {
+		if info, statErr := h.fsys.Stat(path); statErr == nil && info.IsDir() {
+			return unknownHash(label), nil
+		}
+		return nil, fmt.Errorf("hashing targets: reading %s: %w", label, err)
+	}
+	sum := sha256.Sum256(content)
+	return sum[:], nil
+}
+
+// unknownHash returns the hash of a dependency whose content cannot be
+// hashed.
+func unknownHash(label string) []byte {
+	sum := sha256.Sum256([]byte("unknown " + label))
+	return sum[:]
+}
+
+// versionedRepos returns the canonical names of the repositories of the
+// registry modules of g, which are fetched by version, mapped to a string
+// identifying their content.
+func versionedRepos(g *bzlmod.ModuleGraph) map[string]string {
+	repos := make(map[string]string)
+	if g == nil {
+		return repos
+	}
+	for _, m := range g.Modules() {
+		spec := m.RepoSpec
+		if m.Key == bzlmod.RootModuleKey || m.Key.Version == "" || m.Path != "" || spec == nil || spec.Type == "local_path" {
+			continue
+		}
+		repos[m.Key.CanonicalRepoName()] = fmt.Sprintf("%s %s %s %s", m.Key, spec.Integrity, spec.Remote, spec.Commit)
+	}
+	return repos
+}
+
+// targetLabels collects the dependency labels found in a target's
+// attribute values.
+type targetLabels struct {
+	deps []string
+}
+
+// canonical returns value with the labels it contains in canonical form and
+// collects the dependencies among them in labels. The conditions of selects
+// are labels whatever the role, and dependencies.
+func (h *targetHasher) canonical(target *types.RuleInstance, value starlark.Value, role labelRole, labels *targetLabels) starlark.Value {
+	var s string
+	switch v := value.(type) {
+	case starlark.String:
+		s = string(v)
+	case *types.Label:
+		s = v.String()
+	}
+	if s != "" && role != notLabel {
+		label, ok := h.canonicalLabel(target, s)
+		if !ok {
+			return value
+		}
+		if role == depLabel {
+			labels.deps = append(labels.deps, label)
+		}
+		return starlark.String(label)
+	}
+
+	switch v := value.(type) {
+	case *starlark.List:
+		elems := make([]starlark.Value, v.Len())
+		for i := range elems {
+			elems[i] = h.canonical(target, v.Index(i), role, labels)
+		}
+		return starlark.NewList(elems)
+	case starlark.Tuple:
+		elems := make(starlark.Tuple, len(v))
+		for i, x := range v {
+			elems[i] = h.canonical(target, x, role, labels)
+		}
+		return elems
+	case *starlark.Dict:
+		dict := starlark.NewDict(v.Len())
+		for _, item := range v.Items() {
+			_ = dict.SetKey(item[0], h.canonical(target, item[1], role, labels))
+		}
+		return dict
+	case *builtins.SelectorList:
+		elems := make([]starlark.Value, len(v.Elements()))
+		for i, x := range v.Elements() {
+			elems[i] = h.canonical(target, x, role, labels)
+		}
+		return builtins.NewSelectorList(elems)
+	case *builtins.SelectorValue:
+		conditions := make(map[string]starlark.Value, len(v.Conditions()))
+		for key, x := range v.Conditions() {
+			if label, ok := h.canonicalLabel(target, key); ok && key != "//conditions:default" {
+				labels.deps = append(labels.deps, label)
+				key = label
+			}
+			conditions[key] = h.canonical(target, x, role, labels)
+		}
+		return builtins.NewSelectorValue(conditions, v.NoMatchError())
+	}
+	return value
+}
+
+// canonicalLabel resolves s relative to the target's package. It returns
+// false if s is not a label.
+func (h *targetHasher) canonicalLabel(target *types.RuleInstance, s string) (string, bool) {
+	c := types.LabelContext{RepoMapping: h.mapping}
+	if l := target.Label(); l != nil {
+		c.Repo, c.Pkg = l.Repo(), l.Pkg()
+	}
+	l, err := types.ParseLabelInContext(s, c)
+	if err != nil {
+		return "", false
+	}
+	return l.String(), true
+}
@@ -0,0 +1,227 @@
+package bzl
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/albertocavalcante/starlark-go-bazel/bzlmod"
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+)
+
+// myRuleDef defines my_rule, with srcs and deps label list attributes.
+var myRuleDef = ruleDef("my_rule", `"srcs": attr.label_list()`, `"deps": attr.label_list()`)
+
+func TestTargetHashes(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"rules/defs.bzl": myRuleDef,
+		"lib/BUILD": `load("//rules:defs.bzl", "my_rule")
+my_rule(name = "lib", srcs = ["lib.go"])`,
+		"lib/lib.go": "package lib",
+		"app/BUILD": `load("//rules:defs.bzl", "my_rule")
+my_rule(name = "app", srcs = ["main.go"], deps = ["//lib"])`,
+		"app/main.go": "package main",
+		"tool/BUILD": `load("//rules:defs.bzl", "my_rule")
+my_rule(name = "tool", srcs = select({"//conditions:default": ["tool.go"]}))`,
+		"tool/tool.go": "package tool",
+	})
+
+	interp := New(Options{WorkspaceRoot: "/ws", FileSystem: fs})
+	hashes, err := interp.TargetHashes()
+	if err != nil {
+		t.Fatalf("TargetHashes failed: %v", err)
+	}
+	if len(hashes) != 3 {
+		t.Fatalf("hashed %d targets, want 3: %v", len(hashes), hashes)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		content  string
+		affected string
+	}{
+		{"source file", "/ws/lib/lib.go", "package lib // changed", "//app:app //lib:lib"},
+		{"reverse dependency only", "/ws/app/main.go", "package main // changed", "//app:app"},
+		{"source file in select", "/ws/tool/tool.go", "package tool // changed", "//tool:tool"},
+		{"equivalent label", "/ws/app/BUILD", `load("//rules:defs.bzl", "my_rule")
+my_rule(name = "app", srcs = [":main.go"], deps = ["//lib:lib"])`, ""},
+		{"rule definition", "/ws/rules/defs.bzl", myRuleDef + "# changed\n", "//app:app //lib:lib //tool:tool"},
+		{"new package", "/ws/new/BUILD", `load("//rules:defs.bzl", "my_rule")
+my_rule(name = "new")`, "//new:new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs.AddFile(tt.path, []byte(tt.content))
+			affected, after, err := interp.ChangedTargets(hashes, []string{tt.path})
+			if err != nil {
+				t.Fatalf("ChangedTargets failed: %v", err)
+			}
+			if got := strings.Join(affected, " "); got != tt.affected {
+				t.Errorf("affected = %q, want %q", got, tt.affected)
+			}
+			hashes = after
+		})
+	}
+
+	fs.RemoveFile("/ws/new/BUILD")
+	affected, _, err := interp.ChangedTargets(hashes, []string{"/ws/new/BUILD"})
+	if err != nil {
+		t.Fatalf("ChangedTargets failed: %v", err)
+	}
+	if got := strings.Join(affected, " "); got != "//new:new" {
+		t.Errorf("affected after removal = %q", got)
+	}
+}
+
+// unreadableFileSystem fails to read one file.
+type unreadableFileSystem struct {
+	*loader.MemoryFileSystem
+	path string
+}
+
+func (f *unreadableFileSystem) ReadFile(path string) ([]byte, error) {
+	if path == f.path {
+		return nil, &fs.PathError{Op: "read", Path: path, Err: fs.ErrPermission}
+	}
+	return f.MemoryFileSystem.ReadFile(path)
+}
+
+func TestTargetHashesExternalDeps(t *testing.T) {
+	mem := loader.NewMemoryFileSystem()
+	mem.AddFile("/registry/modules/rules_foo/1.0/MODULE.bazel", []byte(`module(name = "rules_foo", version = "1.0")`))
+	mem.AddFile("/registry/modules/rules_foo/1.0/source.json", []byte(`{"type": "archive", "url": "https://example.com/rules_foo-1.0.tar.gz", "integrity": "sha256-abc"}`))
+	mem.AddFile("/ws/MODULE.bazel", []byte(`bazel_dep(name = "rules_foo", version = "1.0", repo_name = "foo")`))
+	mem.AddFile("/ws/rules/defs.bzl", []byte(myRuleDef))
+	mem.AddFile("/ws/versioned/BUILD", []byte(`load("//rules:defs.bzl", "my_rule")
+my_rule(name = "versioned", deps = ["@foo//:lib"])`))
+	mem.AddFile("/ws/local/BUILD", []byte(`load("//rules:defs.bzl", "my_rule")
+my_rule(name = "local", deps = ["@local//:lib"])`))
+	mem.AddFile("/ws/unknown/BUILD", []byte(`load("//rules:defs.bzl", "my_rule")
+my_rule(name = "unknown", deps = ["//nowhere:lib"])`))
+	mem.AddFile("/ws/plain/BUILD", []byte(`load("//rules:defs.bzl", "my_rule")
+my_rule(name = "plain", srcs = ["plain.go"])`))
+	mem.AddFile("/ws/plain/plain.go", []byte("package plain"))
+
+	graph, err := bzlmod.Resolve("/ws", bzlmod.ResolveOptions{
+		FileSystem: mem,
+		Registries: []bzlmod.Registry{bzlmod.NewLocalRegistry(mem, "/registry")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := &unreadableFileSystem{MemoryFileSystem: mem}
+	interp := New(Options{
+		WorkspaceRoot: "/ws",
+		FileSystem:    fsys,
+		ModuleGraph:   graph,
+		ExternalRepos: map[string]string{"local": "/local"},
+	})
+	before, err := interp.TargetHashes()
+	if err != nil {
+		t.Fatalf("TargetHashes failed: %v", err)
+	}
+	after, err := interp.TargetHashes()
+	if err != nil {
+		t.Fatalf("TargetHashes failed: %v", err)
+	}
+	// Dependencies that cannot be hashed are hashed by their label, so the
+	// hashes are the same from one call, or interpreter, to the next.
+	if got := AffectedTargets(before, after); len(got) != 0 {
+		t.Errorf("affected = %v, want none", got)
+	}
+	fresh, err := New(Options{
+		WorkspaceRoot: "/ws",
+		FileSystem:    fsys,
+		ModuleGraph:   graph,
+		ExternalRepos: map[string]string{"local": "/local"},
+	}).TargetHashes()
+	if err != nil {
+		t.Fatalf("TargetHashes failed: %v", err)
+	}
+	if got := AffectedTargets(before, fresh); len(got) != 0 {
+		t.Errorf("affected in a new interpreter = %v, want none", got)
+	}
+
+	mem.AddFile("/ws/local/BUILD", []byte(`load("//rules:defs.bzl", "my_rule")
+my_rule(name = "local", deps = ["@local//:other"])`))
+	interp.Invalidate([]string{"/ws/local/BUILD"})
+	changed, err := interp.TargetHashes()
+	if err != nil {
+		t.Fatalf("TargetHashes failed: %v", err)
+	}
+	if got := strings.Join(AffectedTargets(after, changed), " "); got != "//local:local" {
+		t.Errorf("affected = %q, want the target whose unhashable dependency changed", got)
+	}
+
+	fsys.path = "/ws/plain/plain.go"
+	if _, err := interp.TargetHashes(); err == nil || !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("TargetHashes with an unreadable source file: got error %v, want permission denied", err)
+	}
+}
+
+func TestTargetHashesAtGitRevisions(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	commit := func(message string, files map[string]string) {
+		t.Helper()
+		writeFiles(t, dir, files)
+		git("add", ".")
+		git("commit", "-q", "-m", message)
+	}
+
+	git("init", "-q", "-b", "main")
+	commit("first", map[string]string{
+		"rules/defs.bzl": myRuleDef,
+		"lib/BUILD": `load("//rules:defs.bzl", "my_rule")
+my_rule(name = "lib", srcs = glob(["*.go"]))`,
+		"lib/lib.go": "package lib",
+		"app/BUILD": `load("//rules:defs.bzl", "my_rule")
+my_rule(name = "app", srcs = ["main.go"])`,
+		"app/main.go": "package main",
+	})
+	commit("second", map[string]string{"lib/extra.go": "package lib"})
+	git("tag", "v2")
+	git("gc", "-q")
+	commit("third", map[string]string{"app/BUILD": `load("//rules:defs.bzl", "my_rule")
+my_rule(name = "app", srcs = ["main.go"], deps = ["//lib"])`})
+
+	repo, err := loader.OpenGitRepository(dir)
+	if err != nil {
+		t.Fatalf("OpenGitRepository failed: %v", err)
+	}
+	defer repo.Close()
+	hashesAt := func(rev string) TargetHashes {
+		t.Helper()
+		fsys, err := repo.FileSystem(rev)
+		if err != nil {
+			t.Fatalf("FileSystem(%s) failed: %v", rev, err)
+		}
+		hashes, err := New(Options{WorkspaceRoot: "/", FileSystem: fsys}).TargetHashes()
+		if err != nil {
+			t.Fatalf("TargetHashes at %s failed: %v", rev, err)
+		}
+		return hashes
+	}
+	for _, tt := range []struct{ from, to, affected string }{
+		{"HEAD~2", "v2", "//lib:lib"},
+		{"v2", "main", "//app:app"},
+		{"main^^", "HEAD", "//app:app //lib:lib"},
+	} {
+		if got := strings.Join(AffectedTargets(hashesAt(tt.from), hashesAt(tt.to)), " "); got != tt.affected {
+			t.Errorf("%s..%s: affected = %q, want %q", tt.from, tt.to, got, tt.affected)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package bzl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTarget(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"rules/defs.bzl": `gen = rule(
+    implementation = lambda ctx: [],
+    attrs = {"srcs": attr.label_list(), "out": attr.output()},
+)`,
+		"app/BUILD": `load("//rules:defs.bzl", "gen")
+exports_files(["data.txt"])
+gen(name = "a", srcs = ["a.in"], out = "a.out")
+gen(name = "b", srcs = [":a.out"])`,
+		"app/a.in":       "a",
+		"app/data.txt":   "data",
+		"app/unused.txt": "unused",
+	})
+	interp := New(Options{WorkspaceRoot: "/ws", FileSystem: fs})
+
+	tests := []struct {
+		label, kind, path string
+	}{
+		{"//app:a", "gen rule", ""},
+		{"//app:a.in", "source file", "app/a.in"},
+		{"//app:data.txt", "source file", "app/data.txt"},
+		{"//app:a.out", "generated file", "bazel-out/bin/app/a.out"},
+	}
+	for _, tt := range tests {
+		target, err := interp.Target(tt.label)
+		if err != nil {
+			t.Errorf("Target(%s) failed: %v", tt.label, err)
+			continue
+		}
+		if got := target.Kind(); got != tt.kind {
+			t.Errorf("Target(%s).Kind() = %q, want %q", tt.label, got, tt.kind)
+		}
+		if f := target.Artifact(); (f == nil) != (tt.path == "") || (f != nil && f.Path() != tt.path) {
+			t.Errorf("Target(%s).Artifact() = %v, want %q", tt.label, f, tt.path)
+		}
+	}
+	if _, err := interp.Target("//app:unused.txt"); err == nil || !strings.Contains(err.Error(), "a source file of this name exists") {
+		t.Errorf("expected unexported source file error, got %v", err)
+	}
+	if _, err := interp.Target("//app:nothing"); err == nil || !strings.Contains(err.Error(), "not declared in package 'app'") {
+		t.Errorf("expected no such target error, got %v", err)
+	}
+
+	// Changing a source of a generates a different output for b.
+	before, err := interp.TargetHashes()
+	if err != nil {
+		t.Fatalf("TargetHashes failed: %v", err)
+	}
+	fs.AddFile("/ws/app/a.in", []byte("changed"))
+	affected, _, err := interp.ChangedTargets(before, []string{"/ws/app/a.in"})
+	if err != nil {
+		t.Fatalf("ChangedTargets failed: %v", err)
+	}
+	if got := strings.Join(affected, " "); got != "//app:a //app:b" {
+		t.Errorf("affected = %q", got)
+	}
+}
@@ -0,0 +1,300 @@
+package bzl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/eval"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// VisibilityViolation is a dependency of a rule on a target that is not
+// visible to it.
+type VisibilityViolation struct {
+	// From is the label of the depending rule.
+	From string
+
+	// Attr is the attribute declaring the dependency.
+	Attr string
+
+	// To is the label of the dependency.
+	To string
+
+	// Err is set when the dependency does not exist or its visibility cannot
+	// be evaluated, such as when it names a missing package group.
+	Err error
+}
+
+// String describes the violation like Bazel's analysis error.
+func (v *VisibilityViolation) String() string {
+	if v.Err != nil {
+		return fmt.Sprintf("%s: attribute '%s': %v", v.From, v.Attr, v.Err)
+	}
+	return fmt.Sprintf("target '%s' is not visible from target '%s' (attribute '%s'). "+
+		"Check the visibility declaration of the former target if you think the dependency is legitimate", v.To, v.From, v.Attr)
+}
+
+// CheckVisibility evaluates every package of the workspace and returns the
+// dependency edges that violate visibility, sorted. Every label attribute is
+// checked, including the branches and conditions of selects; dependencies
+// on other repositories are not.
+//
+// A target is visible from its own package and from the packages its
+// visibility allows: its visibility attribute, or else its package's
+// default_visibility, or else private. Exported files have the visibility
+// given to exports_files, other source files that of their package (see
+// Options.NoImplicitFileExport), generated files that of their rule, and
+// package groups are public.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/analysis/DependencyResolver.java
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/analysis/constraints/RuleContextConstraintSemantics.java
+func (i *Interpreter) CheckVisibility() ([]*VisibilityViolation, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	pkgs, err := i.locator.Packages()
+	if err != nil {
+		return nil, fmt.Errorf("checking visibility: %w", err)
+	}
+	c := &visibilityChecker{
+		i:        i,
+		mapping:  i.bzlLoader.RepoMapping(""),
+		packages: make(map[string]*eval.BuildResult),
+	}
+	var violations []*VisibilityViolation
+	for _, pkg := range pkgs {
+		result, err := c.pkg(pkg)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range result.Targets {
+			from := ruleLabel(pkg, target)
+			for _, edge := range c.deps(target) {
+				if v := c.check(pkg, edge.to); v != nil {
+					v.From, v.Attr = from, edge.attr
+					violations = append(violations, v)
+				}
+			}
+		}
+	}
+	sort.Slice(violations, func(a, b int) bool {
+		va, vb := violations[a], violations[b]
+		if va.From != vb.From {
+			return va.From < vb.From
+		}
+		if va.To != vb.To {
+			return va.To < vb.To
+		}
+		return va.Attr < vb.Attr
+	})
+	return violations, nil
+}
+
+// visibilityChecker evaluates visibility over the packages of a workspace.
+type visibilityChecker struct {
+	i        *Interpreter
+	mapping  *types.RepoMapping
+	packages map[string]*eval.BuildResult
+}
+
+// dependency is an edge from a rule through one of its attributes.
+type dependency struct {
+	attr string
+	to   string
+}
+
+// pkg evaluates a package. It returns nil if there is no such package.
+func (c *visibilityChecker) pkg(name string) (*eval.BuildResult, error) {
+	if result, ok := c.packages[name]; ok {
+		return result, nil
+	}
+	var result *eval.BuildResult
+	if buildFile, ok := c.i.locator.BuildFile(name); ok {
+		var err error
+		if result, err = c.i.evaluator.EvalBuildFile(buildFile); err != nil {
+			return nil, err
+		}
+	}
+	c.packages[name] = result
+	return result, nil
+}
+
+// deps returns the distinct dependencies of a rule by attribute. The
+// visibility attribute declares package specifications, not dependencies.
+func (c *visibilityChecker) deps(target *types.RuleInstance) []dependency {
+	h := &targetHasher{mapping: c.mapping}
+	rc := target.RuleClass()
+	var deps []dependency
+	for name, value := range target.AttrValues() {
+		attr, _ := rc.GetAttr(name)
+		if name == "visibility" || attrRole(attr) != depLabel {
+			continue
+		}
+		var labels targetLabels
+		h.canonical(target, value, depLabel, &labels)
+		seen := make(map[string]bool)
+		for _, dep := range labels.deps {
+			if !seen[dep] {
+				seen[dep] = true
+				deps = append(deps, dependency{attr: name, to: dep})
+			}
+		}
+	}
+	return deps
+}
+
+// check returns a violation if the target labeled to is not visible from
+// package from.
+func (c *visibilityChecker) check(from, to string) *VisibilityViolation {
+	l, err := types.ParseLabel(to)
+	if err != nil || l.Repo() != "" || l.Pkg() == from {
+		return nil
+	}
+	result, err := c.pkg(l.Pkg())
+	if err != nil {
+		return &VisibilityViolation{To: to, Err: err}
+	}
+	if result == nil {
+		return &VisibilityViolation{To: to, Err: fmt.Errorf("no such package '%s'", l.Pkg())}
+	}
+	visibility, ok := c.visibility(result, l.Name())
+	if !ok {
+		return &VisibilityViolation{To: to, Err: fmt.Errorf("no such target '%s': target '%s' not declared in package '%s'", to, l.Name(), l.Pkg())}
+	}
+	visible, err := c.allowed(result.Package, visibility, from)
+	if err != nil {
+		return &VisibilityViolation{To: to, Err: fmt.Errorf("visibility of '%s': %w", to, err)}
+	}
+	if !visible {
+		return &VisibilityViolation{To: to}
+	}
+	return nil
+}
+
+// visibility returns the visibility declaration of a target of a package,
+// or false if there is no such target.
+func (c *visibilityChecker) visibility(result *eval.BuildResult, name string) ([]string, bool) {
+	if rule, ok := result.Targets[name]; ok {
+		if v, ok := rule.GetVisibility(); ok && v != starlark.None {
+			return visibilityStrings(v), true
+		}
+		return defaultVisibility(result), true
+	}
+	if f, ok := result.Files[name]; ok {
+		switch {
+		case f.Kind == eval.OutputFile:
+			return c.visibility(result, f.Generator)
+		case f.Exported:
+			return f.Visibility, true
+		case c.i.options.NoImplicitFileExport:
+			return []string{"//visibility:private"}, true
+		case result.DefaultVisibility != nil:
+			return result.DefaultVisibility, true
+		}
+		return []string{"//visibility:public"}, true
+	}
+	if _, ok := result.Groups[name]; ok {
+		return []string{"//visibility:public"}, true
+	}
+	return nil, false
+}
+
+// defaultVisibility returns the visibility of the rules of a package that
+// declare none.
+func defaultVisibility(result *eval.BuildResult) []string {
+	if result.DefaultVisibility != nil {
+		return result.DefaultVisibility
+	}
+	return []string{"//visibility:private"}
+}
+
+// visibilityStrings returns the labels of a visibility attribute value.
+func visibilityStrings(v starlark.Value) []string {
+	var labels []string
+	if iterable, ok := v.(starlark.Iterable); ok {
+		iter := iterable.Iterate()
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			switch x := x.(type) {
+			case starlark.String:
+				labels = append(labels, string(x))
+			case *types.Label:
+				labels = append(labels, x.String())
+			}
+		}
+	}
+	return labels
+}
+
+// allowed reports whether a visibility declaration written in package pkg
+// allows package from.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/RuleVisibility.java
+func (c *visibilityChecker) allowed(pkg string, visibility []string, from string) (bool, error) {
+	for _, s := range visibility {
+		l, err := types.ParseLabelInContext(s, types.LabelContext{Pkg: pkg, RepoMapping: c.mapping})
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case l.Repo() != "":
+			// Packages of other repositories, including their
+			// //visibility:public, never match those of the main one.
+			continue
+		case l.Pkg() == "visibility" && (l.Name() == "public" || l.Name() == "legacy_public"):
+			return true, nil
+		case l.Pkg() == "visibility" && l.Name() == "private":
+			continue
+		case l.Name() == "__pkg__":
+			if from == l.Pkg() {
+				return true, nil
+			}
+		case l.Name() == "__subpackages__":
+			if from == l.Pkg() || l.Pkg() == "" || strings.HasPrefix(from, l.Pkg()+"/") {
+				return true, nil
+			}
+		default:
+			ok, err := c.groupContains(l, from, make(map[string]bool))
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// groupContains reports whether the package group labeled l, or a group it
+// includes, contains package from.
+func (c *visibilityChecker) groupContains(l *types.Label, from string, seen map[string]bool) (bool, error) {
+	if seen[l.String()] {
+		return false, nil
+	}
+	seen[l.String()] = true
+	result, err := c.pkg(l.Pkg())
+	if err != nil {
+		return false, err
+	}
+	var group *eval.PackageGroup
+	if result != nil {
+		group = result.Groups[l.Name()]
+	}
+	if group == nil {
+		return false, fmt.Errorf("label '%s' does not refer to a package group", l)
+	}
+	if group.Matches("", from) {
+		return true, nil
+	}
+	for _, include := range group.Includes {
+		il, err := types.ParseLabel(include)
+		if err != nil {
+			return false, err
+		}
+		if ok, err := c.groupContains(il, from, seen); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
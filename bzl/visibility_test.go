@@ -0,0 +1,79 @@
+package bzl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckVisibility(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"rules/defs.bzl": ruleDef("lib", `"srcs": attr.label_list()`, `"deps": attr.label_list()`, `"out": attr.output()`),
+		"groups/BUILD": `package_group(name = "frontend", packages = ["//ui/...", "-//ui/legacy"])
+package_group(name = "friends", packages = ["//tools"], includes = [":frontend"])
+package_group(name = "everyone", packages = ["public"])`,
+		"core/BUILD": `load("//rules:defs.bzl", "lib")
+package(default_visibility = ["//groups:friends"])
+exports_files(["api.h"], visibility = ["//app:__pkg__"])
+lib(name = "core", srcs = ["core.c", "internal.h"], out = "core.a")
+lib(name = "secret", visibility = ["//visibility:private"])
+lib(name = "shared", visibility = ["//lib:__subpackages__", "//groups:everyone"])
+lib(name = "broken", visibility = ["//groups:nothing"])
+lib(name = "foreign", visibility = ["@other//visibility:public"])`,
+		"ui/BUILD": `load("//rules:defs.bzl", "lib")
+lib(name = "ui", deps = ["//core", "//core:core.a", "//core:internal.h"])`,
+		"ui/legacy/BUILD": `load("//rules:defs.bzl", "lib")
+lib(name = "legacy", deps = ["//core"])`,
+		"tools/BUILD": `load("//rules:defs.bzl", "lib")
+lib(name = "tools", deps = select({
+    "//conditions:default": ["//core"],
+    "//app:fast": ["//core:secret"],
+}))`,
+		"app/BUILD": `load("//rules:defs.bzl", "lib")
+lib(name = "fast", visibility = ["//visibility:public"])
+lib(name = "app", srcs = ["//core:api.h"], deps = ["//core", "//core:shared", "//core:broken", "//core:foreign", "//core:missing"])`,
+	})
+
+	for _, tt := range []struct {
+		noImplicitExport bool
+		want             []string
+	}{
+		{false, []string{
+			"//app:app //core:broken error",
+			"//app:app //core:core",
+			"//app:app //core:foreign",
+			"//app:app //core:missing error",
+			"//tools:tools //core:secret",
+			"//ui/legacy:legacy //core:core",
+		}},
+		{true, []string{
+			"//app:app //core:broken error",
+			"//app:app //core:core",
+			"//app:app //core:foreign",
+			"//app:app //core:missing error",
+			"//tools:tools //core:secret",
+			"//ui/legacy:legacy //core:core",
+			"//ui:ui //core:internal.h",
+		}},
+	} {
+		interp := New(Options{WorkspaceRoot: "/ws", FileSystem: fs, NoImplicitFileExport: tt.noImplicitExport})
+		violations, err := interp.CheckVisibility()
+		if err != nil {
+			t.Fatalf("CheckVisibility failed: %v", err)
+		}
+		var got []string
+		for _, v := range violations {
+			s := v.From + " " + v.To
+			if v.Err != nil {
+				s += " error"
+			}
+			got = append(got, s)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("NoImplicitFileExport=%v: violations:\n%s\nwant:\n%s", tt.noImplicitExport, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+		if len(violations) > 1 && !strings.Contains(violations[1].String(), "target '//core:core' is not visible from target '//app:app'") {
+			t.Errorf("violation message = %s", violations[1])
+		}
+	}
+
+}
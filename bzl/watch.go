@@ -0,0 +1,65 @@
+package bzl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+)
+
+// WatchOptions configures Interpreter.Watch.
+type WatchOptions struct {
+	// Interval between scans of the workspace (default:
+	// loader.DefaultPollInterval).
+	Interval time.Duration
+
+	// Debounce is how long the workspace must be unchanged before changes
+	// are applied (default: loader.DefaultDebounce).
+	Debounce time.Duration
+}
+
+// WatchEvent reports changed files and the cached modules, packages and
+// targets they invalidated.
+type WatchEvent struct {
+	// Paths are the absolute paths created, modified or deleted.
+	Paths []string
+
+	// Modules are the canonical labels of the evicted .bzl modules.
+	Modules []string
+
+	// Packages are the names of the evicted packages.
+	Packages []string
+
+	// Targets are the labels of the targets of the evicted packages.
+	Targets []string
+}
+
+// Watch observes the workspace until ctx is done, evicting the modules and
+// packages that read changed files as changes settle, and calls handler
+// with each batch of changes. Evaluations made concurrently from other
+// goroutines see the workspace either before or after a batch.
+//
+// Watching requires the interpreter to use a *loader.OSFileSystem. Watch
+// returns ctx.Err() once ctx is done, or the error that stopped watching.
+func (i *Interpreter) Watch(ctx context.Context, opts WatchOptions, handler func(WatchEvent)) error {
+	fsys, ok := i.options.FileSystem.(*loader.OSFileSystem)
+	if !ok {
+		return fmt.Errorf("watching requires a *loader.OSFileSystem, got %T", i.options.FileSystem)
+	}
+	w, err := loader.NewPollingWatcher(fsys, opts.Interval, opts.Debounce)
+	if err != nil {
+		return fmt.Errorf("watching %s: %w", fsys.Root(), err)
+	}
+	return w.Watch(ctx, func(paths []string) {
+		inv := i.Invalidate(paths)
+		if handler != nil {
+			handler(WatchEvent{
+				Paths:    paths,
+				Modules:  inv.Modules,
+				Packages: inv.Packages,
+				Targets:  inv.Targets,
+			})
+		}
+	})
+}
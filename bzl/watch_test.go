@@ -0,0 +1,71 @@
+package bzl
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+)
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"lib/defs.bzl": ruleDef("my_rule"),
+		"app/BUILD": `load("//lib:defs.bzl", "my_rule")
+my_rule(name = "app")`,
+		"tool/BUILD": "",
+	})
+
+	interp := New(Options{WorkspaceRoot: root})
+	appBuild := filepath.Join(root, "app/BUILD")
+	for _, f := range []string{appBuild, filepath.Join(root, "tool/BUILD")} {
+		if _, err := interp.EvalFile(f); err != nil {
+			t.Fatalf("EvalFile(%s) failed: %v", f, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan WatchEvent, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- interp.Watch(ctx, WatchOptions{Interval: 10 * time.Millisecond, Debounce: 30 * time.Millisecond}, func(e WatchEvent) {
+			events <- e
+		})
+	}()
+
+	// Wait for the watcher's initial scan before changing files.
+	time.Sleep(50 * time.Millisecond)
+	writeFiles(t, root, map[string]string{"lib/defs.bzl": ruleDef("my_rule") + "# changed\n"})
+
+	select {
+	case e := <-events:
+		if got := strings.Join(e.Paths, " "); got != filepath.Join(root, "lib/defs.bzl") {
+			t.Errorf("Paths = %q", got)
+		}
+		if got := strings.Join(e.Modules, " "); got != "//lib:defs.bzl" {
+			t.Errorf("Modules = %q", got)
+		}
+		if got := strings.Join(e.Packages, " "); got != "app" {
+			t.Errorf("Packages = %q, want app", got)
+		}
+		if got := strings.Join(e.Targets, " "); got != "//app:app" {
+			t.Errorf("Targets = %q", got)
+		}
+	case err := <-errs:
+		t.Fatalf("Watch failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no watch event")
+	}
+
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("Watch returned %v, want context.Canceled", err)
+	}
+	if err := New(Options{FileSystem: loader.NewMemoryFileSystem()}).Watch(ctx, WatchOptions{}, nil); err == nil {
+		t.Error("expected an error watching a MemoryFileSystem")
+	}
+}
@@ -0,0 +1,72 @@
+package bzl
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+)
+
+// PackageLocator returns the locator resolving packages along the
+// interpreter's package path.
+func (i *Interpreter) PackageLocator() *loader.PackageLocator {
+	return i.locator
+}
+
+// PackageOf returns the package owning the file at path, the package of the
+// nearest BUILD file at or above it.
+func (i *Interpreter) PackageOf(path string) (string, bool) {
+	return i.locator.PackageOf(path)
+}
+
+// LabelOf returns the label of the file at path, such as "//app:sub/x.go"
+// for app/sub/x.go when app/sub is not a package.
+func (i *Interpreter) LabelOf(path string) (*types.Label, error) {
+	return i.locator.LabelOf(path)
+}
+
+// Owners returns the sorted labels of the targets of the package owning the
+// file at path that reference it in a label attribute, like
+// `bazel query 'attr(srcs, <file>, //pkg:*)'` over every label attribute.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/query2/engine/OwnerFunction.java
+func (i *Interpreter) Owners(path string) ([]string, error) {
+	file, err := i.locator.LabelOf(path)
+	if err != nil {
+		return nil, err
+	}
+	buildFile, ok := i.locator.BuildFile(file.Pkg())
+	if !ok {
+		return nil, fmt.Errorf("no BUILD file for package '%s'", file.Pkg())
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	result, err := i.evaluator.EvalBuildFile(buildFile)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &targetHasher{mapping: i.bzlLoader.RepoMapping("")}
+	want := file.String()
+	var owners []string
+	for _, target := range result.Targets {
+		rc := target.RuleClass()
+		var labels targetLabels
+		for attrName, value := range target.AttrValues() {
+			attr, _ := rc.GetAttr(attrName)
+			if attrRole(attr) == depLabel {
+				h.canonical(target, value, depLabel, &labels)
+			}
+		}
+		for _, dep := range labels.deps {
+			if dep == want {
+				owners = append(owners, ruleLabel(result.Package, target))
+				break
+			}
+		}
+	}
+	sort.Strings(owners)
+	return owners, nil
+}
@@ -0,0 +1,62 @@
+package bzl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWorkspace(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"MODULE.bazel":   "",
+		"rules/defs.bzl": ruleDef("my_rule", `"srcs": attr.label_list()`),
+		"app/BUILD": `load("//rules:defs.bzl", "my_rule")
+my_rule(name = "app", srcs = glob(["**/*.go"]))
+my_rule(name = "other", srcs = ["sub/x.go"])
+my_rule(name = "none")`,
+		"app/sub/x.go":  "",
+		"app/gen/BUILD": `fail("deleted packages are not evaluated")`,
+		"app/gen/y.go":  "",
+	})
+	fs.AddFile("/vendor/lib/BUILD", []byte(`load("//rules:defs.bzl", "my_rule")
+my_rule(name = "lib", srcs = ["lib.go"])`))
+	fs.AddFile("/vendor/lib/lib.go", nil)
+
+	interp := New(Options{
+		WorkspaceRoot:   "/ws",
+		FileSystem:      fs,
+		PackagePath:     []string{"%workspace%", "/vendor"},
+		DeletedPackages: []string{"app/gen"},
+	})
+	if label, err := interp.LabelOf("/ws/app/gen/y.go"); err != nil || label.String() != "//app:gen/y.go" {
+		t.Errorf("LabelOf in deleted package = %v, %v, want //app:gen/y.go", label, err)
+	}
+	if _, err := interp.EvalFile("/ws/app/gen/BUILD"); err == nil || !strings.Contains(err.Error(), "considered deleted") {
+		t.Errorf("expected deleted package error, got %v", err)
+	}
+	result, err := interp.EvalFile("/ws/app/BUILD")
+	if err != nil {
+		t.Fatalf("EvalFile failed: %v", err)
+	}
+	srcs, _ := result.Targets["app"].Attr("srcs")
+	if got := srcs.String(); got != `["gen/y.go", "sub/x.go"]` {
+		t.Errorf("glob over deleted package = %s", got)
+	}
+	if _, err := interp.EvalFile("/vendor/lib/BUILD"); err != nil {
+		t.Errorf("EvalFile in second package path entry failed: %v", err)
+	}
+
+	owners, err := interp.Owners("/ws/app/sub/x.go")
+	if err != nil {
+		t.Fatalf("Owners failed: %v", err)
+	}
+	if got := strings.Join(owners, " "); got != "//app:app //app:other" {
+		t.Errorf("Owners = %q", got)
+	}
+	hashes, err := interp.TargetHashes()
+	if err != nil {
+		t.Fatalf("TargetHashes failed: %v", err)
+	}
+	if len(hashes) != 4 {
+		t.Errorf("hashed %d targets, want 4: %v", len(hashes), hashes)
+	}
+}
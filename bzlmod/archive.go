@@ -0,0 +1,206 @@
+package bzlmod
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// archiveTypes are the archive types accepted by extract() and
+// download_and_extract(), in the order suffixes are matched.
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/DecompressorValue.java
+var archiveTypes = []string{"tar.gz", "tgz", "tar.bz2", "tbz", "tar.xz", "txz", "tar", "zip", "jar", "war", "aar", "nupkg", "whl"}
+
+// archiveType returns the type of an archive, from the explicit type if
+// given or else from the file name's extension.
+// Reference: DecompressorValue.java getDecompressor()
+func archiveType(name, explicit string) (string, error) {
+	if explicit != "" {
+		name = "archive." + strings.TrimPrefix(explicit, ".")
+	}
+	lower := strings.ToLower(name)
+	for _, t := range archiveTypes {
+		if strings.HasSuffix(lower, "."+t) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("Expected a file with a .zip, .jar, .war, .aar, .nupkg, .whl, .tar, .tar.gz, .tgz, .tar.xz, .txz or .tar.bz2 suffix (got %s)", name)
+}
+
+// extractArchive extracts data into dir, dropping stripPrefix from every
+// entry. Entries outside stripPrefix are skipped.
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/CompressedTarFunction.java
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/ZipDecompressor.java
+func extractArchive(data []byte, kind, dir, stripPrefix string) error {
+	x := &extractor{dir: dir, stripPrefix: strings.Trim(stripPrefix, "/")}
+	var err error
+	switch kind {
+	case "zip", "jar", "war", "aar", "nupkg", "whl":
+		err = x.zip(data)
+	case "tar":
+		err = x.tar(bytes.NewReader(data))
+	case "tar.gz", "tgz":
+		var r io.Reader
+		r, err = gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			err = x.tar(r)
+		}
+	case "tar.bz2", "tbz":
+		err = x.tar(bzip2.NewReader(bytes.NewReader(data)))
+	case "tar.xz", "txz":
+		var r io.Reader
+		r, err = xz.NewReader(bytes.NewReader(data))
+		if err == nil {
+			err = x.tar(r)
+		}
+	default:
+		return fmt.Errorf("unsupported archive type %q", kind)
+	}
+	if err != nil {
+		return err
+	}
+	if x.stripPrefix != "" && !x.sawPrefix {
+		return fmt.Errorf("Prefix \"%s\" was given, but not found in the archive", stripPrefix)
+	}
+	return nil
+}
+
+type extractor struct {
+	dir         string
+	stripPrefix string
+	sawPrefix   bool
+}
+
+// target maps an archive entry name to its destination, or "" if the entry
+// is outside the strip prefix.
+func (x *extractor) target(name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if x.stripPrefix != "" {
+		if name == x.stripPrefix {
+			x.sawPrefix = true
+			return "", nil
+		}
+		rest, ok := strings.CutPrefix(name, x.stripPrefix+"/")
+		if !ok {
+			return "", nil
+		}
+		x.sawPrefix = true
+		name = rest
+	}
+	if name == "" {
+		return "", nil
+	}
+	return filepath.Join(x.dir, filepath.FromSlash(name)), nil
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading tar archive: %w", err)
+		}
+		dest, err := x.target(hdr.Name)
+		if err != nil || dest == "" {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := writeSymlink(dest, hdr.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(dest, tr, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (x *extractor) zip(data []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("reading zip archive: %w", err)
+	}
+	for _, f := range zr.File {
+		dest, err := x.target(f.Name)
+		if err != nil || dest == "" {
+			continue
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(dest, 0o755); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			link, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if err := writeSymlink(dest, string(link)); err != nil {
+				return err
+			}
+		default:
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = writeFile(dest, rc, mode)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeFile(dest string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	perm := os.FileMode(0o644)
+	if mode&0o111 != 0 {
+		perm = 0o755
+	}
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeSymlink(dest, target string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	os.Remove(dest)
+	return os.Symlink(target, dest)
+}
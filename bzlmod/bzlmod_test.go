@@ -0,0 +1,776 @@
+package bzlmod
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"github.com/ulikunitz/xz"
+	"go.starlark.net/starlark"
+)
+
+func TestVersionCompare(t *testing.T) {
+	ordered := []string{"1.0-pre", "1.0", "1.0.1", "1.2", "1.10", "1.a", "2.0+build", ""}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, err := ParseVersion(ordered[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseVersion(ordered[i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Compare(b) >= 0 || b.Compare(a) <= 0 {
+			t.Errorf("expected %q < %q", ordered[i], ordered[i+1])
+		}
+	}
+	if _, err := ParseVersion("1..0"); err == nil {
+		t.Error("expected error for malformed version")
+	}
+}
+
+func TestEvalModuleFile(t *testing.T) {
+	m, err := EvalModuleFile("MODULE.bazel", []byte(`
+module(name = "my_app", version = "1.0", compatibility_level = 1, repo_name = "app")
+bazel_dep(name = "rules_foo", version = "1.2.3")
+bazel_dep(name = "rules_test", version = "0.1", dev_dependency = True)
+
+maven = use_extension("@rules_foo//:ext.bzl", "maven")
+maven.install(artifacts = ["a:b:1"])
+use_repo(maven, "maven", deps = "maven_deps")
+
+register_toolchains("//toolchains:all")
+single_version_override(module_name = "rules_foo", version = "1.2.4")
+local_path_override(module_name = "rules_bar", path = "third_party/bar")
+`), ModuleFileOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if m.Name != "my_app" || m.Version != "1.0" || m.CompatibilityLevel != 1 || m.GetRepoName() != "app" {
+		t.Errorf("unexpected module attributes: %+v", m)
+	}
+	if len(m.Deps) != 2 || m.Deps[1].Name != "rules_test" {
+		t.Errorf("expected dev dependency for root module, got %v", m.Deps)
+	}
+	if len(m.ExtensionUsages) != 1 {
+		t.Fatalf("expected 1 extension usage, got %d", len(m.ExtensionUsages))
+	}
+	usage := m.ExtensionUsages[0]
+	if usage.Imports["deps"] != "maven_deps" || usage.Imports["maven"] != "maven" {
+		t.Errorf("unexpected imports: %v", usage.Imports)
+	}
+	if len(usage.Tags) != 1 || usage.Tags[0].TagName != "install" {
+		t.Fatalf("unexpected tags: %v", usage.Tags)
+	}
+	if _, ok := usage.Tags[0].Attributes["artifacts"].(*starlark.List); !ok {
+		t.Errorf("expected artifacts list attribute, got %v", usage.Tags[0].Attributes)
+	}
+	if o, ok := m.Overrides["rules_foo"].(*SingleVersionOverride); !ok || o.Version != "1.2.4" {
+		t.Errorf("unexpected rules_foo override: %v", m.Overrides["rules_foo"])
+	}
+	if _, ok := m.Overrides["rules_bar"].(*LocalPathOverride); !ok {
+		t.Errorf("unexpected rules_bar override: %v", m.Overrides["rules_bar"])
+	}
+}
+
+func TestEvalModuleFileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errMsg string
+	}{
+		{"load", `load("//:defs.bzl", "x")`, "`load` statements may not be used in MODULE.bazel files"},
+		{"def", "def f():\n    pass", "functions may not be defined in MODULE.bazel files"},
+		{"module twice", `module(name = "a")` + "\n" + `module(name = "b")`, "can only be called once"},
+		{"module late", `bazel_dep(name = "a", version = "1")` + "\n" + `module(name = "b")`, "must be called before any other functions"},
+		{"bad name", `module(name = "Bad")`, "invalid module name 'Bad'"},
+		{"repo name clash", `bazel_dep(name = "a", version = "1")` + "\n" + `bazel_dep(name = "b", version = "1", repo_name = "a")`, "the repo name 'a' is already being used"},
+		{"double override", `local_path_override(module_name = "a", path = "a")` + "\n" + `local_path_override(module_name = "a", path = "b")`, "multiple overrides for dep a found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := EvalModuleFile("MODULE.bazel", []byte(tt.source), ModuleFileOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestEvalModuleFileInclude(t *testing.T) {
+	fs := loader.NewMemoryFileSystem()
+	fs.AddFile("/ws/deps/go.MODULE.bazel", []byte(`bazel_dep(name = "rules_go", version = "0.50.0")`))
+
+	m, err := EvalModuleFile("/ws/MODULE.bazel", []byte(`
+module(name = "my_app")
+include("//deps:go.MODULE.bazel")
+`), ModuleFileOptions{FileSystem: fs, WorkspaceRoot: "/ws"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.Deps) != 1 || m.Deps[0].Name != "rules_go" {
+		t.Errorf("expected included bazel_dep, got %v", m.Deps)
+	}
+
+	_, err = EvalModuleFile("MODULE.bazel", []byte(`include("//deps:go.MODULE.bazel")`),
+		ModuleFileOptions{Key: ModuleKey{Name: "dep", Version: "1"}, FileSystem: fs, WorkspaceRoot: "/ws"})
+	if err == nil || !strings.Contains(err.Error(), "only be used in the root module") {
+		t.Errorf("expected root-only include error, got %v", err)
+	}
+}
+
+// testRegistry builds a local registry of modules with local_path sources.
+func testRegistry(fs *loader.MemoryFileSystem, modules map[string]string) *LocalRegistry {
+	for key, source := range modules {
+		name, version, _ := strings.Cut(key, "@")
+		dir := "/registry/modules/" + name + "/" + version
+		fs.AddFile(dir+"/MODULE.bazel", []byte(source))
+		fs.AddFile(dir+"/source.json", []byte(`{"type": "local_path", "path": "src/`+name+`-`+version+`"}`))
+	}
+	return NewLocalRegistry(fs, "/registry")
+}
+
+func TestResolveMinimalVersionSelection(t *testing.T) {
+	fs := loader.NewMemoryFileSystem()
+	registry := testRegistry(fs, map[string]string{
+		"a@1.0": `module(name = "a", version = "1.0")
+bazel_dep(name = "c", version = "1.1")`,
+		"b@1.0": `module(name = "b", version = "1.0")
+bazel_dep(name = "c", version = "1.3")
+bazel_dep(name = "d", version = "1.0", dev_dependency = True)`,
+		"c@1.1": `module(name = "c", version = "1.1")
+bazel_dep(name = "e", version = "1.0")`,
+		"c@1.3": `module(name = "c", version = "1.3")`,
+		"e@1.0": `module(name = "e", version = "1.0")`,
+	})
+	fs.AddFile("/ws/MODULE.bazel", []byte(`
+module(name = "root", version = "0.1")
+bazel_dep(name = "a", version = "1.0")
+bazel_dep(name = "b", version = "1.0")
+ext = use_extension("@a//:ext.bzl", "ext")
+use_repo(ext, "generated")
+`))
+
+	g, err := Resolve("/ws", ResolveOptions{FileSystem: fs, Registries: []Registry{registry}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var keys []string
+	for _, m := range g.Modules() {
+		keys = append(keys, m.Key.String())
+	}
+	// c@1.1 loses to c@1.3, so e is unreachable; d is a dev dependency of a non-root module.
+	expected := "<root> a@1.0 b@1.0 c@1.3"
+	if got := strings.Join(keys, " "); got != expected {
+		t.Errorf("expected modules %q, got %q", expected, got)
+	}
+
+	a, _ := g.Module(ModuleKey{Name: "a", Version: "1.0"})
+	if dep := a.ResolvedDeps["c"]; dep.Version != "1.3" {
+		t.Errorf("expected a to depend on c@1.3, got %s", dep)
+	}
+
+	mapping := g.RepoMapping(RootModuleKey)
+	if mapping["root"] != "" || mapping["a"] != "a+" || mapping["generated"] != "a++ext+generated" {
+		t.Errorf("unexpected root repo mapping: %v", mapping)
+	}
+	if got := g.Root().ExtensionUsages[0].ExtensionLabel; got != "@@a+//:ext.bzl" {
+		t.Errorf("expected canonical extension label, got %s", got)
+	}
+
+	repos := g.ExternalRepos()
+	if repos["a"] != "/registry/src/a-1.0" || repos["c+"] != "/registry/src/c-1.3" {
+		t.Errorf("unexpected external repos: %v", repos)
+	}
+}
+
+func TestResolveOverrides(t *testing.T) {
+	fs := loader.NewMemoryFileSystem()
+	registry := testRegistry(fs, map[string]string{
+		"a@1.0": `module(name = "a", version = "1.0")`,
+		"a@2.0": `module(name = "a", version = "2.0")`,
+	})
+	fs.AddFile("/ws/third_party/b/MODULE.bazel", []byte(`module(name = "b")
+bazel_dep(name = "a", version = "1.0")`))
+	fs.AddFile("/ws/MODULE.bazel", []byte(`
+bazel_dep(name = "b", version = "3.0")
+single_version_override(module_name = "a", version = "2.0")
+local_path_override(module_name = "b", path = "third_party/b")
+`))
+
+	g, err := Resolve("/ws", ResolveOptions{FileSystem: fs, Registries: []Registry{registry}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, ok := g.Module(ModuleKey{Name: "b"})
+	if !ok || b.Path != "/ws/third_party/b" {
+		t.Fatalf("expected local b module, got %v", b)
+	}
+	if dep := b.ResolvedDeps["a"]; dep.Version != "2.0" {
+		t.Errorf("expected a pinned to 2.0, got %s", dep)
+	}
+}
+
+func TestResolveCompatibilityLevelConflict(t *testing.T) {
+	fs := loader.NewMemoryFileSystem()
+	registry := testRegistry(fs, map[string]string{
+		"a@1.0": `module(name = "a", version = "1.0", compatibility_level = 1)`,
+		"a@2.0": `module(name = "a", version = "2.0", compatibility_level = 2)`,
+		"b@1.0": `module(name = "b", version = "1.0")
+bazel_dep(name = "a", version = "2.0")`,
+	})
+	fs.AddFile("/ws/MODULE.bazel", []byte(`
+bazel_dep(name = "a", version = "1.0")
+bazel_dep(name = "b", version = "1.0")
+`))
+
+	_, err := Resolve("/ws", ResolveOptions{FileSystem: fs, Registries: []Registry{registry}})
+	if err == nil || !strings.Contains(err.Error(), "with compatibility level 2 which is different") {
+		t.Errorf("expected compatibility level error, got %v", err)
+	}
+}
+
+// writeFiles creates files under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tarGz builds a gzipped tarball of files.
+func tarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestRunExtensions(t *testing.T) {
+	ws, downloads, outputBase := t.TempDir(), t.TempDir(), t.TempDir()
+	archive := tarGz(t, map[string]string{"lib-1.0/BUILD.bazel": "# lib", "lib-1.0/defs.bzl": "VERSION = '1.0'"})
+	if err := os.WriteFile(filepath.Join(downloads, "lib-1.0.tar.gz"), archive, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, ws, map[string]string{
+		"MODULE.bazel": `
+module(name = "app")
+deps = use_extension("//:ext.bzl", "deps")
+deps.local(name = "vendored", path = "third_party/vendored")
+deps.archive(name = "lib", url = "https://example.com/releases/lib-1.0.tar.gz")
+deps.archive(name = "test_lib", url = "https://example.com/releases/lib-1.0.tar.gz", dev = True)
+use_repo(deps, "vendored", "lib", my_test_lib = "test_lib")
+`,
+		"third_party/vendored/BUILD.bazel": "",
+		"ext.bzl": `
+load("@bazel_tools//tools/build_defs/repo:local.bzl", "local_repository", "new_local_repository")
+
+def _impl(module_ctx):
+    for mod in module_ctx.modules:
+        for tag in mod.tags.local:
+            local_repository(name = tag.name, path = tag.path)
+    for mod in module_ctx.modules:
+        for tag in mod.tags.archive:
+            result = module_ctx.download_and_extract(tag.url, output = tag.name, strip_prefix = "lib-1.0")
+            module_ctx.file(tag.name + "/label.txt", str(module_ctx.path(Label("//third_party/vendored:BUILD.bazel")).exists))
+            new_local_repository(
+                name = tag.name,
+                path = str(module_ctx.path(tag.name)),
+                build_file_content = module_ctx.read(tag.name + "/BUILD.bazel") + "\n# dev=%s sha=%s" % (tag.dev, result.sha256[:8]),
+            )
+    return module_ctx.extension_metadata(root_module_direct_deps = "all", root_module_direct_dev_deps = [])
+
+_archive = tag_class(attrs = {
+    "name": attr.string(mandatory = True),
+    "url": attr.string(mandatory = True),
+    "dev": attr.bool(),
+})
+
+deps = module_extension(
+    implementation = _impl,
+    tag_classes = {
+        "local": tag_class(attrs = {"name": attr.string(), "path": attr.string()}),
+        "archive": _archive,
+    },
+)
+`,
+	})
+
+	g, err := Resolve(ws, ResolveOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results, err := g.RunExtensions(ExtensionOptions{OutputBase: outputBase, Fetcher: NewDirFetcher(downloads)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != "_main+deps" {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if got := results[0].Metadata.RootModuleDirectDeps; strings.Join(got, ",") != "lib,test_lib,vendored" {
+		t.Errorf("unexpected direct deps: %v", got)
+	}
+
+	roots := g.RepoRoots()
+	if roots["_main+deps+vendored"] != filepath.Join(ws, "third_party/vendored") {
+		t.Errorf("expected local_repository root, got %v", roots)
+	}
+	libRoot := roots["_main+deps+lib"]
+	if libRoot != filepath.Join(outputBase, "external", "_main+deps+lib") {
+		t.Fatalf("unexpected lib root %q", libRoot)
+	}
+	build, err := os.ReadFile(filepath.Join(libRoot, "BUILD.bazel"))
+	if err != nil || !strings.HasPrefix(string(build), "# lib\n# dev=False sha=") {
+		t.Errorf("unexpected BUILD.bazel %q (%v)", build, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(libRoot, "label.txt")); err != nil || string(data) != "True" {
+		t.Errorf("unexpected label.txt %q (%v)", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(libRoot, "defs.bzl")); err != nil || string(data) != "VERSION = '1.0'" {
+		t.Errorf("expected extracted defs.bzl, got %q (%v)", data, err)
+	}
+
+	mapping := g.RepoMappings()["_main+deps+lib"]
+	if mapping["vendored"] != "_main+deps+vendored" || mapping["app"] != "" {
+		t.Errorf("unexpected generated repo mapping: %v", mapping)
+	}
+	if g.RepoMapping(RootModuleKey)["my_test_lib"] != "_main+deps+test_lib" {
+		t.Errorf("unexpected root mapping: %v", g.RepoMapping(RootModuleKey))
+	}
+
+	// Generated repositories are loadable through the root module's mapping.
+	l := loader.NewBzlFileLoader(loader.NewOSFileSystem(ws), ws,
+		loader.WithRepoRoots(g.RepoRoots()), loader.WithRepoMappings(g.RepoMappings()))
+	globals, err := l.Load(&starlark.Thread{}, "@lib//:defs.bzl")
+	if err != nil || globals["VERSION"] != starlark.String("1.0") {
+		t.Errorf("expected to load @lib//:defs.bzl, got %v (%v)", globals, err)
+	}
+}
+
+func TestRunExtensionsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		module string
+		ext    string
+		errMsg string
+	}{
+		{
+			"missing import",
+			`use_repo(use_extension("//:ext.bzl", "ext"), "missing")`,
+			"def _impl(ctx):\n    pass\next = module_extension(implementation = _impl)",
+			`does not generate repository "missing"`,
+		},
+		{
+			"unknown tag class",
+			`use_extension("//:ext.bzl", "ext").other()`,
+			"def _impl(ctx):\n    pass\next = module_extension(implementation = _impl)",
+			"does not have a tag class named other",
+		},
+		{
+			"unknown attribute",
+			`use_extension("//:ext.bzl", "ext").tag(bogus = 1)`,
+			"def _impl(ctx):\n    pass\next = module_extension(implementation = _impl, tag_classes = {\"tag\": tag_class()})",
+			"unknown attribute bogus provided",
+		},
+		{
+			"mandatory attribute",
+			`use_extension("//:ext.bzl", "ext").tag()`,
+			"def _impl(ctx):\n    pass\next = module_extension(implementation = _impl, tag_classes = {\"tag\": tag_class(attrs = {\"x\": attr.string(mandatory = True)})})",
+			"mandatory attribute x isn't being specified",
+		},
+		{
+			"download checksum",
+			`use_extension("//:ext.bzl", "ext")`,
+			"def _impl(ctx):\n    ctx.download(\"https://example.com/MODULE.bazel\", sha256 = \"" + strings.Repeat("0", 64) + "\")\next = module_extension(implementation = _impl)",
+			"Checksum was sha256-",
+		},
+		{
+			"not an extension",
+			`use_extension("//:ext.bzl", "ext")`,
+			"ext = 1",
+			"does not export a module extension called ext",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := t.TempDir()
+			writeFiles(t, ws, map[string]string{"MODULE.bazel": tt.module, "ext.bzl": tt.ext})
+			g, err := Resolve(ws, ResolveOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err = g.RunExtensions(ExtensionOptions{OutputBase: t.TempDir(), Fetcher: NewDirFetcher(ws)})
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func tarXz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	xw, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(xw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	xw.Close()
+	return buf.Bytes()
+}
+
+func TestRepositoryRule(t *testing.T) {
+	ws, outputBase := t.TempDir(), t.TempDir()
+	cache := NewRepositoryCache(t.TempDir(), nil)
+	sum, err := cache.Put(tarXz(t, map[string]string{"tool-2.0/defs.bzl": "TOOL = 'tool'"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, ws, map[string]string{
+		"MODULE.bazel": `
+ext = use_extension("//:ext.bzl", "ext")
+use_repo(ext, "gen")
+`,
+		"BUILD.bazel": "",
+		"template.in": "greeting = '{GREETING}'\n",
+		"ext.bzl": `
+def _gen_impl(ctx):
+    ctx.template("greeting.bzl", ctx.attr.template, {"{GREETING}": ctx.attr.greeting}, executable = False)
+    ctx.file("BUILD.bazel", "")
+    ctx.symlink(ctx.attr.template, "template.in")
+    res = ctx.execute(["sh", "-c", "printf $NAME; exit 3"], environment = {"NAME": ctx.name})
+    ctx.file("exec.txt", "%d %s" % (res.return_code, res.stdout), executable = False)
+    ctx.download_and_extract("https://example.com/tool-2.0.tar.xz", output = "tool", sha256 = ctx.attr.sha256, strip_prefix = "tool-2.0")
+    if not ctx.delete("tool/missing") and ctx.path("tool/defs.bzl").exists:
+        ctx.file("ok.txt", ctx.original_name + " " + ctx.os.name, executable = False)
+
+gen = repository_rule(
+    implementation = _gen_impl,
+    attrs = {
+        "template": attr.label(mandatory = True),
+        "greeting": attr.string(default = "hello"),
+        "sha256": attr.string(),
+    },
+)
+
+def _impl(ctx):
+    gen(name = "gen", template = "//:template.in", sha256 = "` + sum.Hex() + `")
+
+ext = module_extension(implementation = _impl)
+`,
+	})
+	g, err := Resolve(ws, ResolveOptions{})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	results, err := g.RunExtensions(ExtensionOptions{OutputBase: outputBase, Fetcher: cache})
+	if err != nil {
+		t.Fatalf("RunExtensions failed: %v", err)
+	}
+	if def := results[0].Repos["gen"]; def == nil || def.RuleName != "gen" || def.Attrs["greeting"] != starlark.String("hello") {
+		t.Fatalf("unexpected repo definition %+v", def)
+	}
+
+	root := g.RepoRoots()["_main+ext+gen"]
+	for file, want := range map[string]string{
+		"greeting.bzl": "greeting = 'hello'\n",
+		"template.in":  "greeting = '{GREETING}'\n",
+		"exec.txt":     "3 _main+ext+gen",
+		"ok.txt":       "gen " + hostOSName(),
+	} {
+		if data, err := os.ReadFile(filepath.Join(root, file)); err != nil || string(data) != want {
+			t.Errorf("%s: got %q (%v), want %q", file, data, err, want)
+		}
+	}
+
+	l := loader.NewBzlFileLoader(loader.NewOSFileSystem(ws), ws,
+		loader.WithRepoRoots(g.RepoRoots()), loader.WithRepoMappings(g.RepoMappings()))
+	globals, err := l.Load(&starlark.Thread{}, "@gen//tool:defs.bzl")
+	if err != nil || globals["TOOL"] != starlark.String("tool") {
+		t.Errorf("expected to load @gen//tool:defs.bzl, got %v (%v)", globals, err)
+	}
+}
+
+func TestRepositoryRuleErrors(t *testing.T) {
+	const rule = "def _r(ctx):\n    pass\nr = repository_rule(implementation = _r, attrs = {\"x\": attr.string(mandatory = True)})\n"
+	tests := []struct {
+		name   string
+		bzl    string
+		errMsg string
+	}{
+		{"unknown attribute", rule + "def _impl(ctx):\n    r(name = \"a\", x = \"\", y = 1)\n", "no such attribute 'y' in 'r' rule"},
+		{"mandatory attribute", rule + "def _impl(ctx):\n    r(name = \"a\")\n", "missing value for mandatory attribute 'x'"},
+		{"duplicate", rule + "def _impl(ctx):\n    r(name = \"a\", x = \"\")\n    r(name = \"a\", x = \"\")\n", "A repo named a is already defined"},
+		{"invalid name", rule + "def _impl(ctx):\n    r(name = \"-a\", x = \"\")\n", "invalid user-provided repo name '-a'"},
+		{"top level", rule + "r(name = \"a\", x = \"\")\ndef _impl(ctx):\n    pass\n", "repo rules can only be called from within module extension impl functions"},
+		{"name attribute", "r = repository_rule(implementation = print, attrs = {\"name\": attr.string()})\ndef _impl(ctx):\n    pass\n", "attribute 'name' is implicitly defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := t.TempDir()
+			writeFiles(t, ws, map[string]string{
+				"MODULE.bazel": `use_extension("//:ext.bzl", "ext")`,
+				"ext.bzl":      tt.bzl + "ext = module_extension(implementation = _impl)\n",
+			})
+			g, err := Resolve(ws, ResolveOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, err = g.RunExtensions(ExtensionOptions{OutputBase: t.TempDir()})
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestEvalWorkspace(t *testing.T) {
+	ws, outputBase := t.TempDir(), t.TempDir()
+	writeFiles(t, ws, map[string]string{
+		"WORKSPACE": `
+workspace(name = "app")
+local_repository(name = "rules_foo", path = "third_party/rules_foo")
+local_repository(name = "rules_foo", path = "third_party/rules_foo_v2")
+bind(name = "zlib", actual = "@zlib//:zlib")
+register_toolchains("//toolchains:all")
+
+load("@rules_foo//:deps.bzl", "foo_deps")
+foo_deps()
+register_execution_platforms("@rules_foo//:platform")
+
+load("@zlib//:version.bzl", "VERSION")
+bind(name = "zlib_version_" + VERSION)
+`,
+		"WORKSPACE.bazel.bak": "",
+		"third_party/rules_foo_v2/deps.bzl": `
+def foo_deps():
+    if not native.existing_rule("zlib"):
+        native.new_local_repository(name = "zlib", path = "third_party/zlib", build_file_content = "# zlib")
+    native.new_local_repository(name = "unused", path = "third_party/zlib", build_file = "@app//:unused.BUILD")
+`,
+		"third_party/zlib/version.bzl": `VERSION = "1"`,
+		"unused.BUILD":                 "# unused",
+	})
+	w, err := EvalWorkspace(WorkspaceOptions{WorkspaceRoot: ws, OutputBase: outputBase})
+	if err != nil {
+		t.Fatalf("EvalWorkspace failed: %v", err)
+	}
+	if w.Name != "app" {
+		t.Errorf("expected workspace name app, got %q", w.Name)
+	}
+	if got := w.RepoRoots["rules_foo"]; got != filepath.Join(ws, "third_party", "rules_foo_v2") {
+		t.Errorf("expected the last definition of rules_foo to win, got %q", got)
+	}
+	for name, want := range map[string]string{"zlib": "# zlib", "unused": "# unused"} {
+		if data, err := os.ReadFile(filepath.Join(w.RepoRoots[name], "BUILD.bazel")); err != nil || string(data) != want {
+			t.Errorf("%s: unexpected BUILD.bazel %q (%v)", name, data, err)
+		}
+	}
+	if w.Repos["zlib"].RuleName != "new_local_repository" {
+		t.Errorf("unexpected zlib definition %+v", w.Repos["zlib"])
+	}
+	if w.Bindings["zlib"] != "@zlib//:zlib" || w.Bindings["zlib_version_1"] != "" || len(w.Bindings) != 2 {
+		t.Errorf("unexpected bindings %v", w.Bindings)
+	}
+	if len(w.Toolchains) != 1 || w.Toolchains[0] != "//toolchains:all" {
+		t.Errorf("unexpected toolchains %v", w.Toolchains)
+	}
+	if len(w.ExecutionPlatforms) != 1 || w.ExecutionPlatforms[0] != "@rules_foo//:platform" {
+		t.Errorf("unexpected execution platforms %v", w.ExecutionPlatforms)
+	}
+}
+
+func TestEvalWorkspaceErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		workspace string
+		errMsg    string
+	}{
+		{
+			"redefine after load",
+			"local_repository(name = \"a\", path = \"a\")\nload(\"@a//:a.bzl\", \"A\")\nlocal_repository(name = \"a\", path = \"a\")",
+			"Cannot redefine repository after any load statement in the WORKSPACE file (for repository 'a')",
+		},
+		{
+			"workspace not first",
+			"local_repository(name = \"a\", path = \"a\")\nworkspace(name = \"app\")",
+			"workspace() function should be used only at the top of the WORKSPACE file",
+		},
+		{"invalid workspace name", `workspace(name = "1app")`, "invalid workspace name '1app'"},
+		{"unknown repository", `load("@missing//:a.bzl", "A")`, `unknown repository "missing"`},
+		{"missing path", `local_repository(name = "b", path = "b")`, "but it does not exist or is not a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := t.TempDir()
+			writeFiles(t, ws, map[string]string{"a/a.bzl": "A = 1", "a/BUILD.bazel": ""})
+			_, err := EvalWorkspaceFile(filepath.Join(ws, "WORKSPACE"), []byte(tt.workspace), WorkspaceOptions{WorkspaceRoot: ws, OutputBase: t.TempDir()})
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestLockfile(t *testing.T) {
+	ws := t.TempDir()
+	registry := filepath.Join(ws, "registry")
+	for _, version := range []string{"1.0", "1.1"} {
+		writeFiles(t, registry, map[string]string{
+			"modules/foo/" + version + "/MODULE.bazel": `module(name = "foo", version = "` + version + `")`,
+			"modules/foo/" + version + "/source.json":  `{"type": "local_path", "path": "foo"}`,
+		})
+	}
+	writeFiles(t, ws, map[string]string{
+		"registry/foo/BUILD.bazel": "",
+		"ext.bzl": `
+load("@bazel_tools//tools/build_defs/repo:local.bzl", "local_repository")
+
+def _impl(ctx):
+    for mod in ctx.modules:
+        for tag in mod.tags.repo:
+            local_repository(name = tag.name, path = "registry/foo")
+
+ext = module_extension(implementation = _impl, tag_classes = {"repo": tag_class(attrs = {"name": attr.string()})})
+`,
+	})
+	resolve := func(module string) *ModuleGraph {
+		t.Helper()
+		writeFiles(t, ws, map[string]string{"MODULE.bazel": module})
+		g, err := Resolve(ws, ResolveOptions{Registries: []Registry{NewLocalRegistry(nil, registry)}})
+		if err != nil {
+			t.Fatalf("Resolve failed: %v", err)
+		}
+		return g
+	}
+	const module = `
+bazel_dep(name = "foo", version = "1.0")
+ext = use_extension("//:ext.bzl", "ext")
+ext.repo(name = "a")
+use_repo(ext, "a")
+`
+	g := resolve(module)
+	results, err := g.RunExtensions(ExtensionOptions{OutputBase: t.TempDir()})
+	if err != nil {
+		t.Fatalf("RunExtensions failed: %v", err)
+	}
+	recorded, err := g.Lockfile(results)
+	if err != nil {
+		t.Fatalf("Lockfile failed: %v", err)
+	}
+	data, err := recorded.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, ws, map[string]string{LockfileName: string(data)})
+	lf, err := ReadLockfile(nil, ws)
+	if err != nil {
+		t.Fatalf("ReadLockfile failed: %v", err)
+	}
+	if spec := lf.ModuleExtensions["@@//:ext.bzl%ext"]["general"].GeneratedRepoSpecs["a"]; spec == nil || spec.Attributes["path"] != "registry/foo" {
+		t.Fatalf("unexpected lockfile:\n%s", data)
+	}
+	if drifts, err := g.CheckLockfile(lf, LockfileCheckOptions{}); err != nil || len(drifts) != 0 {
+		t.Fatalf("expected no drift, got %v (%v)", drifts, err)
+	}
+
+	// A version bump, a new tag and an edited registry file all drift.
+	writeFiles(t, registry, map[string]string{"modules/foo/1.1/MODULE.bazel": `module(name = "foo", version = "1.1")` + "\n"})
+	g = resolve(strings.Replace(module, `"1.0"`, `"1.1"`, 1) + "ext.repo(name = \"b\")\n")
+	drifts, err := g.CheckLockfile(lf, LockfileCheckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range drifts {
+		got = append(got, string(d.Kind)+": "+d.Message)
+	}
+	want := []string{
+		"extension: module extension @@//:ext.bzl%ext is stale: its usages have changed",
+		"module_version: module foo resolves to version 1.1 but the lockfile has 1.0",
+		"registry_file: registry file file://" + registry + "/modules/foo/1.1/MODULE.bazel is not in the lockfile",
+		"registry_file: registry file file://" + registry + "/modules/foo/1.1/source.json is not in the lockfile",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected drift:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCheckLockfileBazelFormat(t *testing.T) {
+	fs := loader.NewMemoryFileSystem()
+	registry := testRegistry(fs, map[string]string{"foo@1.0": `module(name = "foo", version = "1.0")`})
+	fs.AddFile("/ws/MODULE.bazel", []byte(`
+bazel_dep(name = "foo", version = "1.0")
+ext = use_extension("@foo//:ext.bzl", "ext")
+use_repo(ext, "a", "b")
+`))
+	g, err := Resolve("/ws", ResolveOptions{FileSystem: fs, Registries: []Registry{registry}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf, err := ParseLockfile([]byte(`{
+  "lockFileVersion": 11,
+  "registryFileHashes": {
+    "file:///registry/modules/foo/1.0/MODULE.bazel": "` + sha256Hex([]byte(`module(name = "foo", version = "1.0")`)) + `",
+    "file:///registry/modules/foo/1.0/source.json": null
+  },
+  "moduleDepGraph": {
+    "<root>": {"name": "", "version": "", "key": "<root>"},
+    "foo@1.0": {"name": "foo", "version": "1.0", "key": "foo@1.0"},
+    "bar@2.0": {"name": "bar", "version": "2.0", "key": "bar@2.0"}
+  },
+  "moduleExtensions": {
+    "@@foo+//:ext.bzl%ext": {"os:linux": {"usagesDigest": "x", "generatedRepoSpecs": {"a": {"bzlFile": "@@foo+//:r.bzl", "ruleClassName": "r", "attributes": {}}}}},
+    "@@foo+//:old.bzl%old": {"general": {"generatedRepoSpecs": {}}}
+  }
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	drifts, err := g.CheckLockfile(lf, LockfileCheckOptions{SkipUsagesDigests: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range drifts {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"module extension @@foo+//:ext.bzl%ext is stale: <root> imports repository b, which the lockfile does not record",
+		"module extension @@foo+//:old.bzl%old is in the lockfile but no longer used",
+		"module bar@2.0 is in the lockfile but no longer in the dependency graph",
+		"registry file file:///registry/modules/foo/1.0/source.json has changed: the lockfile has hash (missing), the file has " + sha256Hex([]byte(`{"type": "local_path", "path": "src/foo-1.0"}`)),
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected drift:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
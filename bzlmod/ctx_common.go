@@ -0,0 +1,429 @@
+package bzlmod
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// ioContext implements the file, environment and download methods shared
+// by module_ctx and repository_ctx.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/starlark/StarlarkBaseExternalContext.java
+type ioContext struct {
+	// kind is "module_ctx" or "repository_ctx", used in error messages.
+	kind string
+
+	// workDir is the directory relative paths are resolved against.
+	workDir string
+
+	// labelPath resolves Label arguments to files.
+	labelPath func(*types.Label) (string, error)
+
+	fetcher Fetcher
+	environ map[string]string
+}
+
+// resolvePath converts a string, Label or path argument to a Path. Labels
+// resolve to the file in their repository.
+// Reference: StarlarkBaseExternalContext.java path()
+func (c *ioContext) resolvePath(v starlark.Value, what string) (*Path, error) {
+	switch v := v.(type) {
+	case *Path:
+		return v, nil
+	case starlark.String:
+		p := string(v)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(c.workDir, p)
+		}
+		return NewPath(p), nil
+	case *types.Label:
+		p, err := c.labelPath(v)
+		if err != nil {
+			return nil, err
+		}
+		return NewPath(p), nil
+	}
+	return nil, fmt.Errorf("%s: got %s for '%s', want string, Label or path", c.kind, v.Type(), what)
+}
+
+// attr returns the builtin or value shared by module_ctx and repository_ctx
+// with the given name.
+func (c *ioContext) attr(name string) (starlark.Value, bool) {
+	var fn func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error)
+	switch name {
+	case "path":
+		fn = c.path
+	case "read":
+		fn = c.read
+	case "file":
+		fn = c.file
+	case "getenv":
+		fn = c.getenv
+	case "which":
+		fn = c.which
+	case "download":
+		fn = c.download
+	case "download_and_extract":
+		fn = c.downloadAndExtract
+	case "extract":
+		fn = c.extract
+	case "report_progress":
+		fn = func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+			return starlark.None, nil
+		}
+	case "os":
+		return c.os(), true
+	default:
+		return nil, false
+	}
+	return starlark.NewBuiltin(name, fn), true
+}
+
+// ioAttrNames are the attribute names handled by ioContext.attr.
+var ioAttrNames = []string{"download", "download_and_extract", "extract", "file", "getenv", "os", "path", "read", "report_progress", "which"}
+
+// Reference: StarlarkBaseExternalContext.java path()
+func (c *ioContext) path(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var p starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &p); err != nil {
+		return nil, err
+	}
+	return c.resolvePath(p, "path")
+}
+
+// Reference: StarlarkBaseExternalContext.java readFile()
+func (c *ioContext) read(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		p     starlark.Value
+		watch string
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &p, "watch?", &watch); err != nil {
+		return nil, err
+	}
+	resolved, err := c.resolvePath(p, "path")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(resolved.Path())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.String(data), nil
+}
+
+// Reference: StarlarkBaseExternalContext.java createFile()
+func (c *ioContext) file(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		p          starlark.Value
+		content    string
+		executable = true
+		legacyUTF8 = true
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"path", &p, "content?", &content, "executable?", &executable, "legacy_utf8?", &legacyUTF8,
+	); err != nil {
+		return nil, err
+	}
+	resolved, err := c.resolvePath(p, "path")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileBytes(resolved.Path(), []byte(content), executable); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+// Reference: StarlarkBaseExternalContext.java getEnvironmentValue()
+func (c *ioContext) getenv(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		name string
+		def  starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "default?", &def); err != nil {
+		return nil, err
+	}
+	if v, ok := c.environ[name]; ok {
+		return starlark.String(v), nil
+	}
+	return def, nil
+}
+
+// which searches PATH from the context's environment.
+// Reference: StarlarkBaseExternalContext.java which()
+func (c *ioContext) which(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var program string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "program", &program); err != nil {
+		return nil, err
+	}
+	if strings.ContainsRune(program, filepath.Separator) {
+		return nil, fmt.Errorf("Program argument of which() may not contain a / or a \\ ('%s' given)", program)
+	}
+	if found, ok := lookPath(program, c.environ["PATH"]); ok {
+		return NewPath(found), nil
+	}
+	if _, ok := c.environ["PATH"]; !ok {
+		if found, err := exec.LookPath(program); err == nil {
+			return NewPath(found), nil
+		}
+	}
+	return starlark.None, nil
+}
+
+// lookPath finds an executable program in the directories of path.
+func lookPath(program, path string) (string, bool) {
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			continue
+		}
+		candidate := filepath.Join(dir, program)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() && info.Mode()&0o111 != 0 {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// os returns the repository_os value.
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/starlark/StarlarkOS.java
+func (c *ioContext) os() starlark.Value {
+	environ := starlark.NewDict(len(c.environ))
+	keys := make([]string, 0, len(c.environ))
+	for k := range c.environ {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		environ.SetKey(starlark.String(k), starlark.String(c.environ[k]))
+	}
+	return starlarkstruct.FromStringDict(starlark.String("repository_os"), starlark.StringDict{
+		"name":    starlark.String(hostOSName()),
+		"arch":    starlark.String(hostArch()),
+		"environ": environ,
+	})
+}
+
+// hostOSName returns the Java os.name of the host, lower-cased as Bazel does.
+func hostOSName() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "mac os x"
+	case "windows":
+		return "windows"
+	}
+	return runtime.GOOS
+}
+
+// hostArch returns the Java os.arch of the host.
+func hostArch() string {
+	switch runtime.GOARCH {
+	case "arm64":
+		return "aarch64"
+	case "386":
+		return "x86"
+	}
+	return runtime.GOARCH
+}
+
+// downloadURLs unpacks the url argument of download functions: a string or
+// a sequence of mirror URLs.
+func downloadURLs(v starlark.Value) ([]string, error) {
+	switch v := v.(type) {
+	case starlark.String:
+		return []string{string(v)}, nil
+	case starlark.Iterable:
+		var urls []string
+		iter := v.Iterate()
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			s, ok := x.(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("got %s in 'url', want string", x.Type())
+			}
+			urls = append(urls, string(s))
+		}
+		return urls, nil
+	}
+	return nil, fmt.Errorf("got %s for 'url', want string or sequence of strings", v.Type())
+}
+
+// downloadResult is the struct returned by download functions.
+// Reference: StarlarkBaseExternalContext.java calculateDownloadResult()
+func downloadResult(success bool, data []byte) starlark.Value {
+	fields := starlark.StringDict{"success": starlark.Bool(success)}
+	if success {
+		integrity := SHA256Integrity(data)
+		checksum, _ := ParseChecksum("", integrity)
+		fields["sha256"] = starlark.String(checksum.Hex())
+		fields["integrity"] = starlark.String(integrity)
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, fields)
+}
+
+// Reference: StarlarkBaseExternalContext.java download()
+func (c *ioContext) download(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		url                   starlark.Value
+		output                starlark.Value = starlark.String("")
+		sha256, integrity     string
+		canonicalID           string
+		executable, allowFail bool
+		block                 = true
+		auth, headers         starlark.Value
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"url", &url, "output?", &output, "sha256?", &sha256, "executable?", &executable,
+		"allow_fail?", &allowFail, "canonical_id?", &canonicalID, "auth?", &auth, "headers?", &headers,
+		"integrity?", &integrity, "block?", &block,
+	); err != nil {
+		return nil, err
+	}
+	urls, err := downloadURLs(url)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	checksum, err := ParseChecksum(sha256, integrity)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	if s, ok := output.(starlark.String); ok && s == "" && len(urls) > 0 {
+		output = starlark.String(path.Base(urls[0]))
+	}
+	dest, err := c.resolvePath(output, "output")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := fetchFirst(c.fetcher, urls, checksum)
+	if err != nil {
+		if allowFail {
+			return downloadResult(false, nil), nil
+		}
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	if err := writeFileBytes(dest.Path(), data, executable); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return downloadResult(true, data), nil
+}
+
+// Reference: StarlarkBaseExternalContext.java downloadAndExtract()
+func (c *ioContext) downloadAndExtract(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		url                            starlark.Value
+		output                         starlark.Value = starlark.String("")
+		sha256, integrity, archiveKind string
+		stripPrefix, canonicalID       string
+		allowFail                      bool
+		auth, headers, renameFiles     starlark.Value
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"url", &url, "output?", &output, "sha256?", &sha256, "type?", &archiveKind,
+		"strip_prefix?", &stripPrefix, "allow_fail?", &allowFail, "canonical_id?", &canonicalID,
+		"auth?", &auth, "headers?", &headers, "integrity?", &integrity, "rename_files?", &renameFiles,
+	); err != nil {
+		return nil, err
+	}
+	urls, err := downloadURLs(url)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	checksum, err := ParseChecksum(sha256, integrity)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	dest, err := c.resolvePath(output, "output")
+	if err != nil {
+		return nil, err
+	}
+	kind, err := archiveType(path.Base(urls[0]), archiveKind)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	data, err := fetchFirst(c.fetcher, urls, checksum)
+	if err != nil {
+		if allowFail {
+			return downloadResult(false, nil), nil
+		}
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	if err := extractArchive(data, kind, dest.Path(), stripPrefix); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return downloadResult(true, data), nil
+}
+
+// Reference: StarlarkBaseExternalContext.java extract()
+func (c *ioContext) extract(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		archive                  starlark.Value
+		output                   starlark.Value = starlark.String("")
+		stripPrefix, archiveKind string
+		renameFiles              starlark.Value
+		watchArchive             string
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"archive", &archive, "output?", &output, "strip_prefix?", &stripPrefix,
+		"rename_files?", &renameFiles, "watch_archive?", &watchArchive, "type?", &archiveKind,
+	); err != nil {
+		return nil, err
+	}
+	src, err := c.resolvePath(archive, "archive")
+	if err != nil {
+		return nil, err
+	}
+	dest, err := c.resolvePath(output, "output")
+	if err != nil {
+		return nil, err
+	}
+	kind, err := archiveType(src.Path(), archiveKind)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	data, err := os.ReadFile(src.Path())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	if err := extractArchive(data, kind, dest.Path(), stripPrefix); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+// writeFileBytes writes data to p, creating parent directories.
+func writeFileBytes(p string, data []byte, executable bool) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	perm := os.FileMode(0o644)
+	if executable {
+		perm = 0o755
+	}
+	if err := os.WriteFile(p, data, perm); err != nil {
+		return err
+	}
+	return os.Chmod(p, perm)
+}
+
+// clientEnviron returns the process environment as a map.
+func clientEnviron() map[string]string {
+	environ := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			environ[k] = v
+		}
+	}
+	return environ
+}
@@ -0,0 +1,9 @@
+// Package bzlmod implements Bazel's external dependency system: evaluation of
+// MODULE.bazel files, module resolution against registries, the
+// repository mappings derived from the resulting module graph, module
+// extensions and the repository rules they call, MODULE.bazel.lock drift
+// checks, and the legacy WORKSPACE dialect. Downloads are served by a
+// local Fetcher or a RepositoryCache; nothing is fetched from the network.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/
+package bzlmod
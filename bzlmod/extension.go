@@ -0,0 +1,293 @@
+package bzlmod
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/albertocavalcante/starlark-go-bazel/builtins"
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// ExtensionOptions configures the evaluation of module extensions.
+type ExtensionOptions struct {
+	// OutputBase is the directory generated repositories and extension
+	// working directories are created under. Required.
+	OutputBase string
+
+	// FileSystem reads the .bzl files defining extensions. Defaults to the
+	// OS file system.
+	FileSystem loader.FileSystem
+
+	// Predeclared are the globals of .bzl files. Defaults to Predeclared().
+	Predeclared starlark.StringDict
+
+	// Fetcher serves module_ctx.download() and download_and_extract().
+	Fetcher Fetcher
+
+	// Environ is the environment seen by module_ctx.getenv() and
+	// module_ctx.os.environ. Defaults to the process environment.
+	Environ map[string]string
+
+	// Print receives print() output. Defaults to discarding it.
+	Print func(msg string)
+}
+
+// ExtensionResult is the outcome of evaluating one module extension.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/SingleExtensionEvalValue.java
+type ExtensionResult struct {
+	// ID is the extension's unique identifier, the prefix of the canonical
+	// names of its repositories.
+	ID string
+
+	// Label is the canonical label of the .bzl file defining the extension
+	// and Name the name it is exported as.
+	Label string
+	Name  string
+
+	// Repos are the generated repositories keyed by the name passed to the
+	// repository rule.
+	Repos map[string]*RepoDefinition
+
+	// Metadata is the value returned by the implementation function, or nil.
+	Metadata *ExtensionMetadata
+}
+
+// CanonicalRepoName returns the canonical name of a generated repository.
+func (r *ExtensionResult) CanonicalRepoName(name string) string {
+	return r.ID + "+" + name
+}
+
+// RunExtensions evaluates every module extension used in the graph, in the
+// order the extensions are first used, and fetches the repositories they
+// generate into <output base>/external. The generated repositories are added
+// to RepoRoots and RepoMappings.
+//
+// Repositories are fetched eagerly once the implementation function returns;
+// Bazel fetches them lazily when first needed.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/SingleExtensionEvalFunction.java
+func (g *ModuleGraph) RunExtensions(opts ExtensionOptions) ([]*ExtensionResult, error) {
+	if opts.OutputBase == "" {
+		return nil, fmt.Errorf("module extensions require an output base")
+	}
+	if opts.FileSystem == nil {
+		opts.FileSystem = loader.NewOSFileSystem(g.workspaceRoot)
+	}
+	if opts.Predeclared == nil {
+		opts.Predeclared = Predeclared()
+	}
+	if opts.Environ == nil {
+		opts.Environ = clientEnviron()
+	}
+
+	ids, usages := g.extensionUsages()
+	results := make([]*ExtensionResult, 0, len(ids))
+	for _, id := range ids {
+		result, err := g.runExtension(id, usages[id], opts)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// extensionUsages groups the extension usages of the graph by extension ID
+// and module. The IDs are returned in the order the extensions are first
+// used.
+func (g *ModuleGraph) extensionUsages() ([]string, map[string]map[ModuleKey][]*ExtensionUsage) {
+	var (
+		ids    []string
+		usages = make(map[string]map[ModuleKey][]*ExtensionUsage)
+	)
+	for _, key := range g.order {
+		for _, usage := range g.modules[key].ExtensionUsages {
+			if usages[usage.ExtensionID] == nil {
+				ids = append(ids, usage.ExtensionID)
+				usages[usage.ExtensionID] = make(map[ModuleKey][]*ExtensionUsage)
+			}
+			usages[usage.ExtensionID][key] = append(usages[usage.ExtensionID][key], usage)
+		}
+	}
+	return ids, usages
+}
+
+// runExtension evaluates one extension and fetches its repositories.
+func (g *ModuleGraph) runExtension(id string, usages map[ModuleKey][]*ExtensionUsage, opts ExtensionOptions) (*ExtensionResult, error) {
+	var first *ExtensionUsage
+	for _, key := range g.order {
+		if u := usages[key]; len(u) > 0 {
+			first = u[0]
+			break
+		}
+	}
+	extLabel, err := types.ParseLabel(first.ExtensionLabel)
+	if err != nil {
+		return nil, err
+	}
+	hostMapping := g.mappingOf(extLabel.Repo())
+
+	l := loader.NewBzlFileLoader(opts.FileSystem, g.workspaceRoot,
+		loader.WithPredeclared(opts.Predeclared),
+		loader.WithRepoRoots(g.RepoRoots()),
+		loader.WithRepoMappings(g.RepoMappings()),
+		loader.WithBuiltinModule(LocalRepoRulesLabel, LocalRepoRules()),
+	)
+	thread := &starlark.Thread{
+		Name: "module extension " + id,
+		Load: l.Load,
+		Print: func(_ *starlark.Thread, msg string) {
+			if opts.Print != nil {
+				opts.Print(msg)
+			}
+		},
+	}
+	loader.SetBzlLoader(thread, l)
+
+	globals, err := l.Load(thread, first.ExtensionLabel)
+	if err != nil {
+		return nil, err
+	}
+	ext, ok := globals[first.ExtensionName].(*builtins.ModuleExtension)
+	if !ok {
+		return nil, fmt.Errorf("%s does not export a module extension called %s, yet its use is requested at %s", first.ExtensionLabel, first.ExtensionName, first.Location)
+	}
+
+	// Build module_ctx.modules in breadth-first order from the root.
+	ctx := &ModuleCtx{
+		io: ioContext{
+			kind:      "module_ctx",
+			workDir:   filepath.Join(opts.OutputBase, "modextwd", id),
+			labelPath: (&FetchContext{WorkspaceRoot: g.workspaceRoot, RepoRoots: g.RepoRoots()}).LabelPath,
+			fetcher:   opts.Fetcher,
+			environ:   opts.Environ,
+		},
+		devTags: make(map[*starlarkstruct.Struct]bool),
+	}
+	var modules []starlark.Value
+	for _, key := range g.order {
+		if len(usages[key]) == 0 {
+			continue
+		}
+		m := g.modules[key]
+		lc := types.LabelContext{
+			Repo:        key.CanonicalRepoName(),
+			RepoMapping: types.NewRepoMapping(key.CanonicalRepoName(), g.mappings[key]),
+		}
+		bazelModule, err := newBazelModule(m, usages[key], ext, lc, ctx.devTags)
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, bazelModule)
+		if key == RootModuleKey {
+			for _, u := range usages[key] {
+				ctx.rootHasNonDevDependency = ctx.rootHasNonDevDependency || u.HasNonDevUseExtension
+			}
+		}
+	}
+	ctx.modules = starlark.NewList(modules)
+	ctx.Freeze()
+
+	if err := os.RemoveAll(ctx.io.workDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(ctx.io.workDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// Run the implementation function with repository rules enabled.
+	repos := NewRepoSet(id + "+")
+	SetRepoDefiner(thread, repos)
+	types.SetLabelContext(thread, &types.LabelContext{
+		Repo:        extLabel.Repo(),
+		Pkg:         extLabel.Pkg(),
+		RepoMapping: types.NewRepoMapping(extLabel.Repo(), hostMapping),
+	})
+	ret, err := starlark.Call(thread, ext.Implementation(), starlark.Tuple{ctx}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error evaluating module extension %s in %s: %w", first.ExtensionName, first.ExtensionLabel, err)
+	}
+
+	result := &ExtensionResult{ID: id, Label: first.ExtensionLabel, Name: first.ExtensionName, Repos: repos.Definitions()}
+	switch ret := ret.(type) {
+	case starlark.NoneType:
+	case *ExtensionMetadata:
+		if err := ret.finish(result.Repos); err != nil {
+			return nil, fmt.Errorf("module extension %s in %s: %w", first.ExtensionName, first.ExtensionLabel, err)
+		}
+		result.Metadata = ret
+	default:
+		return nil, fmt.Errorf("expected module extension %s in %s to return None or extension_metadata, got %s", first.ExtensionName, first.ExtensionLabel, ret.Type())
+	}
+
+	// Every imported repository must have been generated.
+	// Reference: SingleExtensionEvalFunction.java validateAllImportsAreGenerated()
+	for _, key := range g.order {
+		for _, u := range usages[key] {
+			for _, local := range sortedKeys(u.Imports) {
+				exported := u.Imports[local]
+				if _, ok := repos.Get(exported); !ok {
+					return nil, fmt.Errorf("module extension \"%s\" from \"%s\" does not generate repository \"%s\", yet it is imported as \"%s\" in the usage at %s",
+						first.ExtensionName, first.ExtensionLabel, exported, local, u.Location)
+				}
+			}
+		}
+	}
+
+	// Generated repositories see the repositories of the module hosting the
+	// extension and each other.
+	// Reference: RepositoryMappingFunction.java computeFromBzlmod()
+	mapping := make(map[string]string, len(hostMapping)+len(result.Repos))
+	for apparent, canonical := range hostMapping {
+		mapping[apparent] = canonical
+	}
+	for _, name := range repos.Names() {
+		mapping[name] = repos.CanonicalName(name)
+	}
+
+	roots, err := repos.Fetch(FetchOptions{
+		OutputBase:    opts.OutputBase,
+		WorkspaceRoot: g.workspaceRoot,
+		Fetcher:       opts.Fetcher,
+		Environ:       opts.Environ,
+		Print:         opts.Print,
+		RepoRoots:     g.RepoRoots(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for canonical, root := range roots {
+		g.generatedRoots[canonical] = root
+		g.generatedMappings[canonical] = mapping
+	}
+	return result, nil
+}
+
+// mappingOf returns the repo mapping of a module or generated repository.
+func (g *ModuleGraph) mappingOf(canonical string) map[string]string {
+	for key, mapping := range g.mappings {
+		if key.CanonicalRepoName() == canonical {
+			return mapping
+		}
+	}
+	if mapping, ok := g.generatedMappings[canonical]; ok {
+		return mapping
+	}
+	return g.mappings[RootModuleKey]
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,253 @@
+package bzlmod
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Fetcher retrieves the contents of download URLs for module_ctx.download()
+// and repository_ctx.download(). There is no network access: every
+// implementation serves URLs from local data.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/downloader/DownloadManager.java
+type Fetcher interface {
+	// Fetch returns the contents of url, or an error wrapping
+	// fs.ErrNotExist if the fetcher does not have it.
+	Fetch(url string) (io.ReadCloser, error)
+}
+
+// DirFetcher serves URLs from a directory: a URL is looked up by its last
+// path segment, so "https://example.com/releases/foo-1.0.tar.gz" is read
+// from <Dir>/foo-1.0.tar.gz. file:// URLs are read directly.
+type DirFetcher struct {
+	Dir string
+}
+
+var _ Fetcher = (*DirFetcher)(nil)
+
+// NewDirFetcher creates a DirFetcher serving files from dir.
+func NewDirFetcher(dir string) *DirFetcher { return &DirFetcher{Dir: dir} }
+
+// Fetch opens the file the URL maps to.
+func (f *DirFetcher) Fetch(rawURL string) (io.ReadCloser, error) {
+	if p, ok := strings.CutPrefix(rawURL, "file://"); ok {
+		return os.Open(filepath.FromSlash(p))
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." || f.Dir == "" {
+		return nil, fmt.Errorf("cannot fetch %s: %w", rawURL, os.ErrNotExist)
+	}
+	return os.Open(filepath.Join(f.Dir, name))
+}
+
+// ChecksumFetcher is a Fetcher that can also serve content by checksum. When
+// a download specifies a checksum, it is looked up by checksum before any URL
+// is tried.
+type ChecksumFetcher interface {
+	Fetcher
+
+	// FetchChecksum returns the content with the given checksum, or an error
+	// wrapping fs.ErrNotExist.
+	FetchChecksum(sum Checksum) (io.ReadCloser, error)
+}
+
+// RepositoryCache is a content-addressed store of downloads using the layout
+// of Bazel's repository cache:
+//
+//	<dir>/content_addressable/<algorithm>/<hex digest>/file
+//
+// Downloads with a checksum are served from the store; other URLs are passed
+// to Fallback, if set.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/cache/RepositoryCache.java
+type RepositoryCache struct {
+	Dir      string
+	Fallback Fetcher
+}
+
+var _ ChecksumFetcher = (*RepositoryCache)(nil)
+
+// NewRepositoryCache creates a RepositoryCache stored in dir.
+func NewRepositoryCache(dir string, fallback Fetcher) *RepositoryCache {
+	return &RepositoryCache{Dir: dir, Fallback: fallback}
+}
+
+// Fetch passes the URL to the fallback fetcher.
+func (c *RepositoryCache) Fetch(url string) (io.ReadCloser, error) {
+	if c.Fallback == nil {
+		return nil, fmt.Errorf("cannot fetch %s: %w", url, os.ErrNotExist)
+	}
+	return c.Fallback.Fetch(url)
+}
+
+// FetchChecksum opens the cache entry for sum.
+func (c *RepositoryCache) FetchChecksum(sum Checksum) (io.ReadCloser, error) {
+	if sum.IsEmpty() {
+		return nil, fmt.Errorf("empty checksum: %w", os.ErrNotExist)
+	}
+	return os.Open(c.entryPath(sum.Algorithm(), sum.Hex()))
+}
+
+// Put adds data to the cache under its sha256 digest and returns the
+// checksum.
+func (c *RepositoryCache) Put(data []byte) (Checksum, error) {
+	sum := sha256.Sum256(data)
+	checksum := Checksum{algorithm: "sha256", digest: sum[:]}
+	p := c.entryPath(checksum.Algorithm(), checksum.Hex())
+	if err := writeFileBytes(p, data, false); err != nil {
+		return Checksum{}, err
+	}
+	return checksum, nil
+}
+
+func (c *RepositoryCache) entryPath(algorithm, digest string) string {
+	return filepath.Join(c.Dir, "content_addressable", algorithm, digest, "file")
+}
+
+// Checksum is an expected digest of downloaded content, given either as a
+// sha256 hex string or as a Subresource Integrity value.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/cache/RepositoryCache.java KeyType
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/downloader/Checksum.java
+type Checksum struct {
+	algorithm string
+	digest    []byte
+}
+
+// ParseChecksum parses the sha256 and integrity arguments of a download
+// call. Both may be empty, in which case the zero Checksum is returned and
+// any content is accepted.
+func ParseChecksum(sha256Hex, integrity string) (Checksum, error) {
+	if sha256Hex != "" && integrity != "" {
+		return Checksum{}, errors.New("expected either 'sha256' or 'integrity', but not both")
+	}
+	if sha256Hex != "" {
+		digest, err := hex.DecodeString(sha256Hex)
+		if err != nil || len(digest) != sha256.Size {
+			return Checksum{}, fmt.Errorf("invalid SHA-256 checksum '%s'", sha256Hex)
+		}
+		return Checksum{algorithm: "sha256", digest: digest}, nil
+	}
+	if integrity != "" {
+		algorithm, encoded, ok := strings.Cut(integrity, "-")
+		if !ok || newHash(algorithm) == nil {
+			return Checksum{}, fmt.Errorf("unsupported checksum '%s'", integrity)
+		}
+		digest, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(digest) != newHash(algorithm).Size() {
+			return Checksum{}, fmt.Errorf("invalid %s checksum '%s'", algorithm, integrity)
+		}
+		return Checksum{algorithm: algorithm, digest: digest}, nil
+	}
+	return Checksum{}, nil
+}
+
+// IsEmpty reports whether no checksum was given.
+func (c Checksum) IsEmpty() bool { return c.algorithm == "" }
+
+// Algorithm returns "sha256", "sha384" or "sha512", or "" for the empty checksum.
+func (c Checksum) Algorithm() string { return c.algorithm }
+
+// Hex returns the digest in hexadecimal.
+func (c Checksum) Hex() string { return hex.EncodeToString(c.digest) }
+
+// Integrity returns the checksum as a Subresource Integrity value.
+func (c Checksum) Integrity() string {
+	if c.IsEmpty() {
+		return ""
+	}
+	return c.algorithm + "-" + base64.StdEncoding.EncodeToString(c.digest)
+}
+
+// Verify checks data against the checksum.
+func (c Checksum) Verify(url string, data []byte) error {
+	if c.IsEmpty() {
+		return nil
+	}
+	h := newHash(c.algorithm)
+	h.Write(data)
+	actual := Checksum{algorithm: c.algorithm, digest: h.Sum(nil)}
+	if string(actual.digest) != string(c.digest) {
+		return fmt.Errorf("Checksum was %s but wanted %s (downloading %s)", actual.Integrity(), c.Integrity(), url)
+	}
+	return nil
+}
+
+// SHA256Integrity returns the sha256 Subresource Integrity value of data,
+// as reported by download() when no checksum was given.
+func SHA256Integrity(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "sha256":
+		return sha256.New()
+	case "sha384":
+		return sha512.New384()
+	case "sha512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// fetchFirst downloads the first of urls the fetcher can serve and verifies
+// it against the checksum. A ChecksumFetcher is asked for the checksum
+// first, and a RepositoryCache keeps what was downloaded. Like Bazel, the
+// next URL is tried when one is missing, but a checksum mismatch is an error.
+// Reference: DownloadManager.java download()
+func fetchFirst(f Fetcher, urls []string, checksum Checksum) ([]byte, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no urls given")
+	}
+	if f == nil {
+		return nil, fmt.Errorf("cannot download %s: no fetcher configured", urls[0])
+	}
+	if cf, ok := f.(ChecksumFetcher); ok && !checksum.IsEmpty() {
+		if rc, err := cf.FetchChecksum(checksum); err == nil {
+			data, err := io.ReadAll(rc)
+			rc.Close()
+			if err == nil && checksum.Verify(urls[0], data) == nil {
+				return data, nil
+			}
+		}
+	}
+	var errs []error
+	for _, u := range urls {
+		rc, err := f.Fetch(u)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("reading %s: %w", u, err))
+			continue
+		}
+		if err := checksum.Verify(u, data); err != nil {
+			return nil, err
+		}
+		if cache, ok := f.(*RepositoryCache); ok {
+			cache.Put(data)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("error downloading %v: %w", urls, errors.Join(errs...))
+}
@@ -0,0 +1,573 @@
+package bzlmod
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// LockfileName is the name of the lockfile next to the root MODULE.bazel.
+const LockfileName = "MODULE.bazel.lock"
+
+// LockfileVersion is the lockFileVersion written by (*ModuleGraph).Lockfile.
+const LockfileVersion = 18
+
+// Lockfile is a parsed MODULE.bazel.lock.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/BazelLockFileValue.java
+type Lockfile struct {
+	// Version is the lockFileVersion.
+	Version int
+
+	// RegistryFileHashes maps the URLs of registry files read during
+	// resolution to their sha256 hex digests, or "" for files recorded as
+	// missing.
+	RegistryFileHashes map[string]string
+
+	// SelectedYankedVersions maps yanked module keys that were allowed to
+	// their yank reasons.
+	SelectedYankedVersions map[string]string
+
+	// ModuleExtensions maps extension keys ("<bzl label>%<name>") to the
+	// extension results, keyed by "general" or by an os/arch factor.
+	ModuleExtensions map[string]map[string]*LockedExtension
+
+	// ModuleDepGraph is the resolved module graph recorded by older lockfile
+	// versions, keyed by module key ("<root>" for the root module), or nil.
+	ModuleDepGraph map[string]*LockedModule
+}
+
+// LockedExtension is the recorded result of a module extension.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/LockFileModuleExtension.java
+type LockedExtension struct {
+	BzlTransitiveDigest string                     `json:"bzlTransitiveDigest,omitempty"`
+	UsagesDigest        string                     `json:"usagesDigest,omitempty"`
+	RecordedFileInputs  map[string]string          `json:"recordedFileInputs,omitempty"`
+	EnvVariables        map[string]*string         `json:"envVariables,omitempty"`
+	GeneratedRepoSpecs  map[string]*LockedRepoSpec `json:"generatedRepoSpecs"`
+}
+
+// LockedRepoSpec describes a repository generated by an extension. Newer
+// lockfiles identify the rule by RepoRuleID ("<bzl label>%<rule>"), older
+// ones by BzlFile and RuleClassName.
+type LockedRepoSpec struct {
+	RepoRuleID    string         `json:"repoRuleId,omitempty"`
+	BzlFile       string         `json:"bzlFile,omitempty"`
+	RuleClassName string         `json:"ruleClassName,omitempty"`
+	Attributes    map[string]any `json:"attributes"`
+}
+
+// LockedModule is a module of the dependency graph recorded by older
+// lockfile versions.
+type LockedModule struct {
+	Name     string            `json:"name"`
+	Version  string            `json:"version"`
+	Key      string            `json:"key"`
+	RepoName string            `json:"repoName"`
+	Deps     map[string]string `json:"deps"`
+}
+
+// lockfileJSON is the on-disk schema. Registry files recorded as missing are
+// null.
+type lockfileJSON struct {
+	LockFileVersion        int                                    `json:"lockFileVersion"`
+	RegistryFileHashes     map[string]*string                     `json:"registryFileHashes"`
+	SelectedYankedVersions map[string]string                      `json:"selectedYankedVersions"`
+	ModuleExtensions       map[string]map[string]*LockedExtension `json:"moduleExtensions"`
+	ModuleDepGraph         map[string]*LockedModule               `json:"moduleDepGraph,omitempty"`
+}
+
+// ParseLockfile parses the contents of a MODULE.bazel.lock file.
+func ParseLockfile(data []byte) (*Lockfile, error) {
+	var raw lockfileJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", LockfileName, err)
+	}
+	lf := &Lockfile{
+		Version:                raw.LockFileVersion,
+		RegistryFileHashes:     make(map[string]string, len(raw.RegistryFileHashes)),
+		SelectedYankedVersions: raw.SelectedYankedVersions,
+		ModuleExtensions:       raw.ModuleExtensions,
+		ModuleDepGraph:         raw.ModuleDepGraph,
+	}
+	for url, hash := range raw.RegistryFileHashes {
+		if hash != nil {
+			lf.RegistryFileHashes[url] = *hash
+		} else {
+			lf.RegistryFileHashes[url] = ""
+		}
+	}
+	if lf.SelectedYankedVersions == nil {
+		lf.SelectedYankedVersions = make(map[string]string)
+	}
+	if lf.ModuleExtensions == nil {
+		lf.ModuleExtensions = make(map[string]map[string]*LockedExtension)
+	}
+	return lf, nil
+}
+
+// ReadLockfile reads the MODULE.bazel.lock file of a workspace. It returns
+// an error wrapping fs.ErrNotExist if there is none.
+func ReadLockfile(fsys loader.FileSystem, workspaceRoot string) (*Lockfile, error) {
+	if fsys == nil {
+		fsys = loader.NewOSFileSystem(workspaceRoot)
+	}
+	data, err := fsys.ReadFile(fsys.Join(workspaceRoot, LockfileName))
+	if err != nil {
+		return nil, err
+	}
+	return ParseLockfile(data)
+}
+
+// Marshal encodes the lockfile the way Bazel writes it: indented JSON with
+// sorted keys and a trailing newline.
+func (lf *Lockfile) Marshal() ([]byte, error) {
+	raw := lockfileJSON{
+		LockFileVersion:        lf.Version,
+		RegistryFileHashes:     make(map[string]*string, len(lf.RegistryFileHashes)),
+		SelectedYankedVersions: lf.SelectedYankedVersions,
+		ModuleExtensions:       lf.ModuleExtensions,
+		ModuleDepGraph:         lf.ModuleDepGraph,
+	}
+	for url, hash := range lf.RegistryFileHashes {
+		if hash != "" {
+			raw.RegistryFileHashes[url] = &hash
+		} else {
+			raw.RegistryFileHashes[url] = nil
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(raw); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// extension returns the recorded result of an extension: the "general"
+// entry, or else the first os/arch-specific one.
+func (lf *Lockfile) extension(key string) (*LockedExtension, bool) {
+	factors := lf.ModuleExtensions[key]
+	if e, ok := factors["general"]; ok {
+		return e, true
+	}
+	names := make([]string, 0, len(factors))
+	for name := range factors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, false
+	}
+	return factors[names[0]], true
+}
+
+// Lockfile records the graph and the results of its module extensions, as
+// returned by RunExtensions, in a new Lockfile.
+//
+// Usages digests are computed by UsagesDigest, which follows Bazel's scheme
+// but not its exact serialization of the usages: lockfiles written by Bazel
+// should be checked with LockfileCheckOptions.SkipUsagesDigests.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/BazelLockFileModule.java
+func (g *ModuleGraph) Lockfile(results []*ExtensionResult) (*Lockfile, error) {
+	hashes, err := g.registryFileHashes()
+	if err != nil {
+		return nil, err
+	}
+	lf := &Lockfile{
+		Version:                LockfileVersion,
+		RegistryFileHashes:     hashes,
+		SelectedYankedVersions: make(map[string]string),
+		ModuleExtensions:       make(map[string]map[string]*LockedExtension),
+	}
+	_, usages := g.extensionUsages()
+	for _, result := range results {
+		var key string
+		for _, moduleUsages := range usages[result.ID] {
+			key = lockfileExtensionKey(moduleUsages[0])
+			break
+		}
+		if key == "" {
+			return nil, fmt.Errorf("extension %s is not used in the module graph", result.ID)
+		}
+		specs := make(map[string]*LockedRepoSpec, len(result.Repos))
+		for name, def := range result.Repos {
+			spec := &LockedRepoSpec{RepoRuleID: def.RuleName, Attributes: make(map[string]any, len(def.Attrs))}
+			for attr, v := range def.Attrs {
+				spec.Attributes[attr] = lockfileValue(v)
+			}
+			specs[name] = spec
+		}
+		lf.ModuleExtensions[key] = map[string]*LockedExtension{
+			"general": {UsagesDigest: g.UsagesDigest(result.ID), GeneratedRepoSpecs: specs},
+		}
+	}
+	return lf, nil
+}
+
+// lockfileValue converts an attribute value to JSON. Labels are recorded in
+// their canonical form.
+func lockfileValue(v starlark.Value) any {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.Bool:
+		return bool(v)
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i
+		}
+		return v.String()
+	case starlark.String:
+		return string(v)
+	case *types.Label:
+		return v.String()
+	case starlark.Indexable:
+		list := make([]any, v.Len())
+		for i := range list {
+			list[i] = lockfileValue(v.Index(i))
+		}
+		return list
+	case *starlark.Dict:
+		dict := make(map[string]any, v.Len())
+		for _, item := range v.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				key = starlark.String(item[0].String())
+			}
+			dict[string(key)] = lockfileValue(item[1])
+		}
+		return dict
+	}
+	return v.String()
+}
+
+// lockfileExtensionKey returns the key of an extension in the lockfile:
+// "<bzl label>%<name>", followed by "%<module>+<n>" for isolated usages.
+// Reference: ModuleExtensionId.java toString()
+func lockfileExtensionKey(u *ExtensionUsage) string {
+	key := u.ExtensionLabel + "%" + u.ExtensionName
+	if u.Isolate {
+		_, isolation, _ := strings.Cut(u.ExtensionID, "+"+u.ExtensionName+"+")
+		key += "%" + isolation
+	}
+	return key
+}
+
+// usagesDigestJSON is the serialization of one module's usages hashed by
+// UsagesDigest. Locations are left out so that reformatting MODULE.bazel
+// does not invalidate extension results.
+type usagesDigestJSON struct {
+	Module     string            `json:"module"`
+	BzlFile    string            `json:"bzlFile"`
+	Name       string            `json:"name"`
+	Isolate    bool              `json:"isolate,omitempty"`
+	Imports    map[string]string `json:"imports"`
+	DevImports []string          `json:"devImports,omitempty"`
+	HasNonDev  bool              `json:"hasNonDevUseExtension"`
+	Tags       []usagesTagJSON   `json:"tags"`
+}
+
+type usagesTagJSON struct {
+	Name          string            `json:"tagName"`
+	Attributes    map[string]string `json:"attributeValues"`
+	DevDependency bool              `json:"devDependency,omitempty"`
+}
+
+// UsagesDigest returns the base64 sha256 digest of the usages of an
+// extension, the usagesDigest of its lockfile entry. The result of an
+// extension is stale once its digest changes.
+//
+// Reference: LockFileModuleExtension.java computeUsagesDigest()
+func (g *ModuleGraph) UsagesDigest(extensionID string) string {
+	_, usages := g.extensionUsages()
+	var entries []usagesDigestJSON
+	for _, key := range g.order {
+		for _, u := range usages[extensionID][key] {
+			entry := usagesDigestJSON{
+				Module:    key.String(),
+				BzlFile:   u.ExtensionBzlFile,
+				Name:      u.ExtensionName,
+				Isolate:   u.Isolate,
+				Imports:   u.Imports,
+				HasNonDev: u.HasNonDevUseExtension,
+				Tags:      make([]usagesTagJSON, 0, len(u.Tags)),
+			}
+			for name := range u.DevImports {
+				entry.DevImports = append(entry.DevImports, name)
+			}
+			sort.Strings(entry.DevImports)
+			for _, tag := range u.Tags {
+				t := usagesTagJSON{Name: tag.TagName, Attributes: make(map[string]string, len(tag.Attributes)), DevDependency: tag.DevDependency}
+				for name, v := range tag.Attributes {
+					t.Attributes[name] = v.String()
+				}
+				entry.Tags = append(entry.Tags, t)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	data, _ := json.Marshal(entries)
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// registryFileHashes hashes the registry files of the selected modules:
+// their MODULE.bazel files and, for registries implementing
+// RegistryFileReader, their source.json files.
+func (g *ModuleGraph) registryFileHashes() (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, key := range g.order {
+		m := g.modules[key]
+		if m.Registry == nil {
+			continue
+		}
+		dir := "modules/" + key.Name + "/" + key.Version + "/"
+		data, err := m.Registry.GetModuleFile(key)
+		if err != nil {
+			return nil, err
+		}
+		hashes[m.Registry.URL()+"/"+dir+ModuleFileName] = sha256Hex(data)
+		if r, ok := m.Registry.(RegistryFileReader); ok {
+			data, err := r.ReadRegistryFile(dir + "source.json")
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				hashes[m.Registry.URL()+"/"+dir+"source.json"] = ""
+			case err != nil:
+				return nil, err
+			default:
+				hashes[m.Registry.URL()+"/"+dir+"source.json"] = sha256Hex(data)
+			}
+		}
+	}
+	return hashes, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// LockfileDriftKind classifies a difference between a lockfile and the
+// module graph.
+type LockfileDriftKind string
+
+const (
+	// DriftModuleVersion: a module resolves to a version the lockfile does
+	// not record, or the recorded graph has a module that is gone.
+	DriftModuleVersion LockfileDriftKind = "module_version"
+
+	// DriftRegistryFile: a registry file's hash is missing from the lockfile
+	// or differs from the recorded one.
+	DriftRegistryFile LockfileDriftKind = "registry_file"
+
+	// DriftExtension: an extension result is missing, stale or no longer
+	// used.
+	DriftExtension LockfileDriftKind = "extension"
+)
+
+// LockfileDrift is one difference between a lockfile and the module graph.
+type LockfileDrift struct {
+	Kind LockfileDriftKind
+
+	// Subject is the module, registry file URL or extension key concerned.
+	Subject string
+
+	// Message describes the difference.
+	Message string
+}
+
+// String returns the drift's message.
+func (d LockfileDrift) String() string { return d.Message }
+
+// LockfileCheckOptions configures CheckLockfile.
+type LockfileCheckOptions struct {
+	// SkipUsagesDigests disables the comparison of extension usages digests,
+	// for lockfiles written by Bazel rather than by Lockfile.
+	SkipUsagesDigests bool
+}
+
+// CheckLockfile compares a lockfile with the graph and reports where the
+// lockfile has drifted, ordered by kind and subject. An up-to-date lockfile
+// yields no drift.
+//
+//   - Module versions are compared with the recorded dependency graph, or,
+//     for lockfiles without one, with the versions whose MODULE.bazel files
+//     have recorded hashes.
+//   - The registry files of the selected modules must have recorded hashes
+//     equal to their current ones.
+//   - Every used extension must have a recorded result whose usages digest
+//     matches and which generates every imported repository; recorded
+//     extensions must still be used.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/BazelLockFileFunction.java
+func (g *ModuleGraph) CheckLockfile(lf *Lockfile, opts LockfileCheckOptions) ([]LockfileDrift, error) {
+	var drifts []LockfileDrift
+	report := func(kind LockfileDriftKind, subject, format string, args ...any) {
+		drifts = append(drifts, LockfileDrift{Kind: kind, Subject: subject, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Module versions.
+	if lf.ModuleDepGraph != nil {
+		recorded := make(map[string]string)
+		for key, m := range lf.ModuleDepGraph {
+			if key != "<root>" {
+				recorded[m.Name] = m.Version
+			}
+		}
+		for _, key := range g.order {
+			if key == RootModuleKey {
+				continue
+			}
+			version, ok := recorded[key.Name]
+			switch {
+			case !ok:
+				report(DriftModuleVersion, key.Name, "module %s resolves to version %s but is not in the lockfile", key.Name, versionString(key.Version))
+			case version != key.Version:
+				report(DriftModuleVersion, key.Name, "module %s resolves to version %s but the lockfile has %s", key.Name, versionString(key.Version), versionString(version))
+			}
+			delete(recorded, key.Name)
+		}
+		for name, version := range recorded {
+			report(DriftModuleVersion, name, "module %s@%s is in the lockfile but no longer in the dependency graph", name, versionString(version))
+		}
+	} else {
+		recorded := lockedRegistryVersions(lf)
+		for _, key := range g.order {
+			if g.modules[key].Registry == nil {
+				continue
+			}
+			versions := recorded[key.Name]
+			if !containsString(versions, key.Version) {
+				if len(versions) == 0 {
+					report(DriftModuleVersion, key.Name, "module %s resolves to version %s but is not in the lockfile", key.Name, versionString(key.Version))
+				} else {
+					report(DriftModuleVersion, key.Name, "module %s resolves to version %s but the lockfile has %s", key.Name, versionString(key.Version), strings.Join(versions, ", "))
+				}
+			}
+		}
+	}
+
+	// Registry file hashes.
+	hashes, err := g.registryFileHashes()
+	if err != nil {
+		return nil, err
+	}
+	for url, hash := range hashes {
+		recorded, ok := lf.RegistryFileHashes[url]
+		switch {
+		case !ok:
+			report(DriftRegistryFile, url, "registry file %s is not in the lockfile", url)
+		case recorded != hash:
+			report(DriftRegistryFile, url, "registry file %s has changed: the lockfile has hash %s, the file has %s", url, hashOrMissing(recorded), hashOrMissing(hash))
+		}
+	}
+
+	// Extensions.
+	ids, usages := g.extensionUsages()
+	used := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		var key string
+		for _, moduleKey := range g.order {
+			if u := usages[id][moduleKey]; len(u) > 0 {
+				key = lockfileExtensionKey(u[0])
+				break
+			}
+		}
+		used[key] = true
+		locked, ok := lf.extension(key)
+		if !ok {
+			report(DriftExtension, key, "module extension %s is not in the lockfile", key)
+			continue
+		}
+		if !opts.SkipUsagesDigests && locked.UsagesDigest != g.UsagesDigest(id) {
+			report(DriftExtension, key, "module extension %s is stale: its usages have changed", key)
+			continue
+		}
+		for _, moduleKey := range g.order {
+			for _, u := range usages[id][moduleKey] {
+				for _, local := range sortedKeys(u.Imports) {
+					if _, ok := locked.GeneratedRepoSpecs[u.Imports[local]]; !ok {
+						report(DriftExtension, key, "module extension %s is stale: %s imports repository %s, which the lockfile does not record", key, moduleKey, u.Imports[local])
+					}
+				}
+			}
+		}
+	}
+	for key := range lf.ModuleExtensions {
+		if !used[key] {
+			report(DriftExtension, key, "module extension %s is in the lockfile but no longer used", key)
+		}
+	}
+
+	sort.SliceStable(drifts, func(i, j int) bool {
+		if drifts[i].Kind != drifts[j].Kind {
+			return drifts[i].Kind < drifts[j].Kind
+		}
+		return drifts[i].Subject < drifts[j].Subject
+	})
+	return drifts, nil
+}
+
+// lockedRegistryVersions returns the versions of each module whose
+// MODULE.bazel file has a recorded hash, in sorted order.
+func lockedRegistryVersions(lf *Lockfile) map[string][]string {
+	versions := make(map[string][]string)
+	for url := range lf.RegistryFileHashes {
+		rest, ok := strings.CutSuffix(url, "/"+ModuleFileName)
+		if !ok {
+			continue
+		}
+		i := strings.LastIndex(rest, "/modules/")
+		if i < 0 {
+			continue
+		}
+		name, version, ok := strings.Cut(rest[i+len("/modules/"):], "/")
+		if !ok || strings.Contains(version, "/") {
+			continue
+		}
+		versions[name] = append(versions[name], version)
+	}
+	for _, v := range versions {
+		sort.Strings(v)
+	}
+	return versions
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func versionString(v string) string {
+	if v == "" {
+		return "_"
+	}
+	return v
+}
+
+func hashOrMissing(hash string) string {
+	if hash == "" {
+		return "(missing)"
+	}
+	return hash
+}
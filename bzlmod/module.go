@@ -0,0 +1,193 @@
+package bzlmod
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+// ModuleKey identifies a module version in the dependency graph. The root
+// module has the zero key.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleKey.java
+type ModuleKey struct {
+	Name    string
+	Version string
+}
+
+// RootModuleKey is the key of the root module.
+var RootModuleKey = ModuleKey{}
+
+// String returns "name@version", or "<root>" for the root module. An empty
+// version is printed as "_".
+func (k ModuleKey) String() string {
+	if k == RootModuleKey {
+		return "<root>"
+	}
+	if k.Version == "" {
+		return k.Name + "@_"
+	}
+	return k.Name + "@" + k.Version
+}
+
+// CanonicalRepoName returns the canonical name of the repository backing the
+// module: "" for the root module (the main repository) and "name+" otherwise.
+// Reference: ModuleKey.java getCanonicalRepoNameWithoutVersion()
+func (k ModuleKey) CanonicalRepoName() string {
+	if k == RootModuleKey {
+		return ""
+	}
+	return k.Name + "+"
+}
+
+// DepSpec is a dependency declared with bazel_dep().
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/DepSpec.java
+type DepSpec struct {
+	Name                  string
+	Version               string
+	RepoName              string
+	MaxCompatibilityLevel int
+}
+
+// Key returns the module key requested by this dependency.
+func (d DepSpec) Key() ModuleKey {
+	return ModuleKey{Name: d.Name, Version: d.Version}
+}
+
+// Tag is a single tag of a module extension, e.g. "maven.install(...)".
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/Tag.java
+type Tag struct {
+	TagName       string
+	Attributes    starlark.StringDict
+	DevDependency bool
+	Location      string
+}
+
+// ExtensionUsage records the use_extension() calls of a module for a single
+// extension, with the tags it specified and the repos it imported.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleExtensionUsage.java
+type ExtensionUsage struct {
+	// ExtensionBzlFile is the label of the .bzl file as written by the module.
+	ExtensionBzlFile string
+
+	// ExtensionName is the name the extension is exported as.
+	ExtensionName string
+
+	// Isolate requests a separate extension instance for this usage.
+	Isolate bool
+
+	// Imports maps local repo names to the names the extension exports them as.
+	Imports map[string]string
+
+	// DevImports is the set of local repo names imported with dev_dependency = True.
+	DevImports map[string]bool
+
+	// Tags are the tags in the order they were specified.
+	Tags []*Tag
+
+	// Location is the position of the first use_extension() call.
+	Location string
+
+	// HasNonDevUseExtension reports whether any use_extension() call for the
+	// usage was made without dev_dependency = True.
+	HasNonDevUseExtension bool
+
+	// ExtensionLabel is the canonical label of the .bzl file, set by Resolve.
+	ExtensionLabel string
+
+	// ExtensionID is the unique identifier of the extension instance, set by
+	// Resolve. Repos generated by the extension are named "<ExtensionID>+<name>".
+	ExtensionID string
+}
+
+// Override customizes how a dependency is found. It is one of
+// *SingleVersionOverride or *LocalPathOverride.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleOverride.java
+type Override interface {
+	isOverride()
+}
+
+// SingleVersionOverride pins a module to a single version and optionally
+// registry.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/SingleVersionOverride.java
+type SingleVersionOverride struct {
+	Version    string
+	Registry   string
+	Patches    []string
+	PatchCmds  []string
+	PatchStrip int
+}
+
+// LocalPathOverride makes a module come from a local directory instead of a
+// registry. Path is relative to the workspace root unless absolute.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/LocalPathOverride.java
+type LocalPathOverride struct {
+	Path string
+}
+
+func (*SingleVersionOverride) isOverride() {}
+func (*LocalPathOverride) isOverride()     {}
+
+// Module is a module as declared by its MODULE.bazel file.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/InterimModule.java
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/Module.java
+type Module struct {
+	Key                ModuleKey
+	Name               string
+	Version            string
+	CompatibilityLevel int
+	RepoName           string
+	BazelCompatibility []string
+
+	// Deps are the bazel_dep() declarations in order.
+	Deps []DepSpec
+
+	// ResolvedDeps maps the repo name of each dependency to the selected
+	// module. Set by Resolve.
+	ResolvedDeps map[string]ModuleKey
+
+	ExtensionUsages    []*ExtensionUsage
+	Toolchains         []string
+	ExecutionPlatforms []string
+
+	// Overrides are the overrides declared by the root module, keyed by
+	// module name. Overrides of other modules are ignored.
+	Overrides map[string]Override
+
+	// Registry is the registry the module file was found in, if any.
+	Registry Registry
+
+	// RepoSpec describes how to fetch the module's repository, when known.
+	RepoSpec *RepoSpec
+
+	// Path is the local directory of the module's repository, or "" if the
+	// repository must be fetched.
+	Path string
+}
+
+// GetRepoName returns the name the module's own repository is visible as.
+func (m *Module) GetRepoName() string {
+	if m.RepoName != "" {
+		return m.RepoName
+	}
+	return m.Name
+}
+
+// depRepoName returns the repo name a dependency is visible as.
+func depRepoName(d DepSpec) string {
+	if d.RepoName != "" {
+		return d.RepoName
+	}
+	return d.Name
+}
+
+func (m *Module) String() string {
+	return fmt.Sprintf("<module %s>", m.Key)
+}
@@ -0,0 +1,311 @@
+package bzlmod
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/albertocavalcante/starlark-go-bazel/builtins"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// ModuleCtx is the module_ctx value passed to a module extension's
+// implementation function.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleExtensionContext.java
+type ModuleCtx struct {
+	io      ioContext
+	modules *starlark.List
+
+	// devTags records which tag structs come from dev_dependency usages.
+	devTags map[*starlarkstruct.Struct]bool
+
+	rootHasNonDevDependency bool
+}
+
+var _ starlark.HasAttrs = (*ModuleCtx)(nil)
+
+func (m *ModuleCtx) String() string        { return "<module_ctx>" }
+func (m *ModuleCtx) Type() string          { return "module_ctx" }
+func (m *ModuleCtx) Freeze()               { m.modules.Freeze() }
+func (m *ModuleCtx) Truth() starlark.Bool  { return true }
+func (m *ModuleCtx) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: module_ctx") }
+
+// Attr returns an attribute of module_ctx.
+func (m *ModuleCtx) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "modules":
+		return m.modules, nil
+	case "root_module_has_non_dev_dependency":
+		return starlark.Bool(m.rootHasNonDevDependency), nil
+	case "is_dev_dependency":
+		return starlark.NewBuiltin(name, m.isDevDependency), nil
+	case "extension_metadata":
+		return starlark.NewBuiltin(name, extensionMetadataBuiltin), nil
+	}
+	if v, ok := m.io.attr(name); ok {
+		return v, nil
+	}
+	return nil, starlark.NoSuchAttrError(fmt.Sprintf("module_ctx has no attribute %q", name))
+}
+
+// AttrNames returns the list of attribute names.
+func (m *ModuleCtx) AttrNames() []string {
+	names := append([]string{"extension_metadata", "is_dev_dependency", "modules", "root_module_has_non_dev_dependency"}, ioAttrNames...)
+	sort.Strings(names)
+	return names
+}
+
+// Reference: ModuleExtensionContext.java isDevDependency()
+func (m *ModuleCtx) isDevDependency(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var tag starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "tag", &tag); err != nil {
+		return nil, err
+	}
+	s, ok := tag.(*starlarkstruct.Struct)
+	if !ok {
+		return nil, fmt.Errorf("%s: got %s, want a tag from module_ctx.modules", b.Name(), tag.Type())
+	}
+	dev, ok := m.devTags[s]
+	if !ok {
+		return nil, fmt.Errorf("%s: got a struct that is not a tag from module_ctx.modules", b.Name())
+	}
+	return starlark.Bool(dev), nil
+}
+
+// ExtensionMetadata is the value returned by module_ctx.extension_metadata().
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleExtensionMetadata.java
+type ExtensionMetadata struct {
+	// RootModuleDirectDeps and RootModuleDirectDevDeps are the repos the
+	// root module should import with use_repo, or nil if not specified.
+	RootModuleDirectDeps    []string
+	RootModuleDirectDevDeps []string
+
+	// allDeps records a "all" argument, resolved to every generated
+	// repository once the extension has run; allDev tells which one.
+	allDeps bool
+	allDev  bool
+
+	// Reproducible reports that the extension's result need not be recorded
+	// in the lockfile.
+	Reproducible bool
+}
+
+var _ starlark.Value = (*ExtensionMetadata)(nil)
+
+func (e *ExtensionMetadata) String() string       { return "<extension_metadata>" }
+func (e *ExtensionMetadata) Type() string         { return "extension_metadata" }
+func (e *ExtensionMetadata) Freeze()              {}
+func (e *ExtensionMetadata) Truth() starlark.Bool { return true }
+func (e *ExtensionMetadata) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: extension_metadata")
+}
+
+// Reference: ModuleExtensionContext.java extensionMetadata()
+func extensionMetadataBuiltin(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		deps         starlark.Value = starlark.None
+		devDeps      starlark.Value = starlark.None
+		reproducible bool
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"root_module_direct_deps?", &deps,
+		"root_module_direct_dev_deps?", &devDeps,
+		"reproducible?", &reproducible,
+	); err != nil {
+		return nil, err
+	}
+	meta := &ExtensionMetadata{Reproducible: reproducible}
+	// "all" makes every generated repository a direct dependency of the
+	// given kind; the other argument must then be empty.
+	for _, all := range []struct {
+		v, other starlark.Value
+		dev      bool
+	}{{deps, devDeps, false}, {devDeps, deps, true}} {
+		if s, ok := all.v.(starlark.String); ok {
+			if s != "all" || !isEmptyRepoList(all.other) {
+				return nil, fmt.Errorf("%s: if one of root_module_direct_deps and root_module_direct_dev_deps is \"all\", the other must be an empty list", b.Name())
+			}
+			meta.allDeps, meta.allDev = true, all.dev
+			return meta, nil
+		}
+	}
+	if (deps == starlark.None) != (devDeps == starlark.None) {
+		return nil, fmt.Errorf("%s: root_module_direct_deps and root_module_direct_dev_deps must both be specified or both be unspecified", b.Name())
+	}
+	var err error
+	if meta.RootModuleDirectDeps, err = metadataRepoList(deps, "root_module_direct_deps"); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	if meta.RootModuleDirectDevDeps, err = metadataRepoList(devDeps, "root_module_direct_dev_deps"); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return meta, nil
+}
+
+func isEmptyRepoList(v starlark.Value) bool {
+	l, ok := v.(*starlark.List)
+	return v == starlark.None || ok && l.Len() == 0
+}
+
+func metadataRepoList(v starlark.Value, what string) ([]string, error) {
+	if v == starlark.None {
+		return nil, nil
+	}
+	l, ok := v.(*starlark.List)
+	if !ok {
+		return nil, fmt.Errorf("got %s for '%s', want list or \"all\"", v.Type(), what)
+	}
+	names, err := stringList(l, what)
+	if err != nil {
+		return nil, err
+	}
+	if names == nil {
+		names = []string{}
+	}
+	return names, nil
+}
+
+// finish resolves "all" and checks that the listed repositories exist.
+// Reference: ModuleExtensionMetadata.java getRootModuleDirectDeps()
+func (e *ExtensionMetadata) finish(generated map[string]*RepoDefinition) error {
+	if e.allDeps {
+		e.RootModuleDirectDeps, e.RootModuleDirectDevDeps = sortedRepoNames(generated), []string{}
+		if e.allDev {
+			e.RootModuleDirectDeps, e.RootModuleDirectDevDeps = e.RootModuleDirectDevDeps, e.RootModuleDirectDeps
+		}
+	}
+	for _, list := range [][]string{e.RootModuleDirectDeps, e.RootModuleDirectDevDeps} {
+		for _, name := range list {
+			if _, ok := generated[name]; !ok {
+				return fmt.Errorf("root_module_direct_deps contained the repo '%s', but it is not generated by the module extension", name)
+			}
+		}
+	}
+	return nil
+}
+
+// newBazelModule builds the bazel_module value of module_ctx.modules for a
+// module's usages of an extension, validating its tags against the
+// extension's tag classes.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/StarlarkBazelModule.java
+func newBazelModule(m *Module, usages []*ExtensionUsage, ext *builtins.ModuleExtension, lc types.LabelContext, devTags map[*starlarkstruct.Struct]bool) (starlark.Value, error) {
+	tags := make(map[string][]starlark.Value)
+	for _, usage := range usages {
+		for _, tag := range usage.Tags {
+			tc, ok := ext.TagClasses()[tag.TagName]
+			if !ok {
+				return nil, fmt.Errorf("The module extension defined at %s%%%s does not have a tag class named %s, but its use is attempted at %s", usage.ExtensionLabel, usage.ExtensionName, tag.TagName, tag.Location)
+			}
+			s, err := newTag(tc, tag, lc)
+			if err != nil {
+				return nil, err
+			}
+			devTags[s] = tag.DevDependency
+			tags[tag.TagName] = append(tags[tag.TagName], s)
+		}
+	}
+
+	tagFields := make(starlark.StringDict, len(ext.TagClasses()))
+	for _, name := range ext.TagClassNames() {
+		tagFields[name] = starlark.NewList(tags[name])
+	}
+	return starlarkstruct.FromStringDict(starlark.String("bazel_module"), starlark.StringDict{
+		"name":    starlark.String(m.Name),
+		"version": starlark.String(m.Version),
+		"is_root": starlark.Bool(m.Key == RootModuleKey),
+		"tags":    starlarkstruct.FromStringDict(starlark.String("bazel_module_tags"), tagFields),
+	}), nil
+}
+
+// newTag type-checks a tag's attributes and fills in defaults.
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/TypeCheckedTag.java
+func newTag(tc *builtins.TagClass, tag *Tag, lc types.LabelContext) (*starlarkstruct.Struct, error) {
+	for name := range tag.Attributes {
+		if _, ok := tc.Attrs()[name]; !ok {
+			return nil, fmt.Errorf("in tag at %s, unknown attribute %s provided", tag.Location, name)
+		}
+	}
+	fields := make(starlark.StringDict, len(tc.Attrs()))
+	for name, schema := range tc.Attrs() {
+		v, ok := tag.Attributes[name]
+		if !ok {
+			if schema.IsMandatory() {
+				return nil, fmt.Errorf("in tag at %s, mandatory attribute %s isn't being specified", tag.Location, name)
+			}
+			v = schema.DefaultValue()
+			if v == starlark.None {
+				v = builtins.AttrTypeDefault(schema.AttrType())
+			}
+		}
+		converted, err := convertAttrValue(schema.AttrType(), v, lc)
+		if err != nil {
+			return nil, fmt.Errorf("in tag at %s, error converting value for attribute %s: %w", tag.Location, name, err)
+		}
+		fields[name] = converted
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, fields), nil
+}
+
+// convertAttrValue checks a value against an attribute type, converting
+// label strings to Labels in the given context.
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/BuildType.java
+func convertAttrValue(attrType string, v starlark.Value, lc types.LabelContext) (starlark.Value, error) {
+	convertLabel := func(v starlark.Value) (starlark.Value, error) {
+		switch v := v.(type) {
+		case *types.Label:
+			return v, nil
+		case starlark.String:
+			return types.ParseLabelInContext(string(v), lc)
+		}
+		return nil, fmt.Errorf("expected value of type 'string' or 'Label', got %s", v.Type())
+	}
+
+	switch attrType {
+	case "string":
+		if _, ok := v.(starlark.String); !ok {
+			return nil, fmt.Errorf("expected value of type 'string', got %s", v.Type())
+		}
+	case "int":
+		if _, ok := v.(starlark.Int); !ok {
+			return nil, fmt.Errorf("expected value of type 'int', got %s", v.Type())
+		}
+	case "bool":
+		if _, ok := v.(starlark.Bool); !ok {
+			return nil, fmt.Errorf("expected value of type 'bool', got %s", v.Type())
+		}
+	case "label":
+		if v == starlark.None {
+			return v, nil
+		}
+		return convertLabel(v)
+	case "string_list", "label_list":
+		l, ok := v.(*starlark.List)
+		if !ok {
+			return nil, fmt.Errorf("expected value of type 'list', got %s", v.Type())
+		}
+		elems := make([]starlark.Value, 0, l.Len())
+		for i := 0; i < l.Len(); i++ {
+			elem := l.Index(i)
+			if attrType == "label_list" {
+				converted, err := convertLabel(elem)
+				if err != nil {
+					return nil, err
+				}
+				elem = converted
+			} else if _, ok := elem.(starlark.String); !ok {
+				return nil, fmt.Errorf("expected value of type 'string' in list, got %s", elem.Type())
+			}
+			elems = append(elems, elem)
+		}
+		return starlark.NewList(elems), nil
+	case "string_dict":
+		if _, ok := v.(*starlark.Dict); !ok {
+			return nil, fmt.Errorf("expected value of type 'dict', got %s", v.Type())
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,566 @@
+package bzlmod
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// ModuleFileName is the name of the file declaring a module.
+const ModuleFileName = "MODULE.bazel"
+
+// threadKeyModuleFile is the key for the moduleFileContext in the thread.
+const threadKeyModuleFile = "starlark-go-bazel:module_file"
+
+var (
+	// Reference: RepositoryName.java VALID_MODULE_NAME
+	moduleNamePattern = regexp.MustCompile(`^[a-z]([a-z0-9._-]*[a-z0-9])?$`)
+
+	// Reference: RepositoryName.java VALID_USER_PROVIDED_NAME
+	repoNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9._-]*$`)
+)
+
+// ModuleFileOptions configures the evaluation of a MODULE.bazel file.
+type ModuleFileOptions struct {
+	// Key is the key of the module being evaluated; RootModuleKey for the root.
+	Key ModuleKey
+
+	// IgnoreDevDependency drops declarations made with dev_dependency = True.
+	// Bazel sets this for every module except the root.
+	IgnoreDevDependency bool
+
+	// FileSystem and WorkspaceRoot resolve include() labels. include() is only
+	// available in the root module.
+	FileSystem    loader.FileSystem
+	WorkspaceRoot string
+}
+
+// moduleFileContext accumulates the module declared by a MODULE.bazel file
+// and its includes.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleThreadContext.java
+type moduleFileContext struct {
+	opts   ModuleFileOptions
+	module *Module
+
+	moduleCalled bool
+	hadNonModule bool
+
+	// repoNames maps repo names visible to the module to where they were declared.
+	repoNames map[string]string
+
+	// extensions indexes usages by (bzl file, name, isolate) for non-isolated usages.
+	extensions map[string]*ExtensionUsage
+
+	included map[string]bool
+}
+
+// EvalModuleFile evaluates the MODULE.bazel source of a module.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/ModuleFileFunction.java
+func EvalModuleFile(filename string, source []byte, opts ModuleFileOptions) (*Module, error) {
+	mc := &moduleFileContext{
+		opts: opts,
+		module: &Module{
+			Key:     opts.Key,
+			Name:    opts.Key.Name,
+			Version: opts.Key.Version,
+		},
+		repoNames:  make(map[string]string),
+		extensions: make(map[string]*ExtensionUsage),
+		included:   make(map[string]bool),
+	}
+	if opts.Key == RootModuleKey {
+		mc.module.Overrides = make(map[string]Override)
+	}
+
+	if err := mc.exec(filename, source); err != nil {
+		return nil, err
+	}
+	return mc.module, nil
+}
+
+// exec runs one MODULE.bazel segment, the main file or an included one.
+// Each segment has its own global namespace.
+func (mc *moduleFileContext) exec(filename string, source []byte) error {
+	f, err := (&syntax.FileOptions{}).Parse(filename, source, 0)
+	if err != nil {
+		return err
+	}
+	if err := checkModuleFileSyntax(f); err != nil {
+		return err
+	}
+
+	thread := &starlark.Thread{Name: filename}
+	thread.SetLocal(threadKeyModuleFile, mc)
+	_, err = starlark.ExecFileOptions(&syntax.FileOptions{}, thread, filename, source, moduleFileGlobals)
+	if err != nil {
+		return fmt.Errorf("error executing %s: %w", filename, err)
+	}
+	return nil
+}
+
+// checkModuleFileSyntax rejects constructs not allowed in MODULE.bazel files.
+// Reference: DotBazelFileSyntaxChecker.java
+func checkModuleFileSyntax(f *syntax.File) error {
+	var err error
+	syntax.Walk(f, func(n syntax.Node) bool {
+		if err != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *syntax.LoadStmt:
+			pos, _ := n.Span()
+			err = fmt.Errorf("%s: `load` statements may not be used in MODULE.bazel files", pos)
+		case *syntax.DefStmt:
+			err = fmt.Errorf("%s: functions may not be defined in MODULE.bazel files", n.Def)
+		}
+		return true
+	})
+	return err
+}
+
+func getModuleFileContext(thread *starlark.Thread, name string) (*moduleFileContext, error) {
+	mc, ok := thread.Local(threadKeyModuleFile).(*moduleFileContext)
+	if !ok {
+		return nil, fmt.Errorf("%s() can only be called from MODULE.bazel", name)
+	}
+	if name != "module" {
+		mc.hadNonModule = true
+	}
+	return mc, nil
+}
+
+// addRepoName records a repo name made visible to the module.
+func (mc *moduleFileContext) addRepoName(name, what string) error {
+	if !repoNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid user-provided repo name '%s': valid names may contain only A-Z, a-z, 0-9, '-', '_' and '.', and must start with a letter", name)
+	}
+	if prev, ok := mc.repoNames[name]; ok {
+		return fmt.Errorf("the repo name '%s' is already being used by %s", name, prev)
+	}
+	mc.repoNames[name] = what
+	return nil
+}
+
+// moduleFileGlobals are the builtins of the MODULE.bazel dialect. They are
+// set in init since include() refers back to them.
+var moduleFileGlobals starlark.StringDict
+
+func init() {
+	moduleFileGlobals = starlark.StringDict{
+		"module":                       starlark.NewBuiltin("module", moduleBuiltin),
+		"bazel_dep":                    starlark.NewBuiltin("bazel_dep", bazelDepBuiltin),
+		"use_extension":                starlark.NewBuiltin("use_extension", useExtensionBuiltin),
+		"use_repo":                     starlark.NewBuiltin("use_repo", useRepoBuiltin),
+		"register_toolchains":          starlark.NewBuiltin("register_toolchains", registerBuiltin),
+		"register_execution_platforms": starlark.NewBuiltin("register_execution_platforms", registerBuiltin),
+		"single_version_override":      starlark.NewBuiltin("single_version_override", singleVersionOverrideBuiltin),
+		"local_path_override":          starlark.NewBuiltin("local_path_override", localPathOverrideBuiltin),
+		"include":                      starlark.NewBuiltin("include", includeBuiltin),
+	}
+}
+
+// Reference: ModuleFileGlobals.java module()
+func moduleBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		name, version, repoName string
+		compatibilityLevel      int
+		bazelCompatibility      *starlark.List
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"name?", &name,
+		"version?", &version,
+		"compatibility_level?", &compatibilityLevel,
+		"repo_name?", &repoName,
+		"bazel_compatibility?", &bazelCompatibility,
+	); err != nil {
+		return nil, err
+	}
+	mc, err := getModuleFileContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	if mc.moduleCalled {
+		return nil, fmt.Errorf("the module() directive can only be called once")
+	}
+	if mc.hadNonModule {
+		return nil, fmt.Errorf("if module() is called, it must be called before any other functions")
+	}
+	mc.moduleCalled = true
+
+	if name != "" && !moduleNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid module name '%s': valid names must 1) only contain lowercase letters (a-z), digits (0-9), dots (.), hyphens (-), and underscores (_); 2) begin with a lowercase letter; 3) end with a lowercase letter or digit.", name)
+	}
+	if _, err := ParseVersion(version); err != nil {
+		return nil, fmt.Errorf("invalid version in module(): %w", err)
+	}
+	if repoName != "" {
+		if err := mc.addRepoName(repoName, "the module() directive"); err != nil {
+			return nil, err
+		}
+	} else if name != "" {
+		mc.repoNames[name] = "the module() directive"
+	}
+	compat, err := stringList(bazelCompatibility, "bazel_compatibility")
+	if err != nil {
+		return nil, err
+	}
+
+	m := mc.module
+	m.Name = name
+	m.Version = version
+	m.CompatibilityLevel = compatibilityLevel
+	m.RepoName = repoName
+	m.BazelCompatibility = compat
+	return starlark.None, nil
+}
+
+// Reference: ModuleFileGlobals.java bazelDep()
+func bazelDepBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		name, version, repoName string
+		maxCompatibilityLevel   = -1
+		devDependency           bool
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"name", &name,
+		"version?", &version,
+		"max_compatibility_level?", &maxCompatibilityLevel,
+		"repo_name?", &repoName,
+		"dev_dependency?", &devDependency,
+	); err != nil {
+		return nil, err
+	}
+	mc, err := getModuleFileContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	if !moduleNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid module name '%s'", name)
+	}
+	if _, err := ParseVersion(version); err != nil {
+		return nil, fmt.Errorf("invalid version in bazel_dep(): %w", err)
+	}
+	if devDependency && mc.opts.IgnoreDevDependency {
+		return starlark.None, nil
+	}
+
+	dep := DepSpec{Name: name, Version: version, RepoName: repoName, MaxCompatibilityLevel: maxCompatibilityLevel}
+	if err := mc.addRepoName(depRepoName(dep), fmt.Sprintf("bazel_dep(name = \"%s\")", name)); err != nil {
+		return nil, err
+	}
+	mc.module.Deps = append(mc.module.Deps, dep)
+	return starlark.None, nil
+}
+
+// Reference: ModuleFileGlobals.java useExtension()
+func useExtensionBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		bzlFile, extName       string
+		devDependency, isolate bool
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"extension_bzl_file", &bzlFile,
+		"extension_name", &extName,
+		"dev_dependency?", &devDependency,
+		"isolate?", &isolate,
+	); err != nil {
+		return nil, err
+	}
+	mc, err := getModuleFileContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := &ExtensionProxy{devDependency: devDependency}
+	if devDependency && mc.opts.IgnoreDevDependency {
+		// Tags and imports of ignored proxies are dropped.
+		proxy.usage = &ExtensionUsage{ExtensionBzlFile: bzlFile, ExtensionName: extName, Imports: map[string]string{}, DevImports: map[string]bool{}}
+		proxy.ignored = true
+		return proxy, nil
+	}
+
+	key := bzlFile + "%" + extName
+	if usage, ok := mc.extensions[key]; ok && !isolate {
+		usage.HasNonDevUseExtension = usage.HasNonDevUseExtension || !devDependency
+		proxy.usage = usage
+		return proxy, nil
+	}
+	usage := &ExtensionUsage{
+		ExtensionBzlFile: bzlFile,
+		ExtensionName:    extName,
+		Isolate:          isolate,
+		Imports:          make(map[string]string),
+		DevImports:       make(map[string]bool),
+		Location:         callerLocation(thread),
+
+		HasNonDevUseExtension: !devDependency,
+	}
+	if !isolate {
+		mc.extensions[key] = usage
+	}
+	mc.module.ExtensionUsages = append(mc.module.ExtensionUsages, usage)
+	proxy.usage = usage
+	return proxy, nil
+}
+
+// Reference: ModuleFileGlobals.java useRepo()
+func useRepoBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: missing argument for extension_proxy", b.Name())
+	}
+	proxy, ok := args[0].(*ExtensionProxy)
+	if !ok {
+		return nil, fmt.Errorf("%s: for extension_proxy, got %s, want module_extension_proxy", b.Name(), args[0].Type())
+	}
+	mc, err := getModuleFileContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	add := func(local, exported string) error {
+		if proxy.ignored {
+			return nil
+		}
+		if err := mc.addRepoName(local, fmt.Sprintf("a use_repo() call for %s", proxy.usage.ExtensionName)); err != nil {
+			return err
+		}
+		proxy.usage.Imports[local] = exported
+		if proxy.devDependency {
+			proxy.usage.DevImports[local] = true
+		}
+		return nil
+	}
+	for _, arg := range args[1:] {
+		s, ok := arg.(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s for repo name, want string", b.Name(), arg.Type())
+		}
+		if err := add(string(s), string(s)); err != nil {
+			return nil, err
+		}
+	}
+	for _, kv := range kwargs {
+		exported, ok := kv[1].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s for repo name, want string", b.Name(), kv[1].Type())
+		}
+		if err := add(string(kv[0].(starlark.String)), string(exported)); err != nil {
+			return nil, err
+		}
+	}
+	return starlark.None, nil
+}
+
+// registerBuiltin implements register_toolchains() and register_execution_platforms().
+// Reference: ModuleFileGlobals.java registerToolchains(), registerExecutionPlatforms()
+func registerBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var devDependency bool
+	if err := starlark.UnpackArgs(b.Name(), nil, kwargs, "dev_dependency?", &devDependency); err != nil {
+		return nil, err
+	}
+	mc, err := getModuleFileContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	var labels []string
+	for _, arg := range args {
+		s, ok := arg.(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s, want string", b.Name(), arg.Type())
+		}
+		labels = append(labels, string(s))
+	}
+	if devDependency && mc.opts.IgnoreDevDependency {
+		return starlark.None, nil
+	}
+	if b.Name() == "register_toolchains" {
+		mc.module.Toolchains = append(mc.module.Toolchains, labels...)
+	} else {
+		mc.module.ExecutionPlatforms = append(mc.module.ExecutionPlatforms, labels...)
+	}
+	return starlark.None, nil
+}
+
+// Reference: ModuleFileGlobals.java singleVersionOverride()
+func singleVersionOverrideBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		moduleName, version, registry string
+		patches, patchCmds            *starlark.List
+		patchStrip                    int
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"module_name", &moduleName,
+		"version?", &version,
+		"registry?", &registry,
+		"patches?", &patches,
+		"patch_cmds?", &patchCmds,
+		"patch_strip?", &patchStrip,
+	); err != nil {
+		return nil, err
+	}
+	mc, err := getModuleFileContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ParseVersion(version); err != nil {
+		return nil, fmt.Errorf("invalid version in single_version_override(): %w", err)
+	}
+	o := &SingleVersionOverride{Version: version, Registry: registry, PatchStrip: patchStrip}
+	if o.Patches, err = stringList(patches, "patches"); err != nil {
+		return nil, err
+	}
+	if o.PatchCmds, err = stringList(patchCmds, "patch_cmds"); err != nil {
+		return nil, err
+	}
+	return starlark.None, mc.addOverride(moduleName, o)
+}
+
+// Reference: ModuleFileGlobals.java localPathOverride()
+func localPathOverrideBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var moduleName, p string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "module_name", &moduleName, "path", &p); err != nil {
+		return nil, err
+	}
+	mc, err := getModuleFileContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	return starlark.None, mc.addOverride(moduleName, &LocalPathOverride{Path: p})
+}
+
+// addOverride records an override. Overrides only take effect in the root
+// module and are silently ignored elsewhere.
+func (mc *moduleFileContext) addOverride(moduleName string, o Override) error {
+	if mc.module.Overrides == nil {
+		return nil
+	}
+	if _, ok := mc.module.Overrides[moduleName]; ok {
+		return fmt.Errorf("multiple overrides for dep %s found", moduleName)
+	}
+	mc.module.Overrides[moduleName] = o
+	return nil
+}
+
+// Reference: ModuleFileGlobals.java include()
+func includeBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var label string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "label", &label); err != nil {
+		return nil, err
+	}
+	mc, err := getModuleFileContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	if mc.opts.Key != RootModuleKey {
+		return nil, fmt.Errorf("include() can only be used in the root module")
+	}
+	if !strings.HasPrefix(label, "//") {
+		return nil, fmt.Errorf("include() only accepts main repo labels (starting with '//'); got %s", label)
+	}
+	pkg, name, ok := strings.Cut(label[2:], ":")
+	if !ok {
+		pkg, name = label[2:], path.Base(label[2:])
+	}
+	if !strings.HasSuffix(name, ".MODULE.bazel") {
+		return nil, fmt.Errorf("the label passed to include() must have a name ending in '.MODULE.bazel'; got %s", label)
+	}
+	if mc.included[label] {
+		return nil, fmt.Errorf("the file %s has already been included", label)
+	}
+	mc.included[label] = true
+	if mc.opts.FileSystem == nil {
+		return nil, fmt.Errorf("include(%s): no file system configured", label)
+	}
+
+	filename := mc.opts.FileSystem.Join(mc.opts.WorkspaceRoot, pkg, name)
+	source, err := mc.opts.FileSystem.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("include(%s): %w", label, err)
+	}
+	if err := mc.exec(filename, source); err != nil {
+		return nil, err
+	}
+	return starlark.None, nil
+}
+
+// ExtensionProxy is the value returned by use_extension(). Attribute access
+// returns the extension's tag classes; calling one records a tag.
+//
+// Reference: ModuleFileGlobals.java ModuleExtensionProxy
+type ExtensionProxy struct {
+	usage         *ExtensionUsage
+	devDependency bool
+	ignored       bool
+}
+
+var _ starlark.HasAttrs = (*ExtensionProxy)(nil)
+
+func (p *ExtensionProxy) String() string {
+	return fmt.Sprintf("<module extension %s from %s>", p.usage.ExtensionName, p.usage.ExtensionBzlFile)
+}
+func (p *ExtensionProxy) Type() string         { return "module_extension_proxy" }
+func (p *ExtensionProxy) Freeze()              {}
+func (p *ExtensionProxy) Truth() starlark.Bool { return true }
+func (p *ExtensionProxy) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: module_extension_proxy")
+}
+
+// Usage returns the extension usage the proxy records into.
+func (p *ExtensionProxy) Usage() *ExtensionUsage { return p.usage }
+
+// Attr returns a callable that records a tag of the given tag class.
+func (p *ExtensionProxy) Attr(name string) (starlark.Value, error) {
+	return starlark.NewBuiltin(p.usage.ExtensionName+"."+name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("%s: unexpected positional arguments", b.Name())
+		}
+		if p.ignored {
+			return starlark.None, nil
+		}
+		attrs := make(starlark.StringDict, len(kwargs))
+		for _, kv := range kwargs {
+			attrs[string(kv[0].(starlark.String))] = kv[1]
+		}
+		p.usage.Tags = append(p.usage.Tags, &Tag{
+			TagName:       name,
+			Attributes:    attrs,
+			DevDependency: p.devDependency,
+			Location:      callerLocation(thread),
+		})
+		return starlark.None, nil
+	}), nil
+}
+
+// AttrNames returns nil: tag classes are only known once the extension is loaded.
+func (p *ExtensionProxy) AttrNames() []string { return nil }
+
+// callerLocation returns the position of the innermost Starlark call.
+func callerLocation(thread *starlark.Thread) string {
+	if thread.CallStackDepth() < 2 {
+		return ""
+	}
+	return thread.CallFrame(1).Pos.String()
+}
+
+// stringList converts an optional list of strings.
+func stringList(l *starlark.List, what string) ([]string, error) {
+	if l == nil {
+		return nil, nil
+	}
+	result := make([]string, 0, l.Len())
+	for i := 0; i < l.Len(); i++ {
+		s, ok := l.Index(i).(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("got %s in '%s', want string", l.Index(i).Type(), what)
+		}
+		result = append(result, string(s))
+	}
+	return result, nil
+}
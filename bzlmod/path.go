@@ -0,0 +1,115 @@
+package bzlmod
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Path is a file system path as returned by module_ctx.path() and
+// repository_ctx.path().
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/rules/repository/StarlarkPath.java
+type Path struct {
+	path string
+}
+
+var (
+	_ starlark.HasAttrs   = (*Path)(nil)
+	_ starlark.Comparable = (*Path)(nil)
+)
+
+// NewPath creates a Path for p.
+func NewPath(p string) *Path { return &Path{path: filepath.Clean(p)} }
+
+// Path returns the path as a string.
+func (p *Path) Path() string { return p.path }
+
+func (p *Path) String() string        { return p.path }
+func (p *Path) Type() string          { return "path" }
+func (p *Path) Freeze()               {}
+func (p *Path) Truth() starlark.Bool  { return true }
+func (p *Path) Hash() (uint32, error) { return starlark.String(p.path).Hash() }
+
+// CompareSameType compares paths by their string form.
+func (p *Path) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	return starlark.Compare(op, starlark.String(p.path), starlark.String(y.(*Path).path))
+}
+
+// Attr returns an attribute of the path.
+func (p *Path) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "basename":
+		return starlark.String(filepath.Base(p.path)), nil
+	case "dirname":
+		dir := filepath.Dir(p.path)
+		if dir == p.path {
+			return starlark.None, nil
+		}
+		return NewPath(dir), nil
+	case "exists":
+		_, err := os.Stat(p.path)
+		return starlark.Bool(err == nil), nil
+	case "is_dir":
+		info, err := os.Stat(p.path)
+		return starlark.Bool(err == nil && info.IsDir()), nil
+	case "realpath":
+		real, err := filepath.EvalSymlinks(p.path)
+		if err != nil {
+			return NewPath(p.path), nil
+		}
+		return NewPath(real), nil
+	case "get_child":
+		return starlark.NewBuiltin("get_child", p.getChild), nil
+	case "readdir":
+		return starlark.NewBuiltin("readdir", p.readdir), nil
+	}
+	return nil, starlark.NoSuchAttrError(fmt.Sprintf("path has no attribute %q", name))
+}
+
+// AttrNames returns the list of attribute names.
+func (p *Path) AttrNames() []string {
+	return []string{"basename", "dirname", "exists", "get_child", "is_dir", "readdir", "realpath"}
+}
+
+// Reference: StarlarkPath.java getChild()
+func (p *Path) getChild(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(kwargs) > 0 {
+		return nil, fmt.Errorf("%s: unexpected keyword arguments", b.Name())
+	}
+	elems := []string{p.path}
+	for _, arg := range args {
+		s, ok := arg.(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s, want string", b.Name(), arg.Type())
+		}
+		elems = append(elems, string(s))
+	}
+	return NewPath(filepath.Join(elems...)), nil
+}
+
+// Reference: StarlarkPath.java readdir()
+func (p *Path) readdir(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var watch string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "watch?", &watch); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	children := make([]starlark.Value, 0, len(names))
+	for _, name := range names {
+		children = append(children, NewPath(filepath.Join(p.path, name)))
+	}
+	return starlark.NewList(children), nil
+}
@@ -0,0 +1,169 @@
+package bzlmod
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+)
+
+// ErrModuleNotFound is returned by a Registry that does not have a module version.
+var ErrModuleNotFound = errors.New("module not found")
+
+// Registry provides module files and source information for module versions.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/Registry.java
+type Registry interface {
+	// URL identifies the registry.
+	URL() string
+
+	// GetModuleFile returns the MODULE.bazel contents of a module version, or
+	// an error wrapping ErrModuleNotFound.
+	GetModuleFile(key ModuleKey) ([]byte, error)
+
+	// GetRepoSpec returns how to fetch the repository of a module version.
+	GetRepoSpec(key ModuleKey) (*RepoSpec, error)
+}
+
+// RegistryFileReader is implemented by registries whose files can be read
+// directly, so that the hashes of their source.json files can be recorded in
+// and checked against MODULE.bazel.lock.
+type RegistryFileReader interface {
+	// ReadRegistryFile reads a file by its slash-separated path relative to
+	// URL().
+	ReadRegistryFile(path string) ([]byte, error)
+}
+
+// RepoSpec describes the repository of a module version, as read from a
+// registry's source.json.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/IndexRegistry.java
+type RepoSpec struct {
+	// Type is "archive", "local_path" or "git_repository".
+	Type string
+
+	// Path is the directory of a local_path repository, resolved against the
+	// registry directory.
+	Path string
+
+	// Archive sources.
+	URLs        []string
+	Integrity   string
+	StripPrefix string
+	ArchiveType string
+	Patches     map[string]string
+	PatchStrip  int
+
+	// Git sources.
+	Remote string
+	Commit string
+	Tag    string
+}
+
+// LocalRegistry is a registry stored in a directory using the index registry
+// layout:
+//
+//	<dir>/modules/<name>/<version>/MODULE.bazel
+//	<dir>/modules/<name>/<version>/source.json
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/IndexRegistry.java
+type LocalRegistry struct {
+	fs  loader.FileSystem
+	dir string
+}
+
+var (
+	_ Registry           = (*LocalRegistry)(nil)
+	_ RegistryFileReader = (*LocalRegistry)(nil)
+)
+
+// NewLocalRegistry creates a registry reading from dir. A "file://" prefix is
+// accepted and stripped.
+func NewLocalRegistry(fsys loader.FileSystem, dir string) *LocalRegistry {
+	if fsys == nil {
+		fsys = loader.NewOSFileSystem("")
+	}
+	return &LocalRegistry{fs: fsys, dir: strings.TrimPrefix(dir, "file://")}
+}
+
+// URL returns the registry directory as a file URL.
+func (r *LocalRegistry) URL() string { return "file://" + r.dir }
+
+// GetModuleFile reads modules/<name>/<version>/MODULE.bazel.
+func (r *LocalRegistry) GetModuleFile(key ModuleKey) ([]byte, error) {
+	data, err := r.fs.ReadFile(r.fs.Join(r.dir, "modules", key.Name, key.Version, ModuleFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s in registry %s: %w", key, r.URL(), ErrModuleNotFound)
+	}
+	return data, err
+}
+
+// ReadRegistryFile reads a file of the registry directory.
+func (r *LocalRegistry) ReadRegistryFile(path string) ([]byte, error) {
+	return r.fs.ReadFile(r.fs.Join(r.dir, filepath.FromSlash(path)))
+}
+
+// sourceJSON is the schema of source.json.
+type sourceJSON struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path"`
+	URL         string            `json:"url"`
+	MirrorURLs  []string          `json:"mirror_urls"`
+	Integrity   string            `json:"integrity"`
+	StripPrefix string            `json:"strip_prefix"`
+	ArchiveType string            `json:"archive_type"`
+	Patches     map[string]string `json:"patches"`
+	PatchStrip  int               `json:"patch_strip"`
+	Remote      string            `json:"remote"`
+	Commit      string            `json:"commit"`
+	Tag         string            `json:"tag"`
+}
+
+// GetRepoSpec reads modules/<name>/<version>/source.json.
+// Reference: IndexRegistry.java getRepoSpec()
+func (r *LocalRegistry) GetRepoSpec(key ModuleKey) (*RepoSpec, error) {
+	versionDir := r.fs.Join(r.dir, "modules", key.Name, key.Version)
+	data, err := r.fs.ReadFile(r.fs.Join(versionDir, "source.json"))
+	if err != nil {
+		return nil, fmt.Errorf("reading source.json of %s: %w", key, err)
+	}
+	var src sourceJSON
+	if err := json.Unmarshal(data, &src); err != nil {
+		return nil, fmt.Errorf("parsing source.json of %s: %w", key, err)
+	}
+
+	spec := &RepoSpec{
+		Type:        src.Type,
+		Integrity:   src.Integrity,
+		StripPrefix: src.StripPrefix,
+		ArchiveType: src.ArchiveType,
+		PatchStrip:  src.PatchStrip,
+		Remote:      src.Remote,
+		Commit:      src.Commit,
+		Tag:         src.Tag,
+	}
+	switch src.Type {
+	case "", "archive":
+		spec.Type = "archive"
+		spec.URLs = append([]string{src.URL}, src.MirrorURLs...)
+		if len(src.Patches) > 0 {
+			spec.Patches = make(map[string]string, len(src.Patches))
+			for name, integrity := range src.Patches {
+				spec.Patches[r.fs.Join(versionDir, "patches", name)] = integrity
+			}
+		}
+	case "local_path":
+		spec.Path = src.Path
+		if !filepath.IsAbs(spec.Path) {
+			spec.Path = r.fs.Join(r.dir, spec.Path)
+		}
+	case "git_repository":
+	default:
+		return nil, fmt.Errorf("invalid source type \"%s\" for module %s", src.Type, key)
+	}
+	return spec, nil
+}
@@ -0,0 +1,400 @@
+package bzlmod
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// RepoDefinition is a repository declared by calling a repository rule.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/RepoSpec.java
+type RepoDefinition struct {
+	// Name is the name given in the call, unique within the caller.
+	Name string
+
+	// RuleName names the repository rule, e.g. "local_repository".
+	RuleName string
+
+	// Attrs are the attribute values passed to the rule.
+	Attrs starlark.StringDict
+
+	// Location is where the rule was called.
+	Location string
+
+	// Fetch creates the repository.
+	Fetch func(*FetchContext) error
+}
+
+// FetchContext is the environment a repository is created in.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/rules/repository/RepositoryDirectoryValue.java
+type FetchContext struct {
+	// Name is the canonical repository name.
+	Name string
+
+	// Dir is the directory the repository is created in:
+	// <output base>/external/<canonical name>.
+	Dir string
+
+	// Root is the root directory of the repository, Dir unless the rule
+	// points at an existing directory as local_repository does.
+	Root string
+
+	// WorkspaceRoot is the main repository's directory. Relative paths in
+	// repository rule attributes are resolved against it.
+	WorkspaceRoot string
+
+	// Fetcher serves download URLs.
+	Fetcher Fetcher
+
+	// Environ is the client environment.
+	Environ map[string]string
+
+	// Print receives print() output.
+	Print func(msg string)
+
+	// RepoRoots maps canonical repository names to their directories.
+	RepoRoots map[string]string
+
+	// fetchRepo fetches a repository of the same RepoSet on first use.
+	fetchRepo func(canonical string) (string, bool, error)
+}
+
+// LabelPath returns the file a label refers to.
+func (c *FetchContext) LabelPath(label *types.Label) (string, error) {
+	root := c.WorkspaceRoot
+	if repo := label.Repo(); repo != "" {
+		dir, ok := c.RepoRoots[repo]
+		if !ok && c.fetchRepo != nil {
+			var err error
+			if dir, ok, err = c.fetchRepo(repo); err != nil {
+				return "", err
+			}
+		}
+		if !ok {
+			return "", fmt.Errorf("unable to resolve %s: repository '@@%s' is not available", label, repo)
+		}
+		root = dir
+	}
+	return filepath.Join(root, filepath.FromSlash(label.Pkg()), filepath.FromSlash(label.Name())), nil
+}
+
+// RepoDefiner receives the repositories declared by repository rule calls.
+// Module extensions and WORKSPACE files install one in the thread.
+type RepoDefiner interface {
+	DefineRepo(thread *starlark.Thread, def *RepoDefinition) error
+}
+
+const threadKeyRepoDefiner = "starlark-go-bazel:repo_definer"
+
+// SetRepoDefiner installs the RepoDefiner of a thread.
+func SetRepoDefiner(thread *starlark.Thread, d RepoDefiner) {
+	thread.SetLocal(threadKeyRepoDefiner, d)
+}
+
+// defineRepo hands a repository definition to the thread's RepoDefiner.
+// Reference: StarlarkRepositoryModule.java RepositoryRuleFunction.call()
+func defineRepo(thread *starlark.Thread, def *RepoDefinition) error {
+	d, ok := thread.Local(threadKeyRepoDefiner).(RepoDefiner)
+	if !ok {
+		return fmt.Errorf("%s: repo rules can only be called from within module extension impl functions", def.RuleName)
+	}
+	if !repoNamePattern.MatchString(def.Name) {
+		return fmt.Errorf("%s: invalid user-provided repo name '%s': valid names may contain only A-Z, a-z, 0-9, '-', '_' and '.', and must start with a letter", def.RuleName, def.Name)
+	}
+	def.Location = callerLocation(thread)
+	return d.DefineRepo(thread, def)
+}
+
+// parseLabelAttr converts a label attribute of a repository rule call,
+// relative to the repository of the calling .bzl file.
+func parseLabelAttr(thread *starlark.Thread, s string) (*types.Label, error) {
+	var c types.LabelContext
+	if lc := types.GetLabelContext(thread); lc != nil {
+		c = *lc
+	}
+	return types.ParseLabelInContext(s, c)
+}
+
+// RepoSet is a RepoDefiner that collects repository definitions and fetches
+// them into an output base. Each repository is fetched at most once; a
+// repository referenced by a label while another is being fetched is
+// fetched first.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/rules/repository/RepositoryDelegatorFunction.java
+type RepoSet struct {
+	prefix string
+	repos  map[string]*RepoDefinition
+
+	roots    map[string]string
+	fetching map[string]bool
+}
+
+var _ RepoDefiner = (*RepoSet)(nil)
+
+// NewRepoSet creates an empty RepoSet. The canonical name of each repository
+// is prefix followed by the name it was defined with.
+func NewRepoSet(prefix string) *RepoSet {
+	return &RepoSet{
+		prefix:   prefix,
+		repos:    make(map[string]*RepoDefinition),
+		roots:    make(map[string]string),
+		fetching: make(map[string]bool),
+	}
+}
+
+// DefineRepo implements RepoDefiner.
+func (s *RepoSet) DefineRepo(_ *starlark.Thread, def *RepoDefinition) error {
+	if prev, ok := s.repos[def.Name]; ok {
+		return fmt.Errorf("A repo named %s is already defined at %s", def.Name, prev.Location)
+	}
+	s.repos[def.Name] = def
+	return nil
+}
+
+// Names returns the names of the defined repositories in sorted order.
+func (s *RepoSet) Names() []string { return sortedRepoNames(s.repos) }
+
+// Get returns the definition of a repository.
+func (s *RepoSet) Get(name string) (*RepoDefinition, bool) {
+	def, ok := s.repos[name]
+	return def, ok
+}
+
+// Definitions returns the definitions keyed by name.
+func (s *RepoSet) Definitions() map[string]*RepoDefinition {
+	defs := make(map[string]*RepoDefinition, len(s.repos))
+	for name, def := range s.repos {
+		defs[name] = def
+	}
+	return defs
+}
+
+// CanonicalName returns the canonical name of a repository of the set.
+func (s *RepoSet) CanonicalName(name string) string { return s.prefix + name }
+
+// FetchOptions configures RepoSet.Fetch.
+type FetchOptions struct {
+	// OutputBase is the directory repositories are created under, in
+	// <output base>/external/<canonical name>. Required.
+	OutputBase string
+
+	// WorkspaceRoot is the main repository's directory.
+	WorkspaceRoot string
+
+	// Fetcher serves download URLs.
+	Fetcher Fetcher
+
+	// Environ is the client environment. Defaults to the process environment.
+	Environ map[string]string
+
+	// Print receives print() output.
+	Print func(msg string)
+
+	// RepoRoots are the directories of repositories outside the set, keyed
+	// by canonical name.
+	RepoRoots map[string]string
+}
+
+// Fetch creates every repository of the set and returns their root
+// directories keyed by canonical name.
+func (s *RepoSet) Fetch(opts FetchOptions) (map[string]string, error) {
+	if opts.OutputBase == "" {
+		return nil, fmt.Errorf("fetching repositories requires an output base")
+	}
+	if opts.Environ == nil {
+		opts.Environ = clientEnviron()
+	}
+	for _, name := range s.Names() {
+		if _, err := s.fetch(name, opts); err != nil {
+			return nil, err
+		}
+	}
+	roots := make(map[string]string, len(s.roots))
+	for canonical, root := range s.roots {
+		roots[canonical] = root
+	}
+	return roots, nil
+}
+
+// fetch creates one repository unless it already exists.
+func (s *RepoSet) fetch(name string, opts FetchOptions) (string, error) {
+	canonical := s.CanonicalName(name)
+	if root, ok := s.roots[canonical]; ok {
+		return root, nil
+	}
+	if s.fetching[name] {
+		return "", fmt.Errorf("cycle while fetching repository '@@%s'", canonical)
+	}
+	s.fetching[name] = true
+	defer delete(s.fetching, name)
+
+	c := &FetchContext{
+		Name:          canonical,
+		Dir:           filepath.Join(opts.OutputBase, "external", canonical),
+		WorkspaceRoot: opts.WorkspaceRoot,
+		Fetcher:       opts.Fetcher,
+		Environ:       opts.Environ,
+		Print:         opts.Print,
+		RepoRoots:     opts.RepoRoots,
+		fetchRepo: func(repo string) (string, bool, error) {
+			name, ok := strings.CutPrefix(repo, s.prefix)
+			if _, defined := s.repos[name]; !ok || !defined {
+				return "", false, nil
+			}
+			root, err := s.fetch(name, opts)
+			return root, err == nil, err
+		},
+	}
+	c.Root = c.Dir
+	if err := os.RemoveAll(c.Dir); err != nil {
+		return "", err
+	}
+	if err := s.repos[name].Fetch(c); err != nil {
+		return "", fmt.Errorf("fetching repository '@@%s': %w", canonical, err)
+	}
+	if err := os.MkdirAll(c.Root, 0o755); err != nil {
+		return "", err
+	}
+	s.roots[canonical] = c.Root
+	return c.Root, nil
+}
+
+// LocalRepoRules returns the globals of
+// @bazel_tools//tools/build_defs/repo:local.bzl.
+//
+// Reference: bazel/tools/build_defs/repo/local.bzl
+func LocalRepoRules() starlark.StringDict {
+	return starlark.StringDict{
+		"local_repository":     starlark.NewBuiltin("local_repository", localRepository),
+		"new_local_repository": starlark.NewBuiltin("new_local_repository", newLocalRepository),
+	}
+}
+
+// LocalRepoRulesLabel is the label LocalRepoRules is loadable as.
+const LocalRepoRulesLabel = "@bazel_tools//tools/build_defs/repo:local.bzl"
+
+// Reference: local.bzl local_repository
+func localRepository(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, path string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "path", &path); err != nil {
+		return nil, err
+	}
+	return starlark.None, defineRepo(thread, &RepoDefinition{
+		Name:     name,
+		RuleName: b.Name(),
+		Attrs:    starlark.StringDict{"name": starlark.String(name), "path": starlark.String(path)},
+		Fetch: func(c *FetchContext) error {
+			dir := c.absPath(path)
+			info, err := os.Stat(dir)
+			if err != nil || !info.IsDir() {
+				return fmt.Errorf("%s: The repository's path is \"%s\" (absolute: \"%s\") but it does not exist or is not a directory.", c.Name, path, dir)
+			}
+			c.Root = dir
+			return nil
+		},
+	})
+}
+
+// Reference: local.bzl new_local_repository
+func newLocalRepository(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		name, path       string
+		buildFile        starlark.Value = starlark.None
+		buildFileContent starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"name", &name, "path", &path,
+		"build_file?", &buildFile, "build_file_content?", &buildFileContent,
+	); err != nil {
+		return nil, err
+	}
+	if (buildFile == starlark.None) == (buildFileContent == starlark.None) {
+		return nil, fmt.Errorf("%s: exactly one of build_file and build_file_content must be provided", b.Name())
+	}
+	switch v := buildFile.(type) {
+	case starlark.NoneType, *types.Label:
+	case starlark.String:
+		label, err := parseLabelAttr(thread, string(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s: build_file: %w", b.Name(), err)
+		}
+		buildFile = label
+	default:
+		return nil, fmt.Errorf("%s: got %s for 'build_file', want string or Label", b.Name(), buildFile.Type())
+	}
+	if _, ok := buildFileContent.(starlark.String); !ok && buildFileContent != starlark.None {
+		return nil, fmt.Errorf("%s: got %s for 'build_file_content', want string", b.Name(), buildFileContent.Type())
+	}
+	return starlark.None, defineRepo(thread, &RepoDefinition{
+		Name:     name,
+		RuleName: b.Name(),
+		Attrs: starlark.StringDict{
+			"name":               starlark.String(name),
+			"path":               starlark.String(path),
+			"build_file":         buildFile,
+			"build_file_content": buildFileContent,
+		},
+		Fetch: func(c *FetchContext) error {
+			src := c.absPath(path)
+			entries, err := os.ReadDir(src)
+			if err != nil {
+				return fmt.Errorf("%s: %w", c.Name, err)
+			}
+			if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if isBuildFileName(e.Name()) {
+					continue
+				}
+				if err := writeSymlink(filepath.Join(c.Dir, e.Name()), filepath.Join(src, e.Name())); err != nil {
+					return err
+				}
+			}
+
+			var content []byte
+			switch v := buildFile.(type) {
+			case *types.Label:
+				file, err := c.LabelPath(v)
+				if err != nil {
+					return err
+				}
+				if content, err = os.ReadFile(file); err != nil {
+					return fmt.Errorf("%s: build_file: %w", c.Name, err)
+				}
+			default:
+				content = []byte(buildFileContent.(starlark.String))
+			}
+			return os.WriteFile(filepath.Join(c.Dir, "BUILD.bazel"), content, 0o644)
+		},
+	})
+}
+
+// absPath resolves a path attribute against the workspace root.
+func (c *FetchContext) absPath(p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(c.WorkspaceRoot, p)
+}
+
+func isBuildFileName(name string) bool {
+	return name == "BUILD" || name == "BUILD.bazel" || name == "WORKSPACE" || name == "WORKSPACE.bazel" || name == "REPO.bazel"
+}
+
+// sortedRepoNames returns the names of definitions in sorted order.
+func sortedRepoNames(defs map[string]*RepoDefinition) []string {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
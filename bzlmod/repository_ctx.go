@@ -0,0 +1,271 @@
+package bzlmod
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// RepositoryCtx is the repository_ctx value passed to a repository rule's
+// implementation function. Relative paths resolve against the repository
+// directory.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/starlark/StarlarkRepositoryContext.java
+type RepositoryCtx struct {
+	io            ioContext
+	name          string
+	originalName  string
+	attr          *starlarkstruct.Struct
+	workspaceRoot string
+}
+
+var _ starlark.HasAttrs = (*RepositoryCtx)(nil)
+
+func (r *RepositoryCtx) String() string       { return "<repository_ctx>" }
+func (r *RepositoryCtx) Type() string         { return "repository_ctx" }
+func (r *RepositoryCtx) Freeze()              {}
+func (r *RepositoryCtx) Truth() starlark.Bool { return true }
+func (r *RepositoryCtx) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: repository_ctx")
+}
+
+// Attr returns an attribute of repository_ctx.
+func (r *RepositoryCtx) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "name":
+		return starlark.String(r.name), nil
+	case "original_name":
+		return starlark.String(r.originalName), nil
+	case "attr":
+		return r.attr, nil
+	case "workspace_root":
+		return NewPath(r.workspaceRoot), nil
+	case "template":
+		return starlark.NewBuiltin(name, r.template), nil
+	case "symlink":
+		return starlark.NewBuiltin(name, r.symlink), nil
+	case "execute":
+		return starlark.NewBuiltin(name, r.execute), nil
+	case "delete":
+		return starlark.NewBuiltin(name, r.delete), nil
+	}
+	if v, ok := r.io.attr(name); ok {
+		return v, nil
+	}
+	return nil, starlark.NoSuchAttrError(fmt.Sprintf("repository_ctx has no attribute %q", name))
+}
+
+// AttrNames returns the list of attribute names.
+func (r *RepositoryCtx) AttrNames() []string {
+	names := append([]string{"attr", "delete", "execute", "name", "original_name", "symlink", "template", "workspace_root"}, ioAttrNames...)
+	sort.Strings(names)
+	return names
+}
+
+// Reference: StarlarkRepositoryContext.java createFileFromTemplate()
+func (r *RepositoryCtx) template(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		p, tmpl       starlark.Value
+		substitutions *starlark.Dict
+		executable    = true
+		watchTemplate string
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"path", &p, "template", &tmpl, "substitutions?", &substitutions,
+		"executable?", &executable, "watch_template?", &watchTemplate,
+	); err != nil {
+		return nil, err
+	}
+	dest, err := r.io.resolvePath(p, "path")
+	if err != nil {
+		return nil, err
+	}
+	src, err := r.io.resolvePath(tmpl, "template")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(src.Path())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	content := string(data)
+	if substitutions != nil {
+		for _, item := range substitutions.Items() {
+			key, ok1 := item[0].(starlark.String)
+			value, ok2 := item[1].(starlark.String)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("%s: substitutions must map strings to strings", b.Name())
+			}
+			content = strings.ReplaceAll(content, string(key), string(value))
+		}
+	}
+	if err := writeFileBytes(dest.Path(), []byte(content), executable); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+// Reference: StarlarkRepositoryContext.java symlink()
+func (r *RepositoryCtx) symlink(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var target, linkName starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "target", &target, "link_name", &linkName); err != nil {
+		return nil, err
+	}
+	from, err := r.io.resolvePath(target, "target")
+	if err != nil {
+		return nil, err
+	}
+	to, err := r.io.resolvePath(linkName, "link_name")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSymlink(to.Path(), from.Path()); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+// Reference: StarlarkRepositoryContext.java delete()
+func (r *RepositoryCtx) delete(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var p starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &p); err != nil {
+		return nil, err
+	}
+	resolved, err := r.io.resolvePath(p, "path")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(resolved.Path()); err != nil {
+		return starlark.False, nil
+	}
+	if err := os.RemoveAll(resolved.Path()); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+	return starlark.True, nil
+}
+
+// execute runs a local process in the repository directory.
+// Reference: StarlarkRepositoryContext.java execute()
+func (r *RepositoryCtx) execute(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		arguments        *starlark.List
+		timeout          = 600
+		environment      *starlark.Dict
+		quiet            = true
+		workingDirectory string
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"arguments", &arguments, "timeout?", &timeout, "environment?", &environment,
+		"quiet?", &quiet, "working_directory?", &workingDirectory,
+	); err != nil {
+		return nil, err
+	}
+	if arguments.Len() == 0 {
+		return nil, fmt.Errorf("%s: arguments must not be empty", b.Name())
+	}
+	argv := make([]string, 0, arguments.Len())
+	for i := 0; i < arguments.Len(); i++ {
+		switch v := arguments.Index(i).(type) {
+		case starlark.String:
+			argv = append(argv, string(v))
+		case *Path:
+			argv = append(argv, v.Path())
+		case *types.Label:
+			p, err := r.io.resolvePath(v, "arguments")
+			if err != nil {
+				return nil, err
+			}
+			argv = append(argv, p.Path())
+		default:
+			return nil, fmt.Errorf("%s: got %s in 'arguments', want string, Label or path", b.Name(), v.Type())
+		}
+	}
+
+	env := make(map[string]string, len(r.io.environ))
+	for k, v := range r.io.environ {
+		env[k] = v
+	}
+	if environment != nil {
+		for _, item := range environment.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("%s: environment keys must be strings", b.Name())
+			}
+			switch v := item[1].(type) {
+			case starlark.NoneType:
+				delete(env, string(key))
+			case starlark.String:
+				env[string(key)] = string(v)
+			default:
+				return nil, fmt.Errorf("%s: environment values must be strings or None", b.Name())
+			}
+		}
+	}
+
+	dir := r.io.workDir
+	if workingDirectory != "" {
+		p, err := r.io.resolvePath(starlark.String(workingDirectory), "working_directory")
+		if err != nil {
+			return nil, err
+		}
+		dir = p.Path()
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Name(), err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	program := argv[0]
+	if !strings.ContainsRune(program, filepath.Separator) {
+		if found, ok := lookPath(program, env["PATH"]); ok {
+			program = found
+		}
+	}
+	cmd := exec.CommandContext(ctx, program, argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = environList(env)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	returnCode := 0
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			returnCode = 256 + 14 // Bazel reports a timeout as SIGALRM.
+			stderr.WriteString(fmt.Sprintf("Timed out after %d seconds\n", timeout))
+		case errors.As(err, &exitErr):
+			returnCode = exitErr.ExitCode()
+		default:
+			returnCode = 256
+			stderr.WriteString(err.Error())
+		}
+	}
+	return starlarkstruct.FromStringDict(starlark.String("exec_result"), starlark.StringDict{
+		"return_code": starlark.MakeInt(returnCode),
+		"stdout":      starlark.String(stdout.String()),
+		"stderr":      starlark.String(stderr.String()),
+	}), nil
+}
+
+// environList converts an environment map to KEY=VALUE form.
+func environList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
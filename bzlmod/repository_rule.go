@@ -0,0 +1,268 @@
+package bzlmod
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/albertocavalcante/starlark-go-bazel/builtins"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// RepositoryRule is a repository rule created by repository_rule(). Calling
+// it declares a repository through the thread's RepoDefiner; fetching the
+// repository runs the implementation function with a repository_ctx.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/repository/starlark/StarlarkRepositoryModule.java
+type RepositoryRule struct {
+	name           string
+	implementation starlark.Callable
+	attrs          map[string]builtins.AttrSchema
+	local          bool
+	configure      bool
+	remotable      bool
+	environ        []string
+	doc            string
+	frozen         bool
+}
+
+var (
+	_ starlark.Value    = (*RepositoryRule)(nil)
+	_ starlark.Callable = (*RepositoryRule)(nil)
+)
+
+// String returns the Starlark representation.
+func (r *RepositoryRule) String() string {
+	if r.name != "" {
+		return fmt.Sprintf("<repository_rule %s>", r.name)
+	}
+	return "<repository_rule>"
+}
+
+// Type returns "repository_rule".
+func (r *RepositoryRule) Type() string { return "repository_rule" }
+
+// Freeze marks the rule as frozen.
+func (r *RepositoryRule) Freeze() { r.frozen = true }
+
+// Truth returns true.
+func (r *RepositoryRule) Truth() starlark.Bool { return true }
+
+// Hash returns an error (repository rules are not hashable).
+func (r *RepositoryRule) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: repository_rule")
+}
+
+// Name returns the rule's name, or the implementation function's name if the
+// rule has not been exported.
+func (r *RepositoryRule) Name() string {
+	if r.name == "" {
+		return r.implementation.Name()
+	}
+	return r.name
+}
+
+// SetName sets the rule's name. Called during export.
+func (r *RepositoryRule) SetName(name string) { r.name = name }
+
+// IsExported reports whether the rule has been assigned to a global.
+func (r *RepositoryRule) IsExported() bool { return r.name != "" }
+
+// Export names the rule after the global it is assigned to.
+func (r *RepositoryRule) Export(name string) error {
+	r.name = name
+	return nil
+}
+
+// Implementation returns the rule's implementation function.
+func (r *RepositoryRule) Implementation() starlark.Callable { return r.implementation }
+
+// Attrs returns the rule's attribute schemas.
+func (r *RepositoryRule) Attrs() map[string]builtins.AttrSchema { return r.attrs }
+
+// IsLocal reports whether the rule was declared with local = True.
+func (r *RepositoryRule) IsLocal() bool { return r.local }
+
+// IsConfigure reports whether the rule was declared with configure = True.
+func (r *RepositoryRule) IsConfigure() bool { return r.configure }
+
+// Environ returns the environment variables the rule depends on.
+func (r *RepositoryRule) Environ() []string { return r.environ }
+
+// Doc returns the documentation string.
+func (r *RepositoryRule) Doc() string { return r.doc }
+
+// CallInternal declares a repository.
+// Reference: StarlarkRepositoryModule.java RepositoryRuleFunction.call()
+func (r *RepositoryRule) CallInternal(thread *starlark.Thread, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("%s: unexpected positional arguments", r.Name())
+	}
+	var lc types.LabelContext
+	if c := types.GetLabelContext(thread); c != nil {
+		lc = *c
+	}
+
+	values := make(starlark.StringDict, len(kwargs))
+	for _, kv := range kwargs {
+		values[string(kv[0].(starlark.String))] = kv[1]
+	}
+	name, ok := values["name"].(starlark.String)
+	if !ok {
+		return nil, fmt.Errorf("%s: missing value for mandatory attribute 'name'", r.Name())
+	}
+
+	attrs := starlark.StringDict{"name": name}
+	for key := range values {
+		if key == "name" {
+			continue
+		}
+		if _, ok := r.attrs[key]; !ok || key[0] == '_' {
+			return nil, fmt.Errorf("%s: no such attribute '%s' in '%s' rule", r.Name(), key, r.Name())
+		}
+	}
+	for key, schema := range r.attrs {
+		v, ok := values[key]
+		if !ok {
+			if schema.IsMandatory() {
+				return nil, fmt.Errorf("%s: missing value for mandatory attribute '%s' in '%s' rule", r.Name(), key, r.Name())
+			}
+			v = schema.DefaultValue()
+			if v == starlark.None {
+				v = builtins.AttrTypeDefault(schema.AttrType())
+			}
+		}
+		converted, err := convertAttrValue(schema.AttrType(), v, lc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: attribute '%s': %w", r.Name(), key, err)
+		}
+		converted.Freeze()
+		attrs[key] = converted
+	}
+
+	threadPrint := thread.Print
+	return starlark.None, defineRepo(thread, &RepoDefinition{
+		Name:     string(name),
+		RuleName: r.Name(),
+		Attrs:    attrs,
+		Fetch: func(c *FetchContext) error {
+			return r.fetch(c, attrs, lc, threadPrint)
+		},
+	})
+}
+
+// fetch runs the implementation function in the repository directory.
+// Reference: StarlarkRepositoryFunction.java fetchInternal()
+func (r *RepositoryRule) fetch(c *FetchContext, attrs starlark.StringDict, lc types.LabelContext, threadPrint func(*starlark.Thread, string)) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	ctx := &RepositoryCtx{
+		io: ioContext{
+			kind:      "repository_ctx",
+			workDir:   c.Dir,
+			labelPath: c.LabelPath,
+			fetcher:   c.Fetcher,
+			environ:   c.Environ,
+		},
+		name:          c.Name,
+		originalName:  string(attrs["name"].(starlark.String)),
+		attr:          starlarkstruct.FromStringDict(starlarkstruct.Default, attrs),
+		workspaceRoot: c.WorkspaceRoot,
+	}
+	thread := &starlark.Thread{Name: "repository " + c.Name, Print: threadPrint}
+	if c.Print != nil {
+		thread.Print = func(_ *starlark.Thread, msg string) { c.Print(msg) }
+	}
+	types.SetLabelContext(thread, &lc)
+
+	ret, err := starlark.Call(thread, r.implementation, starlark.Tuple{ctx}, nil)
+	if err != nil {
+		return err
+	}
+	if ret != starlark.None {
+		if _, ok := ret.(*starlark.Dict); !ok {
+			return fmt.Errorf("%s: expected the implementation function to return None or a dict, got %s", r.Name(), ret.Type())
+		}
+	}
+	return nil
+}
+
+// Predeclared returns the globals of .bzl files that define module
+// extensions and repository rules: builtins.Predeclared() plus
+// repository_rule().
+func Predeclared() starlark.StringDict {
+	predeclared := builtins.Predeclared()
+	predeclared["repository_rule"] = starlark.NewBuiltin("repository_rule", RepositoryRuleBuiltin)
+	return predeclared
+}
+
+// RepositoryRuleBuiltin is the Starlark repository_rule() builtin function.
+//
+// Signature:
+//
+//	repository_rule(
+//	    implementation,
+//	    *,
+//	    attrs = None,
+//	    local = False,
+//	    environ = [],
+//	    configure = False,
+//	    remotable = False,
+//	    doc = None,
+//	)
+//
+// Reference: StarlarkRepositoryModule.java repositoryRule()
+func RepositoryRuleBuiltin(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		implementation              starlark.Callable
+		attrs                       *starlark.Dict
+		local, configure, remotable bool
+		environ                     *starlark.List
+		doc                         starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs,
+		"implementation", &implementation,
+		"attrs?", &attrs,
+		"local?", &local,
+		"environ?", &environ,
+		"configure?", &configure,
+		"remotable?", &remotable,
+		"doc?", &doc,
+	); err != nil {
+		return nil, err
+	}
+
+	r := &RepositoryRule{
+		implementation: implementation,
+		attrs:          make(map[string]builtins.AttrSchema),
+		local:          local,
+		configure:      configure,
+		remotable:      remotable,
+	}
+	if s, ok := doc.(starlark.String); ok {
+		r.doc = string(s)
+	}
+	if attrs != nil {
+		for _, item := range attrs.Items() {
+			key, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("repository_rule: attrs keys must be strings, got %s", item[0].Type())
+			}
+			if key == "name" {
+				return nil, fmt.Errorf("repository_rule: attribute 'name' is implicitly defined")
+			}
+			schema, ok := item[1].(builtins.AttrSchema)
+			if !ok {
+				return nil, fmt.Errorf("repository_rule: attrs values must be attr objects, got %s for %q", item[1].Type(), string(key))
+			}
+			r.attrs[string(key)] = schema
+		}
+	}
+	var err error
+	if r.environ, err = stringList(environ, "environ"); err != nil {
+		return nil, fmt.Errorf("repository_rule: %w", err)
+	}
+	return r, nil
+}
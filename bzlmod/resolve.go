@@ -0,0 +1,510 @@
+package bzlmod
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+)
+
+// ResolveOptions configures module resolution.
+type ResolveOptions struct {
+	// FileSystem reads the root module, included files and local overrides.
+	// Defaults to the OS file system.
+	FileSystem loader.FileSystem
+
+	// Registries are searched in order for module versions.
+	Registries []Registry
+
+	// IgnoreDevDependency also drops the root module's dev dependencies.
+	// Reference: --ignore_dev_dependency
+	IgnoreDevDependency bool
+}
+
+// ModuleGraph is the resolved dependency graph: the root module and every
+// module version selected by Minimal Version Selection that is reachable
+// from it.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/BazelDepGraphValue.java
+type ModuleGraph struct {
+	workspaceRoot string
+	modules       map[ModuleKey]*Module
+	order         []ModuleKey
+	mappings      map[ModuleKey]map[string]string
+
+	// generatedRoots and generatedMappings hold the directories and repo
+	// mappings of repositories generated by module extensions, keyed by
+	// canonical name.
+	generatedRoots    map[string]string
+	generatedMappings map[string]map[string]string
+}
+
+// Resolve evaluates the root MODULE.bazel under workspaceRoot, discovers
+// its transitive dependencies in the registries and selects one version of
+// each module.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/BazelModuleResolutionFunction.java
+func Resolve(workspaceRoot string, opts ResolveOptions) (*ModuleGraph, error) {
+	if opts.FileSystem == nil {
+		opts.FileSystem = loader.NewOSFileSystem(workspaceRoot)
+	}
+	r := &resolver{
+		opts:          opts,
+		workspaceRoot: workspaceRoot,
+		discovered:    make(map[ModuleKey]*Module),
+		depKeys:       make(map[ModuleKey]map[string]ModuleKey),
+	}
+	if err := r.discover(); err != nil {
+		return nil, err
+	}
+	g, err := r.selectVersions()
+	if err != nil {
+		return nil, err
+	}
+	if err := g.computeRepoMappings(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+type resolver struct {
+	opts          ResolveOptions
+	workspaceRoot string
+	root          *Module
+
+	// discovered holds every module version reachable before selection.
+	discovered map[ModuleKey]*Module
+
+	// depKeys maps each module's dependency repo names to the requested keys,
+	// after applying overrides.
+	depKeys map[ModuleKey]map[string]ModuleKey
+}
+
+// discover walks the dependency graph breadth-first from the root module.
+// Reference: Discovery.java
+func (r *resolver) discover() error {
+	filename := r.opts.FileSystem.Join(r.workspaceRoot, ModuleFileName)
+	source, err := r.opts.FileSystem.ReadFile(filename)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	root, err := EvalModuleFile(filename, source, ModuleFileOptions{
+		Key:                 RootModuleKey,
+		IgnoreDevDependency: r.opts.IgnoreDevDependency,
+		FileSystem:          r.opts.FileSystem,
+		WorkspaceRoot:       r.workspaceRoot,
+	})
+	if err != nil {
+		return err
+	}
+	root.Path = r.workspaceRoot
+	r.root = root
+	r.discovered[RootModuleKey] = root
+
+	queue := []*Module{root}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+
+		keys := make(map[string]ModuleKey, len(m.Deps))
+		for _, dep := range m.Deps {
+			key := r.depKey(dep)
+			keys[depRepoName(dep)] = key
+			if _, ok := r.discovered[key]; ok {
+				continue
+			}
+			depModule, err := r.fetch(key)
+			if err != nil {
+				return fmt.Errorf("in %s: %w", m.Key, err)
+			}
+			r.discovered[key] = depModule
+			queue = append(queue, depModule)
+		}
+		r.depKeys[m.Key] = keys
+	}
+	return nil
+}
+
+// depKey returns the key a dependency refers to once the root module's
+// overrides are applied. Dependencies on the root module name refer to the
+// root module itself.
+func (r *resolver) depKey(dep DepSpec) ModuleKey {
+	if dep.Name == r.root.Name {
+		return RootModuleKey
+	}
+	switch o := r.root.Overrides[dep.Name].(type) {
+	case *LocalPathOverride:
+		return ModuleKey{Name: dep.Name}
+	case *SingleVersionOverride:
+		if o.Version != "" {
+			return ModuleKey{Name: dep.Name, Version: o.Version}
+		}
+	}
+	return dep.Key()
+}
+
+// fetch reads and evaluates the module file of a non-root module.
+// Reference: ModuleFileFunction.java getModuleFile()
+func (r *resolver) fetch(key ModuleKey) (*Module, error) {
+	fsys := r.opts.FileSystem
+
+	var (
+		filename string
+		source   []byte
+		registry Registry
+		spec     *RepoSpec
+		dir      string
+	)
+	switch o := r.root.Overrides[key.Name].(type) {
+	case *LocalPathOverride:
+		dir = o.Path
+		if !filepath.IsAbs(dir) {
+			dir = fsys.Join(r.workspaceRoot, dir)
+		}
+		filename = fsys.Join(dir, ModuleFileName)
+		var err error
+		if source, err = fsys.ReadFile(filename); err != nil {
+			return nil, fmt.Errorf("reading module file of %s: %w", key, err)
+		}
+
+	default:
+		registries := r.opts.Registries
+		if svo, ok := o.(*SingleVersionOverride); ok && svo.Registry != "" {
+			registries = []Registry{NewLocalRegistry(fsys, svo.Registry)}
+		}
+		for _, reg := range registries {
+			data, err := reg.GetModuleFile(key)
+			if errors.Is(err, ErrModuleNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			source, registry = data, reg
+			break
+		}
+		if registry == nil {
+			return nil, fmt.Errorf("module not found in registries: %s", key)
+		}
+		filename = registry.URL() + "/modules/" + key.Name + "/" + key.Version + "/" + ModuleFileName
+
+		var err error
+		if spec, err = registry.GetRepoSpec(key); err != nil {
+			return nil, err
+		}
+		if spec.Type == "local_path" {
+			dir = spec.Path
+		}
+	}
+
+	m, err := EvalModuleFile(filename, source, ModuleFileOptions{Key: key, IgnoreDevDependency: true})
+	if err != nil {
+		return nil, err
+	}
+	if m.Name != key.Name {
+		return nil, fmt.Errorf("the MODULE.bazel file of %s declares a different name (%s)", key, m.Name)
+	}
+	m.Key = key
+	m.Registry = registry
+	m.RepoSpec = spec
+	m.Path = dir
+	return m, nil
+}
+
+// selectionGroup is the unit of version selection: a module name at a
+// compatibility level.
+// Reference: Selection.java SelectionGroup
+type selectionGroup struct {
+	name               string
+	compatibilityLevel int
+}
+
+// selectVersions runs Minimal Version Selection: within each selection group
+// the highest requested version wins. The resulting graph contains only the
+// modules reachable from the root; modules of the same name with different
+// compatibility levels are an error.
+// Reference: Selection.java run()
+func (r *resolver) selectVersions() (*ModuleGraph, error) {
+	selected := make(map[selectionGroup]ModuleKey)
+	levels := make(map[string][]int)
+	for key, m := range r.discovered {
+		if key == RootModuleKey {
+			continue
+		}
+		group := selectionGroup{key.Name, m.CompatibilityLevel}
+		prev, ok := selected[group]
+		if !ok {
+			levels[key.Name] = append(levels[key.Name], m.CompatibilityLevel)
+		}
+		if !ok || mustParseVersion(key.Version).Compare(mustParseVersion(prev.Version)) > 0 {
+			selected[group] = key
+		}
+	}
+
+	g := &ModuleGraph{
+		workspaceRoot:     r.workspaceRoot,
+		modules:           make(map[ModuleKey]*Module),
+		generatedRoots:    make(map[string]string),
+		generatedMappings: make(map[string]map[string]string),
+	}
+
+	// dependents records which module first depended on each module name, to
+	// report compatibility level conflicts.
+	type dependent struct {
+		from ModuleKey
+		key  ModuleKey
+	}
+	byName := make(map[string]dependent)
+
+	queue := []ModuleKey{RootModuleKey}
+	g.modules[RootModuleKey] = r.root
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		g.order = append(g.order, key)
+
+		m := r.discovered[key]
+		m.ResolvedDeps = make(map[string]ModuleKey, len(m.Deps))
+		for _, dep := range m.Deps {
+			repoName := depRepoName(dep)
+			requested := r.depKeys[key][repoName]
+			target := requested
+			if requested != RootModuleKey {
+				target = r.resolveDep(requested, dep.MaxCompatibilityLevel, selected, levels)
+			}
+			m.ResolvedDeps[repoName] = target
+
+			if target != RootModuleKey {
+				level := r.discovered[target].CompatibilityLevel
+				if prev, ok := byName[target.Name]; ok {
+					if prevLevel := r.discovered[prev.key].CompatibilityLevel; prevLevel != level {
+						return nil, fmt.Errorf("%s depends on %s with compatibility level %d, but %s depends on %s with compatibility level %d which is different",
+							prev.from, prev.key, prevLevel, key, target, level)
+					}
+				} else {
+					byName[target.Name] = dependent{from: key, key: target}
+				}
+			}
+
+			if _, ok := g.modules[target]; !ok {
+				g.modules[target] = r.discovered[target]
+				queue = append(queue, target)
+			}
+		}
+	}
+	return g, nil
+}
+
+// resolveDep returns the selected version for a requested key. A dependency
+// with max_compatibility_level may be satisfied by the highest selected
+// version at a compatibility level up to that maximum.
+// Reference: Selection.java computeTargetAllowedVersion()
+func (r *resolver) resolveDep(requested ModuleKey, maxLevel int, selected map[selectionGroup]ModuleKey, levels map[string][]int) ModuleKey {
+	level := r.discovered[requested].CompatibilityLevel
+	best := selected[selectionGroup{requested.Name, level}]
+	bestLevel := level
+	for _, l := range levels[requested.Name] {
+		if l > bestLevel && l <= maxLevel {
+			best, bestLevel = selected[selectionGroup{requested.Name, l}], l
+		}
+	}
+	return best
+}
+
+// mustParseVersion parses a version that was validated by the module file
+// evaluator. It panics if s is not a valid version.
+func mustParseVersion(s string) Version {
+	v, err := ParseVersion(s)
+	if err != nil {
+		panic(fmt.Sprintf("bzlmod: unvalidated module version: %v", err))
+	}
+	return v
+}
+
+// computeRepoMappings resolves extension labels and computes each module's
+// repo mapping.
+// Reference: BazelDepGraphFunction.java, ModuleExtensionId.java
+func (g *ModuleGraph) computeRepoMappings() error {
+	g.mappings = make(map[ModuleKey]map[string]string, len(g.modules))
+	for _, key := range g.order {
+		m := g.modules[key]
+		mapping := map[string]string{
+			m.GetRepoName(): key.CanonicalRepoName(),
+			bazelToolsRepo:  bazelToolsRepo,
+		}
+		for repoName, dep := range m.ResolvedDeps {
+			mapping[repoName] = dep.CanonicalRepoName()
+		}
+
+		isolated := 0
+		for _, usage := range m.ExtensionUsages {
+			repo, rest, err := resolveExtensionRepo(usage.ExtensionBzlFile, key, mapping)
+			if err != nil {
+				return fmt.Errorf("in %s: %w", key, err)
+			}
+			usage.ExtensionLabel = "@@" + repo + rest
+
+			prefix := repo
+			if prefix == "" {
+				prefix = mainRepoDirectory
+			}
+			usage.ExtensionID = prefix + "+" + usage.ExtensionName
+			if usage.Isolate {
+				isolated++
+				usage.ExtensionID += fmt.Sprintf("+%s+%d", m.GetRepoName(), isolated)
+			}
+		}
+		for _, usage := range m.ExtensionUsages {
+			for local, exported := range usage.Imports {
+				mapping[local] = usage.ExtensionID + "+" + exported
+			}
+		}
+		g.mappings[key] = mapping
+	}
+	return nil
+}
+
+// bazelToolsRepo is visible from every module under its canonical name.
+// Reference: ModuleFileFunction.java BAZEL_TOOLS_DEPS
+const bazelToolsRepo = "bazel_tools"
+
+// mainRepoDirectory names the main repository where an empty canonical name
+// cannot be used.
+// Reference: LabelConstants.java DEFAULT_REPOSITORY_DIRECTORY
+const mainRepoDirectory = "_main"
+
+// resolveExtensionRepo returns the canonical repo of a use_extension() label
+// and the "//pkg:file" remainder.
+func resolveExtensionRepo(label string, key ModuleKey, mapping map[string]string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(label, "@@"):
+		repo, rest, ok := strings.Cut(label[2:], "//")
+		if !ok {
+			return "", "", fmt.Errorf("invalid label '%s' in use_extension()", label)
+		}
+		return repo, "//" + rest, nil
+	case strings.HasPrefix(label, "@"):
+		apparent, rest, ok := strings.Cut(label[1:], "//")
+		if !ok {
+			return "", "", fmt.Errorf("invalid label '%s' in use_extension()", label)
+		}
+		repo, ok := mapping[apparent]
+		if !ok {
+			return "", "", fmt.Errorf("no repository visible as '@%s' from %s", apparent, key)
+		}
+		return repo, "//" + rest, nil
+	case strings.HasPrefix(label, "//"):
+		return key.CanonicalRepoName(), label, nil
+	case strings.HasPrefix(label, ":"):
+		return key.CanonicalRepoName(), "//" + label, nil
+	}
+	return "", "", fmt.Errorf("invalid label '%s' in use_extension()", label)
+}
+
+// Root returns the root module.
+func (g *ModuleGraph) Root() *Module { return g.modules[RootModuleKey] }
+
+// Module returns the selected module with the given key.
+func (g *ModuleGraph) Module(key ModuleKey) (*Module, bool) {
+	m, ok := g.modules[key]
+	return m, ok
+}
+
+// Modules returns the modules of the graph in breadth-first order from the root.
+func (g *ModuleGraph) Modules() []*Module {
+	modules := make([]*Module, 0, len(g.order))
+	for _, key := range g.order {
+		modules = append(modules, g.modules[key])
+	}
+	return modules
+}
+
+// RepoMapping returns the repo mapping of a module: the repo names visible to
+// it and the canonical repos they refer to. The main repository's canonical
+// name is "".
+func (g *ModuleGraph) RepoMapping(key ModuleKey) map[string]string {
+	mapping, ok := g.mappings[key]
+	if !ok {
+		return nil
+	}
+	return copyMapping(mapping)
+}
+
+func copyMapping(mapping map[string]string) map[string]string {
+	result := make(map[string]string, len(mapping))
+	for apparent, canonical := range mapping {
+		result[apparent] = canonical
+	}
+	return result
+}
+
+// RepoMappings returns the repo mapping of every module keyed by canonical
+// repo name, in the form expected by loader.WithRepoMappings and
+// providers.RunfilesTreeOptions.
+func (g *ModuleGraph) RepoMappings() map[string]map[string]string {
+	result := make(map[string]map[string]string, len(g.mappings))
+	for key := range g.mappings {
+		result[key.CanonicalRepoName()] = g.RepoMapping(key)
+	}
+	for canonical, mapping := range g.generatedMappings {
+		result[canonical] = copyMapping(mapping)
+	}
+	return result
+}
+
+// RepoRoots returns the local directory of every module repository whose
+// location is known without fetching, and of every repository generated by
+// RunExtensions, keyed by canonical repo name.
+func (g *ModuleGraph) RepoRoots() map[string]string {
+	roots := make(map[string]string)
+	for key, m := range g.modules {
+		if key != RootModuleKey && m.Path != "" {
+			roots[key.CanonicalRepoName()] = m.Path
+		}
+	}
+	for canonical, dir := range g.generatedRoots {
+		roots[canonical] = dir
+	}
+	return roots
+}
+
+// ExternalRepos returns the repository mapping for loader.WithRepoMapping:
+// the repo names visible to the root module and all canonical repo names,
+// mapped to their local directories. Repos that are not available locally
+// are omitted.
+func (g *ModuleGraph) ExternalRepos() map[string]string {
+	roots := g.RepoRoots()
+	repos := make(map[string]string, len(roots))
+	for canonical, dir := range roots {
+		repos[canonical] = dir
+	}
+	for apparent, canonical := range g.RepoMapping(RootModuleKey) {
+		if dir, ok := roots[canonical]; ok {
+			repos[apparent] = dir
+		}
+	}
+	return repos
+}
+
+// String returns the resolved modules in breadth-first order, one per line
+// with their direct dependencies.
+func (g *ModuleGraph) String() string {
+	var b strings.Builder
+	for _, m := range g.Modules() {
+		fmt.Fprintf(&b, "%s", m.Key)
+		deps := make([]string, 0, len(m.ResolvedDeps))
+		for _, dep := range m.ResolvedDeps {
+			deps = append(deps, dep.String())
+		}
+		sort.Strings(deps)
+		if len(deps) > 0 {
+			fmt.Fprintf(&b, " -> %s", strings.Join(deps, ", "))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
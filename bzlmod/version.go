@@ -0,0 +1,112 @@
+package bzlmod
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// versionPattern matches a module version: a release, an optional
+// pre-release after "-" and optional build metadata after "+".
+// Reference: Version.java PATTERN
+var versionPattern = regexp.MustCompile(`^([a-zA-Z0-9]+(?:\.[a-zA-Z0-9]+)*)(?:-([a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*))?(?:\+[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)*)?$`)
+
+// Version is a parsed module version. The zero Version is the empty version,
+// used by modules with non-registry overrides; it compares higher than every
+// other version.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/bazel/bzlmod/Version.java
+type Version struct {
+	original   string
+	release    []string
+	prerelease []string
+}
+
+// ParseVersion parses a module version string.
+func ParseVersion(s string) (Version, error) {
+	if s == "" {
+		return Version{}, nil
+	}
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("bad version %q (does not match regex)", s)
+	}
+	v := Version{original: s, release: strings.Split(m[1], ".")}
+	if m[2] != "" {
+		v.prerelease = strings.Split(m[2], ".")
+	}
+	return v, nil
+}
+
+// IsEmpty reports whether v is the empty version.
+func (v Version) IsEmpty() bool { return v.original == "" }
+
+// String returns the version as originally written.
+func (v Version) String() string { return v.original }
+
+// Compare returns -1, 0 or 1 depending on whether v sorts before, equal to or
+// after o. Build metadata is ignored.
+// Reference: Version.java COMPARATOR
+func (v Version) Compare(o Version) int {
+	switch {
+	case v.IsEmpty() && o.IsEmpty():
+		return 0
+	case v.IsEmpty():
+		return 1
+	case o.IsEmpty():
+		return -1
+	}
+	if c := compareIdentifiers(v.release, o.release); c != 0 {
+		return c
+	}
+	// A version without a pre-release sorts after one with it.
+	switch {
+	case len(v.prerelease) == 0 && len(o.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(o.prerelease) == 0:
+		return -1
+	}
+	return compareIdentifiers(v.prerelease, o.prerelease)
+}
+
+// compareIdentifiers compares dot-separated identifier lists. Numeric
+// identifiers compare numerically and sort before alphanumeric ones; a
+// shorter list sorts first when it is a prefix of the other.
+// Reference: Version.java Identifier
+func compareIdentifiers(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareIdentifier(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+func compareIdentifier(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		switch {
+		case an < bn:
+			return -1
+		case an > bn:
+			return 1
+		}
+		return 0
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
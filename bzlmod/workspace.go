@@ -0,0 +1,457 @@
+package bzlmod
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// WorkspaceFileNames are the names of the legacy file declaring external
+// repositories, in order of precedence.
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/cmdline/LabelConstants.java
+var WorkspaceFileNames = []string{"WORKSPACE.bazel", "WORKSPACE"}
+
+// threadKeyWorkspace is the key for the workspaceContext in the thread.
+const threadKeyWorkspace = "starlark-go-bazel:workspace"
+
+// FindWorkspaceFile returns the path of the WORKSPACE file of a workspace,
+// or "" if it has none.
+func FindWorkspaceFile(fsys loader.FileSystem, workspaceRoot string) string {
+	for _, name := range WorkspaceFileNames {
+		p := fsys.Join(workspaceRoot, name)
+		if info, err := fsys.Stat(p); err == nil && !info.IsDir() {
+			return p
+		}
+	}
+	return ""
+}
+
+// WorkspaceOptions configures the evaluation of a WORKSPACE file.
+type WorkspaceOptions struct {
+	// WorkspaceRoot is the main repository's directory. Relative repository
+	// paths and labels of the main repository resolve against it.
+	WorkspaceRoot string
+
+	// OutputBase is the directory repositories are created under, in
+	// <output base>/external/<name>. Required.
+	OutputBase string
+
+	// FileSystem reads the .bzl files loaded by the WORKSPACE file. Defaults
+	// to the OS file system.
+	FileSystem loader.FileSystem
+
+	// Predeclared are the globals of loaded .bzl files, to which the
+	// WORKSPACE flavour of the native module is added. Defaults to
+	// Predeclared().
+	Predeclared starlark.StringDict
+
+	// Fetcher serves repository_ctx.download() and download_and_extract().
+	Fetcher Fetcher
+
+	// Environ is the client environment. Defaults to the process environment.
+	Environ map[string]string
+
+	// Print receives print() output. Defaults to discarding it.
+	Print func(msg string)
+}
+
+// Workspace is the outcome of evaluating a WORKSPACE file.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/skyframe/WorkspaceFileValue.java
+type Workspace struct {
+	// Name is the name given to workspace(), or "".
+	Name string
+
+	// Repos are the declared repositories keyed by name. Their names are
+	// also their canonical names.
+	Repos map[string]*RepoDefinition
+
+	// RepoRoots maps each repository name to its directory.
+	RepoRoots map[string]string
+
+	// Bindings maps the names given to bind() to their actual labels, or
+	// "" for a binding without one. Each is visible as //external:<name>.
+	Bindings map[string]string
+
+	// Toolchains and ExecutionPlatforms are the target patterns registered
+	// by register_toolchains() and register_execution_platforms().
+	Toolchains         []string
+	ExecutionPlatforms []string
+}
+
+// workspaceContext accumulates the declarations of a WORKSPACE file. It is
+// the RepoDefiner of the WORKSPACE thread.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/WorkspaceFactory.java
+type workspaceContext struct {
+	ws    *Workspace
+	repos *RepoSet
+
+	// mainRepo maps the workspace name, once workspace() has been called,
+	// to workspaceRoot.
+	workspaceRoot string
+	mainRepo      map[string]string
+
+	// chunk is the index of the chunk being executed and definedIn the
+	// chunk each repository was defined in.
+	chunk     int
+	definedIn map[string]int
+}
+
+var _ RepoDefiner = (*workspaceContext)(nil)
+
+// DefineRepo records a repository. Within a chunk a later definition
+// replaces an earlier one; a repository defined before a load() may not be
+// redefined after it.
+// Reference: WorkspaceFactoryHelper.java addRepoRule()
+func (wc *workspaceContext) DefineRepo(_ *starlark.Thread, def *RepoDefinition) error {
+	if chunk, ok := wc.definedIn[def.Name]; ok && chunk != wc.chunk {
+		return fmt.Errorf("Cannot redefine repository after any load statement in the WORKSPACE file (for repository '%s')", def.Name)
+	}
+	wc.definedIn[def.Name] = wc.chunk
+	wc.repos.repos[def.Name] = def
+	return nil
+}
+
+// EvalWorkspace evaluates the WORKSPACE file of opts.WorkspaceRoot. It
+// returns an error wrapping fs.ErrNotExist if the workspace has none.
+func EvalWorkspace(opts WorkspaceOptions) (*Workspace, error) {
+	if opts.FileSystem == nil {
+		opts.FileSystem = loader.NewOSFileSystem(opts.WorkspaceRoot)
+	}
+	filename := FindWorkspaceFile(opts.FileSystem, opts.WorkspaceRoot)
+	if filename == "" {
+		return nil, fmt.Errorf("no WORKSPACE file in %s: %w", opts.WorkspaceRoot, os.ErrNotExist)
+	}
+	source, err := opts.FileSystem.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return EvalWorkspaceFile(filename, source, opts)
+}
+
+// EvalWorkspaceFile evaluates a WORKSPACE file and fetches the repositories
+// it declares.
+//
+// The file is split into chunks at load() statements: each chunk is a run
+// of load statements followed by other statements, and repositories
+// declared by earlier chunks can be loaded from in later ones. Loaded
+// repositories are fetched on first use, the rest once evaluation ends.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/skyframe/WorkspaceFileFunction.java
+func EvalWorkspaceFile(filename string, source []byte, opts WorkspaceOptions) (*Workspace, error) {
+	if opts.OutputBase == "" {
+		return nil, fmt.Errorf("evaluating %s requires an output base", filename)
+	}
+	if opts.FileSystem == nil {
+		opts.FileSystem = loader.NewOSFileSystem(opts.WorkspaceRoot)
+	}
+	if opts.Predeclared == nil {
+		opts.Predeclared = Predeclared()
+	}
+	if opts.Environ == nil {
+		opts.Environ = clientEnviron()
+	}
+
+	f, err := (&syntax.FileOptions{}).Parse(filename, source, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	wc := &workspaceContext{
+		ws: &Workspace{
+			Repos:     make(map[string]*RepoDefinition),
+			RepoRoots: make(map[string]string),
+			Bindings:  make(map[string]string),
+		},
+		repos:         NewRepoSet(""),
+		workspaceRoot: opts.WorkspaceRoot,
+		mainRepo:      make(map[string]string),
+		definedIn:     make(map[string]int),
+	}
+	fetchOpts := FetchOptions{
+		OutputBase:    opts.OutputBase,
+		WorkspaceRoot: opts.WorkspaceRoot,
+		Fetcher:       opts.Fetcher,
+		Environ:       opts.Environ,
+		Print:         opts.Print,
+		RepoRoots:     wc.mainRepo,
+	}
+
+	bzlPredeclared := make(starlark.StringDict, len(opts.Predeclared)+1)
+	for name, v := range opts.Predeclared {
+		bzlPredeclared[name] = v
+	}
+	bzlPredeclared["native"] = workspaceNativeModule()
+	l := loader.NewBzlFileLoader(opts.FileSystem, opts.WorkspaceRoot,
+		loader.WithPredeclared(bzlPredeclared),
+		loader.WithBuiltinModule(LocalRepoRulesLabel, LocalRepoRules()),
+		loader.WithRepoResolver(func(repo string) (string, bool, error) {
+			if root, ok := wc.mainRepo[repo]; ok {
+				return root, true, nil
+			}
+			if _, ok := wc.repos.Get(repo); !ok {
+				return "", false, nil
+			}
+			root, err := wc.repos.fetch(repo, fetchOpts)
+			return root, err == nil, err
+		}),
+	)
+
+	thread := &starlark.Thread{
+		Name: filename,
+		Load: l.Load,
+		Print: func(_ *starlark.Thread, msg string) {
+			if opts.Print != nil {
+				opts.Print(msg)
+			}
+		},
+	}
+	loader.SetBzlLoader(thread, l)
+	thread.SetLocal(threadKeyWorkspace, wc)
+	SetRepoDefiner(thread, wc)
+	types.SetLabelContext(thread, &types.LabelContext{})
+
+	// Each chunk sees the globals of the chunks before it.
+	globals := workspaceGlobals()
+	for i, chunk := range workspaceChunks(f) {
+		wc.chunk = i
+		prog, err := starlark.FileProgram(chunk, globals.Has)
+		if err != nil {
+			return nil, err
+		}
+		chunkGlobals, err := prog.Init(thread, globals)
+		if err != nil {
+			return nil, fmt.Errorf("error executing %s: %w", filename, err)
+		}
+		chunkGlobals.Freeze()
+		merged := make(starlark.StringDict, len(globals)+len(chunkGlobals))
+		for name, v := range globals {
+			merged[name] = v
+		}
+		for name, v := range chunkGlobals {
+			merged[name] = v
+		}
+		globals = merged
+	}
+
+	roots, err := wc.repos.Fetch(fetchOpts)
+	if err != nil {
+		return nil, err
+	}
+	wc.ws.Repos = wc.repos.Definitions()
+	wc.ws.RepoRoots = roots
+	return wc.ws, nil
+}
+
+// workspaceChunks splits a WORKSPACE file at the load() statements that
+// follow other statements.
+// Reference: WorkspaceFileFunction.java splitChunks()
+func workspaceChunks(f *syntax.File) []*syntax.File {
+	var (
+		chunks  []*syntax.File
+		current []syntax.Stmt
+		hadBody bool
+	)
+	flush := func() {
+		if len(current) > 0 {
+			chunks = append(chunks, &syntax.File{Path: f.Path, Stmts: current, Options: f.Options})
+		}
+		current, hadBody = nil, false
+	}
+	for _, stmt := range f.Stmts {
+		_, isLoad := stmt.(*syntax.LoadStmt)
+		if isLoad && hadBody {
+			flush()
+		}
+		hadBody = hadBody || !isLoad
+		current = append(current, stmt)
+	}
+	flush()
+	return chunks
+}
+
+func getWorkspaceContext(thread *starlark.Thread, name string) (*workspaceContext, error) {
+	wc, ok := thread.Local(threadKeyWorkspace).(*workspaceContext)
+	if !ok {
+		return nil, fmt.Errorf("%s() can only be called from WORKSPACE", name)
+	}
+	return wc, nil
+}
+
+// workspaceGlobals are the builtins of the WORKSPACE dialect.
+// Reference: WorkspaceFactory.java createWorkspaceFunctions()
+func workspaceGlobals() starlark.StringDict {
+	globals := starlark.StringDict{
+		"workspace": starlark.NewBuiltin("workspace", workspaceBuiltin),
+		"Label":     starlark.NewBuiltin("Label", types.LabelBuiltin),
+	}
+	for name, fn := range workspaceNativeFunctions() {
+		globals[name] = fn
+	}
+	return globals
+}
+
+// workspaceNativeFunctions are the functions available both at the top
+// level of WORKSPACE files and through native in the .bzl files they load.
+func workspaceNativeFunctions() starlark.StringDict {
+	functions := starlark.StringDict{
+		"bind":                         starlark.NewBuiltin("bind", bindBuiltin),
+		"register_toolchains":          starlark.NewBuiltin("register_toolchains", workspaceRegisterBuiltin),
+		"register_execution_platforms": starlark.NewBuiltin("register_execution_platforms", workspaceRegisterBuiltin),
+	}
+	for name, fn := range LocalRepoRules() {
+		functions[name] = fn
+	}
+	return functions
+}
+
+// workspaceNativeModule is the native module of .bzl files loaded from
+// WORKSPACE.
+// Reference: WorkspaceFactory.java createNativeModuleBindings()
+func workspaceNativeModule() *starlarkstruct.Module {
+	members := workspaceNativeFunctions()
+	members["existing_rule"] = starlark.NewBuiltin("native.existing_rule", workspaceExistingRule)
+	members["existing_rules"] = starlark.NewBuiltin("native.existing_rules", workspaceExistingRules)
+	return &starlarkstruct.Module{Name: "native", Members: members}
+}
+
+// Reference: WorkspaceFactory.java newWorkspaceFunction()
+func workspaceBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	wc, err := getWorkspaceContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	if wc.chunk > 0 || len(wc.definedIn) > 0 || wc.ws.Name != "" {
+		return nil, fmt.Errorf("workspace() function should be used only at the top of the WORKSPACE file")
+	}
+	if !repoNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid workspace name '%s': workspace names may contain only A-Z, a-z, 0-9, '-', '_' and '.', and must start with a letter", name)
+	}
+	wc.ws.Name = name
+	wc.mainRepo[name] = wc.workspaceRoot
+	return starlark.None, nil
+}
+
+// Reference: WorkspaceFactory.java newBindFunction()
+func bindBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var (
+		name   string
+		actual starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "actual?", &actual); err != nil {
+		return nil, err
+	}
+	wc, err := getWorkspaceContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := types.ParseLabel("//external:" + name); err != nil {
+		return nil, fmt.Errorf("%s: invalid name '%s': %w", b.Name(), name, err)
+	}
+	target := ""
+	switch v := actual.(type) {
+	case starlark.NoneType:
+	case starlark.String:
+		label, err := parseLabelAttr(thread, string(v))
+		if err != nil {
+			return nil, fmt.Errorf("%s: actual: %w", b.Name(), err)
+		}
+		target = label.String()
+	case *types.Label:
+		target = v.String()
+	default:
+		return nil, fmt.Errorf("%s: got %s for 'actual', want string or Label", b.Name(), actual.Type())
+	}
+	wc.ws.Bindings[name] = target
+	return starlark.None, nil
+}
+
+// Reference: WorkspaceFactory.java newRegisterToolchainsFunction()
+func workspaceRegisterBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), nil, kwargs); err != nil {
+		return nil, err
+	}
+	wc, err := getWorkspaceContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, arg := range args {
+		s, ok := arg.(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("%s: got %s, want string", b.Name(), arg.Type())
+		}
+		patterns = append(patterns, string(s))
+	}
+	if b.Name() == "register_toolchains" {
+		wc.ws.Toolchains = append(wc.ws.Toolchains, patterns...)
+	} else {
+		wc.ws.ExecutionPlatforms = append(wc.ws.ExecutionPlatforms, patterns...)
+	}
+	return starlark.None, nil
+}
+
+// Reference: StarlarkNativeModule.java existingRule()
+func workspaceExistingRule(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name); err != nil {
+		return nil, err
+	}
+	wc, err := getWorkspaceContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	def, ok := wc.repos.Get(name)
+	if !ok {
+		return starlark.None, nil
+	}
+	return repoDefinitionDict(def), nil
+}
+
+// Reference: StarlarkNativeModule.java existingRules()
+func workspaceExistingRules(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	wc, err := getWorkspaceContext(thread, b.Name())
+	if err != nil {
+		return nil, err
+	}
+	rules := starlark.NewDict(len(wc.definedIn))
+	for _, name := range wc.repos.Names() {
+		def, _ := wc.repos.Get(name)
+		if err := rules.SetKey(starlark.String(name), repoDefinitionDict(def)); err != nil {
+			return nil, err
+		}
+	}
+	rules.Freeze()
+	return rules, nil
+}
+
+// repoDefinitionDict describes a repository like native.existing_rule():
+// its attributes plus "kind".
+func repoDefinitionDict(def *RepoDefinition) *starlark.Dict {
+	d := starlark.NewDict(len(def.Attrs) + 1)
+	d.SetKey(starlark.String("kind"), starlark.String(def.RuleName))
+	names := make([]string, 0, len(def.Attrs))
+	for name := range def.Attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d.SetKey(starlark.String(name), def.Attrs[name])
+	}
+	d.Freeze()
+	return d
+}
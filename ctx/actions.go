@@ -2,9 +2,14 @@ package ctx
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+
+	"github.com/albertocavalcante/starlark-go-bazel/providers"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
 )
 
 // ActionType represents the type of action.
@@ -40,6 +45,14 @@ type DeclaredAction struct {
 	ExecutionRequirements map[string]string
 	UseDefaultShellEnv    bool
 
+	// For run and run_shell
+	InputManifests []*File                         // Runfiles manifests of tools and input_manifests
+	ToolsToRun     []*providers.FilesToRunProvider // Tools that carry runfiles
+	Toolchain      string                          // Toolchain type the executable comes from
+	ExecGroup      string                          // Execution group the action runs in
+	ResourceSet    starlark.Callable               // Resource estimator callback
+	UnusedInputs   *File                           // File listing the inputs the action did not use (run only)
+
 	// For expand_template
 	Template      *File
 	Substitutions map[string]string
@@ -173,11 +186,10 @@ func (a *Actions) declareFile(_ *starlark.Thread, _ *starlark.Builtin, args star
 		if !ok {
 			return nil, fmt.Errorf("sibling must be a File, got %s", sibling.Type())
 		}
-		dir := siblingFile.path[:len(siblingFile.path)-len(siblingFile.Basename())]
-		path = dir + filename
+		path = siblingDir(siblingFile) + filename
 	}
 
-	return NewDeclaredFile(path, a.ctx.binDir), nil
+	return a.owned(NewDeclaredFile(path, a.ctx.binDir)), nil
 }
 
 // declareDirectory implements actions.declare_directory(filename, sibling=None).
@@ -201,11 +213,10 @@ func (a *Actions) declareDirectory(_ *starlark.Thread, _ *starlark.Builtin, args
 		if !ok {
 			return nil, fmt.Errorf("sibling must be a File, got %s", sibling.Type())
 		}
-		dir := siblingFile.path[:len(siblingFile.path)-len(siblingFile.Basename())]
-		path = dir + filename
+		path = siblingDir(siblingFile) + filename
 	}
 
-	return NewDirectory(path, a.ctx.binDir), nil
+	return a.owned(NewDirectory(path, a.ctx.binDir)), nil
 }
 
 // declareSymlink implements actions.declare_symlink(filename, sibling=None).
@@ -229,11 +240,21 @@ func (a *Actions) declareSymlink(_ *starlark.Thread, _ *starlark.Builtin, args s
 		if !ok {
 			return nil, fmt.Errorf("sibling must be a File, got %s", sibling.Type())
 		}
-		dir := siblingFile.path[:len(siblingFile.path)-len(siblingFile.Basename())]
-		path = dir + filename
+		path = siblingDir(siblingFile) + filename
 	}
 
-	return NewSymlink(path, a.ctx.binDir), nil
+	return a.owned(NewSymlink(path, a.ctx.binDir)), nil
+}
+
+// owned marks f as produced by the rule owning these actions.
+func (a *Actions) owned(f *File) *File {
+	f.SetOwner(a.ctx.label)
+	return f
+}
+
+// siblingDir returns the root-relative directory of f, with a trailing slash.
+func siblingDir(f *File) string {
+	return strings.TrimSuffix(f.ShortPath(), f.Basename())
 }
 
 // doNothing implements actions.do_nothing(mnemonic, inputs).
@@ -312,6 +333,7 @@ func (a *Actions) write(_ *starlark.Thread, _ *starlark.Builtin, args starlark.T
 func (a *Actions) run(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var outputs starlark.Value
 	var inputs starlark.Value = starlark.NewList(nil)
+	var unusedInputsList starlark.Value = starlark.None
 	var executable starlark.Value
 	var tools starlark.Value = starlark.None
 	var arguments starlark.Value = starlark.NewList(nil)
@@ -320,10 +342,16 @@ func (a *Actions) run(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tup
 	var useDefaultShellEnv bool
 	var env starlark.Value = starlark.None
 	var executionRequirements starlark.Value = starlark.None
+	var inputManifests starlark.Value = starlark.None
+	var execGroup starlark.Value = starlark.None
+	var shadowedAction starlark.Value = starlark.None
+	var resourceSet starlark.Value = starlark.None
+	var toolchain starlark.Value = starlark.None
 
 	if err := starlark.UnpackArgs("run", args, kwargs,
 		"outputs", &outputs,
 		"inputs?", &inputs,
+		"unused_inputs_list?", &unusedInputsList,
 		"executable", &executable,
 		"tools?", &tools,
 		"arguments?", &arguments,
@@ -332,6 +360,11 @@ func (a *Actions) run(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tup
 		"use_default_shell_env?", &useDefaultShellEnv,
 		"env?", &env,
 		"execution_requirements?", &executionRequirements,
+		"input_manifests?", &inputManifests,
+		"exec_group?", &execGroup,
+		"shadowed_action?", &shadowedAction,
+		"resource_set?", &resourceSet,
+		"toolchain?", &toolchain,
 	); err != nil {
 		return nil, err
 	}
@@ -341,32 +374,48 @@ func (a *Actions) run(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tup
 		Mnemonic:           "Action",
 		Outputs:            extractFiles(outputs),
 		Inputs:             extractFiles(inputs),
-		Tools:              extractFiles(tools),
 		Arguments:          extractStrings(arguments),
 		UseDefaultShellEnv: useDefaultShellEnv,
 	}
 
 	// Handle executable
+	// Source: StarlarkActionFactory.run() - executable may be a File, a
+	// FilesToRunProvider or a path string.
 	switch e := executable.(type) {
 	case *File:
 		action.Executable = e
+		if ftr, ok := a.ctx.executable.FilesToRun(e); ok {
+			a.addToolToRun(action, ftr)
+		} else {
+			addTool(action, e)
+		}
+	case *providers.FilesToRunProvider:
+		if e.Executable() == nil {
+			return nil, fmt.Errorf("run: the FilesToRunProvider given as executable has no executable")
+		}
+		action.Executable = e.Executable()
+		a.addToolToRun(action, e)
 	case starlark.String:
 		action.ExecutableString = string(e)
 	default:
-		return nil, fmt.Errorf("executable must be a File or string, got %s", executable.Type())
+		return nil, fmt.Errorf("executable must be a File, FilesToRunProvider or string, got %s", executable.Type())
 	}
 
-	if mnemonic != starlark.None {
-		action.Mnemonic = string(mnemonic.(starlark.String))
-	}
-	if progressMessage != starlark.None {
-		action.ProgressMessage = string(progressMessage.(starlark.String))
+	// Source: StarlarkActionFactory.run() - unused_inputs_list
+	switch f := unusedInputsList.(type) {
+	case starlark.NoneType:
+	case *File:
+		action.UnusedInputs = f
+	default:
+		return nil, fmt.Errorf("run: unused_inputs_list must be a File or None, got %s", unusedInputsList.Type())
 	}
-	if env != starlark.None {
-		action.Env = extractStringDict(env)
+	if shadowedAction != starlark.None {
+		return nil, errShadowedAction("run")
 	}
-	if executionRequirements != starlark.None {
-		action.ExecutionRequirements = extractStringDict(executionRequirements)
+
+	if err := a.setRunParams(action, "run", tools, mnemonic, progressMessage, env,
+		executionRequirements, inputManifests, execGroup, resourceSet, toolchain); err != nil {
+		return nil, err
 	}
 
 	a.declared = append(a.declared, action)
@@ -386,6 +435,11 @@ func (a *Actions) runShell(_ *starlark.Thread, _ *starlark.Builtin, args starlar
 	var useDefaultShellEnv bool
 	var env starlark.Value = starlark.None
 	var executionRequirements starlark.Value = starlark.None
+	var inputManifests starlark.Value = starlark.None
+	var execGroup starlark.Value = starlark.None
+	var shadowedAction starlark.Value = starlark.None
+	var resourceSet starlark.Value = starlark.None
+	var toolchain starlark.Value = starlark.None
 
 	if err := starlark.UnpackArgs("run_shell", args, kwargs,
 		"outputs", &outputs,
@@ -398,6 +452,11 @@ func (a *Actions) runShell(_ *starlark.Thread, _ *starlark.Builtin, args starlar
 		"use_default_shell_env?", &useDefaultShellEnv,
 		"env?", &env,
 		"execution_requirements?", &executionRequirements,
+		"input_manifests?", &inputManifests,
+		"exec_group?", &execGroup,
+		"shadowed_action?", &shadowedAction,
+		"resource_set?", &resourceSet,
+		"toolchain?", &toolchain,
 	); err != nil {
 		return nil, err
 	}
@@ -407,7 +466,6 @@ func (a *Actions) runShell(_ *starlark.Thread, _ *starlark.Builtin, args starlar
 		Mnemonic:           "Action",
 		Outputs:            extractFiles(outputs),
 		Inputs:             extractFiles(inputs),
-		Tools:              extractFiles(tools),
 		Arguments:          extractStrings(arguments),
 		UseDefaultShellEnv: useDefaultShellEnv,
 	}
@@ -427,11 +485,46 @@ func (a *Actions) runShell(_ *starlark.Thread, _ *starlark.Builtin, args starlar
 		return nil, fmt.Errorf("command must be a string, got %s", command.Type())
 	}
 
+	if shadowedAction != starlark.None {
+		return nil, errShadowedAction("run_shell")
+	}
+
+	if err := a.setRunParams(action, "run_shell", tools, mnemonic, progressMessage, env,
+		executionRequirements, inputManifests, execGroup, resourceSet, toolchain); err != nil {
+		return nil, err
+	}
+
+	a.declared = append(a.declared, action)
+	return starlark.None, nil
+}
+
+// errShadowedAction rejects the shadowed_action parameter: declared actions
+// are not Starlark values here, so there is no action to inherit from.
+func errShadowedAction(fn string) error {
+	return fmt.Errorf("%s: shadowed_action is not supported", fn)
+}
+
+// setRunParams applies the parameters shared by run() and run_shell().
+// Source: StarlarkActionFactory.registerStarlarkAction()
+func (a *Actions) setRunParams(action *DeclaredAction, fn string, tools, mnemonic, progressMessage, env,
+	executionRequirements, inputManifests, execGroup, resourceSet, toolchain starlark.Value) error {
+	if err := a.addTools(action, fn, tools); err != nil {
+		return err
+	}
+
 	if mnemonic != starlark.None {
-		action.Mnemonic = string(mnemonic.(starlark.String))
+		s, ok := mnemonic.(starlark.String)
+		if !ok {
+			return fmt.Errorf("%s: mnemonic must be a string, got %s", fn, mnemonic.Type())
+		}
+		action.Mnemonic = string(s)
 	}
 	if progressMessage != starlark.None {
-		action.ProgressMessage = string(progressMessage.(starlark.String))
+		s, ok := progressMessage.(starlark.String)
+		if !ok {
+			return fmt.Errorf("%s: progress_message must be a string, got %s", fn, progressMessage.Type())
+		}
+		action.ProgressMessage = string(s)
 	}
 	if env != starlark.None {
 		action.Env = extractStringDict(env)
@@ -440,8 +533,132 @@ func (a *Actions) runShell(_ *starlark.Thread, _ *starlark.Builtin, args starlar
 		action.ExecutionRequirements = extractStringDict(executionRequirements)
 	}
 
-	a.declared = append(a.declared, action)
-	return starlark.None, nil
+	// Source: StarlarkActionFactory.registerStarlarkAction() - legacy input_manifests
+	if inputManifests != starlark.None {
+		action.InputManifests = append(action.InputManifests, extractFiles(inputManifests)...)
+	}
+
+	// Source: StarlarkActionFactory.registerStarlarkAction() - exec_group must be declared by the rule
+	if execGroup != starlark.None {
+		s, ok := execGroup.(starlark.String)
+		if !ok {
+			return fmt.Errorf("%s: exec_group must be a string, got %s", fn, execGroup.Type())
+		}
+		if !a.ctx.execGroups.Has(string(s)) {
+			return fmt.Errorf("Action declared for non-existent exec group '%s'.", string(s))
+		}
+		action.ExecGroup = string(s)
+	}
+
+	// Source: StarlarkActionFactory.registerStarlarkAction() - resource_set callback
+	if resourceSet != starlark.None {
+		cb, ok := resourceSet.(starlark.Callable)
+		if !ok {
+			return fmt.Errorf("resource_set must be a function, got %s", resourceSet.Type())
+		}
+		action.ResourceSet = cb
+	}
+
+	// Source: StarlarkActionFactory.registerStarlarkAction() - toolchain may be a Label or string
+	switch t := toolchain.(type) {
+	case starlark.NoneType:
+	case starlark.String:
+		action.Toolchain = string(t)
+	case *types.Label:
+		action.Toolchain = t.String()
+	default:
+		return fmt.Errorf("%s: toolchain must be a Label, string or None, got %s", fn, toolchain.Type())
+	}
+
+	return nil
+}
+
+// addTools adds the tools parameter of run()/run_shell() to the action.
+// Tools may be a list of Files, FilesToRunProviders and depsets of Files,
+// or a single depset. Each tool's runfiles are added to the action inputs.
+// Source: StarlarkActionFactory.addTools()
+func (a *Actions) addTools(action *DeclaredAction, fn string, tools starlark.Value) error {
+	var add func(v starlark.Value, topLevel bool) error
+	add = func(v starlark.Value, topLevel bool) error {
+		switch t := v.(type) {
+		case *File:
+			if ftr, ok := a.ctx.executable.FilesToRun(t); ok {
+				a.addToolToRun(action, ftr)
+				return nil
+			}
+			addTool(action, t)
+		case *providers.FilesToRunProvider:
+			a.addToolToRun(action, t)
+		case *types.Depset:
+			for _, elem := range t.ToList() {
+				f, ok := elem.(*File)
+				if !ok {
+					return fmt.Errorf("%s: tools depset must contain Files, got %s", fn, elem.Type())
+				}
+				addTool(action, f)
+			}
+		default:
+			if topLevel {
+				if iter := starlark.Iterate(v); iter != nil {
+					defer iter.Done()
+					var elem starlark.Value
+					for iter.Next(&elem) {
+						if err := add(elem, false); err != nil {
+							return err
+						}
+					}
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: tools must contain Files, FilesToRunProviders or depsets of Files, got %s", fn, v.Type())
+		}
+		return nil
+	}
+
+	if tools != starlark.None {
+		if err := add(tools, true); err != nil {
+			return err
+		}
+	}
+
+	// Tools, including an executable given as a plain File, are always
+	// inputs of the action.
+	for _, t := range action.Tools {
+		if !slices.Contains(action.Inputs, t) {
+			action.Inputs = append(action.Inputs, t)
+		}
+	}
+	return nil
+}
+
+// addToolToRun records a tool with runfiles: its executable, manifests and
+// runfiles become tool inputs of the action.
+// Source: SpawnAction.Builder.addTool(FilesToRunProvider)
+func (a *Actions) addToolToRun(action *DeclaredAction, ftr *providers.FilesToRunProvider) {
+	if slices.Contains(action.ToolsToRun, ftr) {
+		return
+	}
+	action.ToolsToRun = append(action.ToolsToRun, ftr)
+	for _, v := range ftr.FilesToRun() {
+		f, ok := v.(*File)
+		if !ok {
+			continue
+		}
+		addTool(action, f)
+		if !slices.Contains(action.Inputs, f) {
+			action.Inputs = append(action.Inputs, f)
+		}
+	}
+	if m := ftr.RunfilesManifest(); m != nil && !slices.Contains(action.InputManifests, m) {
+		action.InputManifests = append(action.InputManifests, m)
+	}
+}
+
+// addTool records a tool of the action once.
+func addTool(action *DeclaredAction, f *File) {
+	if !slices.Contains(action.Tools, f) {
+		action.Tools = append(action.Tools, f)
+	}
 }
 
 // expandTemplate implements actions.expand_template(...).
@@ -773,6 +990,18 @@ func extractFiles(v starlark.Value) []*File {
 				files = append(files, f)
 			}
 		}
+	case starlark.Tuple:
+		for _, elem := range val {
+			if f, ok := elem.(*File); ok {
+				files = append(files, f)
+			}
+		}
+	case *types.Depset:
+		for _, elem := range val.ToList() {
+			if f, ok := elem.(*File); ok {
+				files = append(files, f)
+			}
+		}
 	case *File:
 		files = append(files, val)
 	case *starlarkstruct.Struct:
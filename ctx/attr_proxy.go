@@ -6,6 +6,7 @@ import (
 
 	"go.starlark.net/starlark"
 
+	"github.com/albertocavalcante/starlark-go-bazel/providers"
 	"github.com/albertocavalcante/starlark-go-bazel/types"
 )
 
@@ -151,6 +152,12 @@ func (f *FilesProxy) Set(name string, files []*File) {
 	f.values[name] = files
 }
 
+// Get returns the files for an attribute.
+func (f *FilesProxy) Get(name string) ([]*File, bool) {
+	files, ok := f.values[name]
+	return files, ok
+}
+
 // FileProxy provides access to ctx.file - single file from label attributes.
 // Source: StarlarkRuleContext.getFile() returns a StructImpl where
 // each attribute marked allow_single_file maps to a single File or None.
@@ -221,6 +228,12 @@ func (f *FileProxy) Set(name string, file *File) {
 // from attributes marked executable=True.
 type ExecutableProxy struct {
 	values map[string]*File
+
+	// filesToRun maps each executable to the FilesToRunProvider of the
+	// target it came from, so actions using it also get its runfiles.
+	// Source: StarlarkRuleContext.getExecutableRunfiles()
+	filesToRun map[*File]*providers.FilesToRunProvider
+
 	frozen bool
 }
 
@@ -232,7 +245,8 @@ var (
 // NewExecutableProxy creates a new ExecutableProxy.
 func NewExecutableProxy() *ExecutableProxy {
 	return &ExecutableProxy{
-		values: make(map[string]*File),
+		values:     make(map[string]*File),
+		filesToRun: make(map[*File]*providers.FilesToRunProvider),
 	}
 }
 
@@ -281,6 +295,21 @@ func (e *ExecutableProxy) Set(name string, file *File) {
 	e.values[name] = file
 }
 
+// SetWithFilesToRun sets the executable for an attribute together with the
+// FilesToRunProvider of the target that provides it.
+func (e *ExecutableProxy) SetWithFilesToRun(name string, file *File, ftr *providers.FilesToRunProvider) {
+	e.values[name] = file
+	if file != nil && ftr != nil {
+		e.filesToRun[file] = ftr
+	}
+}
+
+// FilesToRun returns the FilesToRunProvider registered for an executable.
+func (e *ExecutableProxy) FilesToRun(file *File) (*providers.FilesToRunProvider, bool) {
+	ftr, ok := e.filesToRun[file]
+	return ftr, ok
+}
+
 // OutputsProxy provides access to ctx.outputs - predeclared output files.
 // Source: StarlarkRuleContext.outputs() returns the Outputs struct containing
 // predeclared outputs from output attributes and the outputs dict.
@@ -358,6 +387,12 @@ func (o *OutputsProxy) Set(name string, value starlark.Value) {
 	o.values[name] = value
 }
 
+// Get returns an output.
+func (o *OutputsProxy) Get(name string) (starlark.Value, bool) {
+	v, ok := o.values[name]
+	return v, ok
+}
+
 // SetExecutable sets the executable output.
 func (o *OutputsProxy) SetExecutable(file *File) {
 	o.executable = file
@@ -366,16 +401,19 @@ func (o *OutputsProxy) SetExecutable(file *File) {
 // TargetProxy wraps a target for ctx.attr dependencies.
 // Source: TransitiveInfoCollection provides access to target data.
 type TargetProxy struct {
-	label     *types.Label
-	files     []*File
-	providers map[*types.Provider]*types.ProviderInstance
-	frozen    bool
+	label        *types.Label
+	files        []*File
+	providers    map[*types.Provider]*types.ProviderInstance
+	defaultInfo  *providers.DefaultInfo
+	templateVars *providers.TemplateVariableInfo
+	frozen       bool
 }
 
 var (
 	_ starlark.Value     = (*TargetProxy)(nil)
 	_ starlark.HasAttrs  = (*TargetProxy)(nil)
 	_ starlark.Indexable = (*TargetProxy)(nil)
+	_ starlark.Mapping   = (*TargetProxy)(nil)
 )
 
 // NewTargetProxy creates a new TargetProxy.
@@ -438,6 +476,25 @@ func (t *TargetProxy) Len() int {
 	return 0
 }
 
+// Get implements target[provider].
+// Source: ProviderCollection.getIndex()
+func (t *TargetProxy) Get(key starlark.Value) (starlark.Value, bool, error) {
+	p, ok := key.(*types.Provider)
+	if !ok {
+		return nil, false, fmt.Errorf("type Target only supports indexing by object constructors, got %s instead", key.Type())
+	}
+	if p == providers.DefaultInfoProvider {
+		return t.DefaultInfo(), true, nil
+	}
+	if p == providers.TemplateVariableInfoProvider && t.templateVars != nil {
+		return t.templateVars, true, nil
+	}
+	if pi, ok := t.providers[p]; ok {
+		return pi, true, nil
+	}
+	return nil, false, fmt.Errorf("%s doesn't contain declared provider '%s'", t.String(), p.Name())
+}
+
 // SetFiles sets the files for this target.
 func (t *TargetProxy) SetFiles(files []*File) {
 	t.files = files
@@ -454,6 +511,44 @@ func (t *TargetProxy) GetProvider(provider *types.Provider) (*types.ProviderInst
 	return pi, ok
 }
 
+// SetDefaultInfo sets the DefaultInfo returned by target[DefaultInfo].
+func (t *TargetProxy) SetDefaultInfo(info *providers.DefaultInfo) {
+	t.defaultInfo = info
+}
+
+// DefaultInfo returns the target's DefaultInfo. Targets without an explicit
+// DefaultInfo get one whose files are the target's files.
+// Source: every configured target carries DefaultInfo (DefaultInfo.java)
+func (t *TargetProxy) DefaultInfo() *providers.DefaultInfo {
+	if t.defaultInfo != nil {
+		return t.defaultInfo
+	}
+	info := providers.NewDefaultInfo()
+	items := make([]starlark.Value, len(t.files))
+	for i, f := range t.files {
+		items[i] = f
+	}
+	files, _ := types.DepsetOf(items)
+	info.SetFiles(files)
+	return info
+}
+
+// SetTemplateVariableInfo sets the TemplateVariableInfo returned by
+// target[platform_common.TemplateVariableInfo].
+func (t *TargetProxy) SetTemplateVariableInfo(info *providers.TemplateVariableInfo) {
+	t.templateVars = info
+}
+
+// TemplateVariableInfo returns the target's TemplateVariableInfo, if any.
+func (t *TargetProxy) TemplateVariableInfo() (*providers.TemplateVariableInfo, bool) {
+	return t.templateVars, t.templateVars != nil
+}
+
+// FilesToRun returns the target's FilesToRunProvider.
+func (t *TargetProxy) FilesToRun() *providers.FilesToRunProvider {
+	return t.DefaultInfo().FilesToRun()
+}
+
 // Label returns the target's label.
 func (t *TargetProxy) Label() *types.Label {
 	return t.label
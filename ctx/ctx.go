@@ -43,10 +43,14 @@ package ctx
 
 import (
 	"fmt"
+	"path"
+	"slices"
+	"sort"
 	"strings"
 
 	"go.starlark.net/starlark"
 
+	"github.com/albertocavalcante/starlark-go-bazel/providers"
 	"github.com/albertocavalcante/starlark-go-bazel/types"
 )
 
@@ -78,6 +82,8 @@ type Ctx struct {
 	features         []string          // ctx.features
 	disabledFeatures []string          // ctx.disabled_features
 	makeVariables    map[string]string // ctx.var
+	targetCPU        string            // $(TARGET_CPU)
+	compilationMode  string            // $(COMPILATION_MODE)
 
 	// Status files
 	infoFile    *File // ctx.info_file (non-volatile)
@@ -86,6 +92,9 @@ type Ctx struct {
 	// For location expansion
 	labelMap map[string][]*File
 
+	// Execution groups declared by the rule (Source: RuleClass.getExecGroups)
+	execGroups *ExecGroupCollection
+
 	// Rule metadata
 	isExecutable bool // Whether this is an executable rule
 	isTest       bool // Whether this is a test rule
@@ -113,6 +122,9 @@ type CtxConfig struct {
 	Features         []string
 	DisabledFeatures []string
 	MakeVariables    map[string]string
+	ExecGroups       []string
+	TargetCPU        string // Defaults to "k8"
+	CompilationMode  string // Defaults to "fastbuild"
 }
 
 // NewCtx creates a new Ctx.
@@ -129,7 +141,17 @@ func NewCtx(cfg CtxConfig) *Ctx {
 		features:         cfg.Features,
 		disabledFeatures: cfg.DisabledFeatures,
 		makeVariables:    cfg.MakeVariables,
+		targetCPU:        cfg.TargetCPU,
+		compilationMode:  cfg.CompilationMode,
 		labelMap:         make(map[string][]*File),
+		execGroups:       &ExecGroupCollection{names: cfg.ExecGroups},
+	}
+
+	if ctx.targetCPU == "" {
+		ctx.targetCPU = "k8"
+	}
+	if ctx.compilationMode == "" {
+		ctx.compilationMode = "fastbuild"
 	}
 
 	// Initialize proxies
@@ -243,9 +265,18 @@ func (c *Ctx) Attr(name string) (starlark.Value, error) {
 
 	// Make variables (StarlarkRuleContextApi.var)
 	case "var":
-		d := starlark.NewDict(len(c.makeVariables))
-		for k, v := range c.makeVariables {
-			_ = d.SetKey(starlark.String(k), starlark.String(v))
+		vars, err := c.configMakeVariables()
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(vars))
+		for k := range vars {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d := starlark.NewDict(len(keys))
+		for _, k := range keys {
+			_ = d.SetKey(starlark.String(k), starlark.String(vars[k]))
 		}
 		return d, nil
 
@@ -275,7 +306,7 @@ func (c *Ctx) Attr(name string) (starlark.Value, error) {
 
 	// Exec groups (simplified)
 	case "exec_groups":
-		return &ExecGroupCollection{}, nil
+		return c.execGroups, nil
 
 	// Methods
 	case "runfiles":
@@ -379,52 +410,122 @@ func (c *Ctx) runfilesMethod(_ *starlark.Thread, _ *starlark.Builtin, args starl
 		return nil, err
 	}
 
-	rf := NewRunfiles()
+	rb := providers.NewRunfilesBuilder(c.workspaceName)
 
 	// Add files
-	if list, ok := files.(*starlark.List); ok {
-		for i := range list.Len() {
-			if f, ok := list.Index(i).(*File); ok {
-				rf.AddFile(f)
+	if files != starlark.None {
+		iter := starlark.Iterate(files)
+		if iter == nil {
+			return nil, fmt.Errorf("runfiles: got %s for 'files', want sequence", files.Type())
+		}
+		defer iter.Done()
+		var v starlark.Value
+		for iter.Next(&v) {
+			f, ok := v.(*File)
+			if !ok {
+				return nil, fmt.Errorf("runfiles: files must contain File objects, got %s", v.Type())
 			}
+			rb.AddFile(f)
 		}
 	}
 
-	// Add transitive files (simplified - just add directly)
+	// Add transitive files, keeping the depset structure
+	// Source: StarlarkRuleContext.runfiles() - transitive_files is a depset of Files
 	if transitiveFiles != starlark.None {
-		// In real Bazel this would be a depset
-		if list, ok := transitiveFiles.(*starlark.List); ok {
-			for i := range list.Len() {
-				if f, ok := list.Index(i).(*File); ok {
-					rf.AddTransitiveFile(f)
-				}
-			}
+		d, ok := transitiveFiles.(*types.Depset)
+		if !ok {
+			return nil, fmt.Errorf("runfiles: got %s for 'transitive_files', want depset", transitiveFiles.Type())
 		}
+		if d.Truth() && d.ElementType() != "File" {
+			return nil, fmt.Errorf("runfiles: got a depset of '%s', expected a depset of 'File'", d.ElementType())
+		}
+		rb.AddTransitiveFiles(d)
 	}
 
-	// Add symlinks
-	if d, ok := symlinks.(*starlark.Dict); ok {
-		for _, item := range d.Items() {
-			if path, ok := item[0].(starlark.String); ok {
-				if f, ok := item[1].(*File); ok {
-					rf.AddSymlink(string(path), f)
+	// Add symlinks and root symlinks: a dict of path to File, or a depset of SymlinkEntry
+	if err := addRunfilesSymlinks(symlinks, "symlinks", rb.AddSymlink, rb.AddSymlinks); err != nil {
+		return nil, err
+	}
+	if err := addRunfilesSymlinks(rootSymlinks, "root_symlinks", rb.AddRootSymlink, rb.AddRootSymlinks); err != nil {
+		return nil, err
+	}
+
+	// Source: Runfiles.Builder.addRunfiles(RuleContext, Function) - gather
+	// runfiles from the srcs, deps and data attributes. Data dependencies
+	// also contribute their default outputs.
+	if collectData || collectDefault {
+		for _, attr := range []string{"srcs", "deps", "data"} {
+			v, ok := c.attr.Get(attr)
+			if !ok {
+				continue
+			}
+			for _, t := range targetsOf(v) {
+				info := t.DefaultInfo()
+				if collectData {
+					rb.Merge(orRunfiles(info.DataRunfiles(), info.Runfiles()))
+				}
+				if collectDefault {
+					rb.Merge(orRunfiles(info.DefaultRunfiles(), info.Runfiles()))
+				}
+				if attr == "data" && info.Files() != nil {
+					rb.AddTransitiveFiles(info.Files())
 				}
 			}
 		}
 	}
 
-	// Add root symlinks
-	if d, ok := rootSymlinks.(*starlark.Dict); ok {
-		for _, item := range d.Items() {
-			if path, ok := item[0].(starlark.String); ok {
-				if f, ok := item[1].(*File); ok {
-					rf.AddRootSymlink(string(path), f)
-				}
+	return rb.Build()
+}
+
+// orRunfiles returns rf, or fallback if rf is nil.
+func orRunfiles(rf, fallback *Runfiles) *Runfiles {
+	if rf == nil {
+		return fallback
+	}
+	return rf
+}
+
+// addRunfilesSymlinks adds the symlinks or root_symlinks argument of ctx.runfiles().
+func addRunfilesSymlinks(v starlark.Value, param string, add func(string, *File), addDepset func(*types.Depset)) error {
+	switch s := v.(type) {
+	case *starlark.Dict:
+		for _, item := range s.Items() {
+			path, ok := item[0].(starlark.String)
+			if !ok {
+				return fmt.Errorf("runfiles: %s keys must be strings, got %s", param, item[0].Type())
+			}
+			f, ok := item[1].(*File)
+			if !ok {
+				return fmt.Errorf("runfiles: %s values must be File objects, got %s", param, item[1].Type())
 			}
+			add(string(path), f)
 		}
+	case *types.Depset:
+		if s.Truth() && s.ElementType() != "SymlinkEntry" {
+			return fmt.Errorf("runfiles: got a depset of '%s', expected a depset of 'SymlinkEntry'", s.ElementType())
+		}
+		addDepset(s)
+	default:
+		return fmt.Errorf("runfiles: got %s for '%s', want dict or depset", v.Type(), param)
 	}
+	return nil
+}
 
-	return rf, nil
+// targetsOf returns the targets held by an attribute value, which is either
+// a single target or a list of targets.
+func targetsOf(v starlark.Value) []*TargetProxy {
+	if t, ok := v.(*TargetProxy); ok {
+		return []*TargetProxy{t}
+	}
+	var targets []*TargetProxy
+	if list, ok := v.(*starlark.List); ok {
+		for i := range list.Len() {
+			if t, ok := list.Index(i).(*TargetProxy); ok {
+				targets = append(targets, t)
+			}
+		}
+	}
+	return targets
 }
 
 // expandLocationMethod implements ctx.expand_location().
@@ -432,10 +533,12 @@ func (c *Ctx) runfilesMethod(_ *starlark.Thread, _ *starlark.Builtin, args starl
 func (c *Ctx) expandLocationMethod(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var input string
 	var targets starlark.Value = starlark.NewList(nil)
+	var shortPaths bool
 
 	if err := starlark.UnpackArgs("expand_location", args, kwargs,
 		"input", &input,
 		"targets?", &targets,
+		"short_paths?", &shortPaths,
 	); err != nil {
 		return nil, err
 	}
@@ -457,19 +560,33 @@ func (c *Ctx) expandLocationMethod(_ *starlark.Thread, _ *starlark.Builtin, args
 		}
 	}
 
-	result, err := expandLocation(input, labelMap)
+	// Source: StarlarkRuleContext.expandLocation() - short_paths is an
+	// internal flag making $(location) expand to root-relative paths.
+	if shortPaths {
+		input = strings.NewReplacer("$(location ", "$(rootpath ", "$(locations ", "$(rootpaths ").Replace(input)
+	}
+
+	result, err := c.newLocationExpander(labelMap).expand(input)
 	if err != nil {
 		return nil, err
 	}
 	return starlark.String(result), nil
 }
 
+// newLocationExpander creates a location expander for this rule.
+func (c *Ctx) newLocationExpander(labelMap map[string][]*File) *locationExpander {
+	return newLocationExpander(c.label, c.workspaceName, labelMap)
+}
+
 // expandMakeVariablesMethod implements ctx.expand_make_variables().
+// Variables are looked up in additional_substitutions, then the rule's
+// predefined variables ($@, $<, $(SRCS), $(OUTS), $(@D)), then the
+// TemplateVariableInfo of the toolchains attribute, then ctx.var.
 // Source: StarlarkRuleContext.expandMakeVariables()
 func (c *Ctx) expandMakeVariablesMethod(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var attrName string
 	var command string
-	var additionalSubstitutions starlark.Value
+	var additionalSubstitutions *starlark.Dict
 
 	if err := starlark.UnpackArgs("expand_make_variables", args, kwargs,
 		"attribute_name", &attrName,
@@ -479,59 +596,263 @@ func (c *Ctx) expandMakeVariablesMethod(_ *starlark.Thread, _ *starlark.Builtin,
 		return nil, err
 	}
 
-	result := command
+	additional := make(map[string]string, additionalSubstitutions.Len())
+	for _, item := range additionalSubstitutions.Items() {
+		k, ok := item[0].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("expand_make_variables: additional_substitutions keys must be strings, got %s", item[0].Type())
+		}
+		v, ok := item[1].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("expand_make_variables: additional_substitutions values must be strings, got %s", item[1].Type())
+		}
+		additional[string(k)] = string(v)
+	}
 
-	// First apply additional substitutions
-	if d, ok := additionalSubstitutions.(*starlark.Dict); ok {
-		for _, item := range d.Items() {
-			if k, ok := item[0].(starlark.String); ok {
-				if v, ok := item[1].(starlark.String); ok {
-					pattern := "$(" + string(k) + ")"
-					result = strings.ReplaceAll(result, pattern, string(v))
-				}
+	configVars, err := c.configMakeVariables()
+	if err != nil {
+		return nil, err
+	}
+	ruleVars := c.ruleMakeVariables()
+
+	result, err := expandMakeVariables(command, func(name string) (string, bool) {
+		for _, vars := range []map[string]string{additional, ruleVars, configVars} {
+			if v, ok := vars[name]; ok {
+				return v, true
+			}
+		}
+		return "", false
+	})
+	if err != nil {
+		// Source: RuleContext.attributeError()
+		return nil, fmt.Errorf("in %s attribute of %s: %v", attrName, c.label.String(), err)
+	}
+	return starlark.String(result), nil
+}
+
+// configMakeVariables returns the variables visible through ctx.var: the
+// predefined configuration variables, those of the TemplateVariableInfo
+// providers in the toolchains attribute and the configured MakeVariables.
+// Source: ConfigurationMakeVariableContext.java
+func (c *Ctx) configMakeVariables() (map[string]string, error) {
+	genDir := c.genfilesDir
+	if genDir == "" {
+		genDir = c.binDir
+	}
+	vars := map[string]string{
+		"BINDIR":           c.binDir,
+		"GENDIR":           genDir,
+		"TARGET_CPU":       c.targetCPU,
+		"COMPILATION_MODE": c.compilationMode,
+	}
+
+	// Source: ConfigurationMakeVariableContext - toolchains contribute
+	// TemplateVariableInfo, later entries taking precedence.
+	if toolchains, ok := c.attr.Get("toolchains"); ok {
+		iter := starlark.Iterate(toolchains)
+		if iter == nil {
+			return nil, fmt.Errorf("toolchains attribute must be a list of targets, got %s", toolchains.Type())
+		}
+		defer iter.Done()
+		var v starlark.Value
+		for iter.Next(&v) {
+			t, ok := v.(*TargetProxy)
+			if !ok {
+				return nil, fmt.Errorf("toolchains attribute must be a list of targets, got %s", v.Type())
+			}
+			info, ok := t.TemplateVariableInfo()
+			if !ok {
+				return nil, fmt.Errorf("%s does not provide TemplateVariableInfo", t.Label().String())
+			}
+			for k, val := range info.Variables() {
+				vars[k] = val
 			}
 		}
 	}
 
-	// Then apply ctx.var
 	for k, v := range c.makeVariables {
-		pattern := "$(" + k + ")"
-		result = strings.ReplaceAll(result, pattern, v)
+		vars[k] = v
 	}
+	return vars, nil
+}
 
-	// Handle $$ -> $
-	result = strings.ReplaceAll(result, "$$", "$")
+// ruleMakeVariables returns the genrule-style variables derived from the
+// srcs attribute and the outs output.
+// Source: GenRuleBase.CommandResolverContext.lookupVariable()
+func (c *Ctx) ruleMakeVariables() map[string]string {
+	vars := make(map[string]string)
 
-	return starlark.String(result), nil
+	if srcs, ok := c.files.Get("srcs"); ok {
+		vars["SRCS"] = joinPaths(srcs)
+		if len(srcs) == 1 {
+			vars["<"] = srcs[0].Path()
+		}
+	}
+
+	vars["@D"] = path.Join(c.binDir, c.label.Pkg())
+	if v, ok := c.outputs.Get("outs"); ok {
+		outs := extractFiles(v)
+		vars["OUTS"] = joinPaths(outs)
+		if len(outs) == 1 {
+			vars["@"] = outs[0].Path()
+			vars["@D"] = path.Dir(outs[0].Path())
+		}
+	}
+	return vars
+}
+
+// joinPaths returns the space-separated exec paths of files.
+func joinPaths(files []*File) string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path()
+	}
+	return strings.Join(paths, " ")
 }
 
 // resolveCommandMethod implements ctx.resolve_command().
+// Returns (inputs, argv, input_manifests) where inputs are the files of the
+// given tools and argv runs the expanded command through bash.
 // Source: StarlarkRuleContext.resolveCommand()
 func (c *Ctx) resolveCommandMethod(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var command string
+	var attribute starlark.Value = starlark.None
+	var expandLocations bool
+	var makeVariables starlark.Value = starlark.None
+	var tools starlark.Value = starlark.NewList(nil)
+	var labelDict starlark.Value = starlark.NewDict(0)
+	var executionRequirements starlark.Value = starlark.NewDict(0)
 
 	if err := starlark.UnpackArgs("resolve_command", args, kwargs,
 		"command?", &command,
+		"attribute?", &attribute,
+		"expand_locations?", &expandLocations,
+		"make_variables?", &makeVariables,
+		"tools?", &tools,
+		"label_dict?", &labelDict,
+		"execution_requirements?", &executionRequirements,
 	); err != nil {
 		return nil, err
 	}
 
-	// Returns tuple (inputs, argv, empty list)
+	inputs, labelMap, err := resolveToolTargets("resolve_command", tools)
+	if err != nil {
+		return nil, err
+	}
+
+	// Source: StarlarkRuleContext.resolveCommand() - label_dict entries are
+	// Labels mapped to lists of Files.
+	if d, ok := labelDict.(*starlark.Dict); ok {
+		for _, item := range d.Items() {
+			var key string
+			switch k := item[0].(type) {
+			case *types.Label:
+				key = k.String()
+			case starlark.String:
+				key = string(k)
+			default:
+				return nil, fmt.Errorf("resolve_command: label_dict keys must be Labels, got %s", item[0].Type())
+			}
+			labelMap[key] = append(labelMap[key], extractFiles(item[1])...)
+		}
+	}
+
+	if expandLocations {
+		for k, v := range c.labelMap {
+			if _, ok := labelMap[k]; !ok {
+				labelMap[k] = v
+			}
+		}
+		command, err = c.newLocationExpander(labelMap).expand(command)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if d, ok := makeVariables.(*starlark.Dict); ok {
+		for _, item := range d.Items() {
+			k, kok := item[0].(starlark.String)
+			v, vok := item[1].(starlark.String)
+			if kok && vok {
+				command = strings.ReplaceAll(command, "$("+string(k)+")", string(v))
+			}
+		}
+	}
+
+	inputValues := make([]starlark.Value, len(inputs))
+	for i, f := range inputs {
+		inputValues[i] = f
+	}
 	return starlark.Tuple{
-		starlark.NewList(nil),
+		starlark.NewList(inputValues),
 		starlark.NewList([]starlark.Value{starlark.String("/bin/bash"), starlark.String("-c"), starlark.String(command)}),
 		starlark.NewList(nil),
 	}, nil
 }
 
 // resolveToolsMethod implements ctx.resolve_tools().
+// Returns (inputs, input_manifests) where inputs is a depset of the files
+// needed to run the given tools.
 // Source: StarlarkRuleContext.resolveTools()
 func (c *Ctx) resolveToolsMethod(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	// Returns tuple (inputs depset, empty list)
-	return starlark.Tuple{
-		starlark.NewList(nil), // Simplified: should be a depset
-		starlark.NewList(nil),
-	}, nil
+	var tools starlark.Value = starlark.NewList(nil)
+
+	if err := starlark.UnpackArgs("resolve_tools", args, kwargs,
+		"tools?", &tools,
+	); err != nil {
+		return nil, err
+	}
+
+	inputs, _, err := resolveToolTargets("resolve_tools", tools)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]starlark.Value, len(inputs))
+	for i, f := range inputs {
+		values[i] = f
+	}
+	depset, err := types.DepsetOf(values)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Tuple{depset, starlark.NewList(nil)}, nil
+}
+
+// resolveToolTargets collects the files needed to run each tool target,
+// along with a label map suitable for location expansion.
+// Source: CommandHelper.Builder.addToolDependencies()
+func resolveToolTargets(fn string, tools starlark.Value) ([]*File, map[string][]*File, error) {
+	var inputs []*File
+	labelMap := make(map[string][]*File)
+
+	iter := starlark.Iterate(tools)
+	if iter == nil {
+		return nil, nil, fmt.Errorf("%s: tools must be a list of Targets, got %s", fn, tools.Type())
+	}
+	defer iter.Done()
+
+	var v starlark.Value
+	for iter.Next(&v) {
+		t, ok := v.(*TargetProxy)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: tools must be a list of Targets, got %s", fn, v.Type())
+		}
+		labelMap[t.Label().String()] = t.Files()
+
+		ftr := t.FilesToRun()
+		if ftr.Executable() == nil {
+			// Non-executable tools contribute their default outputs.
+			inputs = append(inputs, t.Files()...)
+			continue
+		}
+		for _, f := range ftr.FilesToRun() {
+			if file, ok := f.(*File); ok {
+				inputs = append(inputs, file)
+			}
+		}
+	}
+	return inputs, labelMap, nil
 }
 
 // tokenizeMethod implements ctx.tokenize().
@@ -748,6 +1069,7 @@ func (t *ToolchainContext) Get(key starlark.Value) (v starlark.Value, found bool
 // ExecGroupCollection represents ctx.exec_groups (simplified).
 // Source: StarlarkExecGroupCollection
 type ExecGroupCollection struct {
+	names  []string // Exec group names declared by the rule
 	frozen bool
 }
 
@@ -770,10 +1092,22 @@ func (e *ExecGroupCollection) AttrNames() []string {
 }
 
 func (e *ExecGroupCollection) Get(key starlark.Value) (v starlark.Value, found bool, err error) {
-	// Return empty exec group for any lookup
+	name, ok := key.(starlark.String)
+	if !ok {
+		return nil, false, fmt.Errorf("exec_groups: key must be a string, got %s", key.Type())
+	}
+	if !e.Has(string(name)) {
+		return nil, false, fmt.Errorf("unrecognized exec group '%s' requested. Available exec groups: [%s]", string(name), strings.Join(e.names, ", "))
+	}
 	return &emptyStruct{}, true, nil
 }
 
+// Has reports whether the rule declared an exec group with the given name.
+// Source: StarlarkExecGroupCollection.containsKey()
+func (e *ExecGroupCollection) Has(name string) bool {
+	return slices.Contains(e.names, name)
+}
+
 // emptyStruct is a helper for empty struct values.
 type emptyStruct struct {
 	frozen bool
@@ -1,10 +1,13 @@
 package ctx
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
 	"go.starlark.net/starlark"
 
+	"github.com/albertocavalcante/starlark-go-bazel/providers"
 	"github.com/albertocavalcante/starlark-go-bazel/types"
 )
 
@@ -301,6 +304,171 @@ func TestActionsRun(t *testing.T) {
 	}
 }
 
+func TestActionsRunFilesToRunProvider(t *testing.T) {
+	label, _ := types.ParseLabel("//pkg:target")
+	ctx := NewCtx(CtxConfig{
+		Label:  label,
+		BinDir: "bazel-out/bin",
+	})
+
+	thread := &starlark.Thread{}
+
+	declareFile, _ := ctx.actions.Attr("declare_file")
+	outputFile, _ := declareFile.(*starlark.Builtin).CallInternal(thread, starlark.Tuple{starlark.String("out.txt")}, nil)
+
+	toolLabel, _ := types.ParseLabel("//tools:gen")
+	tool := types.NewDerivedFile("bazel-out/bin", "tools/gen", toolLabel)
+	data := types.NewSourceFile("tools", "gen.cfg")
+	rb := providers.NewRunfilesBuilder("")
+	rb.AddFile(data)
+	runfiles, err := rb.Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ftr := providers.NewFilesToRunProvider(tool, runfiles)
+
+	run, _ := ctx.actions.Attr("run")
+	_, err = run.(*starlark.Builtin).CallInternal(thread, nil, []starlark.Tuple{
+		{starlark.String("outputs"), starlark.NewList([]starlark.Value{outputFile})},
+		{starlark.String("executable"), ftr},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	action := ctx.actions.DeclaredActions()[0]
+	if action.Executable == nil || action.Executable.ShortPath() != "tools/gen" {
+		t.Errorf("expected executable tools/gen, got %v", action.Executable)
+	}
+
+	var hasData bool
+	for _, f := range action.Inputs {
+		if f.ShortPath() == "tools/gen.cfg" {
+			hasData = true
+		}
+	}
+	if !hasData {
+		t.Errorf("expected tool runfiles in inputs, got %v", action.Inputs)
+	}
+
+	if len(action.InputManifests) != 1 || action.InputManifests[0].ShortPath() != "tools/gen.runfiles_manifest" {
+		t.Errorf("expected runfiles manifest in input manifests, got %v", action.InputManifests)
+	}
+}
+
+func TestActionsRunToolInputs(t *testing.T) {
+	label, _ := types.ParseLabel("//pkg:target")
+	ctx := NewCtx(CtxConfig{Label: label, BinDir: "bazel-out/bin"})
+	thread := &starlark.Thread{}
+	run, _ := ctx.actions.Attr("run")
+	runBuiltin := run.(*starlark.Builtin)
+
+	// A plain File executable is an input even without tools.
+	script := NewFile("tools/script.sh", "", true)
+	unused := NewDeclaredFile("pkg/unused.txt", "bazel-out/bin")
+	_, err := runBuiltin.CallInternal(thread, nil, []starlark.Tuple{
+		{starlark.String("outputs"), starlark.NewList(nil)},
+		{starlark.String("executable"), script},
+		{starlark.String("unused_inputs_list"), unused},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	action := ctx.actions.DeclaredActions()[0]
+	if len(action.Inputs) != 1 || action.Inputs[0] != script {
+		t.Errorf("expected the executable as only input, got %v", action.Inputs)
+	}
+	if action.UnusedInputs != unused {
+		t.Errorf("expected unused inputs list %v, got %v", unused, action.UnusedInputs)
+	}
+
+	// A tool given as both executable and tool is recorded once.
+	toolLabel, _ := types.ParseLabel("//tools:gen")
+	ftr := providers.NewFilesToRunProvider(types.NewDerivedFile("bazel-out/bin", "tools/gen", toolLabel), nil)
+	_, err = runBuiltin.CallInternal(thread, nil, []starlark.Tuple{
+		{starlark.String("outputs"), starlark.NewList(nil)},
+		{starlark.String("executable"), ftr},
+		{starlark.String("tools"), starlark.NewList([]starlark.Value{ftr, script, script})},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	action = ctx.actions.DeclaredActions()[1]
+	if len(action.Tools) != 2 || len(action.Inputs) != 2 || len(action.ToolsToRun) != 1 {
+		t.Errorf("expected 2 tools and inputs and 1 tool to run, got %v, %v, %v", action.Tools, action.Inputs, action.ToolsToRun)
+	}
+
+	_, err = runBuiltin.CallInternal(thread, nil, []starlark.Tuple{
+		{starlark.String("outputs"), starlark.NewList(nil)},
+		{starlark.String("executable"), script},
+		{starlark.String("shadowed_action"), starlark.String("action")},
+	})
+	if err == nil || err.Error() != "run: shadowed_action is not supported" {
+		t.Errorf("expected shadowed_action error, got %v", err)
+	}
+}
+
+func TestActionsRunExecGroup(t *testing.T) {
+	label, _ := types.ParseLabel("//pkg:target")
+	ctx := NewCtx(CtxConfig{
+		Label:      label,
+		BinDir:     "bazel-out/bin",
+		ExecGroups: []string{"compile"},
+	})
+
+	thread := &starlark.Thread{}
+	run, _ := ctx.actions.Attr("run")
+	runBuiltin := run.(*starlark.Builtin)
+
+	_, err := runBuiltin.CallInternal(thread, nil, []starlark.Tuple{
+		{starlark.String("outputs"), starlark.NewList(nil)},
+		{starlark.String("executable"), starlark.String("/bin/true")},
+		{starlark.String("exec_group"), starlark.String("compile")},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := ctx.actions.DeclaredActions()[0].ExecGroup; got != "compile" {
+		t.Errorf("expected exec group compile, got %q", got)
+	}
+
+	_, err = runBuiltin.CallInternal(thread, nil, []starlark.Tuple{
+		{starlark.String("outputs"), starlark.NewList(nil)},
+		{starlark.String("executable"), starlark.String("/bin/true")},
+		{starlark.String("exec_group"), starlark.String("link")},
+	})
+	if err == nil || err.Error() != "Action declared for non-existent exec group 'link'." {
+		t.Errorf("expected non-existent exec group error, got %v", err)
+	}
+}
+
+func TestCtxResolveTools(t *testing.T) {
+	label, _ := types.ParseLabel("//pkg:target")
+	ctx := NewCtx(CtxConfig{Label: label})
+
+	toolLabel, _ := types.ParseLabel("//tools:script")
+	target := NewTargetProxy(toolLabel)
+	target.SetFiles([]*File{NewFile("tools/script.sh", "", true)})
+
+	thread := &starlark.Thread{}
+	resolveTools, _ := ctx.Attr("resolve_tools")
+	result, err := resolveTools.(*starlark.Builtin).CallInternal(thread, nil, []starlark.Tuple{
+		{starlark.String("tools"), starlark.NewList([]starlark.Value{target})},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tuple := result.(starlark.Tuple)
+	inputs, ok := tuple[0].(*types.Depset)
+	if !ok {
+		t.Fatalf("expected depset of inputs, got %s", tuple[0].Type())
+	}
+	if n := len(inputs.ToList()); n != 1 {
+		t.Errorf("expected 1 input, got %d", n)
+	}
+}
+
 func TestActionsRunShell(t *testing.T) {
 	label, _ := types.ParseLabel("//pkg:target")
 	ctx := NewCtx(CtxConfig{
@@ -482,6 +650,119 @@ func TestCtxRunfiles(t *testing.T) {
 	}
 }
 
+func TestCtxRunfilesTransitiveAndCollect(t *testing.T) {
+	label, _ := types.ParseLabel("//pkg:target")
+	ctx := NewCtx(CtxConfig{Label: label, WorkspaceName: "_main"})
+
+	// A data dependency with its own default runfiles
+	depLabel, _ := types.ParseLabel("//dep:data")
+	depOut := NewFile("dep/out.txt", "bazel-out/bin", false)
+	depRunfile := NewFile("dep/runfile.txt", "", true)
+	rb := providers.NewRunfilesBuilder("_main")
+	rb.AddFile(depRunfile)
+	depRunfiles, err := rb.Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	depFiles, _ := types.DepsetOf([]starlark.Value{depOut})
+	depInfo := providers.NewDefaultInfo()
+	depInfo.SetFiles(depFiles)
+	depInfo.SetDefaultRunfiles(depRunfiles)
+	dep := NewTargetProxy(depLabel)
+	dep.SetDefaultInfo(depInfo)
+	ctx.AttrProxy().Set("data", starlark.NewList([]starlark.Value{dep}))
+
+	transitive, _ := types.DepsetOf([]starlark.Value{NewFile("pkg/transitive.txt", "", true)})
+
+	thread := &starlark.Thread{}
+	runfilesMethod, _ := ctx.Attr("runfiles")
+	result, err := runfilesMethod.(*starlark.Builtin).CallInternal(thread, nil, []starlark.Tuple{
+		{starlark.String("transitive_files"), transitive},
+		{starlark.String("collect_default"), starlark.True},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rf := result.(*Runfiles)
+
+	var paths []string
+	for _, v := range rf.Files().ToList() {
+		paths = append(paths, v.(*File).ShortPath())
+	}
+	for _, want := range []string{"pkg/transitive.txt", "dep/runfile.txt", "dep/out.txt"} {
+		if !slices.Contains(paths, want) {
+			t.Errorf("expected %s in runfiles, got %v", want, paths)
+		}
+	}
+
+	// The result is accepted by DefaultInfo as-is
+	_, err = providers.DefaultInfoBuiltin(thread, nil, nil, []starlark.Tuple{
+		{starlark.String("runfiles"), rf},
+	})
+	if err != nil {
+		t.Errorf("DefaultInfo rejected ctx.runfiles() result: %v", err)
+	}
+
+	// transitive_files must be a depset
+	_, err = runfilesMethod.(*starlark.Builtin).CallInternal(thread, nil, []starlark.Tuple{
+		{starlark.String("transitive_files"), starlark.NewList(nil)},
+	})
+	if err == nil {
+		t.Error("expected error for list transitive_files")
+	}
+}
+
+func TestCtxRunfilesCollectDataAndDefault(t *testing.T) {
+	label, _ := types.ParseLabel("//pkg:target")
+	ctx := NewCtx(CtxConfig{Label: label, WorkspaceName: "_main"})
+
+	// A dependency whose data and default runfiles differ
+	runfilesOf := func(path string) *Runfiles {
+		rb := providers.NewRunfilesBuilder("_main")
+		rb.AddFile(NewFile(path, "", true))
+		rf, err := rb.Build()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return rf
+	}
+	depLabel, _ := types.ParseLabel("//dep:lib")
+	depInfo := providers.NewDefaultInfo()
+	depInfo.SetDataRunfiles(runfilesOf("dep/data.txt"))
+	depInfo.SetDefaultRunfiles(runfilesOf("dep/default.txt"))
+	dep := NewTargetProxy(depLabel)
+	dep.SetDefaultInfo(depInfo)
+	ctx.AttrProxy().Set("deps", starlark.NewList([]starlark.Value{dep}))
+
+	thread := &starlark.Thread{}
+	runfilesMethod, _ := ctx.Attr("runfiles")
+	tests := []struct {
+		kwargs []starlark.Tuple
+		want   []string
+	}{
+		{[]starlark.Tuple{{starlark.String("collect_data"), starlark.True}}, []string{"dep/data.txt"}},
+		{[]starlark.Tuple{{starlark.String("collect_default"), starlark.True}}, []string{"dep/default.txt"}},
+		{[]starlark.Tuple{
+			{starlark.String("collect_data"), starlark.True},
+			{starlark.String("collect_default"), starlark.True},
+		}, []string{"dep/data.txt", "dep/default.txt"}},
+	}
+	for _, tt := range tests {
+		result, err := runfilesMethod.(*starlark.Builtin).CallInternal(thread, nil, tt.kwargs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var paths []string
+		for _, v := range result.(*Runfiles).Files().ToList() {
+			paths = append(paths, v.(*File).ShortPath())
+		}
+		slices.Sort(paths)
+		if !slices.Equal(paths, tt.want) {
+			t.Errorf("runfiles(%v) = %v, want %v", tt.kwargs, paths, tt.want)
+		}
+	}
+}
+
 func TestCtxExpandLocation(t *testing.T) {
 	label, _ := types.ParseLabel("//pkg:target")
 	ctx := NewCtx(CtxConfig{Label: label})
@@ -563,6 +844,105 @@ func TestCtxExpandMakeVariables(t *testing.T) {
 	}
 }
 
+func TestCtxExpandLocationFunctions(t *testing.T) {
+	label, _ := types.ParseLabel("//pkg:target")
+	ctx := NewCtx(CtxConfig{Label: label, WorkspaceName: "_main"})
+
+	single := NewFile("pkg/tool.sh", "", true)
+	multi := []*File{
+		NewFile("pkg/a.txt", "bazel-out/bin", false),
+		NewFile("pkg/b.txt", "bazel-out/bin", false),
+	}
+	ctx.SetLabelMap(map[string][]*File{
+		"//pkg:tool": {single},
+		":data":      multi,
+	})
+
+	thread := &starlark.Thread{}
+	expandMethod, _ := ctx.Attr("expand_location")
+	expand := func(input string) (string, error) {
+		result, err := expandMethod.(*starlark.Builtin).CallInternal(thread, starlark.Tuple{starlark.String(input)}, nil)
+		if err != nil {
+			return "", err
+		}
+		return string(result.(starlark.String)), nil
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"$(execpath :tool)", "pkg/tool.sh"},
+		{"$(rootpath tool)", "pkg/tool.sh"},
+		{"$(rlocationpath //pkg:tool)", "_main/pkg/tool.sh"},
+		{"$(execpaths :data)", "bazel-out/bin/pkg/a.txt bazel-out/bin/pkg/b.txt"},
+		{"$(rootpaths :data)", "pkg/a.txt pkg/b.txt"},
+		{"$$(location :tool) $(CC)", "$$(location :tool) $(CC)"},
+	}
+	for _, tt := range tests {
+		got, err := expand(tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+
+	_, err := expand("$(location :data)")
+	if err == nil || !strings.Contains(err.Error(), "expands to more than one file, please use $(locations :data) instead") {
+		t.Errorf("expected ambiguity error, got %v", err)
+	}
+
+	_, err = expand("$(location :missing)")
+	if err == nil || !strings.Contains(err.Error(), "is not a declared prerequisite of this rule") {
+		t.Errorf("expected undeclared prerequisite error, got %v", err)
+	}
+}
+
+func TestCtxExpandMakeVariablesPredefined(t *testing.T) {
+	label, _ := types.ParseLabel("//pkg:gen")
+	ctx := NewCtx(CtxConfig{
+		Label:  label,
+		BinDir: "bazel-out/k8-fastbuild/bin",
+	})
+	ctx.FilesProxy().Set("srcs", []*File{NewFile("pkg/in.txt", "", true)})
+	ctx.OutputsProxy().Set("outs", starlark.NewList([]starlark.Value{
+		NewDeclaredFile("pkg/out.txt", "bazel-out/k8-fastbuild/bin"),
+	}))
+
+	toolchainLabel, _ := types.ParseLabel("//toolchains:cc")
+	toolchain := NewTargetProxy(toolchainLabel)
+	toolchain.SetTemplateVariableInfo(providers.NewTemplateVariableInfo(map[string]string{"CC": "/opt/bin/clang"}))
+	ctx.AttrProxy().Set("toolchains", starlark.NewList([]starlark.Value{toolchain}))
+
+	thread := &starlark.Thread{}
+	expandMethod, _ := ctx.Attr("expand_make_variables")
+	result, err := expandMethod.(*starlark.Builtin).CallInternal(thread, starlark.Tuple{
+		starlark.String("cmd"),
+		starlark.String("$(CC) $< -o $@ -I$(BINDIR) $(TARGET_CPU) $(SRCS) $(OUTS)"),
+		starlark.NewDict(0),
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "/opt/bin/clang pkg/in.txt -o bazel-out/k8-fastbuild/bin/pkg/out.txt -Ibazel-out/k8-fastbuild/bin k8 pkg/in.txt bazel-out/k8-fastbuild/bin/pkg/out.txt"
+	if s := string(result.(starlark.String)); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+
+	_, err = expandMethod.(*starlark.Builtin).CallInternal(thread, starlark.Tuple{
+		starlark.String("cmd"),
+		starlark.String("$(UNDEFINED)"),
+		starlark.NewDict(0),
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "$(UNDEFINED) not defined") {
+		t.Errorf("expected undefined variable error, got %v", err)
+	}
+}
+
 func TestCtxTokenize(t *testing.T) {
 	label, _ := types.ParseLabel("//pkg:target")
 	ctx := NewCtx(CtxConfig{Label: label})
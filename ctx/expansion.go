@@ -0,0 +1,227 @@
+package ctx
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+)
+
+// locationFunctions maps the $(...) location functions to whether they
+// accept multiple files and how a File is rendered.
+// Source: LocationExpander.java allLocationFunctions()
+var locationFunctions = map[string]struct {
+	plural bool
+	kind   pathKind
+}{
+	"location":       {false, execPathKind},
+	"locations":      {true, execPathKind},
+	"execpath":       {false, execPathKind},
+	"execpaths":      {true, execPathKind},
+	"rootpath":       {false, rootPathKind},
+	"rootpaths":      {true, rootPathKind},
+	"rlocationpath":  {false, rlocationPathKind},
+	"rlocationpaths": {true, rlocationPathKind},
+}
+
+// pathKind selects which path of a File a location function expands to.
+// Source: LocationExpander.PathType
+type pathKind int
+
+const (
+	execPathKind      pathKind = iota // Path relative to the execution root
+	rootPathKind                      // Path relative to the runfiles root of the main repo
+	rlocationPathKind                 // Path suitable for Rlocation() in the runfiles libraries
+)
+
+// maxFilesShown limits the files listed in an ambiguous expansion error.
+// Source: LocationExpander.java getPaths()
+const maxFilesShown = 5
+
+// locationExpander expands $(location ...) and related functions.
+// Source: LocationExpander.java
+type locationExpander struct {
+	owner         *types.Label       // Label of the rule doing the expansion
+	workspaceName string             // Name of the main repository
+	labelMap      map[string][]*File // Canonical label -> files
+}
+
+// newLocationExpander creates an expander resolving labels relative to owner's package.
+// Keys of labelMap are canonicalized so that "//pkg:t", "//pkg" and ":t" style
+// keys all resolve.
+func newLocationExpander(owner *types.Label, workspaceName string, labelMap map[string][]*File) *locationExpander {
+	e := &locationExpander{
+		owner:         owner,
+		workspaceName: workspaceName,
+		labelMap:      make(map[string][]*File, len(labelMap)),
+	}
+	for k, files := range labelMap {
+		key := k
+		if l, err := e.resolve(k); err == nil {
+			key = l.String()
+		}
+		e.labelMap[key] = append(e.labelMap[key], files...)
+	}
+	return e
+}
+
+// resolve parses a label relative to the owner's package.
+func (e *locationExpander) resolve(s string) (*types.Label, error) {
+	repo, pkg := "", ""
+	if e.owner != nil {
+		repo, pkg = e.owner.Repo(), e.owner.Pkg()
+	}
+	return types.ParseLabelRelative(s, repo, pkg)
+}
+
+// expand replaces every location function in input. Other $(...) references
+// and escaped "$$" sequences are left untouched.
+// Source: LocationExpander.expand()
+func (e *locationExpander) expand(input string) (string, error) {
+	var result strings.Builder
+	for i := 0; i < len(input); {
+		start := strings.Index(input[i:], "$")
+		if start == -1 {
+			result.WriteString(input[i:])
+			break
+		}
+		start += i
+		result.WriteString(input[i:start])
+
+		// Skip escaped dollar signs so that $$(location x) is not expanded.
+		if strings.HasPrefix(input[start:], "$$") {
+			result.WriteString("$$")
+			i = start + 2
+			continue
+		}
+		if !strings.HasPrefix(input[start:], "$(") {
+			result.WriteByte('$')
+			i = start + 1
+			continue
+		}
+
+		end := strings.IndexByte(input[start:], ')')
+		if end == -1 {
+			return "", fmt.Errorf("unterminated $(%s) expression", input[start+2:])
+		}
+		end += start
+
+		expr := input[start+2 : end]
+		fn, arg, hasArg := strings.Cut(expr, " ")
+		lf, ok := locationFunctions[fn]
+		if !ok || !hasArg {
+			// Not a location function; leave it for Make variable expansion.
+			result.WriteString(input[start : end+1])
+			i = end + 1
+			continue
+		}
+
+		paths, err := e.paths(fn, strings.TrimSpace(arg), lf.plural, lf.kind)
+		if err != nil {
+			return "", err
+		}
+		result.WriteString(strings.Join(paths, " "))
+		i = end + 1
+	}
+	return result.String(), nil
+}
+
+// paths returns the rendered paths of the files of the given label.
+// Source: LocationExpander.LocationFunction.apply()
+func (e *locationExpander) paths(fn, arg string, plural bool, kind pathKind) ([]string, error) {
+	label, err := e.resolve(arg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label in $(%s) expression: %v", fn, err)
+	}
+
+	files, ok := e.labelMap[label.String()]
+	if !ok {
+		return nil, fmt.Errorf("label '%s' in $(%s) expression is not a declared prerequisite of this rule", label, fn)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("label '%s' in $(%s) expression expands to no files", label, fn)
+	}
+
+	var paths []string
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		p := e.render(f, kind)
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+
+	if !plural && len(paths) > 1 {
+		shown := paths
+		if len(shown) > maxFilesShown {
+			shown = shown[:maxFilesShown]
+		}
+		return nil, fmt.Errorf("label '%s' in $(%s) expression expands to more than one file, please use $(%ss %s) instead. Files (at most %d shown) are: [%s]",
+			label, fn, fn, arg, maxFilesShown, strings.Join(shown, ", "))
+	}
+	return paths, nil
+}
+
+// render returns the path of f for the given kind.
+// Source: LocationExpander.getPaths()
+func (e *locationExpander) render(f *File, kind pathKind) string {
+	switch kind {
+	case rootPathKind:
+		return f.ShortPath()
+	case rlocationPathKind:
+		// Files in external repositories have short paths like "../repo/pkg/file".
+		if rest, ok := strings.CutPrefix(f.ShortPath(), "../"); ok {
+			return rest
+		}
+		return path.Join(e.workspaceName, f.ShortPath())
+	default:
+		return f.Path()
+	}
+}
+
+// expandMakeVariables expands $(VAR), ${VAR}, $x and $$ in command using lookup.
+// Source: MakeVariableExpander.expand()
+func expandMakeVariables(command string, lookup func(name string) (string, bool)) (string, error) {
+	var result strings.Builder
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		if c != '$' {
+			result.WriteByte(c)
+			continue
+		}
+
+		i++
+		if i >= len(command) {
+			return "", fmt.Errorf("unterminated $")
+		}
+
+		var name string
+		switch command[i] {
+		case '$':
+			result.WriteByte('$')
+			continue
+		case '(', '{':
+			closing := byte(')')
+			if command[i] == '{' {
+				closing = '}'
+			}
+			end := strings.IndexByte(command[i+1:], closing)
+			if end == -1 {
+				return "", fmt.Errorf("unterminated variable reference")
+			}
+			name = command[i+1 : i+1+end]
+			i += end + 1
+		default:
+			name = command[i : i+1]
+		}
+
+		value, ok := lookup(name)
+		if !ok {
+			return "", fmt.Errorf("$(%s) not defined", name)
+		}
+		result.WriteString(value)
+	}
+	return result.String(), nil
+}
@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"path/filepath"
 
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
 	"github.com/albertocavalcante/starlark-go-bazel/types"
 	"go.starlark.net/starlark"
 )
@@ -39,6 +40,12 @@ type Package struct {
 	// Targets maps target names to their instances
 	Targets map[string]*types.RuleInstance
 
+	// Files maps the names of the package's file targets to them
+	Files map[string]*FileTarget
+
+	// Groups maps the names of the package's package groups to them
+	Groups map[string]*PackageGroup
+
 	// DefaultVisibility is the default visibility for targets in this package
 	DefaultVisibility []string
 
@@ -50,41 +57,71 @@ type Package struct {
 
 	// Loads lists the .bzl files loaded by this BUILD file
 	Loads []string
+
+	// labels is the context labels in the BUILD file are parsed in
+	labels types.LabelContext
 }
 
-// NewPackage creates a new Package for the given path.
+// NewPackage creates a new Package for the given path. The package name is
+// the BUILD file's directory relative to root, whether the paths are
+// absolute or relative.
 func NewPackage(root, buildFile string) *Package {
-	// Extract package name from BUILD file path
-	dir := filepath.Dir(buildFile)
-	name := dir
-	if root != "" && dir != root {
-		rel, err := filepath.Rel(root, dir)
-		if err == nil {
-			name = rel
+	name, ok := loader.NewPackageLocator(nil, root, nil, nil).PackageName(buildFile)
+	if !ok {
+		name = filepath.ToSlash(filepath.Dir(buildFile))
+		if name == "." {
+			name = ""
 		}
 	}
-	if name == "." {
-		name = ""
-	}
 
 	return &Package{
 		Name:      name,
 		Root:      root,
 		BuildFile: buildFile,
 		Targets:   make(map[string]*types.RuleInstance),
+		Files:     make(map[string]*FileTarget),
+		Groups:    make(map[string]*PackageGroup),
+		labels:    types.LabelContext{Pkg: name},
 	}
 }
 
-// AddTarget registers a target in the package.
+// AddTarget registers a target in the package along with its output files.
 // Returns an error if a target with the same name already exists.
 func (p *Package) AddTarget(name string, target *types.RuleInstance) error {
 	if _, exists := p.Targets[name]; exists {
 		return fmt.Errorf("duplicate target name %q in package %q", name, p.Name)
 	}
+	if kind, exists := p.targetKind(name); exists {
+		return fmt.Errorf("%s rule '%s' conflicts with existing %s", target.RuleClassName(), name, kind)
+	}
 	p.Targets[name] = target
+	if err := p.addOutputs(target); err != nil {
+		delete(p.Targets, name)
+		return err
+	}
 	return nil
 }
 
+// targetKind returns the kind of the target of the package named name, if
+// any.
+func (p *Package) targetKind(name string) (string, bool) {
+	if rule, ok := p.Targets[name]; ok {
+		return rule.TargetKind(), true
+	}
+	if f, ok := p.Files[name]; ok {
+		return f.TargetKind(), true
+	}
+	if g, ok := p.Groups[name]; ok {
+		return g.TargetKind(), true
+	}
+	return "", false
+}
+
+// GetFile returns a file target by name, or nil if not found.
+func (p *Package) GetFile(name string) *FileTarget {
+	return p.Files[name]
+}
+
 // GetTarget returns a target by name, or nil if not found.
 func (p *Package) GetTarget(name string) *types.RuleInstance {
 	return p.Targets[name]
@@ -202,8 +239,7 @@ func LicensesBuiltin(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tupl
 // Reference: PackageFactory.exports_files
 func ExportsFilesBuiltin(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
 	var srcs *starlark.List
-	var visibility starlark.Value = starlark.None
-	var licenses starlark.Value = starlark.None
+	var visibility, licenses *starlark.List
 
 	if len(args) > 0 {
 		if list, ok := args[0].(*starlark.List); ok {
@@ -221,9 +257,11 @@ func ExportsFilesBuiltin(thread *starlark.Thread, _ *starlark.Builtin, args star
 				srcs = list
 			}
 		case "visibility":
-			visibility = kv[1]
+			visibility, _ = kv[1].(*starlark.List)
 		case "licenses":
-			licenses = kv[1]
+			licenses, _ = kv[1].(*starlark.List)
+		default:
+			return nil, fmt.Errorf("exports_files: unexpected keyword argument %q", key)
 		}
 	}
 
@@ -231,11 +269,18 @@ func ExportsFilesBuiltin(thread *starlark.Thread, _ *starlark.Builtin, args star
 		return nil, fmt.Errorf("exports_files: missing required argument 'srcs'")
 	}
 
-	// In a full implementation, this would create file targets in the package.
-	// For now, we just validate the arguments.
-	_ = visibility
-	_ = licenses
-
+	pkg := GetPackage(thread)
+	if pkg == nil {
+		return nil, fmt.Errorf("exports_files() can only be called from BUILD files")
+	}
+	// Exported files are public unless a visibility is given.
+	vis := []string{"//visibility:public"}
+	if visibility != nil {
+		vis = stringList(visibility)
+	}
+	if err := pkg.exportFiles(stringList(srcs), vis, stringList(licenses)); err != nil {
+		return nil, fmt.Errorf("exports_files: %w", err)
+	}
 	return starlark.None, nil
 }
 
@@ -284,11 +329,33 @@ func GlobBuiltin(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tup
 		return nil, fmt.Errorf("glob: missing required argument 'include'")
 	}
 
-	// In a full implementation, this would actually glob the filesystem.
-	// For now, return an empty list (globbing is typically done during loading).
-	_ = exclude
-	_ = excludeDirectories
-	_ = allowEmpty
+	// Without a file system that can list directories there is nothing to
+	// glob.
+	g := getGlobContext(thread)
+	if g == nil {
+		return starlark.NewList(nil), nil
+	}
+	matches, err := g.glob(stringList(include), stringList(exclude), excludeDirectories, allowEmpty)
+	if err != nil {
+		return nil, fmt.Errorf("glob: %w", err)
+	}
+	values := make([]starlark.Value, len(matches))
+	for i, m := range matches {
+		values[i] = starlark.String(m)
+	}
+	return starlark.NewList(values), nil
+}
 
-	return starlark.NewList(nil), nil
+// stringList returns the strings of a list, skipping other values.
+func stringList(list *starlark.List) []string {
+	if list == nil {
+		return nil
+	}
+	var strs []string
+	for i := 0; i < list.Len(); i++ {
+		if s, ok := list.Index(i).(starlark.String); ok {
+			strs = append(strs, string(s))
+		}
+	}
+	return strs
 }
@@ -0,0 +1,70 @@
+package eval
+
+import (
+	"strings"
+	"testing"
+
+	"go.starlark.net/starlark"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+)
+
+func TestRuleCallsRegisterTargets(t *testing.T) {
+	fs := loader.NewMemoryFileSystem()
+	fs.AddFile("lib/defs.bzl", []byte(`my_rule = rule(implementation = lambda ctx: [])`))
+	fs.AddFile("app/BUILD", []byte(`load("//lib:defs.bzl", "my_rule")
+
+my_rule(name = "a")
+my_rule(name = "b")`))
+	e := New(Options{
+		BzlLoader:  loader.NewBzlFileLoader(fs, "", loader.WithPredeclared(BzlPredeclared())),
+		FileLoader: loader.NewFileSystemLoader(fs),
+	})
+
+	result, err := e.EvalBuildFile("app/BUILD")
+	if err != nil {
+		t.Fatalf("EvalBuildFile failed: %v", err)
+	}
+	if len(result.Targets) != 2 {
+		t.Fatalf("got %d targets, want 2", len(result.Targets))
+	}
+	a := result.Targets["a"]
+	if a == nil {
+		t.Fatal("target a not registered")
+	}
+	if got := a.RuleClassName(); got != "my_rule" {
+		t.Errorf("rule class name = %q, want the exported name my_rule", got)
+	}
+	if got := a.Label(); got == nil || got.String() != "//app:a" {
+		t.Errorf("label = %v, want //app:a", got)
+	}
+	if got := a.Location(); got != "app/BUILD:3:8" {
+		t.Errorf("location = %q, want app/BUILD:3:8", got)
+	}
+
+	fs.AddFile("dup/BUILD", []byte(`load("//lib:defs.bzl", "my_rule")
+
+my_rule(name = "a")
+my_rule(name = "a")`))
+	if _, err := e.EvalBuildFile("dup/BUILD"); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("duplicate target: got error %v", err)
+	}
+}
+
+func TestRuleCallsOutsideBuildFiles(t *testing.T) {
+	// Without a registrar, rule calls return an instance that belongs to no
+	// package.
+	rc := types.NewRuleClass("", nil, nil)
+	if err := rc.Export("my_rule"); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	thread := &starlark.Thread{Name: "test"}
+	v, err := starlark.Call(thread, rc, nil, []starlark.Tuple{{starlark.String("name"), starlark.String("a")}})
+	if err != nil {
+		t.Fatalf("calling rule failed: %v", err)
+	}
+	if l := v.(*types.RuleInstance).Label(); l != nil {
+		t.Errorf("label = %v, want none", l)
+	}
+}
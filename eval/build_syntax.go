@@ -0,0 +1,61 @@
+package eval
+
+import (
+	"strings"
+
+	"go.starlark.net/syntax"
+)
+
+// BuildSyntaxError reports the constructs that Bazel does not allow in BUILD
+// files, in source order.
+type BuildSyntaxError []syntax.Error
+
+func (e BuildSyntaxError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// CheckBuildSyntax checks that a parsed BUILD file only uses the BUILD
+// dialect of Starlark: no def, for or if statements, and no *args or
+// **kwargs arguments at call sites. It returns a BuildSyntaxError listing
+// every violation, or nil.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/PackageFactory.java checkBuildSyntax()
+func CheckBuildSyntax(f *syntax.File) error {
+	var errs BuildSyntaxError
+	report := func(pos syntax.Position, msg string) {
+		errs = append(errs, syntax.Error{Pos: pos, Msg: msg})
+	}
+	syntax.Walk(f, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.DefStmt:
+			report(n.Def, "functions may not be defined in BUILD files. You may move the function to a .bzl file and load it.")
+			return false
+		case *syntax.ForStmt:
+			report(n.For, "for statements are not allowed in BUILD files. You may inline the loop, move it to a function definition (in a .bzl file), or as a last resort use a list comprehension.")
+			return false
+		case *syntax.IfStmt:
+			report(n.If, "if statements are not allowed in BUILD files. You may move conditional logic to a function definition (in a .bzl file), or for simple cases use an if expression.")
+			return false
+		case *syntax.CallExpr:
+			for _, arg := range n.Args {
+				if u, ok := arg.(*syntax.UnaryExpr); ok {
+					switch u.Op {
+					case syntax.STAR:
+						report(u.OpPos, "*args arguments are not allowed in BUILD files. Pass the arguments in explicitly.")
+					case syntax.STARSTAR:
+						report(u.OpPos, "**kwargs arguments are not allowed in BUILD files. Pass the arguments in explicitly.")
+					}
+				}
+			}
+		}
+		return true
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
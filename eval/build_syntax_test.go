@@ -0,0 +1,36 @@
+package eval
+
+import "testing"
+
+func TestBuildSyntax(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		errMsg string
+	}{
+		{"comprehension", `SRCS = [s + ".go" for s in ["a", "b"]]
+NAME = "x" if SRCS else "y"`, ""},
+		{"def", `def f():
+    pass`, "functions may not be defined in BUILD files"},
+		{"for", `for x in []:
+    pass`, "for statements are not allowed in BUILD files"},
+		{"if", `if True:
+    pass`, "if statements are not allowed in BUILD files"},
+		{"args", `ARGS = ["a"]
+L = len(*ARGS)`, "BUILD:2:9: *args arguments are not allowed in BUILD files"},
+		{"kwargs", `KW = {}
+D = dict(**KW)`, "**kwargs arguments are not allowed in BUILD files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(Options{}).EvalBuild("BUILD", []byte(tt.source))
+			checkError(t, err, tt.errMsg)
+		})
+	}
+
+	// The check can be disabled to evaluate BUILD files with full Starlark.
+	_, err := New(Options{DisableBuildSyntaxCheck: true}).EvalBuild("BUILD", []byte(`def f(**kw):
+    return dict(**kw)
+D = f(a = 1)`))
+	checkError(t, err, "")
+}
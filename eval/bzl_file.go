@@ -12,7 +12,10 @@
 package eval
 
 import (
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
 	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
 )
 
 // BzlModuleContext holds context information about a .bzl module.
@@ -34,6 +37,17 @@ type BzlModuleContext struct {
 	TransitiveDigest []byte
 }
 
+// NewBzlModuleContext returns the context of a module from its
+// initialization context and the repo mapping of its repository.
+func NewBzlModuleContext(ctx *BzlInitThreadContext, mapping *types.RepoMapping) *BzlModuleContext {
+	return &BzlModuleContext{
+		Label:            ctx.Label,
+		RepoMapping:      mapping.Entries(),
+		Loads:            append([]string(nil), ctx.Loads...),
+		TransitiveDigest: ctx.TransitiveDigest,
+	}
+}
+
 // ExportableValue is an interface for values that need to be "exported"
 // when assigned to a top-level variable in a .bzl file.
 //
@@ -49,55 +63,39 @@ type ExportableValue interface {
 	Export(name string) error
 }
 
-// BzlVisibility defines who can load a .bzl file.
+// BzlVisibility defines who can load a .bzl file. It is defined by the
+// loader, which enforces it.
 //
 // Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/BzlVisibility.java
-type BzlVisibility int
+type BzlVisibility = loader.BzlVisibility
 
 const (
 	// BzlVisibilityPublic means the .bzl can be loaded by any package.
-	BzlVisibilityPublic BzlVisibility = iota
+	BzlVisibilityPublic = loader.BzlVisibilityPublic
 
 	// BzlVisibilityPrivate means the .bzl can only be loaded by the same package.
-	BzlVisibilityPrivate
+	BzlVisibilityPrivate = loader.BzlVisibilityPrivate
 
 	// BzlVisibilityPackage means the .bzl can be loaded by specific packages.
-	BzlVisibilityPackage
+	BzlVisibilityPackage = loader.BzlVisibilityPackage
 )
 
 // BzlInitThreadContext holds thread-local state during .bzl execution.
-// This is updated by builtins like visibility() and is read after execution
-// to determine the module's visibility.
 //
 // Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/BzlInitThreadContext.java
-type BzlInitThreadContext struct {
-	// Label of the .bzl file being evaluated
-	Label string
-
-	// TransitiveDigest accumulated from this file and its loads
-	TransitiveDigest []byte
-
-	// BzlVisibility set by visibility() builtin
-	Visibility BzlVisibility
-
-	// VisibilityPackages set by visibility() when using package list
-	VisibilityPackages []string
-}
+type BzlInitThreadContext = loader.BzlInitThreadContext
 
 // ThreadKeyBzlContext is the key for storing BzlInitThreadContext in the thread.
-const ThreadKeyBzlContext = "starlark-go-bazel:bzl_context"
+const ThreadKeyBzlContext = loader.ThreadKeyBzlContext
 
 // SetBzlContext stores the BzlInitThreadContext in the thread.
 func SetBzlContext(thread *starlark.Thread, ctx *BzlInitThreadContext) {
-	thread.SetLocal(ThreadKeyBzlContext, ctx)
+	loader.SetBzlContext(thread, ctx)
 }
 
 // GetBzlContext retrieves the BzlInitThreadContext from the thread.
 func GetBzlContext(thread *starlark.Thread) *BzlInitThreadContext {
-	if ctx := thread.Local(ThreadKeyBzlContext); ctx != nil {
-		return ctx.(*BzlInitThreadContext)
-	}
-	return nil
+	return loader.GetBzlContext(thread)
 }
 
 // FilterExports returns only the exported values from a globals dict.
@@ -123,8 +121,22 @@ func FilterExports(globals starlark.StringDict) starlark.StringDict {
 //
 // Reference: BzlLoadFunction uses getLoadsFromProgram() to extract loads
 func ExtractLoads(source []byte) (map[string][]string, error) {
-	// This would typically parse the source to extract load() statements.
-	// For now, we return an empty map as the actual parsing would be
-	// done by the Starlark parser/compiler.
-	return make(map[string][]string), nil
+	f, err := syntax.LegacyFileOptions().Parse("", source, 0)
+	if err != nil {
+		return nil, err
+	}
+	loads := make(map[string][]string)
+	for _, stmt := range f.Stmts {
+		load, ok := stmt.(*syntax.LoadStmt)
+		if !ok {
+			continue
+		}
+		module := load.ModuleName()
+		symbols := loads[module]
+		for _, from := range load.From {
+			symbols = append(symbols, from.Name)
+		}
+		loads[module] = symbols
+	}
+	return loads, nil
 }
@@ -0,0 +1,26 @@
+package eval
+
+import "testing"
+
+func TestSclFiles(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"config/consts.scl": `GO_VERSION = "1.24"`,
+		"config/defs.bzl":   `X = 1`,
+	})
+	tests := []struct {
+		name   string
+		source string
+		errMsg string
+	}{
+		{"core environment", `load("//config:consts.scl", "GO_VERSION")
+V = struct(go = GO_VERSION)`, ""},
+		{"bzl symbol", `a = attr.string()`, "attr is not available in .scl files"},
+		{"load of bzl", `load("//config:defs.bzl", "X")`, ".scl files may only load other .scl files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestEvaluator(fs, Options{}).EvalBzl("/ws/test.scl", []byte(tt.source))
+			checkError(t, err, tt.errMsg)
+		})
+	}
+}
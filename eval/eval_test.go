@@ -0,0 +1,48 @@
+package eval
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+)
+
+// newTestEvaluator creates an evaluator of the workspace /ws in fs, loading
+// .bzl files through a BzlFileLoader.
+func newTestEvaluator(fs loader.FileSystem, opts Options) *Evaluator {
+	opts.BzlLoader = loader.NewBzlFileLoader(fs, "/ws", loader.WithPredeclared(BzlPredeclared()))
+	opts.FileLoader = loader.NewFileSystemLoader(fs)
+	opts.WorkspaceRoot = "/ws"
+	return New(opts)
+}
+
+// newTestWorkspace returns a file system holding files, keyed by path
+// relative to the workspace root /ws.
+func newTestWorkspace(files map[string]string) *loader.MemoryFileSystem {
+	fs := loader.NewMemoryFileSystem()
+	for name, content := range files {
+		fs.AddFile("/ws/"+name, []byte(content))
+	}
+	return fs
+}
+
+// ruleDef returns a .bzl statement defining a rule with an empty
+// implementation and the given attributes, such as
+// `"srcs": attr.label_list()`.
+func ruleDef(name string, attrs ...string) string {
+	return fmt.Sprintf("%s = rule(\n    implementation = lambda ctx: [],\n    attrs = {%s},\n)\n", name, strings.Join(attrs, ", "))
+}
+
+// checkError reports a failure if err does not contain errMsg, or if err is
+// not nil when errMsg is empty.
+func checkError(t *testing.T, err error, errMsg string) {
+	t.Helper()
+	if errMsg == "" {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	} else if err == nil || !strings.Contains(err.Error(), errMsg) {
+		t.Errorf("expected error containing %q, got %v", errMsg, err)
+	}
+}
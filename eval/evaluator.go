@@ -11,10 +11,12 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/albertocavalcante/starlark-go-bazel/builtins"
 	"github.com/albertocavalcante/starlark-go-bazel/loader"
 	"github.com/albertocavalcante/starlark-go-bazel/types"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
 )
 
 // Evaluator evaluates Starlark files (BUILD and .bzl).
@@ -24,7 +26,13 @@ type Evaluator struct {
 	predeclaredBzl   starlark.StringDict
 	predeclaredBuild starlark.StringDict
 	printHandler     func(msg string)
+	checkBuild       bool
 	cache            map[string]*CachedModule
+	fsys             loader.FileSystem
+	packages         map[string]*packageEntry
+	packageCache     *PackageCache
+	programs         *loader.ProgramCache
+	locator          *loader.PackageLocator
 }
 
 // CachedModule holds a cached module evaluation result.
@@ -40,6 +48,27 @@ type Options struct {
 	PredeclaredBzl   starlark.StringDict
 	PredeclaredBuild starlark.StringDict
 	PrintHandler     func(msg string)
+
+	// DisableBuildSyntaxCheck evaluates BUILD files with full Starlark
+	// instead of rejecting the constructs Bazel forbids in them.
+	DisableBuildSyntaxCheck bool
+
+	// PackageCacheDir, if set, is the directory of a PackageCache used by
+	// EvalBuildFile to skip evaluating unchanged packages across processes.
+	PackageCacheDir string
+
+	// WorkspaceRoot is the directory of the main repository. Package names
+	// are the paths of BUILD file directories relative to it.
+	WorkspaceRoot string
+
+	// PackageLocator, if set, names packages instead of WorkspaceRoot,
+	// following its package path and deleted packages.
+	PackageLocator *loader.PackageLocator
+
+	// ProgramCache caches the compiled programs of .bzl files, such as
+	// loader.SharedProgramCache("") to share them with the other evaluators
+	// of the process. By default each evaluator has its own.
+	ProgramCache *loader.ProgramCache
 }
 
 // New creates a new Evaluator.
@@ -54,35 +83,70 @@ func New(opts Options) *Evaluator {
 		predeclaredBuild[k] = v
 	}
 
+	var fsys loader.FileSystem
+	if l, ok := opts.FileLoader.(interface{ FileSystem() loader.FileSystem }); ok {
+		fsys = l.FileSystem()
+	}
+
+	var packageCache *PackageCache
+	if opts.PackageCacheDir != "" {
+		packageCache = NewPackageCache(opts.PackageCacheDir)
+	}
+
+	programs := opts.ProgramCache
+	if programs == nil {
+		programs = loader.NewProgramCache("")
+	}
+
+	locator := opts.PackageLocator
+	if locator == nil && opts.WorkspaceRoot != "" {
+		locator = loader.NewPackageLocator(fsys, opts.WorkspaceRoot, nil, nil)
+	}
+
 	return &Evaluator{
 		bzlLoader:        opts.BzlLoader,
 		fileLoader:       opts.FileLoader,
 		predeclaredBzl:   predeclaredBzl,
 		predeclaredBuild: predeclaredBuild,
 		printHandler:     opts.PrintHandler,
+		checkBuild:       !opts.DisableBuildSyntaxCheck,
 		cache:            make(map[string]*CachedModule),
+		fsys:             fsys,
+		packages:         make(map[string]*packageEntry),
+		packageCache:     packageCache,
+		programs:         programs,
+		locator:          locator,
 	}
 }
 
 // BzlResult contains the result of evaluating a .bzl file.
 type BzlResult struct {
 	Globals starlark.StringDict
+
+	// Module describes the evaluated file: its label, direct loads, repo
+	// mapping and transitive digest.
+	Module *BzlModuleContext
 }
 
 // BuildResult contains the result of evaluating a BUILD file.
 type BuildResult struct {
 	Targets map[string]*types.RuleInstance
+	Files   map[string]*FileTarget
+	Groups  map[string]*PackageGroup
 	Globals starlark.StringDict
 	Package string
+
+	// DefaultVisibility is the default_visibility given to package(), if
+	// any.
+	DefaultVisibility []string
+
+	// Deps lists the files, directories and modules the evaluation read.
+	Deps *PackageDeps
 }
 
 // EvalBzl evaluates a .bzl file and returns its exports.
 func (e *Evaluator) EvalBzl(path string, source []byte) (*BzlResult, error) {
-	dir := filepath.Dir(path)
-	pkg := strings.TrimPrefix(dir, "/")
-	if pkg == "." {
-		pkg = ""
-	}
+	pkg := e.packageName(path)
 
 	thread := &starlark.Thread{
 		Name:  path,
@@ -96,23 +160,96 @@ func (e *Evaluator) EvalBzl(path string, source []byte) (*BzlResult, error) {
 		thread.Load = e.makeLoadFunc()
 	}
 	loader.SetCurrentPackage(thread, pkg)
+	repoMapping := e.mainRepoMapping()
+	types.SetLabelContext(thread, &types.LabelContext{Pkg: pkg, RepoMapping: repoMapping})
+	bzlContext := loader.NewBzlInitThreadContext("//"+pkg+":"+filepath.Base(path), "", pkg)
+	SetBzlContext(thread, bzlContext)
 
-	globals, err := starlark.ExecFile(thread, path, source, e.predeclaredBzl)
+	globals, err := e.programs.ExecFile(thread, path, source, e.predeclaredBzl)
 	if err != nil {
 		return nil, fmt.Errorf("evaluating %s: %w", path, err)
 	}
+	bzlContext.SetTransitiveDigest(source)
 
-	return &BzlResult{Globals: globals}, nil
+	return &BzlResult{Globals: globals, Module: NewBzlModuleContext(bzlContext, repoMapping)}, nil
+}
+
+// Module returns the context of a module loaded through the BzlLoader,
+// keyed by canonical label.
+func (e *Evaluator) Module(label string) (*BzlModuleContext, bool) {
+	l, ok := e.bzlLoader.(interface {
+		InitContext(label string) (*loader.BzlInitThreadContext, bool)
+		RepoMapping(repo string) *types.RepoMapping
+	})
+	if !ok {
+		return nil, false
+	}
+	ctx, ok := l.InitContext(label)
+	if !ok {
+		return nil, false
+	}
+	return NewBzlModuleContext(ctx, l.RepoMapping(ctx.Repo())), true
 }
 
 // EvalBuild evaluates a BUILD file and returns its targets.
 func (e *Evaluator) EvalBuild(path string, source []byte) (*BuildResult, error) {
-	dir := filepath.Dir(path)
-	pkg := strings.TrimPrefix(dir, "/")
-	if pkg == "." {
-		pkg = ""
+	result, _, err := e.evalBuild(path, source)
+	return result, err
+}
+
+// evalBuild evaluates a BUILD file and also returns its deps on failure.
+func (e *Evaluator) evalBuild(path string, source []byte) (*BuildResult, *PackageDeps, error) {
+	pkg := e.packageName(path)
+	thread := e.newBuildThread(path, pkg)
+
+	p := &Package{
+		Name:      pkg,
+		BuildFile: path,
+		Targets:   make(map[string]*types.RuleInstance),
+		Files:     make(map[string]*FileTarget),
+		Groups:    make(map[string]*PackageGroup),
+		labels:    types.LabelContext{Pkg: pkg, RepoMapping: e.mainRepoMapping()},
+	}
+	if e.locator != nil {
+		p.Root, _ = e.locator.PackageRoot(pkg)
+	}
+	SetPackage(thread, p)
+	types.SetTargetRegistrar(thread, func(target *types.RuleInstance) error {
+		return RegisterTarget(thread, target)
+	})
+
+	deps := &PackageDeps{Files: []string{e.absPath(path)}}
+	if e.locator != nil && e.locator.IsDeleted(pkg) {
+		// Reference: PackageLookupFunction.java deletedPackages check
+		return nil, deps, fmt.Errorf("no such package '%s': Package is considered deleted due to --deleted_packages", pkg)
+	}
+	loader.SetLoadObserver(thread, deps.addLoad)
+	if fsys, ok := e.fsys.(loader.DirFileSystem); ok {
+		thread.SetLocal(threadKeyGlob, &globContext{fsys: fsys, dir: filepath.Dir(path), pkg: pkg, locator: e.locator, deps: deps})
 	}
 
+	globals, err := e.execBuild(thread, path, source)
+	if err != nil {
+		return nil, deps, fmt.Errorf("evaluating %s: %w", path, err)
+	}
+
+	p.finish()
+
+	return &BuildResult{
+		Targets: p.Targets,
+		Files:   p.Files,
+		Groups:  p.Groups,
+		Globals: globals,
+		Package: pkg,
+
+		DefaultVisibility: p.DefaultVisibility,
+		Deps:              deps,
+	}, deps, nil
+}
+
+// newBuildThread creates the thread evaluating the BUILD file of package
+// pkg, which loads modules relative to the package.
+func (e *Evaluator) newBuildThread(path, pkg string) *starlark.Thread {
 	thread := &starlark.Thread{
 		Name:  path,
 		Print: e.makePrintHandler(),
@@ -125,20 +262,29 @@ func (e *Evaluator) EvalBuild(path string, source []byte) (*BuildResult, error)
 		thread.Load = e.makeLoadFunc()
 	}
 	loader.SetCurrentPackage(thread, pkg)
+	types.SetLabelContext(thread, &types.LabelContext{Pkg: pkg, RepoMapping: e.mainRepoMapping()})
+	return thread
+}
 
-	targets := make(map[string]*types.RuleInstance)
-	thread.SetLocal("targets", targets)
-
-	globals, err := starlark.ExecFile(thread, path, source, e.predeclaredBuild)
+// execBuild parses a BUILD file, checks its syntax unless disabled, and
+// executes it.
+func (e *Evaluator) execBuild(thread *starlark.Thread, path string, source []byte) (starlark.StringDict, error) {
+	f, err := syntax.LegacyFileOptions().Parse(path, source, 0)
 	if err != nil {
-		return nil, fmt.Errorf("evaluating %s: %w", path, err)
+		return nil, err
 	}
-
-	return &BuildResult{
-		Targets: targets,
-		Globals: globals,
-		Package: pkg,
-	}, nil
+	if e.checkBuild {
+		if err := CheckBuildSyntax(f); err != nil {
+			return nil, err
+		}
+	}
+	prog, err := starlark.FileProgram(f, e.predeclaredBuild.Has)
+	if err != nil {
+		return nil, err
+	}
+	globals, err := prog.Init(thread, e.predeclaredBuild)
+	globals.Freeze()
+	return globals, err
 }
 
 // EvalBzlFile loads and evaluates a .bzl file from the filesystem.
@@ -153,16 +299,66 @@ func (e *Evaluator) EvalBzlFile(path string) (*BzlResult, error) {
 	return e.EvalBzl(path, source)
 }
 
-// EvalBuildFile loads and evaluates a BUILD file from the filesystem.
+// EvalBuildFile loads and evaluates a BUILD file from the filesystem. The
+// result, or error, is cached and reused as long as the BUILD file, the
+// directories it globbed and the modules it loaded are unchanged. Modules
+// stay cached by the BzlLoader until Invalidate evicts them. With a package
+// cache, successful results are also stored on disk, and unchanged packages
+// are read from there instead of being evaluated.
 func (e *Evaluator) EvalBuildFile(path string) (*BuildResult, error) {
 	if e.fileLoader == nil {
 		return nil, fmt.Errorf("no file loader configured")
 	}
 	source, err := e.fileLoader.Load(path)
 	if err != nil {
+		delete(e.packages, path)
 		return nil, fmt.Errorf("loading %s: %w", path, err)
 	}
-	return e.EvalBuild(path, source)
+	if entry, ok := e.packages[path]; ok && e.current(entry, source) {
+		return entry.result, entry.err
+	}
+	var key string
+	if e.packageCache != nil {
+		if key = e.packageKey(path, source); key != "" {
+			if result, ok := e.cachedPackage(key); ok {
+				e.packages[path] = e.newPackageEntry(source, result, nil, result.Deps)
+				return result, nil
+			}
+		}
+	}
+	result, deps, err := e.evalBuild(path, source)
+	e.packages[path] = e.newPackageEntry(source, result, err, deps)
+	if err == nil && key != "" {
+		// Packages with values that cannot be serialized are not cached.
+		_ = e.packageCache.Put(key, result)
+	}
+	return result, err
+}
+
+// packageName returns the package of a file from its path, relative to the
+// package path entry containing it if any.
+func (e *Evaluator) packageName(path string) string {
+	if e.locator != nil {
+		if pkg, ok := e.locator.PackageName(path); ok {
+			return pkg
+		}
+	}
+	pkg := strings.TrimPrefix(filepath.ToSlash(filepath.Dir(path)), "/")
+	if pkg == "." {
+		pkg = ""
+	}
+	return pkg
+}
+
+// mainRepoMapping returns the main repository's repo mapping if the
+// BzlLoader has one.
+func (e *Evaluator) mainRepoMapping() *types.RepoMapping {
+	if m, ok := e.bzlLoader.(interface {
+		RepoMapping(repo string) *types.RepoMapping
+	}); ok {
+		return m.RepoMapping("")
+	}
+	return nil
 }
 
 func (e *Evaluator) makePrintHandler() func(*starlark.Thread, string) {
@@ -175,6 +371,9 @@ func (e *Evaluator) makePrintHandler() func(*starlark.Thread, string) {
 
 func (e *Evaluator) makeLoadFunc() func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
 	return func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+		if err := loader.CheckSclLoad(thread.Name, module); err != nil {
+			return nil, err
+		}
 		if cached, ok := e.cache[module]; ok {
 			return cached.Globals, cached.Err
 		}
@@ -195,13 +394,19 @@ func (e *Evaluator) makeLoadFunc() func(thread *starlark.Thread, module string)
 			Print: thread.Print,
 		}
 
-		globals, err := starlark.ExecFile(newThread, module, source, e.predeclaredBzl)
+		globals, err := e.programs.ExecFile(newThread, module, source, e.predeclaredBzl)
 		e.cache[module] = &CachedModule{Globals: globals, Err: err}
 
 		return globals, err
 	}
 }
 
+// BzlPredeclared returns the predeclared environment of .bzl files, for use
+// with loader.WithPredeclared.
+func BzlPredeclared() starlark.StringDict {
+	return makeBzlPredeclared()
+}
+
 func makeBzlPredeclared() starlark.StringDict {
 	return starlark.StringDict{
 		"Label":    starlark.NewBuiltin("Label", types.LabelBuiltin),
@@ -210,9 +415,13 @@ func makeBzlPredeclared() starlark.StringDict {
 		"depset":   starlark.NewBuiltin("depset", types.DepsetBuiltin),
 		"rule":     starlark.NewBuiltin("rule", types.RuleBuiltin),
 		"attr":     newAttrModule(),
-		"True":     starlark.True,
-		"False":    starlark.False,
-		"None":     starlark.None,
+
+		"module_extension": starlark.NewBuiltin("module_extension", builtins.ModuleExtensionBuiltin),
+		"tag_class":        starlark.NewBuiltin("tag_class", builtins.TagClassBuiltin),
+		"visibility":       starlark.NewBuiltin("visibility", loader.VisibilityBuiltin),
+		"True":             starlark.True,
+		"False":            starlark.False,
+		"None":             starlark.None,
 	}
 }
 
@@ -224,7 +433,9 @@ func makeBuildPredeclared() starlark.StringDict {
 		"package":       starlark.NewBuiltin("package", PackageBuiltin),
 		"licenses":      starlark.NewBuiltin("licenses", LicensesBuiltin),
 		"exports_files": starlark.NewBuiltin("exports_files", ExportsFilesBuiltin),
+		"package_group": starlark.NewBuiltin("package_group", PackageGroupBuiltin),
 		"glob":          starlark.NewBuiltin("glob", GlobBuiltin),
+		"select":        starlark.NewBuiltin("select", builtins.Select),
 		"True":          starlark.True,
 		"False":         starlark.False,
 		"None":          starlark.None,
@@ -359,3 +570,14 @@ func (a *attrDescriptorValue) Hash() (uint32, error) {
 	return 0, fmt.Errorf("unhashable type: Attribute")
 }
 func (a *attrDescriptorValue) Descriptor() *types.AttrDescriptor { return a.desc }
+
+// AttrType, DefaultValue and IsMandatory implement builtins.AttrSchema so
+// these attributes can be used in tag_class().
+func (a *attrDescriptorValue) AttrType() string { return string(a.desc.Type) }
+func (a *attrDescriptorValue) DefaultValue() starlark.Value {
+	if a.desc.Default == nil {
+		return starlark.None
+	}
+	return a.desc.Default
+}
+func (a *attrDescriptorValue) IsMandatory() bool { return a.desc.Mandatory }
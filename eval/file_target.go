@@ -0,0 +1,287 @@
+package eval
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/builtins"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// FileKind tells input files from output files.
+type FileKind int
+
+const (
+	// InputFile is a source file of the package, declared by exports_files
+	// or created implicitly for a label of a rule in the package.
+	//
+	// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/InputFile.java
+	InputFile FileKind = iota
+
+	// OutputFile is a file generated by a rule of the package, declared by
+	// an attr.output or attr.output_list attribute or an implicit output.
+	//
+	// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/OutputFile.java
+	OutputFile
+)
+
+// String returns the target kind, as printed by `bazel query --output
+// label_kind`.
+func (k FileKind) String() string {
+	if k == OutputFile {
+		return "generated file"
+	}
+	return "source file"
+}
+
+// FileTarget is a file target of a package.
+type FileTarget struct {
+	// Label is the label of the file.
+	Label *types.Label
+
+	// Kind tells input files from output files.
+	Kind FileKind
+
+	// Exported is set for input files declared by exports_files.
+	Exported bool
+
+	// Visibility and Licenses are those given to exports_files. Visibility
+	// is nil for files created implicitly, which have the package's default
+	// visibility.
+	Visibility []string
+	Licenses   []string
+
+	// Generator is the name of the rule generating an output file.
+	Generator string
+}
+
+// Name returns the name of the file in its package.
+func (f *FileTarget) Name() string { return f.Label.Name() }
+
+// TargetKind returns "source file" or "generated file".
+func (f *FileTarget) TargetKind() string { return f.Kind.String() }
+
+// Artifact returns the file as seen by rule implementations: a source file,
+// or an output under bazel-out/bin owned by its generating rule.
+func (f *FileTarget) Artifact() *types.File {
+	if f.Kind == InputFile {
+		return types.NewSourceFile(f.Label.Pkg(), f.Label.Name())
+	}
+	owner := types.NewLabel(f.Label.Repo(), f.Label.Pkg(), f.Generator)
+	return types.NewDerivedFile("bazel-out/bin", path.Join(f.Label.Pkg(), f.Label.Name()), owner)
+}
+
+// fileLabel parses s as the label of a file of the package.
+func (p *Package) fileLabel(s string) (*types.Label, error) {
+	l, err := types.ParseLabelInContext(s, p.labels)
+	if err != nil {
+		return nil, err
+	}
+	if l.Repo() != p.labels.Repo || l.Pkg() != p.Name {
+		return nil, fmt.Errorf("label '%s' is not in the current package", s)
+	}
+	if l.Name() == "" || strings.HasPrefix(l.Name(), "/") || strings.Contains("/"+l.Name()+"/", "/../") {
+		return nil, fmt.Errorf("invalid target name '%s'", l.Name())
+	}
+	return l, nil
+}
+
+// exportFiles declares the input files of exports_files. Files that are
+// already input files get the new visibility and licenses.
+//
+// Reference: Package.Builder.createOrUpdateInputFile
+func (p *Package) exportFiles(srcs, visibility, licenses []string) error {
+	for _, src := range srcs {
+		l, err := p.fileLabel(src)
+		if err != nil {
+			return err
+		}
+		if f, ok := p.Files[l.Name()]; !ok || f.Kind != InputFile {
+			if kind, exists := p.targetKind(l.Name()); exists {
+				return fmt.Errorf("generated label '%s' conflicts with existing %s", l, kind)
+			}
+		}
+		p.Files[l.Name()] = &FileTarget{
+			Label:      l,
+			Kind:       InputFile,
+			Exported:   true,
+			Visibility: visibility,
+			Licenses:   licenses,
+		}
+	}
+	return nil
+}
+
+// addOutputs declares the output files of a rule: the labels of its output
+// attributes and its implicit outputs.
+//
+// Reference: Rule.populateOutputFiles
+func (p *Package) addOutputs(rule *types.RuleInstance) error {
+	var names []string
+	rc := rule.RuleClass()
+	for attrName, value := range rule.AttrValues() {
+		attr, ok := rc.GetAttr(attrName)
+		if !ok || (attr.Type != types.AttrTypeOutput && attr.Type != types.AttrTypeOutputList) {
+			continue
+		}
+		for _, s := range labelStrings(value) {
+			l, err := p.fileLabel(s)
+			if err != nil {
+				return fmt.Errorf("%s: attribute '%s': %w", rule.Name(), attrName, err)
+			}
+			names = append(names, l.Name())
+		}
+	}
+	implicit, err := implicitOutputs(rule)
+	if err != nil {
+		return err
+	}
+	names = append(names, implicit...)
+
+	for _, name := range names {
+		if name == rule.Name() {
+			return fmt.Errorf("rule '%s' has an output file with the same name as the rule", rule.Name())
+		}
+		if kind, exists := p.targetKind(name); exists {
+			return fmt.Errorf("generated file '%s' in rule '%s' conflicts with existing %s", name, rule.Name(), kind)
+		}
+		p.Files[name] = &FileTarget{
+			Label:     types.NewLabel(p.labels.Repo, p.Name, name),
+			Kind:      OutputFile,
+			Generator: rule.Name(),
+		}
+	}
+	return nil
+}
+
+// implicitOutputs expands the output templates of the deprecated outputs
+// parameter of rule(). Templates substitute %{name} and the rule's string
+// attributes. Computed implicit outputs, given as a function, are not
+// supported and ignored.
+//
+// Reference: ImplicitOutputsFunction.fromTemplates
+func implicitOutputs(rule *types.RuleInstance) ([]string, error) {
+	dict, ok := rule.RuleClass().ImplicitOutputs().(*starlark.Dict)
+	if !ok {
+		return nil, nil
+	}
+	var names []string
+	for _, item := range dict.Items() {
+		tmpl, ok := item[1].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("%s: implicit output %s must be a string template", rule.Name(), item[0])
+		}
+		name, err := expandOutputTemplate(rule, string(tmpl))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// expandOutputTemplate substitutes the %{attr} placeholders of an implicit
+// output template with the rule's name and string attribute values, or
+// their defaults.
+func expandOutputTemplate(rule *types.RuleInstance, tmpl string) (string, error) {
+	var b strings.Builder
+	rest := tmpl
+	for {
+		start := strings.Index(rest, "%{")
+		if start < 0 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("%s: unterminated placeholder in implicit output '%s'", rule.Name(), tmpl)
+		}
+		b.WriteString(rest[:start])
+		attr := rest[start+2 : start+end]
+		v, ok := rule.GetAttrValue(attr)
+		if !ok {
+			if desc, found := rule.RuleClass().GetAttr(attr); found && desc.Type == types.AttrTypeString {
+				v, ok = desc.Default, true
+				if v == nil || v == starlark.None {
+					v = starlark.String("")
+				}
+			}
+		}
+		switch s, isString := v.(starlark.String); {
+		case attr == "name":
+			b.WriteString(rule.Name())
+		case ok && isString:
+			b.WriteString(string(s))
+		default:
+			return "", fmt.Errorf("%s: implicit output '%s' refers to attribute '%s', which is not a string", rule.Name(), tmpl, attr)
+		}
+		rest = rest[start+end+1:]
+	}
+}
+
+// finish creates the input files of the labels of rule attributes that
+// refer to the package and name no target. The visibility attribute holds
+// package specifications rather than dependencies and is skipped.
+//
+// Reference: Package.Builder.beforeBuild
+func (p *Package) finish() {
+	for _, rule := range p.Targets {
+		rc := rule.RuleClass()
+		for attrName, value := range rule.AttrValues() {
+			attr, ok := rc.GetAttr(attrName)
+			if !ok || attrName == "visibility" || (attr.Type != types.AttrTypeLabel && attr.Type != types.AttrTypeLabelList) {
+				continue
+			}
+			for _, s := range labelStrings(value) {
+				l, err := p.fileLabel(s)
+				if err != nil {
+					continue
+				}
+				if _, exists := p.targetKind(l.Name()); exists {
+					continue
+				}
+				p.Files[l.Name()] = &FileTarget{Label: l, Kind: InputFile}
+			}
+		}
+	}
+}
+
+// labelStrings returns the labels in an attribute value, including those of
+// every branch of a select().
+func labelStrings(value starlark.Value) []string {
+	switch v := value.(type) {
+	case starlark.String:
+		return []string{string(v)}
+	case *types.Label:
+		return []string{v.String()}
+	case *starlark.List:
+		var labels []string
+		for i := 0; i < v.Len(); i++ {
+			labels = append(labels, labelStrings(v.Index(i))...)
+		}
+		return labels
+	case starlark.Tuple:
+		var labels []string
+		for _, x := range v {
+			labels = append(labels, labelStrings(x)...)
+		}
+		return labels
+	case *builtins.SelectorList:
+		var labels []string
+		for _, x := range v.Elements() {
+			labels = append(labels, labelStrings(x)...)
+		}
+		return labels
+	case *builtins.SelectorValue:
+		var labels []string
+		for _, x := range v.Conditions() {
+			labels = append(labels, labelStrings(x)...)
+		}
+		return labels
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package eval
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFileTargets(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"rules/defs.bzl": `gen = rule(
+    implementation = lambda ctx: [],
+    attrs = {"srcs": attr.label_list(), "out": attr.output(), "flavor": attr.string()},
+    outputs = {"archive": "lib%{name}_%{flavor}.a"},
+)`,
+		"app/BUILD": `load("//rules:defs.bzl", "gen")
+exports_files(["data.txt"], visibility = ["//other:__pkg__"], licenses = ["notice"])
+exports_files(["README.md"])
+gen(name = "a", srcs = ["a.in", ":b.in"], out = "a.out", flavor = "x")
+gen(name = "b", srcs = [":a.out"])`,
+	})
+	dir := t.TempDir()
+
+	for _, run := range []string{"evaluated", "cached"} {
+		result, err := newTestEvaluator(fs, Options{PackageCacheDir: dir}).EvalBuildFile("/ws/app/BUILD")
+		if err != nil {
+			t.Fatalf("%s: EvalBuildFile failed: %v", run, err)
+		}
+		tests := []struct {
+			name, kind, path, generator string
+		}{
+			{"a.in", "source file", "app/a.in", ""},
+			{"b.in", "source file", "app/b.in", ""},
+			{"data.txt", "source file", "app/data.txt", ""},
+			{"README.md", "source file", "app/README.md", ""},
+			{"a.out", "generated file", "bazel-out/bin/app/a.out", "a"},
+			{"liba_x.a", "generated file", "bazel-out/bin/app/liba_x.a", "a"},
+			{"libb_.a", "generated file", "bazel-out/bin/app/libb_.a", "b"},
+		}
+		if len(result.Files) != len(tests) {
+			t.Errorf("%s: %d files, want %d", run, len(result.Files), len(tests))
+		}
+		for _, tt := range tests {
+			f := result.Files[tt.name]
+			if f == nil {
+				t.Errorf("%s: no file %s", run, tt.name)
+				continue
+			}
+			if f.TargetKind() != tt.kind || f.Artifact().Path() != tt.path || f.Generator != tt.generator {
+				t.Errorf("%s: %s is a %s at %s generated by %q, want a %s at %s generated by %q",
+					run, tt.name, f.TargetKind(), f.Artifact().Path(), f.Generator, tt.kind, tt.path, tt.generator)
+			}
+		}
+		data := result.Files["data.txt"]
+		if got := fmt.Sprint(data.Exported, data.Visibility, data.Licenses); got != "true [//other:__pkg__] [notice]" {
+			t.Errorf("%s: data.txt exported, visibility and licenses = %s", run, got)
+		}
+		if got := fmt.Sprint(result.Files["README.md"].Visibility); got != "[//visibility:public]" {
+			t.Errorf("%s: default exported visibility = %s", run, got)
+		}
+		if result.Files["a.in"].Exported {
+			t.Errorf("%s: implicit input file marked exported", run)
+		}
+	}
+
+	for _, tt := range []struct{ build, errMsg string }{
+		{`load("//rules:defs.bzl", "gen")
+gen(name = "x", out = "x")`, "same name as the rule"},
+		{`load("//rules:defs.bzl", "gen")
+gen(name = "x", out = "y")
+gen(name = "y")`, "conflicts with existing generated file"},
+		{`load("//rules:defs.bzl", "gen")
+gen(name = "x")
+exports_files(["x"])`, "conflicts with existing gen rule"},
+		{`exports_files(["//other:f"])`, "not in the current package"},
+		{`exports_files(["../f"])`, "invalid target name"},
+	} {
+		_, err := newTestEvaluator(fs, Options{}).EvalBuild("/ws/bad/BUILD", []byte(tt.build))
+		checkError(t, err, tt.errMsg)
+	}
+}
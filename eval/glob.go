@@ -0,0 +1,193 @@
+package eval
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"go.starlark.net/starlark"
+)
+
+// threadKeyGlob is the key for the globContext of a BUILD file evaluation.
+const threadKeyGlob = "starlark-go-bazel:glob"
+
+// globContext lets glob() list the package directory of the BUILD file
+// being evaluated and records the directories it examines.
+type globContext struct {
+	fsys loader.DirFileSystem
+	dir  string
+	deps *PackageDeps
+
+	// pkg is the package of the BUILD file; subpackages deleted by locator
+	// are searched.
+	pkg     string
+	locator *loader.PackageLocator
+}
+
+func getGlobContext(thread *starlark.Thread) *globContext {
+	if g, ok := thread.Local(threadKeyGlob).(*globContext); ok {
+		return g
+	}
+	return nil
+}
+
+// glob returns the sorted paths, relative to the package directory, of the
+// files matching any include pattern and no exclude pattern. Directories are
+// matched too unless excludeDirs is set. Subpackages, directories with a
+// BUILD file that are not deleted packages, are not searched.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/GlobCache.java
+func (g *globContext) glob(include, exclude []string, excludeDirs, allowEmpty bool) ([]string, error) {
+	for _, pattern := range append(append([]string(nil), include...), exclude...) {
+		if err := checkGlobPattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+
+	var candidates []string
+	if err := g.walk(g.dir, "", excludeDirs, &candidates); err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	matched := make(map[string]bool, len(include))
+	for _, p := range candidates {
+		name := strings.Split(p, "/")
+		included := false
+		for _, pattern := range include {
+			if matchGlob(strings.Split(pattern, "/"), name) {
+				matched[pattern] = true
+				included = true
+			}
+		}
+		if !included {
+			continue
+		}
+		excluded := false
+		for _, pattern := range exclude {
+			if matchGlob(strings.Split(pattern, "/"), name) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			matches = append(matches, p)
+		}
+	}
+	if !allowEmpty {
+		for _, pattern := range include {
+			if !matched[pattern] {
+				return nil, fmt.Errorf("glob pattern '%s' didn't match anything, but allow_empty is set to False", pattern)
+			}
+		}
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// walk appends the paths under dir, whose path relative to the package
+// directory is rel, to candidates.
+func (g *globContext) walk(dir, rel string, excludeDirs bool, candidates *[]string) error {
+	entries, err := g.fsys.ReadDir(dir)
+	g.deps.addDir(loader.AbsPath(g.fsys, dir), listingDigest(entries, err))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if rel != "" {
+		for _, entry := range entries {
+			if !entry.IsDir() && (entry.Name() == "BUILD" || entry.Name() == "BUILD.bazel") && !g.isDeleted(rel) {
+				return nil
+			}
+		}
+		if !excludeDirs {
+			*candidates = append(*candidates, rel)
+		}
+	}
+	for _, entry := range entries {
+		p := path.Join(rel, entry.Name())
+		if !entry.IsDir() {
+			*candidates = append(*candidates, p)
+			continue
+		}
+		if err := g.walk(g.fsys.Join(dir, entry.Name()), p, excludeDirs, candidates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDeleted reports whether the subpackage at rel is a deleted package.
+func (g *globContext) isDeleted(rel string) bool {
+	return g.locator != nil && g.locator.IsDeleted(path.Join(g.pkg, rel))
+}
+
+// listingDigest returns a digest of the names and kinds of the entries of a
+// directory, or "missing" if it cannot be listed.
+func listingDigest(entries []fs.DirEntry, err error) string {
+	if err != nil {
+		return "missing"
+	}
+	h := sha256.New()
+	for _, entry := range entries {
+		kind := "f"
+		if entry.IsDir() {
+			kind = "d"
+		}
+		fmt.Fprintf(h, "%s\x00%s\n", entry.Name(), kind)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkGlobPattern rejects the patterns Bazel forbids.
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/vfs/UnixGlob.java checkPatternForError()
+func checkGlobPattern(pattern string) error {
+	switch {
+	case pattern == "":
+		return fmt.Errorf("pattern cannot be empty")
+	case strings.HasPrefix(pattern, "/"):
+		return fmt.Errorf("pattern cannot be absolute")
+	}
+	for _, segment := range strings.Split(pattern, "/") {
+		switch {
+		case segment == "":
+			return fmt.Errorf("empty segment not permitted in glob pattern '%s'", pattern)
+		case segment == "." || segment == "..":
+			return fmt.Errorf("segment '%s' not permitted in glob pattern '%s'", segment, pattern)
+		case segment != "**" && strings.Contains(segment, "**"):
+			return fmt.Errorf("recursive wildcard must be its own segment in glob pattern '%s'", pattern)
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern '%s': %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchGlob matches the segments of a path against those of a pattern, in
+// which "**" matches any number of segments.
+func matchGlob(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchGlob(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], name[0])
+	return ok && matchGlob(pattern[1:], name[1:])
+}
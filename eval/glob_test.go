@@ -0,0 +1,34 @@
+package eval
+
+import "testing"
+
+func TestGlob(t *testing.T) {
+	fs := newTestWorkspace(nil)
+	for _, f := range []string{"a.txt", "b.go", "data/c.txt", "data/deep/d.txt", "sub/BUILD", "sub/e.txt"} {
+		fs.AddFile("/ws/pkg/"+f, nil)
+	}
+	tests := []struct {
+		expr   string
+		want   string
+		errMsg string
+	}{
+		{`glob(["*.txt"])`, `["a.txt"]`, ""},
+		{`glob(["**/*.txt"], exclude = ["data/deep/**"])`, `["a.txt", "data/c.txt"]`, ""},
+		{`glob(["data/*"], exclude_directories = 0)`, `["data/c.txt", "data/deep"]`, ""},
+		{`glob(["sub/*"])`, `[]`, ""},
+		{`glob(["*.rs"], allow_empty = False)`, "", "glob pattern '*.rs' didn't match anything"},
+		{`glob(["../*.txt"])`, "", "segment '..' not permitted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			result, err := newTestEvaluator(fs, Options{}).EvalBuild("/ws/pkg/BUILD", []byte("X = "+tt.expr))
+			checkError(t, err, tt.errMsg)
+			if err != nil || tt.errMsg != "" {
+				return
+			}
+			if got := result.Globals["X"].String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package eval
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+)
+
+// PackageDeps lists what the evaluation of a BUILD file depended on.
+// Paths are absolute as returned by loader.AbsPath.
+type PackageDeps struct {
+	// Files are the files read, starting with the BUILD file.
+	Files []string
+
+	// Dirs are the directories listed by glob(), including the
+	// subdirectories examined to find subpackages.
+	Dirs []string
+
+	// Loads are the canonical labels of the modules loaded, including
+	// failed loads.
+	Loads []string
+
+	// listings holds the listing digest of each of Dirs.
+	listings map[string]string
+}
+
+func (d *PackageDeps) addDir(dir, listing string) {
+	if !slices.Contains(d.Dirs, dir) {
+		d.Dirs = append(d.Dirs, dir)
+	}
+	if d.listings == nil {
+		d.listings = make(map[string]string)
+	}
+	d.listings[dir] = listing
+}
+
+func (d *PackageDeps) addLoad(label string) {
+	if !slices.Contains(d.Loads, label) {
+		d.Loads = append(d.Loads, label)
+	}
+}
+
+// affectedBy reports whether a package with these deps must be re-evaluated
+// after the changed paths were modified, created or deleted, or the evicted
+// modules were invalidated. Creating or deleting a file changes the listing
+// of its directory.
+func (d *PackageDeps) affectedBy(changed, evicted map[string]bool) bool {
+	for _, f := range d.Files {
+		if changed[f] {
+			return true
+		}
+	}
+	for _, dir := range d.Dirs {
+		if changed[dir] {
+			return true
+		}
+	}
+	for p := range changed {
+		if slices.Contains(d.Dirs, filepath.Dir(p)) {
+			return true
+		}
+	}
+	for _, label := range d.Loads {
+		if evicted[label] {
+			return true
+		}
+	}
+	return false
+}
+
+// packageEntry is a BUILD file evaluation cached by EvalBuildFile.
+type packageEntry struct {
+	result *BuildResult
+	err    error
+	deps   *PackageDeps
+
+	// source is the digest of the BUILD file evaluated, and modules the
+	// transitive digests of the modules it loaded.
+	source  [32]byte
+	modules map[string][]byte
+}
+
+// newPackageEntry records an evaluation of a BUILD file with the digests
+// current checks it against.
+func (e *Evaluator) newPackageEntry(source []byte, result *BuildResult, err error, deps *PackageDeps) *packageEntry {
+	entry := &packageEntry{result: result, err: err, deps: deps, source: sha256.Sum256(source)}
+	if l, ok := e.bzlLoader.(interface {
+		Result(label string) (*loader.LoadResult, bool)
+	}); ok {
+		entry.modules = make(map[string][]byte, len(deps.Loads))
+		for _, label := range deps.Loads {
+			if r, found := l.Result(label); found {
+				entry.modules[label] = r.TransitiveDigest
+			}
+		}
+	}
+	return entry
+}
+
+// current reports whether a cached evaluation is still valid for the BUILD
+// file now reading source: the file, the directories listed by glob() and
+// the modules loaded, as cached by the BzlLoader, are unchanged. A module
+// that failed to load is never current, so failed loads are retried.
+func (e *Evaluator) current(entry *packageEntry, source []byte) bool {
+	if sha256.Sum256(source) != entry.source || !e.listingsUnchanged(entry.deps) {
+		return false
+	}
+	if len(entry.deps.Loads) == 0 {
+		return true
+	}
+	l, ok := e.bzlLoader.(interface {
+		Result(label string) (*loader.LoadResult, bool)
+	})
+	if !ok {
+		return false
+	}
+	for _, label := range entry.deps.Loads {
+		digest, recorded := entry.modules[label]
+		r, found := l.Result(label)
+		if !recorded || !found || !bytes.Equal(r.TransitiveDigest, digest) {
+			return false
+		}
+	}
+	return true
+}
+
+// listingsUnchanged reports whether the directories listed by glob() still
+// have the recorded entries.
+func (e *Evaluator) listingsUnchanged(deps *PackageDeps) bool {
+	if len(deps.listings) == 0 {
+		return true
+	}
+	fsys, ok := e.fsys.(loader.DirFileSystem)
+	if !ok {
+		return false
+	}
+	for dir, listing := range deps.listings {
+		if listingDigest(fsys.ReadDir(dir)) != listing {
+			return false
+		}
+	}
+	return true
+}
+
+// Invalidation reports what Invalidate evicted.
+type Invalidation struct {
+	// Modules are the canonical labels of the evicted .bzl modules.
+	Modules []string
+
+	// Packages are the names of the evicted packages.
+	Packages []string
+
+	// Targets are the labels of the targets the evicted packages had.
+	Targets []string
+}
+
+// Invalidate evicts the cached modules and packages that depend on any of
+// changedPaths, which were modified, created or deleted. They are evaluated
+// again on their next use; everything else stays cached.
+//
+// Modules are evicted by the BzlLoader if it supports invalidation, and
+// packages when their BUILD file, a directory listed by glob() or a module
+// they loaded changed. The modules of the legacy load path, which does not
+// record loads, are all evicted.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/skyframe/InvalidatingNodeVisitor.java
+func (e *Evaluator) Invalidate(changedPaths []string) *Invalidation {
+	inv := &Invalidation{}
+	if l, ok := e.bzlLoader.(interface{ Invalidate([]string) []string }); ok {
+		inv.Modules = l.Invalidate(changedPaths)
+	}
+	e.cache = make(map[string]*CachedModule)
+
+	changed := make(map[string]bool, len(changedPaths))
+	for _, p := range changedPaths {
+		changed[e.absPath(p)] = true
+	}
+	evicted := make(map[string]bool, len(inv.Modules))
+	for _, label := range inv.Modules {
+		evicted[label] = true
+	}
+	for key, entry := range e.packages {
+		if !entry.deps.affectedBy(changed, evicted) {
+			continue
+		}
+		delete(e.packages, key)
+		if entry.result != nil {
+			inv.Packages = append(inv.Packages, entry.result.Package)
+			for name := range entry.result.Targets {
+				inv.Targets = append(inv.Targets, "//"+entry.result.Package+":"+name)
+			}
+		} else {
+			inv.Packages = append(inv.Packages, e.packageName(key))
+		}
+	}
+	sort.Strings(inv.Packages)
+	sort.Strings(inv.Targets)
+	return inv
+}
+
+// absPath normalizes a path of the evaluator's file system.
+func (e *Evaluator) absPath(path string) string {
+	if e.fsys == nil {
+		return path
+	}
+	return loader.AbsPath(e.fsys, path)
+}
@@ -0,0 +1,151 @@
+package eval
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInvalidate(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"lib/b.bzl": `B = 1`,
+		"lib/a.bzl": `load(":b.bzl", "B")
+A = B`,
+		"lib/c.bzl": `C = 1`,
+		"app/BUILD": `load("//lib:a.bzl", "A")
+SRCS = glob(["**/*.go"])`,
+		"app/x.go":      "",
+		"app/sub/y.go":  "",
+		"app/pkg/BUILD": "",
+		"app/pkg/z.go":  "",
+		"tool/BUILD":    `load("//lib:c.bzl", "C")`,
+	})
+	e := newTestEvaluator(fs, Options{})
+	srcs := func() string {
+		t.Helper()
+		result, err := e.EvalBuildFile("/ws/app/BUILD")
+		if err != nil {
+			t.Fatalf("EvalBuildFile failed: %v", err)
+		}
+		return result.Globals["SRCS"].String()
+	}
+	if got := srcs(); got != `["sub/y.go", "x.go"]` {
+		t.Errorf("SRCS = %s", got)
+	}
+	if _, err := e.EvalBuildFile("/ws/tool/BUILD"); err != nil {
+		t.Fatalf("EvalBuildFile failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		change   func()
+		paths    []string
+		modules  string
+		packages string
+		srcs     string
+	}{
+		{"transitive load", func() { fs.AddFile("/ws/lib/b.bzl", []byte(`B = 2`)) }, []string{"/ws/lib/b.bzl"},
+			"//lib:a.bzl //lib:b.bzl", "app", `["sub/y.go", "x.go"]`},
+		{"unrelated file", func() {}, []string{"/ws/README.md"}, "", "", `["sub/y.go", "x.go"]`},
+		{"file added to glob", func() { fs.AddFile("/ws/app/sub/w.go", nil) }, []string{"/ws/app/sub/w.go"},
+			"", "app", `["sub/w.go", "sub/y.go", "x.go"]`},
+		{"subpackage removed", func() { fs.RemoveFile("/ws/app/pkg/BUILD") }, []string{"/ws/app/pkg/BUILD"},
+			"", "app", `["pkg/z.go", "sub/w.go", "sub/y.go", "x.go"]`},
+		{"other package", func() {}, []string{"/ws/lib/c.bzl"}, "//lib:c.bzl", "tool", `["pkg/z.go", "sub/w.go", "sub/y.go", "x.go"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			inv := e.Invalidate(tt.paths)
+			if got := strings.Join(inv.Modules, " "); got != tt.modules {
+				t.Errorf("Modules = %q, want %q", got, tt.modules)
+			}
+			if got := strings.Join(inv.Packages, " "); got != tt.packages {
+				t.Errorf("Packages = %q, want %q", got, tt.packages)
+			}
+			if got := srcs(); got != tt.srcs {
+				t.Errorf("SRCS = %s, want %s", got, tt.srcs)
+			}
+		})
+	}
+}
+
+func TestEvalBuildFileRevalidates(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"lib/defs.bzl": ruleDef("my_rule", `"srcs": attr.label_list(allow_files = True)`),
+		"app/BUILD": `load("//lib:defs.bzl", "my_rule")
+print("evaluating")
+my_rule(name = "a", srcs = glob(["*.go"]))`,
+		"app/a.go": "",
+	})
+
+	evals := 0
+	e := newTestEvaluator(fs, Options{PrintHandler: func(string) { evals++ }})
+	evalApp := func() (*BuildResult, error) {
+		t.Helper()
+		return e.EvalBuildFile("/ws/app/BUILD")
+	}
+
+	first, err := evalApp()
+	if err != nil {
+		t.Fatalf("EvalBuildFile failed: %v", err)
+	}
+	if second, _ := evalApp(); second != first || evals != 1 {
+		t.Fatalf("unchanged package was evaluated %d times, want 1", evals)
+	}
+
+	// An edit is seen without Invalidate.
+	fs.AddFile("/ws/app/BUILD", []byte(`load("//lib:defs.bzl", "my_rule")
+print("evaluating")
+my_rule(name = "b", srcs = glob(["*.go"]))`))
+	result, err := evalApp()
+	if err != nil {
+		t.Fatalf("EvalBuildFile failed: %v", err)
+	}
+	if _, ok := result.Targets["b"]; !ok || evals != 2 {
+		t.Fatalf("edited BUILD file not re-evaluated: targets %v, %d evaluations", result.Targets, evals)
+	}
+
+	// So is a new file matched by glob().
+	fs.AddFile("/ws/app/b.go", nil)
+	if _, err := evalApp(); err != nil || evals != 3 {
+		t.Fatalf("package not re-evaluated after a globbed directory changed: %v, %d evaluations", err, evals)
+	}
+
+	// Errors are not kept once fixed.
+	fs.AddFile("/ws/app/BUILD", []byte(`my_rule(name = "c")`))
+	if _, err := evalApp(); err == nil {
+		t.Fatal("EvalBuildFile succeeded on a BUILD file using an undefined rule")
+	}
+	fs.AddFile("/ws/app/BUILD", []byte(`load("//lib:defs.bzl", "my_rule")
+my_rule(name = "c")`))
+	result, err = evalApp()
+	if err != nil {
+		t.Fatalf("fixed BUILD file still fails: %v", err)
+	}
+	if _, ok := result.Targets["c"]; !ok {
+		t.Fatalf("targets %v, want c", result.Targets)
+	}
+
+	// Deleting the BUILD file is an error, not the last result.
+	fs.RemoveFile("/ws/app/BUILD")
+	if _, err := evalApp(); err == nil {
+		t.Fatal("EvalBuildFile succeeded on a deleted BUILD file")
+	}
+}
+
+func TestEvalBuildFileRetriesFailedLoads(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"app/BUILD": `load("//lib:defs.bzl", "my_rule")
+my_rule(name = "app")`,
+	})
+	e := newTestEvaluator(fs, Options{})
+
+	if _, err := e.EvalBuildFile("/ws/app/BUILD"); err == nil {
+		t.Fatal("EvalBuildFile succeeded loading a missing module")
+	}
+	fs.AddFile("/ws/lib/defs.bzl", []byte(ruleDef("my_rule")))
+	e.Invalidate([]string{"/ws/lib/defs.bzl"})
+	if _, err := e.EvalBuildFile("/ws/app/BUILD"); err != nil {
+		t.Fatalf("EvalBuildFile failed after the module was created: %v", err)
+	}
+}
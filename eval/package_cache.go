@@ -0,0 +1,508 @@
+package eval
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/albertocavalcante/starlark-go-bazel/builtins"
+	"github.com/albertocavalcante/starlark-go-bazel/loader"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// PackageFormatVersion is the version of the package serialization format.
+// Cached packages of other versions are ignored.
+const PackageFormatVersion = 4
+
+// serializedPackage is the serialized form of an evaluated package.
+type serializedPackage struct {
+	Version  int                         `json:"version"`
+	Package  string                      `json:"package"`
+	Targets  []*serializedTarget         `json:"targets"`
+	Files    []*serializedFile           `json:"files,omitempty"`
+	Groups   []*serializedGroup          `json:"groups,omitempty"`
+	Default  []string                    `json:"defaultVisibility,omitempty"`
+	Globals  map[string]*serializedValue `json:"globals,omitempty"`
+	Deps     []string                    `json:"deps"`
+	Loads    []string                    `json:"loads,omitempty"`
+	Listings map[string]string           `json:"globListings,omitempty"`
+}
+
+// serializedTarget is the serialized form of a rule instance. Its rule
+// class is identified by name and by the label of the .bzl file defining it.
+type serializedTarget struct {
+	Name      string                      `json:"name"`
+	RuleClass string                      `json:"ruleClass"`
+	Defined   string                      `json:"ruleClassDefinedIn"`
+	Label     *serializedLabel            `json:"label,omitempty"`
+	Location  string                      `json:"location,omitempty"`
+	Attrs     map[string]*serializedValue `json:"attrs"`
+}
+
+// serializedFile is the serialized form of a file target.
+type serializedFile struct {
+	Label      *serializedLabel `json:"label"`
+	Generator  string           `json:"generator,omitempty"`
+	Exported   bool             `json:"exported,omitempty"`
+	Visibility []string         `json:"visibility,omitempty"`
+	Licenses   []string         `json:"licenses,omitempty"`
+}
+
+// serializedGroup is the serialized form of a package group.
+type serializedGroup struct {
+	Label    *serializedLabel `json:"label"`
+	Packages []string         `json:"packages,omitempty"`
+	Includes []string         `json:"includes,omitempty"`
+}
+
+type serializedLabel struct {
+	Repo string `json:"repo,omitempty"`
+	Pkg  string `json:"pkg"`
+	Name string `json:"name"`
+}
+
+// serializedValue is the serialized form of an attribute or global value.
+// Elems holds the elements of lists, tuples and selects, and the
+// alternating keys and values of dicts and selectors.
+type serializedValue struct {
+	Kind  string             `json:"kind"`
+	Bool  bool               `json:"bool,omitempty"`
+	Str   string             `json:"str,omitempty"`
+	Label *serializedLabel   `json:"label,omitempty"`
+	Elems []*serializedValue `json:"elems,omitempty"`
+}
+
+// EncodePackage serializes an evaluated package: its targets with their
+// labels, locations and attribute values, including select(), its file
+// targets and package groups, its default visibility, its globals, and the
+// files, modules and glob directory listings it depends on. It fails if a
+// value cannot be serialized, such as a function.
+func EncodePackage(result *BuildResult) ([]byte, error) {
+	p := &serializedPackage{
+		Version: PackageFormatVersion,
+		Package: result.Package,
+		Default: result.DefaultVisibility,
+		Globals: make(map[string]*serializedValue, len(result.Globals)),
+	}
+	if deps := result.Deps; deps != nil {
+		p.Deps, p.Loads, p.Listings = deps.Files, deps.Loads, deps.listings
+	}
+	names := make([]string, 0, len(result.Targets))
+	for name := range result.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		target := result.Targets[name]
+		if target.RuleClass().DefinitionLabel() == "" {
+			return nil, fmt.Errorf("target %s: rule class %s was not exported by a loaded .bzl file", name, target.RuleClassName())
+		}
+		t := &serializedTarget{
+			Name:      name,
+			RuleClass: target.RuleClassName(),
+			Defined:   target.RuleClass().DefinitionLabel(),
+			Label:     encodeLabel(target.Label()),
+			Location:  target.Location(),
+			Attrs:     make(map[string]*serializedValue),
+		}
+		for attr, v := range target.AttrValues() {
+			sv, err := encodeValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("target %s: attribute %s: %w", name, attr, err)
+			}
+			t.Attrs[attr] = sv
+		}
+		p.Targets = append(p.Targets, t)
+	}
+	files := make([]string, 0, len(result.Files))
+	for name := range result.Files {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	for _, name := range files {
+		f := result.Files[name]
+		p.Files = append(p.Files, &serializedFile{
+			Label:      encodeLabel(f.Label),
+			Generator:  f.Generator,
+			Exported:   f.Exported,
+			Visibility: f.Visibility,
+			Licenses:   f.Licenses,
+		})
+	}
+	groups := make([]string, 0, len(result.Groups))
+	for name := range result.Groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	for _, name := range groups {
+		g := result.Groups[name]
+		p.Groups = append(p.Groups, &serializedGroup{
+			Label:    encodeLabel(g.Label),
+			Packages: g.Packages,
+			Includes: g.Includes,
+		})
+	}
+	for name, v := range result.Globals {
+		sv, err := encodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("global %s: %w", name, err)
+		}
+		p.Globals[name] = sv
+	}
+	return json.Marshal(p)
+}
+
+// DecodePackage deserializes a package encoded by EncodePackage. The rule
+// class of each target is looked up with ruleClass by the label of the .bzl
+// file defining it and its name; decoding fails if one is not found.
+func DecodePackage(data []byte, ruleClass func(label, name string) *types.RuleClass) (*BuildResult, error) {
+	var p serializedPackage
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if p.Version != PackageFormatVersion {
+		return nil, fmt.Errorf("package format version %d, want %d", p.Version, PackageFormatVersion)
+	}
+	result := &BuildResult{
+		Targets: make(map[string]*types.RuleInstance, len(p.Targets)),
+		Files:   make(map[string]*FileTarget, len(p.Files)),
+		Groups:  make(map[string]*PackageGroup, len(p.Groups)),
+		Globals: make(starlark.StringDict, len(p.Globals)),
+		Package: p.Package,
+
+		DefaultVisibility: p.Default,
+		Deps:              &PackageDeps{Files: p.Deps, Loads: p.Loads, listings: p.Listings},
+	}
+	for dir := range p.Listings {
+		result.Deps.Dirs = append(result.Deps.Dirs, dir)
+	}
+	sort.Strings(result.Deps.Dirs)
+	for _, t := range p.Targets {
+		rc := ruleClass(t.Defined, t.RuleClass)
+		if rc == nil {
+			return nil, fmt.Errorf("target %s: rule class %s defined in %q not found", t.Name, t.RuleClass, t.Defined)
+		}
+		attrs := make(map[string]starlark.Value, len(t.Attrs))
+		for name, sv := range t.Attrs {
+			v, err := decodeValue(sv)
+			if err != nil {
+				return nil, fmt.Errorf("target %s: attribute %s: %w", t.Name, name, err)
+			}
+			attrs[name] = v
+		}
+		target := types.NewRuleInstance(rc, t.Name, attrs)
+		if t.Label != nil {
+			target.SetLabel(decodeLabel(t.Label))
+		}
+		target.SetLocation(t.Location)
+		target.Freeze()
+		result.Targets[t.Name] = target
+	}
+	for _, f := range p.Files {
+		file := &FileTarget{
+			Label:      decodeLabel(f.Label),
+			Generator:  f.Generator,
+			Exported:   f.Exported,
+			Visibility: f.Visibility,
+			Licenses:   f.Licenses,
+		}
+		if f.Generator != "" {
+			file.Kind = OutputFile
+		}
+		result.Files[file.Name()] = file
+	}
+	for _, g := range p.Groups {
+		group := &PackageGroup{Label: decodeLabel(g.Label), Packages: g.Packages, Includes: g.Includes}
+		result.Groups[group.Name()] = group
+	}
+	for name, sv := range p.Globals {
+		v, err := decodeValue(sv)
+		if err != nil {
+			return nil, fmt.Errorf("global %s: %w", name, err)
+		}
+		v.Freeze()
+		result.Globals[name] = v
+	}
+	return result, nil
+}
+
+func encodeLabel(l *types.Label) *serializedLabel {
+	if l == nil {
+		return nil
+	}
+	return &serializedLabel{Repo: l.Repo(), Pkg: l.Pkg(), Name: l.Name()}
+}
+
+func decodeLabel(l *serializedLabel) *types.Label {
+	return types.NewLabel(l.Repo, l.Pkg, l.Name)
+}
+
+func encodeValue(v starlark.Value) (*serializedValue, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return &serializedValue{Kind: "none"}, nil
+	case starlark.Bool:
+		return &serializedValue{Kind: "bool", Bool: bool(v)}, nil
+	case starlark.Int:
+		return &serializedValue{Kind: "int", Str: v.String()}, nil
+	case starlark.String:
+		return &serializedValue{Kind: "string", Str: string(v)}, nil
+	case *types.Label:
+		return &serializedValue{Kind: "label", Label: encodeLabel(v)}, nil
+	case *starlark.List:
+		return encodeElems("list", v)
+	case starlark.Tuple:
+		return encodeElems("tuple", v)
+	case *starlark.Dict:
+		sv := &serializedValue{Kind: "dict"}
+		for _, item := range v.Items() {
+			if err := appendElems(sv, item[0], item[1]); err != nil {
+				return nil, err
+			}
+		}
+		return sv, nil
+	case *builtins.SelectorList:
+		sv := &serializedValue{Kind: "select"}
+		for _, elem := range v.Elements() {
+			if err := appendElems(sv, elem); err != nil {
+				return nil, err
+			}
+		}
+		return sv, nil
+	case *builtins.SelectorValue:
+		sv := &serializedValue{Kind: "selector", Str: v.NoMatchError()}
+		conditions := v.Conditions()
+		keys := make([]string, 0, len(conditions))
+		for key := range conditions {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := appendElems(sv, starlark.String(key), conditions[key]); err != nil {
+				return nil, err
+			}
+		}
+		return sv, nil
+	}
+	return nil, fmt.Errorf("cannot serialize a value of type %s", v.Type())
+}
+
+func encodeElems(kind string, iterable starlark.Iterable) (*serializedValue, error) {
+	sv := &serializedValue{Kind: kind}
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var elem starlark.Value
+	for iter.Next(&elem) {
+		if err := appendElems(sv, elem); err != nil {
+			return nil, err
+		}
+	}
+	return sv, nil
+}
+
+func appendElems(sv *serializedValue, values ...starlark.Value) error {
+	for _, v := range values {
+		elem, err := encodeValue(v)
+		if err != nil {
+			return err
+		}
+		sv.Elems = append(sv.Elems, elem)
+	}
+	return nil
+}
+
+func decodeValue(sv *serializedValue) (starlark.Value, error) {
+	elems := make([]starlark.Value, len(sv.Elems))
+	for i, e := range sv.Elems {
+		v, err := decodeValue(e)
+		if err != nil {
+			return nil, err
+		}
+		elems[i] = v
+	}
+	switch sv.Kind {
+	case "none":
+		return starlark.None, nil
+	case "bool":
+		return starlark.Bool(sv.Bool), nil
+	case "int":
+		i, ok := new(big.Int).SetString(sv.Str, 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid int %q", sv.Str)
+		}
+		return starlark.MakeBigInt(i), nil
+	case "string":
+		return starlark.String(sv.Str), nil
+	case "label":
+		if sv.Label == nil {
+			return nil, fmt.Errorf("label value without a label")
+		}
+		return decodeLabel(sv.Label), nil
+	case "list":
+		return starlark.NewList(elems), nil
+	case "tuple":
+		return starlark.Tuple(elems), nil
+	case "dict":
+		d := starlark.NewDict(len(elems) / 2)
+		for i := 0; i+1 < len(elems); i += 2 {
+			if err := d.SetKey(elems[i], elems[i+1]); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	case "select":
+		return builtins.NewSelectorList(elems), nil
+	case "selector":
+		conditions := make(map[string]starlark.Value, len(elems)/2)
+		for i := 0; i+1 < len(elems); i += 2 {
+			key, ok := elems[i].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("selector condition of type %s", elems[i].Type())
+			}
+			conditions[string(key)] = elems[i+1]
+		}
+		return builtins.NewSelectorValue(conditions, sv.Str), nil
+	}
+	return nil, fmt.Errorf("unknown value kind %q", sv.Kind)
+}
+
+// PackageCache stores evaluated packages in a directory, keyed by a digest
+// of the BUILD file, its path and the transitive digests of the modules it
+// loads. Entries also record the directory listings glob() depended on,
+// and are only used while those listings are unchanged.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/skyframe/PackageFunction.java
+type PackageCache struct {
+	dir string
+}
+
+// NewPackageCache creates a cache storing packages in dir, which is created
+// when the first package is stored.
+func NewPackageCache(dir string) *PackageCache {
+	return &PackageCache{dir: dir}
+}
+
+// Get returns the package stored under key, or false if there is none or it
+// cannot be decoded.
+func (c *PackageCache) Get(key string, ruleClass func(label, name string) *types.RuleClass) (*BuildResult, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	result, err := DecodePackage(data, ruleClass)
+	if err != nil {
+		return nil, false
+	}
+	return result, true
+}
+
+// Put stores a package under key.
+func (c *PackageCache) Put(key string, result *BuildResult) error {
+	data, err := EncodePackage(result)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	// Write atomically so that concurrent readers never see partial entries.
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+func (c *PackageCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// packageKey returns the cache key of a BUILD file, or "" if it cannot be
+// computed. It loads the modules the file loads to get their transitive
+// digests.
+func (e *Evaluator) packageKey(path string, source []byte) string {
+	l, ok := e.bzlLoader.(interface {
+		Result(label string) (*loader.LoadResult, bool)
+	})
+	if !ok {
+		return ""
+	}
+	loads, err := ExtractLoads(source)
+	if err != nil {
+		return ""
+	}
+	modules := make([]string, 0, len(loads))
+	for module := range loads {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+
+	h := sha256.New()
+	pkg := e.packageName(path)
+	fmt.Fprintf(h, "package format %d\x00%s\x00%s\x00check build syntax %t\x00%d\x00", PackageFormatVersion, e.absPath(path), pkg, e.checkBuild, len(source))
+	h.Write(source)
+	thread := e.newBuildThread(path, pkg)
+	var label string
+	loader.SetLoadObserver(thread, func(l string) { label = l })
+	for _, module := range modules {
+		label = ""
+		if _, err := thread.Load(thread, module); err != nil || label == "" {
+			return ""
+		}
+		fmt.Fprintf(h, "%s\x00", label)
+		if result, ok := l.Result(label); ok {
+			h.Write(result.TransitiveDigest)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedPackage returns the package stored under key if its glob()
+// directory listings are unchanged.
+func (e *Evaluator) cachedPackage(key string) (*BuildResult, bool) {
+	result, ok := e.packageCache.Get(key, e.ruleClassLookup())
+	if !ok {
+		return nil, false
+	}
+	if !e.listingsUnchanged(result.Deps) {
+		return nil, false
+	}
+	return result, true
+}
+
+// ruleClassLookup returns a function finding a rule class exported by a
+// loaded module, by the label of the module defining it and its name.
+func (e *Evaluator) ruleClassLookup() func(label, name string) *types.RuleClass {
+	l, ok := e.bzlLoader.(interface {
+		Result(label string) (*loader.LoadResult, bool)
+	})
+	return func(label, name string) *types.RuleClass {
+		if !ok || label == "" {
+			return nil
+		}
+		result, found := l.Result(label)
+		if !found {
+			return nil
+		}
+		rc, _ := result.Globals[name].(*types.RuleClass)
+		if rc == nil || rc.DefinitionLabel() != label {
+			return nil
+		}
+		return rc
+	}
+}
@@ -0,0 +1,176 @@
+package eval
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPackageCache(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"lib/defs.bzl": ruleDef("my_rule", `"srcs": attr.string_list()`, `"opts": attr.string_list()`),
+		"app/BUILD": `load("//lib:defs.bzl", "my_rule")
+print("evaluating")
+SRCS = glob(["*.go"])
+my_rule(
+    name = "app",
+    srcs = SRCS,
+    opts = ["-O"] + select({"//conditions:default": [], ":debug": ["-g"]}),
+)`,
+		"app/main.go": "",
+	})
+	dir := t.TempDir()
+
+	evals := 0
+	evalApp := func(opts Options) *BuildResult {
+		t.Helper()
+		opts.PackageCacheDir = dir
+		opts.PrintHandler = func(string) { evals++ }
+		result, err := newTestEvaluator(fs, opts).EvalBuildFile("/ws/app/BUILD")
+		if err != nil {
+			t.Fatalf("EvalBuildFile failed: %v", err)
+		}
+		return result
+	}
+
+	first := evalApp(Options{})
+	cached := evalApp(Options{})
+	if evals != 1 {
+		t.Fatalf("evaluated %d times, want 1", evals)
+	}
+	for _, r := range []*BuildResult{first, cached} {
+		app := r.Targets["app"]
+		if app == nil {
+			t.Fatal("missing target app")
+		}
+		opts, _ := app.GetAttrValue("opts")
+		if got := opts.String(); got != `["-O"] + select({"//conditions:default": [], ":debug": ["-g"]})` {
+			t.Errorf("opts = %s", got)
+		}
+		if got := app.Label().String(); got != "//app:app" {
+			t.Errorf("label = %s", got)
+		}
+		if got := app.Location(); got != "/ws/app/BUILD:4:8" {
+			t.Errorf("location = %s", got)
+		}
+		if got := app.RuleClassName(); got != "my_rule" {
+			t.Errorf("rule class = %s", got)
+		}
+		if got := r.Globals["SRCS"].String(); got != `["main.go"]` {
+			t.Errorf("SRCS = %s", got)
+		}
+	}
+
+	// Glob results, loaded modules and the BUILD syntax check are part of
+	// the key.
+	fs.AddFile("/ws/app/util.go", nil)
+	if got := evalApp(Options{}).Globals["SRCS"].String(); got != `["main.go", "util.go"]` || evals != 2 {
+		t.Errorf("after adding a file: SRCS = %s, %d evaluations", got, evals)
+	}
+	fs.AddFile("/ws/lib/defs.bzl", []byte(ruleDef("my_rule", `"srcs": attr.string_list()`, `"opts": attr.string_list()`, `"extra": attr.string()`)))
+	evalApp(Options{})
+	evalApp(Options{})
+	if evals != 3 {
+		t.Errorf("evaluated %d times after changing a loaded module, want 3", evals)
+	}
+	evalApp(Options{DisableBuildSyntaxCheck: true})
+	if evals != 4 {
+		t.Errorf("evaluated %d times after disabling the syntax check, want 4", evals)
+	}
+
+	// Entries whose rule classes cannot be resolved are misses.
+	entries, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, entry := range entries {
+		data, err := os.ReadFile(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = []byte(strings.ReplaceAll(string(data), `"ruleClassDefinedIn":"//lib:defs.bzl"`, `"ruleClassDefinedIn":"//other:defs.bzl"`))
+		if err := os.WriteFile(entry, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	evalApp(Options{})
+	if evals != 5 {
+		t.Errorf("evaluated %d times with an unresolvable rule class, want 5", evals)
+	}
+}
+
+func TestPackageCacheRuleClassIdentity(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"a/defs.bzl": ruleDef("my_library", `"deps": attr.label_list()`),
+		"b/defs.bzl": ruleDef("my_library", `"srcs": attr.string_list()`),
+		"app/BUILD": `load("//a:defs.bzl", a_library = "my_library")
+load("//b:defs.bzl", b_library = "my_library")
+a_library(name = "a", deps = [":b"])
+b_library(name = "b", srcs = ["b.go"])`,
+	})
+	dir := t.TempDir()
+
+	for _, run := range []string{"evaluated", "cached"} {
+		e := newTestEvaluator(fs, Options{PackageCacheDir: dir})
+		result, err := e.EvalBuildFile("/ws/app/BUILD")
+		if err != nil {
+			t.Fatalf("%s: EvalBuildFile failed: %v", run, err)
+		}
+		for name, want := range map[string]string{"a": "//a:defs.bzl", "b": "//b:defs.bzl"} {
+			rc := result.Targets[name].RuleClass()
+			if got := rc.DefinitionLabel(); got != want {
+				t.Errorf("%s: target %s has rule class from %q, want %q", run, name, got, want)
+			}
+		}
+		if _, ok := result.Targets["a"].RuleClass().GetAttr("deps"); !ok {
+			t.Errorf("%s: rule class of a lost its attributes", run)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("cache holds %d entries, want 1", len(entries))
+	}
+}
+
+func TestPackageFormatVersion(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"lib/defs.bzl": ruleDef("my_rule"),
+		"app/BUILD": `load("//lib:defs.bzl", "my_rule")
+my_rule(name = "app")
+exports_files(["a.txt"])`,
+	})
+	e := newTestEvaluator(fs, Options{})
+	result, err := e.EvalBuildFile("/ws/app/BUILD")
+	if err != nil {
+		t.Fatalf("EvalBuildFile failed: %v", err)
+	}
+	data, err := EncodePackage(result)
+	if err != nil {
+		t.Fatalf("EncodePackage failed: %v", err)
+	}
+	if _, err := DecodePackage(data, e.ruleClassLookup()); err != nil {
+		t.Fatalf("DecodePackage failed: %v", err)
+	}
+
+	// An entry of an earlier format, lacking the file targets, is rejected
+	// rather than decoded with missing fields.
+	current := fmt.Sprintf(`"version":%d`, PackageFormatVersion)
+	old := strings.Replace(string(data), current, fmt.Sprintf(`"version":%d`, PackageFormatVersion-1), 1)
+	old = strings.Replace(old, `"files":`, `"x":`, 1)
+	if old == string(data) {
+		t.Fatal("version not found in encoded package")
+	}
+	if _, err := DecodePackage([]byte(old), e.ruleClassLookup()); err == nil || !strings.Contains(err.Error(), "package format version") {
+		t.Errorf("expected version error, got %v", err)
+	}
+
+	cache := NewPackageCache(t.TempDir())
+	if err := cache.Put("key", result); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache.path("key"), []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("key", e.ruleClassLookup()); ok {
+		t.Error("cache returned an entry of an earlier format")
+	}
+}
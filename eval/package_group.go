@@ -0,0 +1,158 @@
+package eval
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// PackageGroup is a package_group target: a named set of packages that
+// visibility declarations can refer to.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/PackageGroup.java
+type PackageGroup struct {
+	// Label is the label of the group.
+	Label *types.Label
+
+	// Packages are the group's package specifications in canonical form:
+	// "//foo", "//foo/...", "@@repo//foo", any of them negated by a leading
+	// "-", "public" or "private".
+	Packages []string
+
+	// Includes are the canonical labels of the groups whose packages also
+	// belong to this one.
+	Includes []string
+}
+
+// Name returns the name of the group in its package.
+func (g *PackageGroup) Name() string { return g.Label.Name() }
+
+// TargetKind returns "package group".
+func (g *PackageGroup) TargetKind() string { return "package group" }
+
+// Matches reports whether the package pkg of repository repo is matched by
+// the group's own specifications: by one of them, and by none of the
+// negated ones. Included groups are not consulted.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/PackageGroupContents.java
+func (g *PackageGroup) Matches(repo, pkg string) bool {
+	matched := false
+	for _, spec := range g.Packages {
+		negated := strings.HasPrefix(spec, "-")
+		if !matchPackageSpec(strings.TrimPrefix(spec, "-"), repo, pkg) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// matchPackageSpec reports whether a canonical, non-negated package
+// specification matches a package.
+func matchPackageSpec(spec, repo, pkg string) bool {
+	switch spec {
+	case "public":
+		return true
+	case "private":
+		return false
+	}
+	specRepo := ""
+	if strings.HasPrefix(spec, "@@") {
+		specRepo, spec, _ = strings.Cut(spec[2:], "//")
+	} else {
+		spec = strings.TrimPrefix(spec, "//")
+	}
+	if specRepo != repo {
+		return false
+	}
+	switch {
+	case spec == "...":
+		return true
+	case strings.HasSuffix(spec, "/..."):
+		base := strings.TrimSuffix(spec, "/...")
+		return pkg == base || strings.HasPrefix(pkg, base+"/")
+	}
+	return pkg == spec
+}
+
+// ParsePackageSpec validates a package specification of package_group and
+// returns its canonical form, resolving apparent repository names through
+// the context's repo mapping.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/PackageSpecification.java fromString()
+func ParsePackageSpec(spec string, c types.LabelContext) (string, error) {
+	if spec == "public" || spec == "private" {
+		return spec, nil
+	}
+	s, negated := strings.CutPrefix(spec, "-")
+	if !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "@") {
+		return "", fmt.Errorf("invalid package name '%s': must start with '//'", spec)
+	}
+	if strings.Contains(s, ":") {
+		return "", fmt.Errorf("invalid package name '%s': package names may not contain ':'", spec)
+	}
+	// Parse "<spec>:x" as a label to resolve the repository.
+	l, err := types.ParseLabelInContext(s+":x", c)
+	if err != nil {
+		return "", fmt.Errorf("invalid package name '%s': %w", spec, err)
+	}
+	pkg := l.Pkg()
+	if pkg != "..." {
+		for _, seg := range strings.Split(strings.TrimSuffix(pkg, "/..."), "/") {
+			if (seg == "" && pkg != "") || seg == "." || seg == ".." || seg == "..." {
+				return "", fmt.Errorf("invalid package name '%s'", spec)
+			}
+		}
+	}
+	canonical := "//" + pkg
+	if l.Repo() != "" {
+		canonical = "@@" + l.Repo() + canonical
+	}
+	if negated {
+		canonical = "-" + canonical
+	}
+	return canonical, nil
+}
+
+// PackageGroupBuiltin implements package_group() for BUILD files.
+//
+// Signature: package_group(name, packages = [], includes = [])
+//
+// Reference: PackageFactory.packageGroup
+func PackageGroupBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	var packages, includes *starlark.List
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "packages?", &packages, "includes?", &includes); err != nil {
+		return nil, err
+	}
+	pkg := GetPackage(thread)
+	if pkg == nil {
+		return nil, fmt.Errorf("package_group() can only be called from BUILD files")
+	}
+	if kind, exists := pkg.targetKind(name); exists {
+		return nil, fmt.Errorf("package_group: '%s' conflicts with existing %s", name, kind)
+	}
+
+	group := &PackageGroup{Label: types.NewLabel(pkg.labels.Repo, pkg.Name, name)}
+	for _, spec := range stringList(packages) {
+		canonical, err := ParsePackageSpec(spec, pkg.labels)
+		if err != nil {
+			return nil, fmt.Errorf("package_group: %w", err)
+		}
+		group.Packages = append(group.Packages, canonical)
+	}
+	for _, include := range stringList(includes) {
+		l, err := types.ParseLabelInContext(include, pkg.labels)
+		if err != nil {
+			return nil, fmt.Errorf("package_group: %w", err)
+		}
+		group.Includes = append(group.Includes, l.String())
+	}
+	pkg.Groups[name] = group
+	return starlark.None, nil
+}
@@ -0,0 +1,69 @@
+package eval
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+)
+
+func TestPackageGroup(t *testing.T) {
+	fs := newTestWorkspace(map[string]string{
+		"groups/BUILD": `package_group(name = "frontend", packages = ["//ui/...", "-//ui/legacy", "@other//lib"])
+package_group(name = "friends", packages = ["//tools"], includes = [":frontend"])`,
+	})
+	result, err := newTestEvaluator(fs, Options{}).EvalBuildFile("/ws/groups/BUILD")
+	if err != nil {
+		t.Fatalf("EvalBuildFile failed: %v", err)
+	}
+	frontend := result.Groups["frontend"]
+	if got := strings.Join(frontend.Packages, " "); got != "//ui/... -//ui/legacy @@other//lib" {
+		t.Errorf("canonical packages = %s", got)
+	}
+	if got := strings.Join(result.Groups["friends"].Includes, " "); got != "//groups:frontend" {
+		t.Errorf("includes = %s", got)
+	}
+	for _, tt := range []struct {
+		repo, pkg string
+		want      bool
+	}{
+		{"", "ui", true},
+		{"", "ui/web", true},
+		{"", "ui/legacy", false},
+		{"", "tools", false},
+		{"other", "lib", true},
+		{"other", "ui", false},
+	} {
+		if got := frontend.Matches(tt.repo, tt.pkg); got != tt.want {
+			t.Errorf("Matches(%q, %q) = %v, want %v", tt.repo, tt.pkg, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct{ build, errMsg string }{
+		{`package_group(name = "g", packages = ["foo"])`, "must start with '//'"},
+		{`package_group(name = "g", packages = ["//foo:bar"])`, "may not contain ':'"},
+		{`package_group(name = "g", packages = ["//foo/../bar"])`, "invalid package name"},
+		{`package_group(name = "g")
+package_group(name = "g")`, "conflicts with existing package group"},
+	} {
+		_, err := newTestEvaluator(fs, Options{}).EvalBuild("/ws/bad/BUILD", []byte(tt.build))
+		checkError(t, err, tt.errMsg)
+	}
+}
+
+func TestParsePackageSpec(t *testing.T) {
+	c := types.LabelContext{Pkg: "app"}
+	for spec, want := range map[string]string{
+		"public":     "public",
+		"private":    "private",
+		"//...":      "//...",
+		"//foo":      "//foo",
+		"-//foo/...": "-//foo/...",
+		"@@r//foo":   "@@r//foo",
+	} {
+		got, err := ParsePackageSpec(spec, c)
+		if err != nil || got != want {
+			t.Errorf("ParsePackageSpec(%q) = %q, %v, want %q", spec, got, err, want)
+		}
+	}
+}
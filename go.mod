@@ -4,6 +4,9 @@ go 1.24
 
 toolchain go1.25.6
 
-require go.starlark.net v0.0.0-20260102030733-3fee463870c9
+require (
+	github.com/ulikunitz/xz v0.5.17
+	go.starlark.net v0.0.0-20260102030733-3fee463870c9
+)
 
 require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
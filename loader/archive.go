@@ -0,0 +1,263 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveFileSystem is a read-only FileSystem over the files of a zip or
+// tar.gz archive, like the archives fetched by http_archive. Paths are
+// slash-separated and rooted at "/"; directories missing from the archive
+// are implied by the files they contain. Symbolic and hard links are not
+// supported and are skipped.
+type ArchiveFileSystem struct {
+	entries map[string]*archiveEntry // keyed by io/fs name
+}
+
+// archiveEntry is a file or directory of an archive. It is its own
+// fs.FileInfo.
+type archiveEntry struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+
+	data     []byte    // tar files
+	zipFile  *zip.File // zip files
+	children []string  // directories, sorted
+}
+
+func (e *archiveEntry) Name() string       { return e.name }
+func (e *archiveEntry) Size() int64        { return e.size }
+func (e *archiveEntry) Mode() fs.FileMode  { return e.mode }
+func (e *archiveEntry) ModTime() time.Time { return e.modTime }
+func (e *archiveEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *archiveEntry) Sys() any           { return nil }
+
+// OpenArchive reads the archive at path, a .zip, .tar.gz or .tgz file.
+func OpenArchive(path string) (*ArchiveFileSystem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch {
+	case strings.HasSuffix(path, ".zip"):
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		// Zip members are read on demand, so the archive is kept in memory
+		// rather than open.
+		data := make([]byte, info.Size())
+		if _, err := io.ReadFull(f, data); err != nil {
+			return nil, err
+		}
+		return NewZipFileSystem(bytes.NewReader(data), info.Size())
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return NewTarGzFileSystem(f)
+	}
+	return nil, fmt.Errorf("%s: unsupported archive type, want .zip, .tar.gz or .tgz", path)
+}
+
+// NewZipFileSystem reads the index of the zip archive in r, whose size is
+// size. Files are decompressed when read.
+func NewZipFileSystem(r io.ReaderAt, size int64) (*ArchiveFileSystem, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	a := newArchiveFileSystem()
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = a.addDir(zf.Name, zf.Modified)
+		case mode.IsRegular():
+			err = a.addFile(zf.Name, &archiveEntry{
+				size:    int64(zf.UncompressedSize64),
+				mode:    mode.Perm(),
+				modTime: zf.Modified,
+				zipFile: zf,
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a.finish(), nil
+}
+
+// NewTarGzFileSystem reads the gzip-compressed tar archive from r into
+// memory.
+func NewTarGzFileSystem(r io.Reader) (*ArchiveFileSystem, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	a := newArchiveFileSystem()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = a.addDir(hdr.Name, hdr.ModTime)
+		case tar.TypeReg:
+			var data []byte
+			if data, err = io.ReadAll(tr); err != nil {
+				return nil, err
+			}
+			err = a.addFile(hdr.Name, &archiveEntry{
+				size:    int64(len(data)),
+				mode:    fs.FileMode(hdr.Mode).Perm(),
+				modTime: hdr.ModTime,
+				data:    data,
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return a.finish(), nil
+}
+
+func newArchiveFileSystem() *ArchiveFileSystem {
+	a := &ArchiveFileSystem{entries: make(map[string]*archiveEntry)}
+	a.entries["."] = &archiveEntry{name: "/", mode: fs.ModeDir | 0o755}
+	return a
+}
+
+// addFile adds a file and its parent directories. Names are cleaned as
+// paths rooted at "/", so none escapes the archive. A later file replaces an
+// earlier one of the same name, as when extracting the archive; a name that
+// is also a directory is an error.
+func (a *ArchiveFileSystem) addFile(name string, e *archiveEntry) error {
+	name = fsName(name)
+	if name == "." {
+		return nil
+	}
+	if old, ok := a.entries[name]; ok && old.IsDir() {
+		return conflictError(name)
+	}
+	if err := a.addDir(path.Dir(name), time.Time{}); err != nil {
+		return err
+	}
+	e.name = path.Base(name)
+	a.entries[name] = e
+	return nil
+}
+
+// addDir adds a directory and its parents, if missing. A directory or
+// parent that is also a file is an error.
+func (a *ArchiveFileSystem) addDir(name string, modTime time.Time) error {
+	for name = fsName(name); name != "."; name = path.Dir(name) {
+		if e, ok := a.entries[name]; ok {
+			if !e.IsDir() {
+				return conflictError(name)
+			}
+			if e.modTime.IsZero() {
+				e.modTime = modTime
+			}
+			return nil
+		}
+		a.entries[name] = &archiveEntry{name: path.Base(name), mode: fs.ModeDir | 0o755, modTime: modTime}
+		modTime = time.Time{}
+	}
+	return nil
+}
+
+func conflictError(name string) error {
+	return fmt.Errorf("archive: /%s is both a file and a directory", name)
+}
+
+// finish links directories to their children.
+func (a *ArchiveFileSystem) finish() *ArchiveFileSystem {
+	for name := range a.entries {
+		if name == "." {
+			continue
+		}
+		parent := a.entries[path.Dir(name)]
+		parent.children = append(parent.children, name)
+	}
+	for _, e := range a.entries {
+		sort.Strings(e.children)
+	}
+	return a
+}
+
+func (a *ArchiveFileSystem) lookup(op, p string) (*archiveEntry, error) {
+	e, ok := a.entries[fsName(p)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+// ReadFile reads the file at path.
+func (a *ArchiveFileSystem) ReadFile(p string) ([]byte, error) {
+	e, err := a.lookup("read", p)
+	if err != nil {
+		return nil, err
+	}
+	if e.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: p, Err: errors.New("is a directory")}
+	}
+	if e.zipFile == nil {
+		return bytes.Clone(e.data), nil
+	}
+	r, err := e.zipFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Stat returns file info for the given path.
+func (a *ArchiveFileSystem) Stat(p string) (fs.FileInfo, error) {
+	return a.lookup("stat", p)
+}
+
+// ReadDir returns the entries of the directory at path, sorted by name.
+func (a *ArchiveFileSystem) ReadDir(p string) ([]fs.DirEntry, error) {
+	e, err := a.lookup("readdir", p)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: errors.New("not a directory")}
+	}
+	entries := make([]fs.DirEntry, len(e.children))
+	for i, child := range e.children {
+		entries[i] = fs.FileInfoToDirEntry(a.entries[child])
+	}
+	return entries, nil
+}
+
+// Join joins path elements with slashes.
+func (a *ArchiveFileSystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Abs returns the path rooted at "/".
+func (a *ArchiveFileSystem) Abs(p string) (string, error) {
+	return rootedName(p), nil
+}
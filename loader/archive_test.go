@@ -0,0 +1,81 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchiveFileSystem(t *testing.T) {
+	t.Run("zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, name := range testFileNames() {
+			w, err := zw.Create("repo-1.0/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(testFiles[name]))
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		fsys, err := NewZipFileSystem(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFileSystem(t, fsys, "/repo-1.0")
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "repo.tar.gz")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		for _, name := range testFileNames() {
+			content := testFiles[name]
+			tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+			tw.Write([]byte(content))
+		}
+		tw.Close()
+		gz.Close()
+		f.Close()
+		fsys, err := OpenArchive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFileSystem(t, fsys, "/")
+
+		// Reads return copies of the archive's contents.
+		data, _ := fsys.ReadFile("/app/BUILD")
+		data[0] ^= 0xff
+		if again, _ := fsys.ReadFile("/app/BUILD"); string(again) != testFiles["app/BUILD"] {
+			t.Errorf("ReadFile after modifying a previous result = %q, want %q", again, testFiles["app/BUILD"])
+		}
+	})
+
+	t.Run("conflicts", func(t *testing.T) {
+		for _, names := range [][]string{{"a", "a/b"}, {"a/b", "a"}, {"a/", "a"}, {"a", "a/"}} {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			for _, name := range names {
+				if _, err := zw.Create(name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewZipFileSystem(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+				t.Errorf("archive of %q: got no error, want a file and directory conflict", names)
+			}
+		}
+	})
+}
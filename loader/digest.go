@@ -0,0 +1,51 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"slices"
+
+	"go.starlark.net/starlark"
+)
+
+// ThreadKeyLoadObserver is the key for the function notified of the modules
+// loaded by a thread.
+const ThreadKeyLoadObserver = "starlark-go-bazel:load_observer"
+
+// SetLoadObserver sets a function called with the canonical label of every
+// module loaded by the thread, such as a BUILD file's, including failed
+// loads.
+func SetLoadObserver(thread *starlark.Thread, observer func(label string)) {
+	thread.SetLocal(ThreadKeyLoadObserver, observer)
+}
+
+// recordLoad records a module loaded by the file that thread is
+// initializing, if any. Modules loaded more than once are recorded once.
+func recordLoad(thread *starlark.Thread, label string, digest []byte) {
+	if observer, ok := thread.Local(ThreadKeyLoadObserver).(func(string)); ok {
+		observer(label)
+	}
+	ctx := GetBzlContext(thread)
+	if ctx == nil || slices.Contains(ctx.Loads, label) {
+		return
+	}
+	ctx.Loads = append(ctx.Loads, label)
+	ctx.loadDigests = append(ctx.loadDigests, digest)
+}
+
+// SetTransitiveDigest sets TransitiveDigest to the sha256 digest of the
+// file's source followed by the label and transitive digest of each module
+// it loaded. It must be called once all loads have been recorded, that is
+// after the file has been executed.
+//
+// Reference: BzlLoadFunction.java computeInternalWithCompiledBzl() transitive digest fingerprint
+func (c *BzlInitThreadContext) SetTransitiveDigest(source []byte) {
+	sourceDigest := sha256.Sum256(source)
+	h := sha256.New()
+	h.Write(sourceDigest[:])
+	for i, label := range c.Loads {
+		h.Write([]byte(label))
+		h.Write([]byte{0})
+		h.Write(c.loadDigests[i])
+	}
+	c.TransitiveDigest = h.Sum(nil)
+}
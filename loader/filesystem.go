@@ -9,6 +9,8 @@ package loader
 import (
 	"io/fs"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -30,6 +32,11 @@ func (f *MemoryFileSystem) AddFile(path string, content []byte) {
 	f.files[path] = content
 }
 
+// RemoveFile removes a file from the in-memory filesystem.
+func (f *MemoryFileSystem) RemoveFile(path string) {
+	delete(f.files, path)
+}
+
 // ReadFile reads a file from memory.
 func (f *MemoryFileSystem) ReadFile(path string) ([]byte, error) {
 	content, ok := f.files[path]
@@ -67,19 +74,56 @@ func (f *MemoryFileSystem) Glob(pattern string) ([]string, error) {
 	return matches, nil
 }
 
-// memFileInfo implements fs.FileInfo for in-memory files.
+// ReadDir lists the files and the implied directories directly under path.
+// A directory exists as long as it contains a file.
+func (f *MemoryFileSystem) ReadDir(path string) ([]fs.DirEntry, error) {
+	prefix := filepath.Clean(path)
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	infos := make(map[string]*memFileInfo)
+	for p, content := range f.files {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		if name, _, isDir := strings.Cut(rest, "/"); isDir {
+			infos[name] = &memFileInfo{name: name, dir: true}
+		} else {
+			infos[name] = &memFileInfo{name: name, size: int64(len(content))}
+		}
+	}
+	if len(infos) == 0 {
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// memFileInfo implements fs.FileInfo for in-memory files and directories.
 type memFileInfo struct {
 	name string
 	size int64
+	dir  bool
 }
 
 func (fi *memFileInfo) Name() string       { return fi.name }
 func (fi *memFileInfo) Size() int64        { return fi.size }
-func (fi *memFileInfo) Mode() fs.FileMode  { return 0644 }
 func (fi *memFileInfo) ModTime() time.Time { return time.Time{} }
-func (fi *memFileInfo) IsDir() bool        { return false }
+func (fi *memFileInfo) IsDir() bool        { return fi.dir }
 func (fi *memFileInfo) Sys() any           { return nil }
 
+func (fi *memFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
 // Join implements FileSystem.Join for MemoryFileSystem.
 func (f *MemoryFileSystem) Join(elem ...string) string {
 	return filepath.Join(elem...)
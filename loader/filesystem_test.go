@@ -0,0 +1,96 @@
+package loader
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testFiles is the tree the file system tests read, keyed by slash
+// separated path.
+var testFiles = map[string]string{
+	"rules/defs.bzl": `PREFIX = "lib_"`,
+	"app/BUILD":      `load("//rules:defs.bzl", "PREFIX")`,
+	"app/a.go":       "package app",
+	"app/sub/b.go":   "package sub",
+	"app/README.txt": "",
+}
+
+// testFileNames returns the sorted names of testFiles.
+func testFileNames() []string {
+	names := make([]string, 0, len(testFiles))
+	for name := range testFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// writeFiles writes files, keyed by slash separated path, under dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listDir returns the entries of a directory as "name" for files and
+// "name/" for directories.
+func listDir(t *testing.T, fsys DirFileSystem, dir string) string {
+	t.Helper()
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir(%s) failed: %v", dir, err)
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+		if e.IsDir() {
+			names[i] += "/"
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// checkFileSystem checks that fsys holds testFiles under root.
+func checkFileSystem(t *testing.T, fsys DirFileSystem, root string) {
+	t.Helper()
+	for name, want := range testFiles {
+		content, err := fsys.ReadFile(fsys.Join(root, name))
+		if err != nil || string(content) != want {
+			t.Errorf("ReadFile(%s) = %q, %v, want %q", name, content, err, want)
+		}
+		info, err := fsys.Stat(fsys.Join(root, name))
+		if err != nil || info.IsDir() || info.Size() != int64(len(want)) {
+			t.Errorf("Stat(%s) = %v, %v, want a file of %d bytes", name, info, err, len(want))
+		}
+	}
+	if got := listDir(t, fsys, fsys.Join(root, "app")); got != "BUILD README.txt a.go sub/" {
+		t.Errorf("ReadDir(app) = %s", got)
+	}
+	if info, err := fsys.Stat(fsys.Join(root, "app/sub")); err != nil || !info.IsDir() {
+		t.Errorf("Stat(app/sub) = %v, %v, want a directory", info, err)
+	}
+	if _, err := fsys.ReadFile(fsys.Join(root, "app/missing.go")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile(app/missing.go) error = %v, want fs.ErrNotExist", err)
+	}
+	if _, err := fsys.Stat(fsys.Join(root, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(missing) error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestOSFileSystem(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, testFiles)
+	checkFileSystem(t, NewOSFileSystem(root), root)
+}
@@ -0,0 +1,988 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitRepository reads the objects and refs of a local Git repository
+// directly from its git directory, both loose objects and packfiles, without
+// running git or checking anything out. Only SHA-1 repositories are
+// supported.
+//
+// Reference: https://git-scm.com/docs/gitformat-pack
+type GitRepository struct {
+	gitDir     string   // HEAD and the refs of the worktree
+	commonDir  string   // shared refs; differs from gitDir in linked worktrees
+	objectDirs []string // the objects directory and its alternates
+
+	mu    sync.Mutex
+	packs []*gitPack // nil until loaded
+	trees map[gitHash][]gitTreeEntry
+}
+
+// gitHash is a SHA-1 object name.
+type gitHash [20]byte
+
+func (h gitHash) String() string { return hex.EncodeToString(h[:]) }
+
+// gitObject is a decompressed object.
+type gitObject struct {
+	typ  string // "commit", "tree", "blob" or "tag"
+	data []byte
+}
+
+// gitTreeEntry is an entry of a tree object.
+type gitTreeEntry struct {
+	name string
+	mode uint32
+	hash gitHash
+}
+
+// Git file modes of tree entries.
+const (
+	gitModeDir     = 0o040000
+	gitModeExec    = 0o100755
+	gitModeSymlink = 0o120000
+	gitModeGitlink = 0o160000
+)
+
+// OpenGitRepository opens the repository at path, which is either a working
+// tree containing a .git directory or file, or a git directory such as a
+// bare repository.
+func OpenGitRepository(path string) (*GitRepository, error) {
+	gitDir := path
+	dotGit := filepath.Join(path, ".git")
+	if info, err := os.Stat(dotGit); err == nil {
+		gitDir = dotGit
+		if !info.IsDir() {
+			// A linked worktree or submodule: .git names the git directory.
+			content, err := os.ReadFile(dotGit)
+			if err != nil {
+				return nil, err
+			}
+			dir, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir: ")
+			if !ok {
+				return nil, fmt.Errorf("%s: not a gitdir file", dotGit)
+			}
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(path, dir)
+			}
+			gitDir = dir
+		}
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, "HEAD")); err != nil {
+		return nil, fmt.Errorf("%s: not a git repository", path)
+	}
+
+	commonDir := gitDir
+	if content, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(content))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	if config, err := os.ReadFile(filepath.Join(commonDir, "config")); err == nil {
+		for _, line := range strings.Split(string(config), "\n") {
+			key, value, ok := strings.Cut(line, "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "objectformat") && strings.TrimSpace(value) != "sha1" {
+				return nil, fmt.Errorf("%s: unsupported object format %s", path, strings.TrimSpace(value))
+			}
+		}
+	}
+
+	objects := filepath.Join(commonDir, "objects")
+	objectDirs := []string{objects}
+	if content, err := os.ReadFile(filepath.Join(objects, "info", "alternates")); err == nil {
+		for _, alt := range strings.Split(string(content), "\n") {
+			alt = strings.TrimSpace(alt)
+			if alt == "" || strings.HasPrefix(alt, "#") {
+				continue
+			}
+			if !filepath.IsAbs(alt) {
+				alt = filepath.Join(objects, alt)
+			}
+			objectDirs = append(objectDirs, alt)
+		}
+	}
+
+	return &GitRepository{
+		gitDir:     gitDir,
+		commonDir:  commonDir,
+		objectDirs: objectDirs,
+		trees:      make(map[gitHash][]gitTreeEntry),
+	}, nil
+}
+
+// Close closes the packfiles opened by the repository.
+func (r *GitRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	for _, p := range r.packs {
+		if p.file != nil {
+			errs = append(errs, p.file.Close())
+			p.file = nil
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Resolve returns the commit named by rev: a full or abbreviated commit
+// name, HEAD, or a branch, tag or remote-tracking branch, optionally
+// followed by ~<n> and ^<n> suffixes selecting ancestors.
+//
+// Reference: https://git-scm.com/docs/gitrevisions
+func (r *GitRepository) Resolve(rev string) (string, error) {
+	h, err := r.resolve(rev)
+	if err != nil {
+		return "", err
+	}
+	return h.String(), nil
+}
+
+func (r *GitRepository) resolve(rev string) (gitHash, error) {
+	name, suffix := rev, ""
+	if i := strings.IndexAny(rev, "~^"); i >= 0 {
+		name, suffix = rev[:i], rev[i:]
+	}
+	h, err := r.resolveName(name)
+	if err != nil {
+		return h, fmt.Errorf("resolving %s: %w", rev, err)
+	}
+	if h, err = r.peelToCommit(h); err != nil {
+		return h, fmt.Errorf("resolving %s: %w", rev, err)
+	}
+	for suffix != "" {
+		op := suffix[0]
+		end := 1
+		for end < len(suffix) && suffix[end] >= '0' && suffix[end] <= '9' {
+			end++
+		}
+		n := 1
+		if end > 1 {
+			if n, err = strconv.Atoi(suffix[1:end]); err != nil {
+				return h, fmt.Errorf("resolving %s: %w", rev, err)
+			}
+		}
+		suffix = suffix[end:]
+		if op == '~' {
+			for ; n > 0; n-- {
+				if h, err = r.parent(h, 1); err != nil {
+					return h, fmt.Errorf("resolving %s: %w", rev, err)
+				}
+			}
+		} else if n > 0 {
+			if h, err = r.parent(h, n); err != nil {
+				return h, fmt.Errorf("resolving %s: %w", rev, err)
+			}
+		}
+	}
+	return h, nil
+}
+
+// resolveName resolves an object name or ref name.
+func (r *GitRepository) resolveName(name string) (gitHash, error) {
+	var h gitHash
+	if len(name) == 2*len(h) {
+		if _, err := hex.Decode(h[:], []byte(name)); err == nil {
+			return h, nil
+		}
+	}
+	// Reference: https://git-scm.com/docs/gitrevisions <refname>
+	refs := []string{"refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name, "refs/remotes/" + name + "/HEAD"}
+	if strings.HasPrefix(name, "refs/") || name != "" && strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") == "" {
+		refs = append([]string{name}, refs...)
+	}
+	for _, ref := range refs {
+		if h, ok, err := r.readRef(ref, 0); err != nil || ok {
+			return h, err
+		}
+	}
+	if len(name) >= 4 && isHex(name) {
+		return r.expandHash(strings.ToLower(name))
+	}
+	return h, fmt.Errorf("unknown revision %q", name)
+}
+
+// readRef reads a loose or packed ref, following symbolic refs.
+func (r *GitRepository) readRef(ref string, depth int) (gitHash, bool, error) {
+	var h gitHash
+	if depth > 5 {
+		return h, false, fmt.Errorf("symbolic ref loop at %s", ref)
+	}
+	if ref == "" || strings.Contains(ref, "..") {
+		return h, false, nil
+	}
+	for _, dir := range []string{r.gitDir, r.commonDir} {
+		content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(ref)))
+		if err != nil {
+			continue
+		}
+		value := strings.TrimSpace(string(content))
+		if target, ok := strings.CutPrefix(value, "ref: "); ok {
+			return r.readRef(target, depth+1)
+		}
+		if _, err := hex.Decode(h[:], []byte(value)); err != nil || len(value) != 2*len(h) {
+			return h, false, fmt.Errorf("malformed ref %s", ref)
+		}
+		return h, true, nil
+	}
+	packed, err := os.ReadFile(filepath.Join(r.commonDir, "packed-refs"))
+	if err != nil {
+		return h, false, nil
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		if value, name, ok := strings.Cut(line, " "); ok && name == ref && len(value) == 2*len(h) {
+			if _, err := hex.Decode(h[:], []byte(value)); err == nil {
+				return h, true, nil
+			}
+		}
+	}
+	return h, false, nil
+}
+
+// expandHash finds the object whose name starts with prefix.
+func (r *GitRepository) expandHash(prefix string) (gitHash, error) {
+	var h gitHash
+	matches := make(map[gitHash]bool)
+	for _, dir := range r.objectDirs {
+		entries, _ := os.ReadDir(filepath.Join(dir, prefix[:2]))
+		for _, e := range entries {
+			name := prefix[:2] + e.Name()
+			if strings.HasPrefix(name, prefix) && len(name) == 2*len(h) {
+				if _, err := hex.Decode(h[:], []byte(name)); err == nil {
+					matches[h] = true
+				}
+			}
+		}
+	}
+	packs, err := r.loadPacks()
+	if err != nil {
+		return h, err
+	}
+	for _, p := range packs {
+		for _, m := range p.withPrefix(prefix) {
+			matches[m] = true
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return h, fmt.Errorf("unknown revision %q", prefix)
+	case 1:
+		for m := range matches {
+			h = m
+		}
+		return h, nil
+	}
+	return h, fmt.Errorf("ambiguous object name %q", prefix)
+}
+
+// peelToCommit follows annotated tags to the commit they point to.
+func (r *GitRepository) peelToCommit(h gitHash) (gitHash, error) {
+	for range 10 {
+		obj, err := r.object(h)
+		if err != nil {
+			return h, err
+		}
+		switch obj.typ {
+		case "commit":
+			return h, nil
+		case "tag":
+			if h, err = headerHash(obj.data, "object"); err != nil {
+				return h, err
+			}
+		default:
+			return h, fmt.Errorf("object %s is a %s, not a commit", h, obj.typ)
+		}
+	}
+	return h, fmt.Errorf("too many nested tags at %s", h)
+}
+
+// parent returns the n-th parent of a commit, starting at 1.
+func (r *GitRepository) parent(commit gitHash, n int) (gitHash, error) {
+	obj, err := r.object(commit)
+	if err != nil {
+		return commit, err
+	}
+	i := 0
+	for _, line := range strings.Split(string(obj.data), "\n") {
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "parent "); ok {
+			if i++; i == n {
+				var h gitHash
+				_, err := hex.Decode(h[:], []byte(value))
+				return h, err
+			}
+		}
+	}
+	return commit, fmt.Errorf("commit %s has no parent %d", commit, n)
+}
+
+// headerHash returns the object named by the first header line of a commit
+// or tag starting with key.
+func headerHash(data []byte, key string) (gitHash, error) {
+	var h gitHash
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, key+" "); ok {
+			_, err := hex.Decode(h[:], []byte(value))
+			return h, err
+		}
+	}
+	return h, fmt.Errorf("missing %s header", key)
+}
+
+// object reads an object from the loose objects or the packfiles.
+func (r *GitRepository) object(h gitHash) (*gitObject, error) {
+	name := h.String()
+	for _, dir := range r.objectDirs {
+		content, err := os.ReadFile(filepath.Join(dir, name[:2], name[2:]))
+		if err != nil {
+			continue
+		}
+		return parseLooseObject(name, content)
+	}
+	packs, err := r.loadPacks()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range packs {
+		if offset, ok := p.find(h); ok {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return r.packedObject(p, offset, 0)
+		}
+	}
+	return nil, fmt.Errorf("object %s not found", name)
+}
+
+// objectSize returns the size of an object's data, reading only the
+// header of the loose object or of the pack entry.
+func (r *GitRepository) objectSize(h gitHash) (int64, error) {
+	name := h.String()
+	for _, dir := range r.objectDirs {
+		f, err := os.Open(filepath.Join(dir, name[:2], name[2:]))
+		if err != nil {
+			continue
+		}
+		defer f.Close()
+		return looseObjectSize(name, f)
+	}
+	packs, err := r.loadPacks()
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range packs {
+		if offset, ok := p.find(h); ok {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return r.packedSize(p, offset)
+		}
+	}
+	return 0, fmt.Errorf("object %s not found", name)
+}
+
+// looseObjectSize decompresses the "<type> <size>\x00" header of a loose
+// object.
+func looseObjectSize(name string, r io.Reader) (int64, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("object %s: %w", name, err)
+	}
+	defer zr.Close()
+	// The longest header is "commit " followed by a 20 digit size.
+	header, err := bufio.NewReaderSize(zr, 32).ReadSlice(0)
+	if err != nil {
+		return 0, fmt.Errorf("object %s: malformed header", name)
+	}
+	_, size, _ := strings.Cut(string(header[:len(header)-1]), " ")
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("object %s: malformed header", name)
+	}
+	return n, nil
+}
+
+// parseLooseObject decompresses a loose object: "<type> <size>\x00<data>".
+func parseLooseObject(name string, content []byte) (*gitObject, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", name, err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("object %s: %w", name, err)
+	}
+	header, data, ok := bytes.Cut(raw, []byte{0})
+	typ, size, _ := strings.Cut(string(header), " ")
+	if n, err := strconv.Atoi(size); !ok || err != nil || n != len(data) {
+		return nil, fmt.Errorf("object %s: malformed header", name)
+	}
+	return &gitObject{typ: typ, data: data}, nil
+}
+
+// tree returns the parsed entries of a tree object.
+func (r *GitRepository) tree(h gitHash) ([]gitTreeEntry, error) {
+	r.mu.Lock()
+	entries, ok := r.trees[h]
+	r.mu.Unlock()
+	if ok {
+		return entries, nil
+	}
+	obj, err := r.object(h)
+	if err != nil {
+		return nil, err
+	}
+	if obj.typ != "tree" {
+		return nil, fmt.Errorf("object %s is a %s, not a tree", h, obj.typ)
+	}
+	for data := obj.data; len(data) > 0; {
+		header, rest, ok := bytes.Cut(data, []byte{0})
+		mode, name, ok2 := strings.Cut(string(header), " ")
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if !ok || !ok2 || err != nil || len(rest) < len(gitHash{}) {
+			return nil, fmt.Errorf("tree %s: malformed entry", h)
+		}
+		e := gitTreeEntry{name: name, mode: uint32(m)}
+		copy(e.hash[:], rest)
+		entries = append(entries, e)
+		data = rest[len(e.hash):]
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	r.mu.Lock()
+	r.trees[h] = entries
+	r.mu.Unlock()
+	return entries, nil
+}
+
+// gitPack is a packfile with its version 2 index.
+type gitPack struct {
+	path    string
+	fanout  [256]uint32
+	names   []byte // sorted object names, 20 bytes each
+	offsets []uint64
+	file    *os.File // opened on first use
+}
+
+// loadPacks reads the indexes of all packfiles once.
+func (r *GitRepository) loadPacks() ([]*gitPack, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.packs != nil {
+		return r.packs, nil
+	}
+	packs := []*gitPack{}
+	for _, dir := range r.objectDirs {
+		indexes, _ := filepath.Glob(filepath.Join(dir, "pack", "*.idx"))
+		for _, idx := range indexes {
+			p, err := readPackIndex(idx)
+			if err != nil {
+				return nil, err
+			}
+			packs = append(packs, p)
+		}
+	}
+	r.packs = packs
+	return packs, nil
+}
+
+// readPackIndex reads a version 2 pack index.
+func readPackIndex(idx string) (*gitPack, error) {
+	data, err := os.ReadFile(idx)
+	if err != nil {
+		return nil, err
+	}
+	const headerLen, fanoutLen = 8, 256 * 4
+	if len(data) < headerLen+fanoutLen || !bytes.Equal(data[:headerLen], []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return nil, fmt.Errorf("%s: unsupported pack index", idx)
+	}
+	p := &gitPack{path: strings.TrimSuffix(idx, ".idx") + ".pack"}
+	for i := range p.fanout {
+		p.fanout[i] = binary.BigEndian.Uint32(data[headerLen+4*i:])
+	}
+	n := int(p.fanout[255])
+	namesAt := headerLen + fanoutLen
+	offsetsAt := namesAt + n*20 + n*4 // names, then CRCs
+	largeAt := offsetsAt + n*4
+	if len(data) < largeAt {
+		return nil, fmt.Errorf("%s: truncated pack index", idx)
+	}
+	p.names = data[namesAt : namesAt+n*20]
+	p.offsets = make([]uint64, n)
+	for i := range p.offsets {
+		off := binary.BigEndian.Uint32(data[offsetsAt+4*i:])
+		if off&0x80000000 == 0 {
+			p.offsets[i] = uint64(off)
+			continue
+		}
+		at := largeAt + 8*int(off&0x7fffffff)
+		if len(data) < at+8 {
+			return nil, fmt.Errorf("%s: truncated pack index", idx)
+		}
+		p.offsets[i] = binary.BigEndian.Uint64(data[at:])
+	}
+	return p, nil
+}
+
+// bounds returns the range of names starting with the byte b.
+func (p *gitPack) bounds(b byte) (lo, hi int) {
+	if b > 0 {
+		lo = int(p.fanout[b-1])
+	}
+	return lo, int(p.fanout[b])
+}
+
+func (p *gitPack) name(i int) []byte { return p.names[20*i : 20*i+20] }
+
+// find returns the offset of an object in the pack.
+func (p *gitPack) find(h gitHash) (uint64, bool) {
+	lo, hi := p.bounds(h[0])
+	i := lo + sort.Search(hi-lo, func(i int) bool { return bytes.Compare(p.name(lo+i), h[:]) >= 0 })
+	if i < hi && bytes.Equal(p.name(i), h[:]) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// withPrefix returns the objects of the pack whose hex names start with
+// prefix, which has at least two digits.
+func (p *gitPack) withPrefix(prefix string) []gitHash {
+	first, err := strconv.ParseUint(prefix[:2], 16, 8)
+	if err != nil {
+		return nil
+	}
+	var matches []gitHash
+	lo, hi := p.bounds(byte(first))
+	for i := lo; i < hi; i++ {
+		if strings.HasPrefix(hex.EncodeToString(p.name(i)), prefix) {
+			var h gitHash
+			copy(h[:], p.name(i))
+			matches = append(matches, h)
+		}
+	}
+	return matches
+}
+
+// Pack object types.
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packTypes = map[int]string{packCommit: "commit", packTree: "tree", packBlob: "blob", packTag: "tag"}
+
+// packedObject reads the object at offset, applying deltas. It must be
+// called with r.mu held.
+func (r *GitRepository) packedObject(p *gitPack, offset uint64, depth int) (*gitObject, error) {
+	if depth > 100 {
+		return nil, fmt.Errorf("%s: delta chain too long at %d", p.path, offset)
+	}
+	hdr, br, err := p.header(offset)
+	if err != nil {
+		return nil, err
+	}
+	typ, size := hdr.typ, hdr.size
+
+	var base *gitObject
+	switch typ {
+	case packOfsDelta:
+		if base, err = r.packedObject(p, hdr.base, depth+1); err != nil {
+			return nil, err
+		}
+	case packRefDelta:
+		h := hdr.baseHash
+		r.mu.Unlock()
+		base, err = r.object(h)
+		r.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	// The size in the header is not trusted for allocation: the data is read
+	// up to one byte past it, then checked against it.
+	data, err := io.ReadAll(io.LimitReader(zr, int64(min(size, math.MaxInt64-1))+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: object at %d: %w", p.path, offset, err)
+	}
+	if uint64(len(data)) != size {
+		return nil, fmt.Errorf("%s: object at %d: size %d, header says %d", p.path, offset, len(data), size)
+	}
+	if base == nil {
+		name, ok := packTypes[typ]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown object type %d at %d", p.path, typ, offset)
+		}
+		return &gitObject{typ: name, data: data}, nil
+	}
+	patched, err := applyDelta(base.data, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: object at %d: %w", p.path, offset, err)
+	}
+	return &gitObject{typ: base.typ, data: patched}, nil
+}
+
+// packHeader is the header of a packfile entry.
+type packHeader struct {
+	typ  int
+	size uint64 // size of the entry's data, or of the delta for deltas
+	// base is the offset of the base object of an ofs-delta, baseHash
+	// the name of the base object of a ref-delta.
+	base     uint64
+	baseHash gitHash
+}
+
+// header reads the header of the entry at offset, returning a reader
+// positioned at the start of its compressed data. It must be called with
+// the repository's mu held.
+func (p *gitPack) header(offset uint64) (packHeader, *bufio.Reader, error) {
+	var hdr packHeader
+	if p.file == nil {
+		f, err := os.Open(p.path)
+		if err != nil {
+			return hdr, nil, err
+		}
+		p.file = f
+	}
+	br := bufio.NewReader(io.NewSectionReader(p.file, int64(offset), 1<<62))
+	c, err := br.ReadByte()
+	if err != nil {
+		return hdr, nil, err
+	}
+	hdr.typ = int(c>>4) & 7
+	hdr.size = uint64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = br.ReadByte(); err != nil {
+			return hdr, nil, err
+		}
+		hdr.size |= uint64(c&0x7f) << shift
+	}
+
+	switch hdr.typ {
+	case packOfsDelta:
+		c, err := br.ReadByte()
+		if err != nil {
+			return hdr, nil, err
+		}
+		rel := uint64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = br.ReadByte(); err != nil {
+				return hdr, nil, err
+			}
+			rel = (rel+1)<<7 | uint64(c&0x7f)
+		}
+		if rel > offset {
+			return hdr, nil, fmt.Errorf("%s: bad delta offset at %d", p.path, offset)
+		}
+		hdr.base = offset - rel
+	case packRefDelta:
+		if _, err := io.ReadFull(br, hdr.baseHash[:]); err != nil {
+			return hdr, nil, err
+		}
+	}
+	return hdr, br, nil
+}
+
+// packedSize returns the size of the object at offset without
+// reconstructing it: the size in the entry header, or for deltas the
+// result size at the start of the delta data. It must be called with
+// r.mu held.
+func (r *GitRepository) packedSize(p *gitPack, offset uint64) (int64, error) {
+	hdr, br, err := p.header(offset)
+	if err != nil {
+		return 0, err
+	}
+	if hdr.typ != packOfsDelta && hdr.typ != packRefDelta {
+		return int64(hdr.size), nil
+	}
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	zbr := bufio.NewReader(zr)
+	var size uint64
+	// The delta data starts with the base size and then the result size.
+	for range 2 {
+		if size, err = binary.ReadUvarint(zbr); err != nil {
+			return 0, fmt.Errorf("%s: object at %d: %w", p.path, offset, err)
+		}
+	}
+	return int64(size), nil
+}
+
+// applyDelta applies a git delta to base.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	varint := func() (uint64, error) {
+		var v uint64
+		for shift := 0; ; shift += 7 {
+			if len(delta) == 0 {
+				return 0, errors.New("truncated delta")
+			}
+			c := delta[0]
+			delta = delta[1:]
+			v |= uint64(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return v, nil
+			}
+		}
+	}
+	srcSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+	dstSize, err := varint()
+	if err != nil {
+		return nil, err
+	}
+	if srcSize != uint64(len(base)) {
+		return nil, errors.New("delta base size mismatch")
+	}
+	// Preallocate no more than base and delta could plausibly expand to, so
+	// that a corrupt result size does not exhaust memory.
+	out := make([]byte, 0, min(dstSize, uint64(len(base)+len(delta))))
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			var off, n uint64
+			for i := 0; i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return nil, errors.New("truncated delta")
+				}
+				if i < 4 {
+					off |= uint64(delta[0]) << (8 * i)
+				} else {
+					n |= uint64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if off+n > uint64(len(base)) {
+				return nil, errors.New("delta copy out of range")
+			}
+			out = append(out, base[off:off+n]...)
+		case op != 0:
+			if int(op) > len(delta) {
+				return nil, errors.New("truncated delta")
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		default:
+			return nil, errors.New("invalid delta opcode")
+		}
+	}
+	if uint64(len(out)) != dstSize {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return out, nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// GitFileSystem is a read-only FileSystem over the tree of a commit of a
+// GitRepository, for evaluating a workspace at a past revision. Paths are
+// slash-separated and rooted at "/". Symbolic links read as their target
+// path and submodules as empty directories.
+type GitFileSystem struct {
+	repo   *GitRepository
+	commit gitHash
+	tree   gitHash
+}
+
+// FileSystem returns the file system of the commit named by rev, in the
+// syntax of Resolve.
+func (r *GitRepository) FileSystem(rev string) (*GitFileSystem, error) {
+	commit, err := r.resolve(rev)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := r.object(commit)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := headerHash(obj.data, "tree")
+	if err != nil {
+		return nil, fmt.Errorf("commit %s: %w", commit, err)
+	}
+	return &GitFileSystem{repo: r, commit: commit, tree: tree}, nil
+}
+
+// Commit returns the name of the commit the file system reads.
+func (f *GitFileSystem) Commit() string { return f.commit.String() }
+
+// lookup returns the tree entry at p. The root is a directory entry.
+func (f *GitFileSystem) lookup(op, p string) (gitTreeEntry, error) {
+	e := gitTreeEntry{name: "/", mode: gitModeDir, hash: f.tree}
+	name := fsName(p)
+	if name == "." {
+		return e, nil
+	}
+	for _, part := range strings.Split(name, "/") {
+		if e.mode != gitModeDir {
+			return e, &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+		}
+		entries, err := f.repo.tree(e.hash)
+		if err != nil {
+			return e, &fs.PathError{Op: op, Path: p, Err: err}
+		}
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].name >= part })
+		if i == len(entries) || entries[i].name != part {
+			return e, &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+		}
+		e = entries[i]
+	}
+	return e, nil
+}
+
+// ReadFile reads the file at path.
+func (f *GitFileSystem) ReadFile(p string) ([]byte, error) {
+	e, err := f.lookup("read", p)
+	if err != nil {
+		return nil, err
+	}
+	if e.mode == gitModeDir || e.mode == gitModeGitlink {
+		return nil, &fs.PathError{Op: "read", Path: p, Err: errors.New("is a directory")}
+	}
+	obj, err := f.repo.object(e.hash)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: p, Err: err}
+	}
+	return obj.data, nil
+}
+
+// Stat returns file info for the given path. The size of a file is taken
+// from its object header; its content is not read.
+func (f *GitFileSystem) Stat(p string) (fs.FileInfo, error) {
+	e, err := f.lookup("stat", p)
+	if err != nil {
+		return nil, err
+	}
+	return f.info(e)
+}
+
+func (f *GitFileSystem) info(e gitTreeEntry) (*gitFileInfo, error) {
+	info := &gitFileInfo{entry: e}
+	if e.mode != gitModeDir && e.mode != gitModeGitlink {
+		size, err := f.repo.objectSize(e.hash)
+		if err != nil {
+			return nil, err
+		}
+		info.size = size
+	}
+	return info, nil
+}
+
+// ReadDir returns the entries of the directory at path, sorted by name.
+func (f *GitFileSystem) ReadDir(p string) ([]fs.DirEntry, error) {
+	e, err := f.lookup("readdir", p)
+	if err != nil {
+		return nil, err
+	}
+	switch e.mode {
+	case gitModeGitlink:
+		return nil, nil
+	case gitModeDir:
+	default:
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: errors.New("not a directory")}
+	}
+	entries, err := f.repo.tree(e.hash)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: err}
+	}
+	dirEntries := make([]fs.DirEntry, len(entries))
+	for i, e := range entries {
+		dirEntries[i] = &gitDirEntry{fs: f, entry: e}
+	}
+	return dirEntries, nil
+}
+
+// Join joins path elements with slashes.
+func (f *GitFileSystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Abs returns the path rooted at "/".
+func (f *GitFileSystem) Abs(p string) (string, error) {
+	return rootedName(p), nil
+}
+
+// gitFileInfo implements fs.FileInfo for tree entries.
+type gitFileInfo struct {
+	entry gitTreeEntry
+	size  int64
+}
+
+func (fi *gitFileInfo) Name() string       { return fi.entry.name }
+func (fi *gitFileInfo) Size() int64        { return fi.size }
+func (fi *gitFileInfo) Mode() fs.FileMode  { return gitFileMode(fi.entry.mode) }
+func (fi *gitFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *gitFileInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi *gitFileInfo) Sys() any           { return nil }
+
+// gitDirEntry implements fs.DirEntry, reading the file for Info only.
+type gitDirEntry struct {
+	fs    *GitFileSystem
+	entry gitTreeEntry
+}
+
+func (d *gitDirEntry) Name() string               { return d.entry.name }
+func (d *gitDirEntry) IsDir() bool                { return d.Type().IsDir() }
+func (d *gitDirEntry) Type() fs.FileMode          { return gitFileMode(d.entry.mode).Type() }
+func (d *gitDirEntry) Info() (fs.FileInfo, error) { return d.fs.info(d.entry) }
+
+func gitFileMode(mode uint32) fs.FileMode {
+	switch mode {
+	case gitModeDir, gitModeGitlink:
+		return fs.ModeDir | 0o755
+	case gitModeSymlink:
+		return fs.ModeSymlink | 0o777
+	case gitModeExec:
+		return 0o755
+	}
+	return 0o644
+}
@@ -0,0 +1,164 @@
+package loader
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGitRepo is a git repository created by a test.
+type testGitRepo struct {
+	t   *testing.T
+	dir string
+}
+
+// newTestGitRepo initializes a repository on branch main, skipping the test
+// if git is not installed.
+func newTestGitRepo(t *testing.T) *testGitRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	r := &testGitRepo{t: t, dir: t.TempDir()}
+	r.git("init", "-q", "-b", "main")
+	return r
+}
+
+// git runs a git command in the repository and returns its output.
+func (r *testGitRepo) git(args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "gc.auto=0"}, args...)...)
+	cmd.Dir = r.dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes files, keyed by slash separated path, and commits them.
+func (r *testGitRepo) commit(message string, files map[string]string) {
+	r.t.Helper()
+	writeFiles(r.t, r.dir, files)
+	r.git("add", ".")
+	r.git("commit", "-q", "-m", message)
+}
+
+func TestGitFileSystem(t *testing.T) {
+	r := newTestGitRepo(t)
+	var large strings.Builder
+	for i := range 200 {
+		fmt.Fprintf(&large, "// line %d of a file large enough to be stored as a delta\n", i)
+	}
+	r.commit("first", testFiles)
+	r.commit("second", map[string]string{"app/a.go": large.String(), "app/copy.txt": large.String() + "// copy\n", "app/sub/c.go": "package sub"})
+	r.git("tag", "-a", "v2", "-m", "version 2")
+	// Pack the first two commits, storing one of app/a.go and app/copy.txt
+	// as a delta of the other.
+	r.git("gc", "-q", "--aggressive")
+	r.commit("third", map[string]string{"app/a.go": large.String() + "// changed\n"})
+
+	repo, err := OpenGitRepository(r.dir)
+	if err != nil {
+		t.Fatalf("OpenGitRepository failed: %v", err)
+	}
+	defer repo.Close()
+
+	first := r.git("rev-parse", "HEAD~2")
+	for _, rev := range []string{first, first[:7], "HEAD~2", "main^^", "v2~1"} {
+		if got, err := repo.Resolve(rev); err != nil || got != first {
+			t.Errorf("Resolve(%s) = %s, %v, want %s", rev, got, err, first)
+		}
+	}
+	if _, err := repo.Resolve("no-such-branch"); err == nil {
+		t.Error("Resolve(no-such-branch) succeeded")
+	}
+
+	fsys, err := repo.FileSystem(first)
+	if err != nil {
+		t.Fatalf("FileSystem(%s) failed: %v", first, err)
+	}
+	if fsys.Commit() != first {
+		t.Errorf("Commit() = %s, want %s", fsys.Commit(), first)
+	}
+	checkFileSystem(t, fsys, "/")
+
+	for rev, want := range map[string]string{"v2": large.String(), "main": large.String() + "// changed\n"} {
+		fsys, err := repo.FileSystem(rev)
+		if err != nil {
+			t.Fatalf("FileSystem(%s) failed: %v", rev, err)
+		}
+		content, err := fsys.ReadFile("app/a.go")
+		if err != nil || string(content) != want {
+			t.Errorf("%s: ReadFile(app/a.go) = %d bytes, %v, want %d", rev, len(content), err, len(want))
+		}
+		if info, err := fsys.Stat("/app/a.go"); err != nil || info.Size() != int64(len(want)) {
+			t.Errorf("%s: Stat(app/a.go) = %v, %v, want %d bytes", rev, info, err, len(want))
+		}
+		if got := listDir(t, fsys, "app/sub"); got != "b.go c.go" {
+			t.Errorf("%s: ReadDir(app/sub) = %s", rev, got)
+		}
+		if _, err := fsys.Stat("/app/missing.go"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: Stat(missing) error = %v", rev, err)
+		}
+	}
+	// Sizes come from the object and delta headers and agree with the
+	// content, whether the object is loose, packed or a delta.
+	for _, rev := range []string{first, "v2", "main"} {
+		fsys, err := repo.FileSystem(rev)
+		if err != nil {
+			t.Fatalf("FileSystem(%s) failed: %v", rev, err)
+		}
+		entries, err := fsys.ReadDir("app")
+		if err != nil {
+			t.Fatalf("%s: ReadDir(app) failed: %v", rev, err)
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				t.Fatalf("%s: Info(%s) failed: %v", rev, e.Name(), err)
+			}
+			content, _ := fsys.ReadFile("app/" + e.Name())
+			if info.Size() != int64(len(content)) {
+				t.Errorf("%s: app/%s has size %d, want %d", rev, e.Name(), info.Size(), len(content))
+			}
+		}
+	}
+}
+
+func TestGitPackedObjectSize(t *testing.T) {
+	// A blob entry whose header claims far more data than it holds.
+	var pack bytes.Buffer
+	pack.Write([]byte{0xbf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x0f})
+	zw := zlib.NewWriter(&pack)
+	zw.Write([]byte("hello"))
+	zw.Close()
+	path := filepath.Join(t.TempDir(), "test.pack")
+	if err := os.WriteFile(path, pack.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &GitRepository{}
+	p := &gitPack{path: path}
+	defer func() {
+		if p.file != nil {
+			p.file.Close()
+		}
+	}()
+	r.mu.Lock()
+	_, err := r.packedObject(p, 0, 0)
+	r.mu.Unlock()
+	if err == nil || !strings.Contains(err.Error(), "header says") {
+		t.Errorf("packedObject with an oversized header: got error %v, want size mismatch", err)
+	}
+}
@@ -0,0 +1,82 @@
+package loader
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+// Invalidate evicts the cached modules read from any of changedPaths, and
+// the modules that load them directly or transitively, so that the next
+// load re-evaluates them. Modules whose load is still in progress are kept.
+// It returns the canonical labels of the evicted modules, sorted.
+//
+// This is a lightweight version of Skyframe's change pruning: a module only
+// depends on its source file and on the modules it loads.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/skyframe/InvalidatingNodeVisitor.java
+func (l *BzlFileLoader) Invalidate(changedPaths []string) []string {
+	changed := make(map[string]bool, len(changedPaths))
+	for _, p := range changedPaths {
+		changed[l.absPath(p)] = true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	evicted := make(map[string]bool)
+	for {
+		progress := false
+		for label, entry := range l.cache {
+			if evicted[label] || !isDone(entry) {
+				continue
+			}
+			if changed[entry.path] || loadsAny(entry.loads, evicted) {
+				evicted[label] = true
+				progress = true
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+
+	labels := make([]string, 0, len(evicted))
+	for label := range evicted {
+		delete(l.cache, label)
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// absPath normalizes a path so that the paths recorded for modules compare
+// equal to the changed paths passed to Invalidate.
+func (l *BzlFileLoader) absPath(path string) string {
+	return AbsPath(l.fs, path)
+}
+
+// AbsPath returns the absolute, cleaned form of path in fsys, or the cleaned
+// path if fsys cannot make it absolute.
+func AbsPath(fsys FileSystem, path string) string {
+	if abs, err := fsys.Abs(path); err == nil {
+		return filepath.Clean(abs)
+	}
+	return filepath.Clean(path)
+}
+
+func isDone(entry *loadEntry) bool {
+	select {
+	case <-entry.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func loadsAny(loads []string, labels map[string]bool) bool {
+	for _, label := range loads {
+		if labels[label] {
+			return true
+		}
+	}
+	return false
+}
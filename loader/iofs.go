@@ -0,0 +1,63 @@
+package loader
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IOFileSystem adapts an io/fs.FS, such as an embed.FS, an fstest.MapFS or
+// a zip.Reader, to FileSystem. Its paths are slash-separated and rooted at
+// "/": "/pkg/BUILD" and "pkg/BUILD" both name the file "pkg/BUILD" of the
+// fs.FS.
+type IOFileSystem struct {
+	fsys fs.FS
+}
+
+// NewIOFileSystem creates a FileSystem reading from fsys.
+func NewIOFileSystem(fsys fs.FS) *IOFileSystem {
+	return &IOFileSystem{fsys: fsys}
+}
+
+// FS returns the adapted fs.FS.
+func (f *IOFileSystem) FS() fs.FS { return f.fsys }
+
+// ReadFile reads the file at path.
+func (f *IOFileSystem) ReadFile(path string) ([]byte, error) {
+	return fs.ReadFile(f.fsys, fsName(path))
+}
+
+// Stat returns file info for the given path.
+func (f *IOFileSystem) Stat(path string) (fs.FileInfo, error) {
+	return fs.Stat(f.fsys, fsName(path))
+}
+
+// ReadDir returns the entries of the directory at path, sorted by name.
+func (f *IOFileSystem) ReadDir(path string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(f.fsys, fsName(path))
+}
+
+// Join joins path elements with slashes.
+func (f *IOFileSystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// Abs returns the path rooted at "/".
+func (f *IOFileSystem) Abs(p string) (string, error) {
+	return rootedName(p), nil
+}
+
+// fsName converts a path rooted at "/" to an io/fs name.
+func fsName(p string) string {
+	name := strings.TrimPrefix(rootedName(p), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// rootedName returns the clean, slash-separated form of p rooted at "/".
+func rootedName(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
@@ -0,0 +1,14 @@
+package loader
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestIOFileSystem(t *testing.T) {
+	mapFS := fstest.MapFS{}
+	for name, content := range testFiles {
+		mapFS[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	checkFileSystem(t, NewIOFileSystem(mapFS), "/")
+}
@@ -18,8 +18,8 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/albertocavalcante/starlark-go-bazel/types"
 	"go.starlark.net/starlark"
-	"go.starlark.net/syntax"
 )
 
 // Thread context keys for storing loader state.
@@ -49,6 +49,14 @@ type FileSystem interface {
 	Abs(path string) (string, error)
 }
 
+// DirFileSystem is a FileSystem that can also list directories, which
+// glob() needs.
+type DirFileSystem interface {
+	FileSystem
+	// ReadDir returns the entries of the directory at path, sorted by name.
+	ReadDir(path string) ([]fs.DirEntry, error)
+}
+
 // Loader loads Starlark files by path.
 type Loader interface {
 	// Load loads a Starlark file by path.
@@ -102,6 +110,15 @@ func (f *OSFileSystem) Stat(path string) (os.FileInfo, error) {
 	return os.Stat(fullPath)
 }
 
+// ReadDir returns the entries of the directory at path, sorted by name.
+func (f *OSFileSystem) ReadDir(path string) ([]fs.DirEntry, error) {
+	fullPath := path
+	if !filepath.IsAbs(path) {
+		fullPath = filepath.Join(f.root, path)
+	}
+	return os.ReadDir(fullPath)
+}
+
 // Join joins path elements.
 func (f *OSFileSystem) Join(elem ...string) string {
 	return filepath.Join(elem...)
@@ -204,9 +221,26 @@ type BzlFileLoader struct {
 	// These are the "predeclared environment" in Bazel terminology.
 	predeclared starlark.StringDict
 
-	// Repository mapping for external repository resolution.
-	// Maps apparent repository names to canonical repository roots.
-	repoMapping map[string]string
+	// repoRoots maps canonical repository names to their root directories.
+	repoRoots map[string]string
+
+	// repoMappings holds the repo mapping of each repository, keyed by
+	// canonical name ("" for the main repository). Repositories without an
+	// entry see every repository under its canonical name.
+	repoMappings map[string]*types.RepoMapping
+
+	// resolveRepo locates repositories missing from repoRoots, if set.
+	resolveRepo func(repo string) (string, bool, error)
+
+	// builtinModules are modules provided by the application rather than
+	// read from disk, keyed by canonical label (e.g. @bazel_tools//...).
+	builtinModules map[string]starlark.StringDict
+
+	// programs caches the compiled programs of loaded files.
+	programs *ProgramCache
+
+	// locator finds the packages of the main repository, if set.
+	locator *PackageLocator
 
 	// Cache of loaded modules, keyed by canonical label.
 	// This matches Bazel's approach of caching BzlLoadValues.
@@ -217,9 +251,15 @@ type BzlFileLoader struct {
 // loadEntry represents a cached module or a module being loaded.
 // The ready channel is used to wait for in-progress loads.
 type loadEntry struct {
-	globals starlark.StringDict
-	err     error
-	ready   chan struct{} // closed when load completes
+	globals    starlark.StringDict
+	bzlContext *BzlInitThreadContext
+	err        error
+	ready      chan struct{} // closed when load completes
+
+	// path is the file the module was read from and loads the modules it
+	// loaded, also when loading failed. Invalidate uses them.
+	path  string
+	loads []string
 }
 
 // BzlFileLoaderOption configures a BzlFileLoader.
@@ -232,10 +272,70 @@ func WithPredeclared(predeclared starlark.StringDict) BzlFileLoaderOption {
 	}
 }
 
-// WithRepoMapping sets the repository mapping for external repos.
+// WithRepoMapping sets the root directories of external repos, keyed by
+// repository name. The names are visible unchanged from every repository
+// without a mapping of its own (WORKSPACE semantics).
 func WithRepoMapping(mapping map[string]string) BzlFileLoaderOption {
 	return func(l *BzlFileLoader) {
-		l.repoMapping = mapping
+		for name, root := range mapping {
+			l.repoRoots[name] = root
+		}
+	}
+}
+
+// WithRepoRoots sets the root directories of external repos, keyed by
+// canonical repository name.
+func WithRepoRoots(roots map[string]string) BzlFileLoaderOption {
+	return WithRepoMapping(roots)
+}
+
+// WithRepoMappings sets the repo mapping of each repository, keyed by
+// canonical name ("" for the main repository): the apparent names visible
+// from the repository and the canonical repositories they refer to.
+// Reference: RepositoryMappingFunction.java
+func WithRepoMappings(mappings map[string]map[string]string) BzlFileLoaderOption {
+	return func(l *BzlFileLoader) {
+		for owner, entries := range mappings {
+			l.repoMappings[owner] = types.NewRepoMapping(owner, entries)
+		}
+	}
+}
+
+// WithRepoResolver sets a function locating repositories without a known
+// root, such as WORKSPACE repositories that are fetched on first use. It
+// returns false if the repository does not exist.
+func WithRepoResolver(resolve func(repo string) (root string, ok bool, err error)) BzlFileLoaderOption {
+	return func(l *BzlFileLoader) {
+		l.resolveRepo = resolve
+	}
+}
+
+// WithBuiltinModule makes a module with the given label loadable without a
+// file, like the modules Bazel embeds in @bazel_tools.
+func WithBuiltinModule(label string, globals starlark.StringDict) BzlFileLoaderOption {
+	return func(l *BzlFileLoader) {
+		if parsed, err := types.ParseLabel(label); err == nil {
+			label = parsed.String()
+		}
+		l.builtinModules[label] = globals
+	}
+}
+
+// WithProgramCache sets the cache of compiled programs, such as
+// SharedProgramCache("") to share them with the other loaders of the
+// process. By default each loader has its own.
+func WithProgramCache(c *ProgramCache) BzlFileLoaderOption {
+	return func(l *BzlFileLoader) {
+		l.programs = c
+	}
+}
+
+// WithPackageLocator looks up the packages of the main repository with
+// locator, following its package path and deleted packages, instead of
+// under repoRoot alone.
+func WithPackageLocator(locator *PackageLocator) BzlFileLoaderOption {
+	return func(l *BzlFileLoader) {
+		l.locator = locator
 	}
 }
 
@@ -243,11 +343,14 @@ func WithRepoMapping(mapping map[string]string) BzlFileLoaderOption {
 // The repoRoot is the path to the workspace root (main repository).
 func NewBzlFileLoader(fs FileSystem, repoRoot string, opts ...BzlFileLoaderOption) *BzlFileLoader {
 	l := &BzlFileLoader{
-		fs:          fs,
-		repoRoot:    repoRoot,
-		predeclared: make(starlark.StringDict),
-		repoMapping: make(map[string]string),
-		cache:       make(map[string]*loadEntry),
+		fs:             fs,
+		repoRoot:       repoRoot,
+		predeclared:    make(starlark.StringDict),
+		repoRoots:      make(map[string]string),
+		repoMappings:   make(map[string]*types.RepoMapping),
+		builtinModules: make(map[string]starlark.StringDict),
+		programs:       NewProgramCache(""),
+		cache:          make(map[string]*loadEntry),
 	}
 	for _, opt := range opts {
 		opt(l)
@@ -269,10 +372,20 @@ func NewBzlFileLoader(fs FileSystem, repoRoot string, opts ...BzlFileLoaderOptio
 // Reference: BzlLoadFunction.computeInternal() and InliningState.beginLoad()/finishLoad()
 func (l *BzlFileLoader) Load(thread *starlark.Thread, module string) (starlark.StringDict, error) {
 	// Resolve the module string to a canonical label and filesystem path.
-	label, path, err := l.resolveModule(thread, module)
+	resolved, path, err := l.resolveModule(thread, module)
 	if err != nil {
 		return nil, fmt.Errorf("load(%q): %w", module, err)
 	}
+	label := resolved.String()
+	if ctx := GetBzlContext(thread); ctx != nil {
+		if err := CheckSclLoad(ctx.Label, label); err != nil {
+			return nil, err
+		}
+	}
+	if globals, ok := l.builtinModules[label]; ok {
+		recordLoad(thread, label, nil)
+		return globals, nil
+	}
 
 	// Check for load cycle.
 	// Bazel uses a LinkedHashSet<BzlLoadValue.Key> for cycle detection.
@@ -291,14 +404,20 @@ func (l *BzlFileLoader) Load(thread *starlark.Thread, module string) (starlark.S
 	entry, ok := l.cache[label]
 	if !ok {
 		// Start a new load.
-		entry = &loadEntry{ready: make(chan struct{})}
+		entry = &loadEntry{ready: make(chan struct{}), path: l.absPath(path)}
 		l.cache[label] = entry
 		l.mu.Unlock()
 
 		// Perform the load (outside the lock).
-		globals, loadErr := l.loadFile(thread, label, path, loadStack)
+		globals, bzlContext, loadErr := l.loadFile(thread, resolved, path, loadStack)
 		entry.globals = globals
 		entry.err = loadErr
+		if bzlContext != nil {
+			entry.loads = bzlContext.Loads
+			if loadErr == nil {
+				entry.bzlContext = bzlContext
+			}
+		}
 		close(entry.ready)
 	} else {
 		l.mu.Unlock()
@@ -307,97 +426,152 @@ func (l *BzlFileLoader) Load(thread *starlark.Thread, module string) (starlark.S
 	}
 
 	if entry.err != nil {
+		recordLoad(thread, label, nil)
 		return nil, entry.err
 	}
+	recordLoad(thread, label, entry.bzlContext.TransitiveDigest)
+
+	// Loads made by a file in a package must respect the loaded file's
+	// visibility(). Loads made directly by Go callers are not checked.
+	if thread.Local(ThreadKeyCurrentPkg) != nil {
+		if err := checkLoadVisibility(entry.bzlContext, label, GetCurrentRepo(thread), GetCurrentPackage(thread)); err != nil {
+			return nil, err
+		}
+	}
 	return entry.globals, nil
 }
 
+// Result returns the result of a loaded module, keyed by canonical label.
+func (l *BzlFileLoader) Result(label string) (*LoadResult, bool) {
+	l.mu.Lock()
+	entry, ok := l.cache[label]
+	l.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	<-entry.ready
+	if entry.err != nil {
+		return nil, false
+	}
+	return &LoadResult{Globals: entry.globals, TransitiveDigest: entry.bzlContext.TransitiveDigest}, true
+}
+
+// Results returns the results of the modules loaded successfully so far,
+// keyed by canonical label. Loads in progress are not included.
+func (l *BzlFileLoader) Results() map[string]*LoadResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	results := make(map[string]*LoadResult, len(l.cache))
+	for label, entry := range l.cache {
+		if isDone(entry) && entry.err == nil {
+			results[label] = &LoadResult{Globals: entry.globals, TransitiveDigest: entry.bzlContext.TransitiveDigest}
+		}
+	}
+	return results
+}
+
+// InitContext returns the initialization context of a loaded module, which
+// records its visibility(), keyed by canonical label.
+func (l *BzlFileLoader) InitContext(label string) (*BzlInitThreadContext, bool) {
+	l.mu.Lock()
+	entry, ok := l.cache[label]
+	l.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	<-entry.ready
+	return entry.bzlContext, entry.bzlContext != nil
+}
+
 // resolveModule resolves a module string to a canonical label and filesystem path.
 //
 // Label resolution rules (from BzlLoadFunction.getLoadLabels()):
-// 1. "@repo//pkg:file.bzl" - External repository label
-// 2. "//pkg:file.bzl" - Main repository label
-// 3. ":file.bzl" - Relative to current package
-// 4. "file.bzl" - Relative to current package (legacy, discouraged)
+// 1. "@repo//pkg:file.bzl" - External repository label, resolved through the
+// repo mapping of the loading file's repository
+// 2. "@@repo//pkg:file.bzl" - Canonical repository label
+// 3. "//pkg:file.bzl" - Label in the loading file's repository
+// 4. ":file.bzl" - Relative to current package
+// 5. "file.bzl" - Relative to current package (legacy, discouraged)
 //
-// The returned label is the canonical form (e.g., "@main//pkg:file.bzl" for main repo).
+// The returned label is the canonical form (e.g., "@@rules_foo+//pkg:file.bzl").
 // The returned path is the filesystem path to read.
-func (l *BzlFileLoader) resolveModule(thread *starlark.Thread, module string) (label, path string, err error) {
-	currentPkg := GetCurrentPackage(thread)
+func (l *BzlFileLoader) resolveModule(thread *starlark.Thread, module string) (label *types.Label, path string, err error) {
 	currentRepo := GetCurrentRepo(thread)
+	c := types.LabelContext{
+		Repo:        currentRepo,
+		Pkg:         GetCurrentPackage(thread),
+		RepoMapping: l.RepoMapping(currentRepo),
+	}
 
-	var repo, pkg, file string
-
-	switch {
-	case strings.HasPrefix(module, "@"):
-		// External repository: @repo//pkg:file.bzl
-		idx := strings.Index(module, "//")
-		if idx == -1 {
-			return "", "", fmt.Errorf("invalid label %q: missing //", module)
-		}
-		repo = module[1:idx]
-		rest := module[idx+2:]
-		pkg, file, err = splitPkgTarget(rest)
-		if err != nil {
-			return "", "", err
-		}
-
-	case strings.HasPrefix(module, "//"):
-		// Main repository: //pkg:file.bzl
-		repo = currentRepo // Stay in current repo context
-		rest := module[2:]
-		pkg, file, err = splitPkgTarget(rest)
-		if err != nil {
-			return "", "", err
-		}
-
-	case strings.HasPrefix(module, ":"):
-		// Relative to current package: :file.bzl
-		repo = currentRepo
-		pkg = currentPkg
-		file = module[1:]
+	if strings.HasPrefix(module, "@") && !strings.Contains(module, "//") {
+		return nil, "", fmt.Errorf("invalid label %q: missing //", module)
+	}
 
-	default:
-		// Legacy relative path (discouraged but supported)
-		repo = currentRepo
-		pkg = currentPkg
-		file = module
+	label, err = types.ParseLabelInContext(module, c)
+	if err != nil {
+		return nil, "", err
 	}
 
 	// Validate .bzl extension.
 	// Reference: BzlLoadFunction.checkValidLoadLabel()
-	if !strings.HasSuffix(file, ".bzl") && !strings.HasSuffix(file, ".scl") {
-		return "", "", fmt.Errorf("file must have .bzl or .scl extension, got %q", file)
+	if !strings.HasSuffix(label.Name(), ".bzl") && !strings.HasSuffix(label.Name(), ".scl") {
+		return nil, "", fmt.Errorf("file must have .bzl or .scl extension, got %q", label.Name())
 	}
 
-	// Build canonical label.
-	if repo != "" {
-		label = fmt.Sprintf("@%s//%s:%s", repo, pkg, file)
-	} else {
-		label = fmt.Sprintf("//%s:%s", pkg, file)
+	if _, ok := l.builtinModules[label.String()]; ok {
+		return label, "", nil
 	}
 
 	// Resolve filesystem path.
 	repoRoot := l.repoRoot
-	if repo != "" {
-		if mappedRoot, ok := l.repoMapping[repo]; ok {
-			repoRoot = mappedRoot
+	if repo := label.Repo(); repo != "" {
+		root, ok, err := l.lookupRepo(repo)
+		if err != nil {
+			return nil, "", err
+		}
+		if ok {
+			repoRoot = root
 		} else if repo != "main" {
-			return "", "", fmt.Errorf("unknown repository %q", repo)
+			return nil, "", fmt.Errorf("unknown repository %q", repo)
+		}
+	} else if l.locator != nil {
+		// Reference: BzlLoadFunction.java checkLabelPackage via ContainingPackageLookupValue
+		if l.locator.IsDeleted(label.Pkg()) {
+			return nil, "", fmt.Errorf("label '%s' is invalid because '%s' is not a package", label, label.Pkg())
+		}
+		if root, ok := l.locator.PackageRoot(label.Pkg()); ok {
+			repoRoot = root
 		}
 	}
 
-	path = l.fs.Join(repoRoot, pkg, file)
+	path = l.fs.Join(repoRoot, label.Pkg(), label.Name())
 	return label, path, nil
 }
 
-// splitPkgTarget splits "pkg:target" or "pkg/subpkg:target" into package and target.
-func splitPkgTarget(s string) (pkg, target string, err error) {
-	idx := strings.LastIndex(s, ":")
-	if idx == -1 {
-		return "", "", fmt.Errorf("invalid label: missing colon in %q", s)
+// lookupRepo returns the root directory of a repository, consulting the
+// repo resolver on a miss.
+func (l *BzlFileLoader) lookupRepo(repo string) (string, bool, error) {
+	l.mu.Lock()
+	root, ok := l.repoRoots[repo]
+	l.mu.Unlock()
+	if ok || l.resolveRepo == nil {
+		return root, ok, nil
+	}
+	root, ok, err := l.resolveRepo(repo)
+	if err != nil || !ok {
+		return "", false, err
 	}
-	return s[:idx], s[idx+1:], nil
+	l.mu.Lock()
+	l.repoRoots[repo] = root
+	l.mu.Unlock()
+	return root, true, nil
+}
+
+// RepoMapping returns the repo mapping of the repository with the given
+// canonical name, or nil if the repository sees every repository under its
+// canonical name.
+func (l *BzlFileLoader) RepoMapping(repo string) *types.RepoMapping {
+	return l.repoMappings[repo]
 }
 
 // loadFile actually loads and executes a .bzl file.
@@ -408,11 +582,13 @@ func splitPkgTarget(s string) (pkg, target string, err error) {
 // 2. Parse and compile
 // 3. Execute with a child thread that has updated load stack
 // 4. Extract exported globals
-func (l *BzlFileLoader) loadFile(thread *starlark.Thread, label, path string, parentStack []string) (starlark.StringDict, error) {
+func (l *BzlFileLoader) loadFile(thread *starlark.Thread, resolved *types.Label, path string, parentStack []string) (starlark.StringDict, *BzlInitThreadContext, error) {
+	label := resolved.String()
+
 	// Read source.
 	source, err := l.fs.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("reading %s: %w", path, err)
+		return nil, nil, fmt.Errorf("reading %s: %w", path, err)
 	}
 
 	// Create a child thread for this module's execution.
@@ -426,43 +602,52 @@ func (l *BzlFileLoader) loadFile(thread *starlark.Thread, label, path string, pa
 		Load:  l.Load, // Recursive loads use this loader
 	}
 
-	// Extract package from label for context.
-	pkg := ""
-	if idx := strings.Index(label, "//"); idx != -1 {
-		rest := label[idx+2:]
-		if colonIdx := strings.LastIndex(rest, ":"); colonIdx != -1 {
-			pkg = rest[:colonIdx]
-		}
-	}
-
-	// Extract repo from label.
-	repo := ""
-	if strings.HasPrefix(label, "@") {
-		if idx := strings.Index(label, "//"); idx != -1 {
-			repo = label[1:idx]
-		}
-	}
-
-	// Set up thread context.
+	// Set up thread context. Labels in the module are resolved relative to
+	// its own repository and that repository's repo mapping.
+	repo, pkg := resolved.Repo(), resolved.Pkg()
 	SetBzlLoader(childThread, l)
 	SetCurrentPackage(childThread, pkg)
 	SetCurrentRepo(childThread, repo)
+	types.SetLabelContext(childThread, &types.LabelContext{
+		Repo:        repo,
+		Pkg:         pkg,
+		RepoMapping: l.RepoMapping(repo),
+	})
 	l.setLoadStack(childThread, append(parentStack, label))
+	bzlContext := NewBzlInitThreadContext(label, repo, pkg)
+	SetBzlContext(childThread, bzlContext)
 
 	// Execute the module.
 	// Reference: Starlark.execFileProgram() called from BzlLoadFunction.executeBzlFile()
-	globals, err := starlark.ExecFileOptions(
-		&syntax.FileOptions{},
-		childThread,
-		path,
-		source,
-		l.predeclared,
-	)
+	globals, err := l.programs.ExecFile(childThread, path, source, l.predeclared)
 	if err != nil {
-		return nil, fmt.Errorf("executing %s: %w", label, err)
+		return nil, bzlContext, fmt.Errorf("executing %s: %w", label, err)
 	}
+	bzlContext.SetTransitiveDigest(source)
+
+	// Name the exportable values assigned to globals, such as repository
+	// rules.
+	// Reference: BzlLoadFunction.java exportGlobals via StarlarkExportable
+	for _, name := range globals.Keys() {
+		if v, ok := globals[name].(exportable); ok && !v.IsExported() {
+			if err := v.Export(name); err != nil {
+				return nil, bzlContext, fmt.Errorf("executing %s: %w", label, err)
+			}
+			if rc, ok := v.(*types.RuleClass); ok {
+				rc.SetDefinitionEnvironment(label, bzlContext.TransitiveDigest)
+			}
+		}
+	}
+
+	return globals, bzlContext, nil
+}
 
-	return globals, nil
+// exportable is implemented by values named after the global they are first
+// assigned to. It matches eval.ExportableValue.
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/StarlarkExportable.java
+type exportable interface {
+	IsExported() bool
+	Export(name string) error
 }
 
 // getLoadStack retrieves the current load stack from the thread.
@@ -479,7 +664,8 @@ func (l *BzlFileLoader) setLoadStack(thread *starlark.Thread, stack []string) {
 }
 
 // ClearCache removes all cached modules.
-// Useful for testing or when source files have changed.
+// Useful for testing; Invalidate only removes the modules affected by
+// changed files.
 func (l *BzlFileLoader) ClearCache() {
 	l.mu.Lock()
 	l.cache = make(map[string]*loadEntry)
@@ -0,0 +1,19 @@
+package loader
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkError reports a failure if err does not contain errMsg, or if err is
+// not nil when errMsg is empty.
+func checkError(t *testing.T, err error, errMsg string) {
+	t.Helper()
+	if errMsg == "" {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	} else if err == nil || !strings.Contains(err.Error(), errMsg) {
+		t.Errorf("expected error containing %q, got %v", errMsg, err)
+	}
+}
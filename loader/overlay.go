@@ -0,0 +1,231 @@
+package loader
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OverlayFileSystem layers in-memory edits, such as the unsaved buffers of
+// an editor, over a base FileSystem, usually an OSFileSystem. Files set in
+// the overlay shadow the base's, and files removed from it are hidden, while
+// the base is never modified. Directories are listed if the base implements
+// DirFileSystem.
+type OverlayFileSystem struct {
+	base FileSystem
+
+	mu      sync.RWMutex
+	files   map[string][]byte // keyed by absolute path
+	deleted map[string]bool
+}
+
+// NewOverlayFileSystem creates an overlay with no edits over base.
+func NewOverlayFileSystem(base FileSystem) *OverlayFileSystem {
+	return &OverlayFileSystem{
+		base:    base,
+		files:   make(map[string][]byte),
+		deleted: make(map[string]bool),
+	}
+}
+
+// SetFile sets the content of the file at path in the overlay.
+func (f *OverlayFileSystem) SetFile(path string, content []byte) {
+	key := f.key(path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[key] = content
+	delete(f.deleted, key)
+}
+
+// RemoveFile hides the file at path, whether it is in the overlay or the
+// base.
+func (f *OverlayFileSystem) RemoveFile(path string) {
+	key := f.key(path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, key)
+	f.deleted[key] = true
+}
+
+// Revert drops the edits made to the file at path, exposing the base's
+// version again.
+func (f *OverlayFileSystem) Revert(path string) {
+	key := f.key(path)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, key)
+	delete(f.deleted, key)
+}
+
+// Edits returns the absolute paths of the files set or removed in the
+// overlay, sorted.
+func (f *OverlayFileSystem) Edits() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	paths := make([]string, 0, len(f.files)+len(f.deleted))
+	for p := range f.files {
+		paths = append(paths, p)
+	}
+	for p := range f.deleted {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Base returns the file system under the overlay.
+func (f *OverlayFileSystem) Base() FileSystem { return f.base }
+
+// ReadFile reads a file from the overlay, or else from the base.
+func (f *OverlayFileSystem) ReadFile(path string) ([]byte, error) {
+	key := f.key(path)
+	f.mu.RLock()
+	content, ok := f.files[key]
+	deleted := f.deleted[key]
+	f.mu.RUnlock()
+	if ok {
+		return content, nil
+	}
+	if deleted {
+		return nil, &fs.PathError{Op: "read", Path: path, Err: fs.ErrNotExist}
+	}
+	return f.base.ReadFile(path)
+}
+
+// Stat returns file info from the overlay, or else from the base. A
+// directory exists in the overlay as long as it contains a file, and a
+// directory of the base stops existing once all its entries are removed.
+func (f *OverlayFileSystem) Stat(path string) (fs.FileInfo, error) {
+	key := f.key(path)
+	f.mu.RLock()
+	content, ok := f.files[key]
+	deleted := f.deleted[key]
+	dir := !ok && f.hasFilesUnder(key)
+	emptied := !ok && !deleted && !dir && f.emptied(key)
+	f.mu.RUnlock()
+	switch {
+	case ok:
+		return &memFileInfo{name: filepath.Base(key), size: int64(len(content))}, nil
+	case deleted, emptied:
+		return nil, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+	info, err := f.base.Stat(path)
+	if err != nil && dir {
+		return &memFileInfo{name: filepath.Base(key), dir: true}, nil
+	}
+	return info, err
+}
+
+// ReadDir lists a directory of the base with the overlay's edits applied.
+func (f *OverlayFileSystem) ReadDir(path string) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	var baseErr error
+	if base, ok := f.base.(DirFileSystem); ok {
+		entries, baseErr = base.ReadDir(path)
+	} else {
+		baseErr = &fs.PathError{Op: "readdir", Path: path, Err: errors.ErrUnsupported}
+	}
+
+	dir := f.key(path)
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if baseErr == nil && !f.hasFilesUnder(dir) && f.emptied(dir) {
+		return nil, &fs.PathError{Op: "readdir", Path: path, Err: fs.ErrNotExist}
+	}
+	byName := make(map[string]fs.DirEntry, len(entries))
+	for _, e := range entries {
+		p := prefix + e.Name()
+		if f.deleted[p] || e.IsDir() && f.emptied(p) {
+			continue
+		}
+		byName[e.Name()] = e
+	}
+	for p, content := range f.files {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		if name, _, isDir := strings.Cut(rest, "/"); isDir {
+			if _, ok := byName[name]; !ok {
+				byName[name] = fs.FileInfoToDirEntry(&memFileInfo{name: name, dir: true})
+			}
+		} else {
+			byName[name] = fs.FileInfoToDirEntry(&memFileInfo{name: name, size: int64(len(content))})
+		}
+	}
+	if baseErr != nil && len(byName) == 0 {
+		return nil, baseErr
+	}
+
+	merged := make([]fs.DirEntry, 0, len(byName))
+	for _, e := range byName {
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
+
+// Join joins path elements.
+func (f *OverlayFileSystem) Join(elem ...string) string {
+	return f.base.Join(elem...)
+}
+
+// Abs returns the absolute path in the base.
+func (f *OverlayFileSystem) Abs(path string) (string, error) {
+	return f.base.Abs(path)
+}
+
+// key returns the absolute, clean form of path that edits are keyed by.
+func (f *OverlayFileSystem) key(path string) string {
+	if abs, err := f.base.Abs(path); err == nil {
+		path = abs
+	}
+	return filepath.Clean(path)
+}
+
+// hasFilesUnder reports whether the overlay sets a file under dir. It must
+// be called with f.mu held.
+func (f *OverlayFileSystem) hasFilesUnder(dir string) bool {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for p := range f.files {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// emptied reports whether dir is a directory of the base all of whose
+// entries are removed in the overlay, directly or by removing everything
+// under a subdirectory. Files the overlay sets under dir are not considered.
+// It must be called with f.mu held.
+func (f *OverlayFileSystem) emptied(dir string) bool {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	removed := false
+	for p := range f.deleted {
+		if strings.HasPrefix(p, prefix) {
+			removed = true
+			break
+		}
+	}
+	base, ok := f.base.(DirFileSystem)
+	if !removed || !ok {
+		return false
+	}
+	entries, err := base.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		return false
+	}
+	for _, e := range entries {
+		p := prefix + e.Name()
+		if !f.deleted[p] && !(e.IsDir() && f.emptied(p)) {
+			return false
+		}
+	}
+	return true
+}
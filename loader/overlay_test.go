@@ -0,0 +1,73 @@
+package loader
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverlayFileSystem(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, testFiles)
+	overlay := NewOverlayFileSystem(NewOSFileSystem(root))
+	checkFileSystem(t, overlay, root)
+
+	path := func(name string) string { return filepath.Join(root, name) }
+	overlay.SetFile(path("rules/defs.bzl"), []byte(`PREFIX = "edited_"`))
+	overlay.SetFile(path("app/new/d.go"), nil)
+	overlay.RemoveFile(path("app/a.go"))
+
+	if content, err := overlay.ReadFile(path("rules/defs.bzl")); err != nil || string(content) != `PREFIX = "edited_"` {
+		t.Errorf("ReadFile(edited) = %q, %v", content, err)
+	}
+	if _, err := overlay.ReadFile(path("app/a.go")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile(removed) error = %v, want fs.ErrNotExist", err)
+	}
+	if got := listDir(t, overlay, path("app")); got != "BUILD README.txt new/ sub/" {
+		t.Errorf("ReadDir(app) with edits = %s", got)
+	}
+	if info, err := overlay.Stat(path("app/new")); err != nil || !info.IsDir() {
+		t.Errorf("Stat(app/new) = %v, %v, want a directory", info, err)
+	}
+	if _, err := os.Stat(path("app/a.go")); err != nil {
+		t.Errorf("base modified: %v", err)
+	}
+	if got := len(overlay.Edits()); got != 3 {
+		t.Errorf("%d edits, want 3", got)
+	}
+
+	overlay.Revert(path("app/a.go"))
+	if got := listDir(t, overlay, path("app")); got != "BUILD README.txt a.go new/ sub/" {
+		t.Errorf("ReadDir(app) after revert = %s", got)
+	}
+
+	// Directories exist only while the merged view has entries in them.
+	overlay.RemoveFile(path("app/new/d.go"))
+	overlay.RemoveFile(path("app/sub/b.go"))
+	if got := listDir(t, overlay, path("app")); got != "BUILD README.txt a.go" {
+		t.Errorf("ReadDir(app) with emptied directories = %s", got)
+	}
+	for _, dir := range []string{"app/new", "app/sub"} {
+		if _, err := overlay.Stat(path(dir)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Stat(%s) error = %v, want fs.ErrNotExist", dir, err)
+		}
+	}
+	for _, name := range []string{"app/BUILD", "app/README.txt", "app/a.go"} {
+		overlay.RemoveFile(path(name))
+	}
+	if _, err := overlay.ReadDir(path("app")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadDir(app) with all files removed error = %v, want fs.ErrNotExist", err)
+	}
+	if got := listDir(t, overlay, root); got != "rules/" {
+		t.Errorf("ReadDir(root) with app emptied = %s", got)
+	}
+	overlay.SetFile(path("app/sub/e.go"), nil)
+	if info, err := overlay.Stat(path("app")); err != nil || !info.IsDir() {
+		t.Errorf("Stat(app) after adding a file = %v, %v, want a directory", info, err)
+	}
+	if got := listDir(t, overlay, path("app")); got != "sub/" {
+		t.Errorf("ReadDir(app) after adding a file = %s", got)
+	}
+}
@@ -0,0 +1,252 @@
+package loader
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"sync"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// ProgramCache holds compiled Starlark programs keyed by a digest of the
+// file name, the source and the names of the predeclared environment, so
+// that a module shared by many loaders and interpreters is parsed, resolved
+// and compiled only once. With a directory, compiled programs are also
+// written to disk and reused by later processes.
+//
+// Programs are immutable and may be executed any number of times, by
+// concurrent threads. A changed file gets a new key; the stale entry is
+// evicted once maxPrograms more recently used programs are held in memory.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/skyframe/BzlCompileFunction.java
+type ProgramCache struct {
+	dir string
+
+	mu       sync.Mutex
+	programs map[string]*list.Element // of *programEntry
+	lru      list.List                // most recently used first
+}
+
+// maxPrograms is the number of compiled programs a ProgramCache holds in
+// memory.
+const maxPrograms = 1024
+
+type programEntry struct {
+	key  string
+	prog *starlark.Program
+}
+
+var (
+	sharedProgramCachesMu sync.Mutex
+	sharedProgramCaches   = make(map[string]*ProgramCache)
+)
+
+// NewProgramCache creates an empty program cache. If dir is not empty,
+// compiled programs are also stored in and read from dir.
+func NewProgramCache(dir string) *ProgramCache {
+	return &ProgramCache{
+		dir:      dir,
+		programs: make(map[string]*list.Element),
+	}
+}
+
+// SharedProgramCache returns the process-wide program cache for dir, creating
+// it on first use. The cache for "" is memory only. Loaders and evaluators
+// only use it when given it explicitly.
+func SharedProgramCache(dir string) *ProgramCache {
+	if dir != "" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+	}
+	sharedProgramCachesMu.Lock()
+	defer sharedProgramCachesMu.Unlock()
+	c, ok := sharedProgramCaches[dir]
+	if !ok {
+		c = NewProgramCache(dir)
+		sharedProgramCaches[dir] = c
+	}
+	return c
+}
+
+// Len returns the number of programs held in memory.
+func (c *ProgramCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.programs)
+}
+
+// Compile returns the compiled program of a file whose predeclared
+// environment is predeclared, compiling it if it is not cached. Files that
+// fail to compile are not cached.
+func (c *ProgramCache) Compile(filename string, source []byte, predeclared starlark.StringDict) (*starlark.Program, error) {
+	key := programKey(filename, source, predeclared)
+
+	if prog := c.get(key); prog != nil {
+		return prog, nil
+	}
+
+	prog := c.read(key)
+	if prog == nil {
+		var err error
+		_, prog, err = starlark.SourceProgramOptions(&syntax.FileOptions{}, filename, source, predeclared.Has)
+		if err != nil {
+			return nil, err
+		}
+		// The disk cache is best effort; a failed write only costs a
+		// compilation in a later process.
+		_ = c.write(key, prog)
+	}
+
+	return c.put(key, prog), nil
+}
+
+// get returns the program held in memory under key, or nil.
+func (c *ProgramCache) get(key string) *starlark.Program {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.programs[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*programEntry).prog
+}
+
+// put holds prog in memory under key, unless another program was put there
+// concurrently, and returns the program held. The least recently used
+// program is evicted if the cache is full.
+func (c *ProgramCache) put(key string, prog *starlark.Program) *starlark.Program {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.programs[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*programEntry).prog
+	}
+	c.programs[key] = c.lru.PushFront(&programEntry{key: key, prog: prog})
+	if c.lru.Len() > maxPrograms {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.programs, oldest.Value.(*programEntry).key)
+	}
+	return prog
+}
+
+// ExecFile is like the package-level ExecFile, but takes compiled programs
+// from the cache.
+func (c *ProgramCache) ExecFile(thread *starlark.Thread, filename string, source []byte, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	if IsSclFile(filename) {
+		globals, err := c.exec(thread, filename, source, SclPredeclared())
+		return globals, sclError(err, predeclared)
+	}
+	return c.exec(thread, filename, source, predeclared)
+}
+
+func (c *ProgramCache) exec(thread *starlark.Thread, filename string, source []byte, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	prog, err := c.Compile(filename, source, predeclared)
+	if err != nil {
+		return nil, err
+	}
+	globals, err := prog.Init(thread, predeclared)
+	globals.Freeze()
+	return globals, err
+}
+
+// read returns the program stored on disk under key, or nil. Entries written
+// by other versions of the compiler are ignored.
+func (c *ProgramCache) read(key string) *starlark.Program {
+	if c.dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil
+	}
+	prog, err := starlark.CompiledProgram(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return prog
+}
+
+// write stores prog on disk under key.
+func (c *ProgramCache) write(key string, prog *starlark.Program) error {
+	if c.dir == "" {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := prog.Write(&buf); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	// Write atomically so that concurrent readers never see partial entries.
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+func (c *ProgramCache) path(key string) string {
+	return filepath.Join(c.dir, key+".sko")
+}
+
+// programKey digests everything compilation depends on: the compiler
+// version, the file name recorded in positions, the source, and which names
+// resolve to the predeclared environment.
+func programKey(filename string, source []byte, predeclared starlark.StringDict) string {
+	names := make([]string, 0, len(predeclared))
+	for name := range predeclared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	h.Write([]byte(starlarkVersion))
+	h.Write([]byte{0})
+	h.Write([]byte(filename))
+	h.Write([]byte{0})
+	sum := sha256.Sum256(source)
+	h.Write(sum[:])
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// starlarkVersion is the version of go.starlark.net the binary was built
+// with, if known.
+var starlarkVersion = func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == "go.starlark.net" {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			return dep.Version + " " + dep.Sum
+		}
+	}
+	return ""
+}()
@@ -0,0 +1,78 @@
+package loader
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestProgramCache(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewMemoryFileSystem()
+	fs.AddFile("/ws/rules/defs.bzl", []byte(`INFO = {"x": 1}`))
+	fs.AddFile("/ws/rules/other.bzl", []byte(`load(":defs.bzl", "INFO")
+OTHER = INFO`))
+
+	var infos []starlark.Value
+	for range 2 {
+		l := NewBzlFileLoader(fs, "/ws", WithProgramCache(SharedProgramCache(dir)))
+		globals, err := l.Load(&starlark.Thread{}, "//rules:other.bzl")
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		infos = append(infos, globals["OTHER"])
+	}
+	// Programs are shared, the values they create are not.
+	if infos[0] == infos[1] {
+		t.Error("loaders share module globals")
+	}
+	if n := SharedProgramCache(dir).Len(); n != 2 {
+		t.Errorf("cache holds %d programs, want 2", n)
+	}
+
+	// Another process finds the compiled programs on disk.
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("%d programs on disk, want 2", len(entries))
+	}
+	source := []byte(`X = 1`)
+	if _, err := NewProgramCache(dir).Compile("/ws/x.bzl", source, nil); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := NewProgramCache(dir).Compile("/ws/x.bzl", source, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals, err := prog.Init(&starlark.Thread{}, nil)
+	if err != nil || globals["X"] != starlark.MakeInt(1) {
+		t.Errorf("X = %v, %v", globals["X"], err)
+	}
+}
+
+func TestProgramCacheEviction(t *testing.T) {
+	c := NewProgramCache("")
+	first, err := c.Compile("/ws/first.bzl", []byte(`X = 0`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range maxPrograms {
+		// Keep the first program recently used.
+		if _, err := c.Compile("/ws/first.bzl", []byte(`X = 0`), nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Compile(fmt.Sprintf("/ws/%d.bzl", i), []byte(`X = 1`), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := c.Len(); n != maxPrograms {
+		t.Errorf("cache holds %d programs, want %d", n, maxPrograms)
+	}
+	if prog, _ := c.Compile("/ws/first.bzl", []byte(`X = 0`), nil); prog != first {
+		t.Error("recently used program was evicted")
+	}
+	if c.get(programKey("/ws/0.bzl", []byte(`X = 1`), nil)) != nil {
+		t.Error("least recently used program was not evicted")
+	}
+}
@@ -0,0 +1,62 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// IsSclFile reports whether name, a file name or label, refers to a file in
+// the Starlark configuration language dialect (.scl).
+func IsSclFile(name string) bool {
+	return strings.HasSuffix(name, ".scl")
+}
+
+// SclPredeclared returns the predeclared environment of .scl files: the
+// core Starlark universe plus struct() and visibility(). Build-language
+// symbols such as rule, native, attr and provider are not available.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/BazelStarlarkEnvironment.java createSclEnvironment()
+func SclPredeclared() starlark.StringDict {
+	return starlark.StringDict{
+		"struct":     starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"visibility": starlark.NewBuiltin("visibility", VisibilityBuiltin),
+	}
+}
+
+// CheckSclLoad rejects a load of module made by the file from when from is a
+// .scl file and module is not.
+// Reference: BzlLoadFunction.java getLoadLabels()
+func CheckSclLoad(from, module string) error {
+	if IsSclFile(from) && !IsSclFile(module) {
+		return fmt.Errorf("load of %s is not allowed: .scl files may only load other .scl files", module)
+	}
+	return nil
+}
+
+// ExecFile executes a .bzl or .scl module. A .scl file is executed with
+// SclPredeclared instead of predeclared, and references to symbols that are
+// only available in .bzl files are reported as such. The compiled program is
+// not cached; use ProgramCache.ExecFile to reuse it.
+func ExecFile(thread *starlark.Thread, filename string, source []byte, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	return NewProgramCache("").ExecFile(thread, filename, source, predeclared)
+}
+
+// sclError rewrites the resolve errors of a .scl file that reference
+// symbols of the .bzl environment predeclared.
+func sclError(err error, predeclared starlark.StringDict) error {
+	var errs resolve.ErrorList
+	if errors.As(err, &errs) {
+		for i, e := range errs {
+			name, ok := strings.CutPrefix(e.Msg, "undefined: ")
+			if _, bzlOnly := predeclared[name]; ok && bzlOnly {
+				errs[i].Msg = fmt.Sprintf("%s is not available in .scl files, which only have the core Starlark environment", name)
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package loader
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestSclDialect(t *testing.T) {
+	fs := NewMemoryFileSystem()
+	fs.AddFile("/ws/config/base.scl", []byte(`VERSIONS = {"go": "1.24"}`))
+	fs.AddFile("/ws/config/consts.scl", []byte(`load(":base.scl", "VERSIONS")
+GO_VERSION = VERSIONS["go"]
+PLATFORM = struct(os = "linux")`))
+	fs.AddFile("/ws/config/defs.bzl", []byte(`X = 1`))
+	fs.AddFile("/ws/config/bad_load.scl", []byte(`load(":defs.bzl", "X")`))
+	fs.AddFile("/ws/config/bad_rule.scl", []byte(`r = rule()`))
+	fs.AddFile("/ws/config/use.bzl", []byte(`load(":consts.scl", "GO_VERSION", "PLATFORM")
+V = GO_VERSION + PLATFORM.os`))
+	predeclared := starlark.StringDict{"rule": starlark.NewBuiltin("rule", nil)}
+	l := NewBzlFileLoader(fs, "/ws", WithPredeclared(predeclared))
+
+	tests := []struct {
+		name   string
+		module string
+		errMsg string
+	}{
+		{"bzl loads scl", "//config:use.bzl", ""},
+		{"scl loads bzl", "//config:bad_load.scl", "load of //config:defs.bzl is not allowed: .scl files may only load other .scl files"},
+		{"bzl symbol in scl", "//config:bad_rule.scl", "rule is not available in .scl files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := l.Load(&starlark.Thread{}, tt.module)
+			checkError(t, err, tt.errMsg)
+		})
+	}
+	if globals, _ := l.Load(&starlark.Thread{}, "//config:use.bzl"); globals["V"] != starlark.String("1.24linux") {
+		t.Errorf("V = %v", globals["V"])
+	}
+	checkError(t, CheckSclLoad("//:test.scl", "//config:defs.bzl"), ".scl files may only load other .scl files")
+	checkError(t, CheckSclLoad("//:test.bzl", "//config:consts.scl"), "")
+}
@@ -0,0 +1,207 @@
+package loader
+
+import (
+	"fmt"
+	"strings"
+
+	"go.starlark.net/starlark"
+)
+
+// BzlVisibility defines who can load a .bzl file.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/BzlVisibility.java
+type BzlVisibility int
+
+const (
+	// BzlVisibilityPublic means the .bzl can be loaded by any package.
+	BzlVisibilityPublic BzlVisibility = iota
+
+	// BzlVisibilityPrivate means the .bzl can only be loaded by the same package.
+	BzlVisibilityPrivate
+
+	// BzlVisibilityPackage means the .bzl can be loaded by specific packages.
+	BzlVisibilityPackage
+)
+
+// BzlInitThreadContext holds thread-local state during .bzl execution.
+// This is updated by builtins like visibility() and is read after execution
+// to determine the module's visibility.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/BzlInitThreadContext.java
+type BzlInitThreadContext struct {
+	// Label of the .bzl file being evaluated
+	Label string
+
+	// TransitiveDigest accumulated from this file and its loads
+	TransitiveDigest []byte
+
+	// Loads lists the canonical labels of the modules loaded directly by
+	// the file, in load order.
+	Loads []string
+
+	// BzlVisibility set by visibility() builtin
+	Visibility BzlVisibility
+
+	// VisibilityPackages set by visibility() when using package list:
+	// package specifications such as "//pkg", "//pkg/..." or "-//pkg/...",
+	// relative to the repository of the .bzl file, or "public".
+	VisibilityPackages []string
+
+	// repo and pkg locate the .bzl file; visibilitySet records whether
+	// visibility() has been called. loadDigests holds the transitive digests
+	// of Loads.
+	repo, pkg     string
+	visibilitySet bool
+	loadDigests   [][]byte
+}
+
+// ThreadKeyBzlContext is the key for storing BzlInitThreadContext in the thread.
+const ThreadKeyBzlContext = "starlark-go-bazel:bzl_context"
+
+// SetBzlContext stores the BzlInitThreadContext in the thread.
+func SetBzlContext(thread *starlark.Thread, ctx *BzlInitThreadContext) {
+	thread.SetLocal(ThreadKeyBzlContext, ctx)
+}
+
+// GetBzlContext retrieves the BzlInitThreadContext from the thread.
+func GetBzlContext(thread *starlark.Thread) *BzlInitThreadContext {
+	if ctx := thread.Local(ThreadKeyBzlContext); ctx != nil {
+		return ctx.(*BzlInitThreadContext)
+	}
+	return nil
+}
+
+// NewBzlInitThreadContext creates the context of a .bzl file in package pkg
+// of repository repo.
+func NewBzlInitThreadContext(label, repo, pkg string) *BzlInitThreadContext {
+	return &BzlInitThreadContext{Label: label, repo: repo, pkg: pkg}
+}
+
+// Repo returns the canonical name of the repository of the .bzl file.
+func (c *BzlInitThreadContext) Repo() string {
+	return c.repo
+}
+
+// AllowsLoadFrom reports whether the .bzl file may be loaded from package
+// pkg of repository repo. A .bzl file can always be loaded from its own
+// package; negated specifications take precedence over the others.
+// Reference: BzlVisibility.java allowsPackage()
+func (c *BzlInitThreadContext) AllowsLoadFrom(repo, pkg string) bool {
+	if repo == c.repo && pkg == c.pkg {
+		return true
+	}
+	switch c.Visibility {
+	case BzlVisibilityPublic:
+		return true
+	case BzlVisibilityPrivate:
+		return false
+	}
+	allowed := false
+	for _, spec := range c.VisibilityPackages {
+		if spec == "public" {
+			allowed = true
+			continue
+		}
+		negated := strings.HasPrefix(spec, "-")
+		if repo != c.repo || !matchesPackageSpec(strings.TrimPrefix(spec, "-"), pkg) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// matchesPackageSpec matches a package against "//pkg" or "//pkg/...".
+func matchesPackageSpec(spec, pkg string) bool {
+	spec = strings.TrimPrefix(spec, "//")
+	if prefix, ok := strings.CutSuffix(spec, "..."); ok {
+		prefix = strings.TrimSuffix(prefix, "/")
+		return prefix == "" || pkg == prefix || strings.HasPrefix(pkg, prefix+"/")
+	}
+	return pkg == spec
+}
+
+// VisibilityBuiltin implements visibility(), which sets the load visibility
+// of the .bzl file being initialized: "public", "private", or a package
+// specification or list of them.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/packages/BazelStarlarkBuiltins.java visibility()
+func VisibilityBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var value starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &value); err != nil {
+		return nil, err
+	}
+	ctx := GetBzlContext(thread)
+	if ctx == nil {
+		return nil, fmt.Errorf("visibility() can only be used during .bzl initialization (top-level evaluation)")
+	}
+	if thread.CallStackDepth() != 2 {
+		return nil, fmt.Errorf("load visibility may only be set at the top level")
+	}
+	if ctx.visibilitySet {
+		return nil, fmt.Errorf("load visibility may not be set more than once")
+	}
+
+	var specs []string
+	switch v := value.(type) {
+	case starlark.String:
+		specs = []string{string(v)}
+	case *starlark.List:
+		for i := 0; i < v.Len(); i++ {
+			s, ok := v.Index(i).(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("got %s in visibility list, want string", v.Index(i).Type())
+			}
+			specs = append(specs, string(s))
+		}
+	default:
+		return nil, fmt.Errorf("Invalid visibility: got '%s', want string or list of strings", value.Type())
+	}
+
+	visibility := BzlVisibilityPackage
+	var packages []string
+	for _, spec := range specs {
+		switch {
+		case spec == "public", spec == "private":
+			if len(specs) == 1 {
+				if spec == "public" {
+					visibility = BzlVisibilityPublic
+				} else {
+					visibility = BzlVisibilityPrivate
+				}
+			} else if spec == "public" {
+				packages = append(packages, spec)
+			}
+		case strings.HasPrefix(strings.TrimPrefix(spec, "-"), "//"):
+			if strings.Contains(spec, ":") {
+				return nil, fmt.Errorf("invalid package specification '%s': visibility() takes package specifications, not labels", spec)
+			}
+			packages = append(packages, spec)
+		case strings.HasPrefix(strings.TrimPrefix(spec, "-"), "@"):
+			return nil, fmt.Errorf("invalid package specification '%s': visibility() only accepts packages of the current repository", spec)
+		default:
+			return nil, fmt.Errorf("invalid package specification '%s': must be \"public\", \"private\", or start with \"//\"", spec)
+		}
+	}
+	ctx.Visibility = visibility
+	ctx.VisibilityPackages = packages
+	ctx.visibilitySet = true
+	return starlark.None, nil
+}
+
+// checkLoadVisibility rejects a load of a .bzl file whose visibility does not
+// include the loading package.
+// Reference: BzlLoadFunction.java checkLoadVisibilities()
+func checkLoadVisibility(ctx *BzlInitThreadContext, label, repo, pkg string) error {
+	if ctx == nil || ctx.AllowsLoadFrom(repo, pkg) {
+		return nil
+	}
+	loadingPackage := "//" + pkg
+	if repo != "" {
+		loadingPackage = "@@" + repo + loadingPackage
+	}
+	return fmt.Errorf("Starlark file %s is not visible for loading from package %s. Check the file's `visibility()` declaration.", label, loadingPackage)
+}
@@ -0,0 +1,53 @@
+package loader
+
+import (
+	"testing"
+
+	"go.starlark.net/starlark"
+)
+
+func TestLoadVisibility(t *testing.T) {
+	fs := NewMemoryFileSystem()
+	fs.AddFile("/ws/internal/private.bzl", []byte(`visibility("private")
+X = 1`))
+	fs.AddFile("/ws/internal/same_pkg.bzl", []byte(`load(":private.bzl", "X")
+Y = X`))
+	fs.AddFile("/ws/internal/restricted.bzl", []byte(`visibility(["//app/...", "-//app/legacy/..."])
+Z = 1`))
+	fs.AddFile("/ws/internal/helper.bzl", []byte(`def f():
+    visibility("public")
+f()`))
+	fs.AddFile("/ws/internal/twice.bzl", []byte(`visibility("public")
+visibility("public")`))
+	fs.AddFile("/ws/internal/label.bzl", []byte(`visibility(["//app:x"])`))
+	predeclared := starlark.StringDict{"visibility": starlark.NewBuiltin("visibility", VisibilityBuiltin)}
+	l := NewBzlFileLoader(fs, "/ws", WithPredeclared(predeclared))
+
+	tests := []struct {
+		name   string
+		pkg    string
+		module string
+		errMsg string
+	}{
+		{"same package", "", "//internal:same_pkg.bzl", ""},
+		{"private", "", "//internal:private.bzl", "Starlark file //internal:private.bzl is not visible for loading from package //. Check the file's `visibility()` declaration."},
+		{"package spec", "app/sub", "//internal:restricted.bzl", ""},
+		{"negated package spec", "app/legacy", "//internal:restricted.bzl", "Starlark file //internal:restricted.bzl is not visible for loading from package //app/legacy."},
+		{"not top level", "", "//internal:helper.bzl", "load visibility may only be set at the top level"},
+		{"set twice", "", "//internal:twice.bzl", "load visibility may not be set more than once"},
+		{"label", "", "//internal:label.bzl", "visibility() takes package specifications, not labels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thread := &starlark.Thread{}
+			SetCurrentPackage(thread, tt.pkg)
+			_, err := l.Load(thread, tt.module)
+			checkError(t, err, tt.errMsg)
+		})
+	}
+
+	// Loads made directly by Go callers are not checked.
+	if _, err := l.Load(&starlark.Thread{}, "//internal:private.bzl"); err != nil {
+		t.Errorf("unchecked load failed: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PollingWatcher reports the files created, modified or deleted under the
+// root of an OSFileSystem by scanning the tree periodically. It is portable
+// and needs no OS notification support; version control metadata
+// directories are skipped.
+type PollingWatcher struct {
+	root     string
+	interval time.Duration
+	debounce time.Duration
+	snapshot map[string]fileStamp
+}
+
+// fileStamp is what a scan compares to detect changes. Directories only
+// change by being created or deleted.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	dir     bool
+}
+
+// Default polling settings.
+const (
+	DefaultPollInterval = 500 * time.Millisecond
+	DefaultDebounce     = 200 * time.Millisecond
+)
+
+// skippedDirs are not scanned.
+var skippedDirs = map[string]bool{".git": true, ".hg": true, ".svn": true}
+
+// NewPollingWatcher creates a watcher of the root of fsys that scans every
+// interval and reports changes once none were seen for debounce. Zero
+// durations select the defaults.
+func NewPollingWatcher(fsys *OSFileSystem, interval, debounce time.Duration) (*PollingWatcher, error) {
+	root, err := filepath.Abs(fsys.Root())
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	w := &PollingWatcher{root: root, interval: interval, debounce: debounce}
+	if w.snapshot, err = w.scanTree(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Scan scans the tree and returns the absolute paths that changed since the
+// previous scan, sorted.
+func (w *PollingWatcher) Scan() ([]string, error) {
+	snapshot, err := w.scanTree()
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for p, stamp := range snapshot {
+		if old, ok := w.snapshot[p]; !ok || old != stamp {
+			changed = append(changed, p)
+		}
+	}
+	for p := range w.snapshot {
+		if _, ok := snapshot[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	w.snapshot = snapshot
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// Watch scans the tree every interval until ctx is done, and calls onChange
+// with the paths changed since the previous call once the tree has been
+// stable for the debounce period. It returns ctx.Err() or the first scan
+// error.
+func (w *PollingWatcher) Watch(ctx context.Context, onChange func(paths []string)) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	pending := make(map[string]bool)
+	var lastChange time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			changed, err := w.Scan()
+			if err != nil {
+				return err
+			}
+			for _, p := range changed {
+				pending[p] = true
+			}
+			if len(changed) > 0 {
+				lastChange = now
+				continue
+			}
+			if len(pending) == 0 || now.Sub(lastChange) < w.debounce {
+				continue
+			}
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			pending = make(map[string]bool)
+			onChange(paths)
+		}
+	}
+}
+
+func (w *PollingWatcher) scanTree() (map[string]fileStamp, error) {
+	snapshot := make(map[string]fileStamp)
+	err := filepath.WalkDir(w.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files deleted during the scan are reported by the next one.
+			if p != w.root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if skippedDirs[d.Name()] && p != w.root {
+				return filepath.SkipDir
+			}
+			snapshot[p] = fileStamp{dir: true}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		snapshot[p] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return snapshot, err
+}
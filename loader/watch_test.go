@@ -0,0 +1,66 @@
+package loader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollingWatcher(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, testFiles)
+	w, err := NewPollingWatcher(NewOSFileSystem(root), time.Millisecond, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewPollingWatcher failed: %v", err)
+	}
+	scan := func() string {
+		t.Helper()
+		changed, err := w.Scan()
+		if err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		for i, p := range changed {
+			changed[i], _ = filepath.Rel(root, p)
+		}
+		return filepath.ToSlash(strings.Join(changed, " "))
+	}
+	if got := scan(); got != "" {
+		t.Errorf("unchanged tree: changed = %q", got)
+	}
+
+	writeFiles(t, root, map[string]string{"app/a.go": "package app // changed", "app/new/c.go": "", ".git/HEAD": ""})
+	if err := os.Remove(filepath.Join(root, "app/README.txt")); err != nil {
+		t.Fatal(err)
+	}
+	// Version control metadata is skipped.
+	if got := scan(); got != "app/README.txt app/a.go app/new app/new/c.go" {
+		t.Errorf("changed = %q", got)
+	}
+
+	// Watch reports changes once the tree is stable.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan []string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- w.Watch(ctx, func(paths []string) { events <- paths })
+	}()
+	writeFiles(t, root, map[string]string{"rules/defs.bzl": `PREFIX = "changed_"`})
+	select {
+	case paths := <-events:
+		if got := strings.Join(paths, " "); got != filepath.Join(root, "rules/defs.bzl") {
+			t.Errorf("Watch reported %q", got)
+		}
+	case err := <-errs:
+		t.Fatalf("Watch failed: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+	}
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("Watch returned %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,268 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+)
+
+// ErrNoWorkspace is returned by FindWorkspaceRoot for paths outside any
+// workspace.
+var ErrNoWorkspace = errors.New("not in a Bazel workspace")
+
+// workspaceBoundaryFiles mark the root directory of a workspace.
+// Reference: bazel/src/main/cpp/workspace_layout.cc WorkspaceLayout::InWorkspace()
+var workspaceBoundaryFiles = []string{"MODULE.bazel", "REPO.bazel", "WORKSPACE.bazel", "WORKSPACE"}
+
+// FindWorkspaceRoot returns the workspace root of path: the nearest
+// directory at or above it containing MODULE.bazel, REPO.bazel,
+// WORKSPACE.bazel or WORKSPACE. It returns an error wrapping ErrNoWorkspace
+// if there is none.
+//
+// Reference: bazel/src/main/cpp/workspace_layout.cc WorkspaceLayout::GetWorkspace()
+func FindWorkspaceRoot(fsys FileSystem, p string) (string, error) {
+	dir, err := fsys.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	if info, err := fsys.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+	for {
+		for _, name := range workspaceBoundaryFiles {
+			if info, err := fsys.Stat(fsys.Join(dir, name)); err == nil && !info.IsDir() {
+				return dir, nil
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("%s: %w", p, ErrNoWorkspace)
+		}
+		dir = parent
+	}
+}
+
+// WorkspacePlaceholder stands for the workspace root in package path
+// entries.
+const WorkspacePlaceholder = "%workspace%"
+
+// PackageLocator finds packages and the packages owning files, following
+// Bazel's --package_path and --deleted_packages flags: a package is looked
+// up in each package path entry in turn, and deleted packages are treated as
+// if they had no BUILD file, so that their files belong to the enclosing
+// package.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/pkgcache/PathPackageLocator.java
+type PackageLocator struct {
+	fsys    FileSystem
+	roots   []string
+	deleted map[string]bool
+}
+
+// NewPackageLocator creates a locator of the packages of the workspace at
+// workspaceRoot. Entries of packagePath may use WorkspacePlaceholder, and
+// relative entries are relative to the workspace root; an empty package
+// path is the workspace root alone. deletedPackages are package names such
+// as "foo/bar". fsys may be nil to only compute package names from paths.
+func NewPackageLocator(fsys FileSystem, workspaceRoot string, packagePath, deletedPackages []string) *PackageLocator {
+	l := &PackageLocator{fsys: fsys, deleted: make(map[string]bool)}
+	if len(packagePath) == 0 {
+		packagePath = []string{WorkspacePlaceholder}
+	}
+	for _, entry := range packagePath {
+		entry = strings.ReplaceAll(entry, WorkspacePlaceholder, workspaceRoot)
+		if !filepath.IsAbs(entry) {
+			entry = filepath.Join(workspaceRoot, entry)
+		}
+		l.roots = append(l.roots, l.abs(entry))
+	}
+	for _, pkg := range deletedPackages {
+		l.deleted[strings.Trim(strings.TrimPrefix(pkg, "//"), "/")] = true
+	}
+	return l
+}
+
+// Roots returns the absolute package path entries.
+func (l *PackageLocator) Roots() []string { return l.roots }
+
+// IsDeleted reports whether pkg is a deleted package.
+func (l *PackageLocator) IsDeleted(pkg string) bool { return l.deleted[pkg] }
+
+// abs returns the absolute, clean form of p.
+func (l *PackageLocator) abs(p string) string {
+	var abs string
+	var err error
+	if l.fsys != nil {
+		abs, err = l.fsys.Abs(p)
+	} else {
+		abs, err = filepath.Abs(p)
+	}
+	if err != nil {
+		return filepath.Clean(p)
+	}
+	return filepath.Clean(abs)
+}
+
+// relPath returns the slash-separated path of p relative to the first
+// package path entry containing it, and that entry.
+func (l *PackageLocator) relPath(p string) (rel, root string, ok bool) {
+	abs := l.abs(p)
+	for _, root := range l.roots {
+		r, err := filepath.Rel(root, abs)
+		if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if r == "." {
+			r = ""
+		}
+		return filepath.ToSlash(r), root, true
+	}
+	return "", "", false
+}
+
+// PackageName returns the name of the package whose BUILD file is at
+// buildFile, its directory relative to the package path entry containing
+// it. It returns false if buildFile is outside the package path.
+func (l *PackageLocator) PackageName(buildFile string) (string, bool) {
+	rel, _, ok := l.relPath(filepath.Dir(l.abs(buildFile)))
+	return rel, ok
+}
+
+// buildFileIn returns the BUILD file of the directory pkg under root, if
+// any.
+func (l *PackageLocator) buildFileIn(root, pkg string) (string, bool) {
+	if l.fsys == nil {
+		return "", false
+	}
+	for _, name := range []string{"BUILD.bazel", "BUILD"} {
+		p := l.fsys.Join(root, pkg, name)
+		if info, err := l.fsys.Stat(p); err == nil && !info.IsDir() {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// BuildFile returns the BUILD file of pkg in the first package path entry
+// that has it, preferring BUILD.bazel to BUILD. Deleted packages have none.
+func (l *PackageLocator) BuildFile(pkg string) (string, bool) {
+	if l.deleted[pkg] {
+		return "", false
+	}
+	for _, root := range l.roots {
+		if p, ok := l.buildFileIn(root, pkg); ok {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// PackageRoot returns the package path entry holding pkg.
+func (l *PackageLocator) PackageRoot(pkg string) (string, bool) {
+	buildFile, ok := l.BuildFile(pkg)
+	if !ok {
+		return "", false
+	}
+	_, root, ok := l.relPath(buildFile)
+	return root, ok
+}
+
+// PackageOf returns the package owning the file or directory at p: the
+// package of the nearest BUILD file in p, if it is a directory, or above it,
+// up to the package path entry containing p. It returns false if there is
+// none.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/skyframe/ContainingPackageLookupFunction.java
+func (l *PackageLocator) PackageOf(p string) (string, bool) {
+	rel, root, ok := l.relPath(p)
+	if !ok {
+		return "", false
+	}
+	// The search starts at p itself, which only has a BUILD file if it is
+	// a directory.
+	for dir := rel; ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+		if !l.deleted[dir] {
+			if _, ok := l.buildFileIn(root, dir); ok {
+				return dir, true
+			}
+		}
+		if dir == "" {
+			return "", false
+		}
+	}
+}
+
+// LabelOf returns the label of the file at p, in the package owning it.
+func (l *PackageLocator) LabelOf(p string) (*types.Label, error) {
+	pkg, ok := l.PackageOf(p)
+	if !ok {
+		return nil, fmt.Errorf("%s is not in a package", p)
+	}
+	rel, _, _ := l.relPath(p)
+	if rel == pkg {
+		return nil, fmt.Errorf("%s is the directory of package //%s", p, pkg)
+	}
+	name := strings.TrimPrefix(rel, pkg+"/")
+	if pkg == "" {
+		name = rel
+	}
+	return types.ParseLabel("//" + pkg + ":" + name)
+}
+
+// Packages returns the names of the packages of the package path, sorted,
+// skipping deleted packages and packages shadowed by an earlier entry.
+// Version control metadata directories are not searched. The file system
+// must implement DirFileSystem.
+func (l *PackageLocator) Packages() ([]string, error) {
+	fsys, ok := l.fsys.(DirFileSystem)
+	if !ok {
+		return nil, errors.New("listing packages: file system cannot list directories")
+	}
+	found := make(map[string]bool)
+	var walk func(dir, pkg string) error
+	walk = func(dir, pkg string) error {
+		entries, err := fsys.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			switch {
+			case e.IsDir():
+				if skippedDirs[name] {
+					continue
+				}
+				if err := walk(fsys.Join(dir, name), path.Join(pkg, name)); err != nil {
+					return err
+				}
+			case name == "BUILD.bazel" || name == "BUILD":
+				if !l.deleted[pkg] {
+					found[pkg] = true
+				}
+			}
+		}
+		return nil
+	}
+	for _, root := range l.roots {
+		if err := walk(root, ""); err != nil {
+			return nil, err
+		}
+	}
+	pkgs := make([]string, 0, len(found))
+	for pkg := range found {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Strings(pkgs)
+	return pkgs, nil
+}
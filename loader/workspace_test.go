@@ -0,0 +1,109 @@
+package loader
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFindWorkspaceRoot(t *testing.T) {
+	fs := NewMemoryFileSystem()
+	fs.AddFile("/ws/MODULE.bazel", nil)
+	fs.AddFile("/ws/app/sub/x.go", nil)
+	fs.AddFile("/ws/nested/REPO.bazel", nil)
+	fs.AddFile("/vendor/lib/BUILD", nil)
+
+	for path, want := range map[string]string{
+		"/ws/app/sub/x.go":        "/ws",
+		"/ws/app/sub":             "/ws",
+		"/ws/nested/pkg/BUILD":    "/ws/nested",
+		"/ws/MODULE.bazel":        "/ws",
+		"/vendor/lib/BUILD":       "",
+		"/vendor/lib/missing.txt": "",
+	} {
+		root, err := FindWorkspaceRoot(fs, path)
+		if want == "" {
+			if !errors.Is(err, ErrNoWorkspace) {
+				t.Errorf("FindWorkspaceRoot(%s) = %q, %v, want ErrNoWorkspace", path, root, err)
+			}
+			continue
+		}
+		if err != nil || root != want {
+			t.Errorf("FindWorkspaceRoot(%s) = %q, %v, want %s", path, root, err, want)
+		}
+	}
+}
+
+func TestPackageLocator(t *testing.T) {
+	fs := NewMemoryFileSystem()
+	fs.AddFile("/ws/app/BUILD", nil)
+	fs.AddFile("/ws/app/sub/x.go", nil)
+	fs.AddFile("/ws/app/gen/BUILD", nil)
+	fs.AddFile("/ws/app/gen/y.go", nil)
+	fs.AddFile("/ws/lib/BUILD.bazel", nil)
+	fs.AddFile("/ws/lib/BUILD", nil)
+	fs.AddFile("/vendor/lib/BUILD", nil)
+	fs.AddFile("/vendor/lib/lib.go", nil)
+	fs.AddFile("/vendor/third/BUILD", nil)
+
+	l := NewPackageLocator(fs, "/ws", []string{WorkspacePlaceholder, "/vendor"}, []string{"//app/gen"})
+	if got := strings.Join(l.Roots(), " "); got != "/ws /vendor" {
+		t.Errorf("Roots() = %s", got)
+	}
+	if !l.IsDeleted("app/gen") || l.IsDeleted("app") {
+		t.Error("IsDeleted does not follow deletedPackages")
+	}
+
+	pkgs, err := l.Packages()
+	if err != nil {
+		t.Fatalf("Packages failed: %v", err)
+	}
+	// lib is found in the first package path entry only.
+	if got := strings.Join(pkgs, " "); got != "app lib third" {
+		t.Errorf("Packages() = %s", got)
+	}
+	for pkg, want := range map[string]string{"lib": "/ws/lib/BUILD.bazel", "third": "/vendor/third/BUILD", "app/gen": ""} {
+		got, ok := l.BuildFile(pkg)
+		if got != want || ok != (want != "") {
+			t.Errorf("BuildFile(%s) = %q, %v, want %q", pkg, got, ok, want)
+		}
+	}
+	if pkg, ok := l.PackageName("/vendor/third/BUILD"); !ok || pkg != "third" {
+		t.Errorf("PackageName(/vendor/third/BUILD) = %q, %v", pkg, ok)
+	}
+
+	// Directories are owned by their own package, if they are one.
+	for path, want := range map[string]string{
+		"/ws/lib":     "lib",
+		"/ws/lib/":    "lib",
+		"/ws/app/sub": "app",
+		"/ws/app/gen": "app",
+		"/vendor/lib": "lib",
+		"/ws":         "",
+	} {
+		pkg, ok := l.PackageOf(path)
+		if pkg != want || ok != (path != "/ws") {
+			t.Errorf("PackageOf(%s) = %q, %v, want %q", path, pkg, ok, want)
+		}
+	}
+
+	for path, want := range map[string]string{
+		"/ws/app/sub/x.go":    "//app:sub/x.go",
+		"/ws/app/gen/y.go":    "//app:gen/y.go",
+		"/ws/app/sub":         "//app:sub",
+		"/vendor/lib/lib.go":  "//lib:lib.go",
+		"/ws/lib":             "",
+		"/elsewhere/file.txt": "",
+	} {
+		label, err := l.LabelOf(path)
+		if want == "" {
+			if err == nil {
+				t.Errorf("LabelOf(%s) = %s, want error", path, label)
+			}
+			continue
+		}
+		if err != nil || label.String() != want {
+			t.Errorf("LabelOf(%s) = %v, %v, want %s", path, label, err, want)
+		}
+	}
+}
@@ -30,6 +30,10 @@ type PackageContext struct {
 	// Empty string for the main repository.
 	RepoName string
 
+	// RepoMapping is the repo mapping of the repository. Nil means every
+	// repository is visible under its canonical name.
+	RepoMapping *types.RepoMapping
+
 	// PackageDir is the absolute path to the package directory on disk.
 	// Used for glob operations.
 	PackageDir string
@@ -96,5 +100,9 @@ func (ctx *PackageContext) GetRule(name string) map[string]starlark.Value {
 // ResolveLabel resolves a label string relative to the current package.
 // Returns a *types.Label.
 func (ctx *PackageContext) ResolveLabel(input string) (*types.Label, error) {
-	return types.ParseLabelRelative(input, ctx.RepoName, ctx.PackagePath)
+	return types.ParseLabelInContext(input, types.LabelContext{
+		Repo:        ctx.RepoName,
+		Pkg:         ctx.PackagePath,
+		RepoMapping: ctx.RepoMapping,
+	})
 }
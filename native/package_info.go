@@ -107,7 +107,7 @@ func packageRelativeLabel(thread *starlark.Thread, b *starlark.Builtin, args sta
 		return nil, fmt.Errorf("invalid label in native.package_relative_label: expected string or Label, got %s", input.Type())
 	}
 
-	label, err := types.ParseLabelRelative(inputStr, ctx.RepoName, ctx.PackagePath)
+	label, err := ctx.ResolveLabel(inputStr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid label in native.package_relative_label: %w", err)
 	}
@@ -33,7 +33,7 @@ var DefaultInfoProvider = types.NewProvider("DefaultInfo", []string{
 //   - data_runfiles: Runfiles for when this target is a data dependency
 //   - default_runfiles: Runfiles for when running this target
 //   - executable: File to execute for executable/test rules
-//   - files_to_run: FilesToRunProvider (not implemented yet)
+//   - files_to_run: FilesToRunProvider for the executable and its runfiles
 type DefaultInfo struct {
 	// files is a depset of Files representing the default outputs
 	// From DefaultInfo.java: files field (Depset)
@@ -161,8 +161,8 @@ func (d *DefaultInfo) Attr(name string) (starlark.Value, error) {
 
 	case "files_to_run":
 		// From DefaultInfoApi.java: getFilesToRun()
-		// Not fully implemented yet
-		return starlark.None, nil
+		// "A FilesToRunProvider object containing information about the executable and runfiles"
+		return d.FilesToRun(), nil
 
 	default:
 		return nil, starlark.NoSuchAttrError(fmt.Sprintf("DefaultInfo has no attribute %q", name))
@@ -236,6 +236,23 @@ func (d *DefaultInfo) SetExecutable(executable *types.File) {
 	d.executable = executable
 }
 
+// FilesToRun returns the FilesToRunProvider for this target.
+// Non-executable targets get EmptyFilesToRunProvider.
+// Reference: DefaultInfo.java getFilesToRun()
+func (d *DefaultInfo) FilesToRun() *FilesToRunProvider {
+	if d.executable == nil {
+		return EmptyFilesToRunProvider
+	}
+	runfiles := d.defaultRunfiles
+	if runfiles == nil {
+		runfiles = d.runfiles
+	}
+	if runfiles == nil {
+		runfiles = EmptyRunfiles
+	}
+	return NewFilesToRunProvider(d.executable, runfiles)
+}
+
 // Provider returns the DefaultInfo provider.
 func (d *DefaultInfo) Provider() *types.Provider {
 	return DefaultInfoProvider
@@ -0,0 +1,183 @@
+// Package providers implements Bazel's built-in providers.
+//
+// FilesToRunProvider implementation based on:
+// - bazel/src/main/java/com/google/devtools/build/lib/analysis/FilesToRunProvider.java
+// - bazel/src/main/java/com/google/devtools/build/lib/starlarkbuildapi/FilesToRunProviderApi.java
+package providers
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// FilesToRunProvider contains information about how to run an executable
+// target: the executable itself, its runfiles and the manifests describing them.
+//
+// It is exposed to Starlark as DefaultInfo.files_to_run and is accepted by
+// ctx.actions.run() as the executable or as an entry of tools.
+//
+// Reference: FilesToRunProvider.java
+type FilesToRunProvider struct {
+	// executable is the main executable, or nil for non-executable targets.
+	// From FilesToRunProvider.java: getExecutable()
+	executable *types.File
+
+	// runfiles are the runfiles of the executable.
+	// From FilesToRunProvider.java: getRunfilesSupport().getRunfiles()
+	runfiles *Runfiles
+
+	// runfilesManifest is the <executable>.runfiles_manifest file.
+	// From FilesToRunProvider.java: getRunfilesManifest()
+	runfilesManifest *types.File
+
+	// repoMappingManifest is the <executable>.repo_mapping file.
+	// From FilesToRunProvider.java: getRepoMappingManifest()
+	repoMappingManifest *types.File
+
+	frozen bool
+}
+
+var (
+	_ starlark.Value    = (*FilesToRunProvider)(nil)
+	_ starlark.HasAttrs = (*FilesToRunProvider)(nil)
+)
+
+// EmptyFilesToRunProvider is returned for targets that are not executable.
+// Reference: FilesToRunProvider.java: EMPTY field
+var EmptyFilesToRunProvider = &FilesToRunProvider{frozen: true}
+
+// NewFilesToRunProvider creates a FilesToRunProvider for an executable and its runfiles.
+// The runfiles and repo mapping manifests are derived from the executable path,
+// matching RunfilesSupport.java. A nil executable yields a provider without manifests.
+func NewFilesToRunProvider(executable *types.File, runfiles *Runfiles) *FilesToRunProvider {
+	p := &FilesToRunProvider{
+		executable: executable,
+		runfiles:   runfiles,
+	}
+	if executable != nil && runfiles != nil {
+		p.runfilesManifest = siblingArtifact(executable, ".runfiles_manifest")
+		p.repoMappingManifest = siblingArtifact(executable, ".repo_mapping")
+	}
+	return p
+}
+
+// siblingArtifact returns a derived artifact next to f with the given suffix.
+func siblingArtifact(f *types.File, suffix string) *types.File {
+	rootPath := ""
+	if f.Root() != nil {
+		rootPath = f.Root().ExecPathString()
+	}
+	shortPath := f.ShortPath() + suffix
+	return types.NewFile(path.Join(rootPath, shortPath), shortPath, types.NewFileRoot(rootPath), f.Owner(), false)
+}
+
+// String returns the Starlark representation.
+func (p *FilesToRunProvider) String() string {
+	if p.executable == nil {
+		return "FilesToRunProvider(executable = None)"
+	}
+	return fmt.Sprintf("FilesToRunProvider(executable = %s)", p.executable.String())
+}
+
+// Type returns "FilesToRunProvider".
+func (p *FilesToRunProvider) Type() string { return "FilesToRunProvider" }
+
+// Freeze marks the provider as frozen.
+func (p *FilesToRunProvider) Freeze() {
+	if p.frozen {
+		return
+	}
+	p.frozen = true
+	if p.executable != nil {
+		p.executable.Freeze()
+	}
+	if p.runfiles != nil {
+		p.runfiles.Freeze()
+	}
+}
+
+// Truth returns true.
+func (p *FilesToRunProvider) Truth() starlark.Bool { return true }
+
+// Hash returns an error.
+func (p *FilesToRunProvider) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: FilesToRunProvider")
+}
+
+// Attr returns an attribute of the provider.
+// Reference: FilesToRunProviderApi.java interface methods
+func (p *FilesToRunProvider) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "executable":
+		// From FilesToRunProviderApi.java: getExecutable()
+		// "The main executable or None if it does not exist."
+		return fileOrNone(p.executable), nil
+
+	case "runfiles_manifest":
+		// From FilesToRunProviderApi.java: getRunfilesManifest()
+		// "The runfiles manifest or None if it does not exist."
+		return fileOrNone(p.runfilesManifest), nil
+
+	case "repo_mapping_manifest":
+		// From FilesToRunProviderApi.java: getRepoMappingManifest()
+		// "The repo mapping manifest or None if it does not exist."
+		return fileOrNone(p.repoMappingManifest), nil
+
+	default:
+		return nil, starlark.NoSuchAttrError(fmt.Sprintf("FilesToRunProvider has no attribute %q", name))
+	}
+}
+
+// AttrNames returns the list of attribute names.
+func (p *FilesToRunProvider) AttrNames() []string {
+	return []string{"executable", "repo_mapping_manifest", "runfiles_manifest"}
+}
+
+// Executable returns the executable, or nil.
+func (p *FilesToRunProvider) Executable() *types.File { return p.executable }
+
+// Runfiles returns the executable's runfiles, or nil.
+func (p *FilesToRunProvider) Runfiles() *Runfiles { return p.runfiles }
+
+// RunfilesManifest returns the runfiles manifest, or nil.
+func (p *FilesToRunProvider) RunfilesManifest() *types.File { return p.runfilesManifest }
+
+// RepoMappingManifest returns the repo mapping manifest, or nil.
+func (p *FilesToRunProvider) RepoMappingManifest() *types.File { return p.repoMappingManifest }
+
+// FilesToRun returns every file needed to run the executable: the executable,
+// its manifests and its runfiles artifacts.
+// Reference: FilesToRunProvider.java getFilesToRun()
+func (p *FilesToRunProvider) FilesToRun() []starlark.Value {
+	var files []starlark.Value
+	for _, f := range []*types.File{p.executable, p.runfilesManifest, p.repoMappingManifest} {
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	if p.runfiles != nil {
+		files = append(files, p.runfiles.Files().ToList()...)
+		for _, v := range p.runfiles.Symlinks().ToList() {
+			if s, ok := v.(*types.SymlinkEntry); ok {
+				files = append(files, s.Target())
+			}
+		}
+		for _, v := range p.runfiles.RootSymlinks().ToList() {
+			if s, ok := v.(*types.SymlinkEntry); ok {
+				files = append(files, s.Target())
+			}
+		}
+	}
+	return files
+}
+
+// fileOrNone returns f as a Starlark value, or None if f is nil.
+func fileOrNone(f *types.File) starlark.Value {
+	if f == nil {
+		return starlark.None
+	}
+	return f
+}
@@ -276,40 +276,38 @@ func (r *Runfiles) mergeMethod(thread *starlark.Thread, b *starlark.Builtin, arg
 }
 
 // MergeAll returns a new runfiles object that includes all contents of this one and the sequence.
+// The result's depsets reference the merged runfiles' depsets directly rather
+// than nesting pairwise merges.
 // Reference: Runfiles.java mergeAll() method
 func (r *Runfiles) MergeAll(others []*Runfiles) (*Runfiles, error) {
-	// When merging exactly one non-empty Runfiles object, return that object
-	var result *Runfiles
-	var uniqueNonEmpty *Runfiles
-
-	if !r.IsEmpty() {
-		result = r
-		uniqueNonEmpty = r
-	}
-
-	for _, other := range others {
-		if !other.IsEmpty() {
-			if result == nil {
-				result = other
-				uniqueNonEmpty = other
-			} else {
-				uniqueNonEmpty = nil
-				var err error
-				result, err = result.Merge(other)
-				if err != nil {
-					return nil, err
-				}
-			}
+	var nonEmpty []*Runfiles
+	for _, rf := range append([]*Runfiles{r}, others...) {
+		if !rf.IsEmpty() {
+			nonEmpty = append(nonEmpty, rf)
 		}
 	}
 
-	if uniqueNonEmpty != nil {
-		return uniqueNonEmpty, nil
+	// When merging exactly one non-empty Runfiles object, return that object
+	switch len(nonEmpty) {
+	case 0:
+		return EmptyRunfiles, nil
+	case 1:
+		return nonEmpty[0], nil
+	}
+
+	prefix := ""
+	for _, rf := range nonEmpty {
+		if rf.prefix != "" {
+			prefix = rf.prefix
+			break
+		}
 	}
-	if result != nil {
-		return result, nil
+
+	rb := NewRunfilesBuilder(prefix)
+	for _, rf := range nonEmpty {
+		rb.Merge(rf)
 	}
-	return EmptyRunfiles, nil
+	return rb.Build()
 }
 
 // mergeAllMethod implements the Starlark merge_all() method.
@@ -338,11 +336,14 @@ func (r *Runfiles) mergeAllMethod(thread *starlark.Thread, b *starlark.Builtin,
 // RunfilesBuilder helps construct Runfiles objects.
 // Reference: Runfiles.Builder in Runfiles.java
 type RunfilesBuilder struct {
-	prefix       string
-	files        []starlark.Value
-	symlinks     []*types.SymlinkEntry
-	rootSymlinks []*types.SymlinkEntry
-	transitive   []*Runfiles
+	prefix                 string
+	files                  []starlark.Value
+	transitiveFiles        []*types.Depset
+	symlinks               []*types.SymlinkEntry
+	transitiveSymlinks     []*types.Depset
+	rootSymlinks           []*types.SymlinkEntry
+	transitiveRootSymlinks []*types.Depset
+	transitive             []*Runfiles
 }
 
 // NewRunfilesBuilder creates a new RunfilesBuilder.
@@ -362,11 +363,10 @@ func (rb *RunfilesBuilder) AddFiles(files []*types.File) {
 	}
 }
 
-// AddTransitiveFiles adds a depset of files.
+// AddTransitiveFiles adds a depset of files, preserving its structure.
+// Reference: Runfiles.Builder.addTransitiveArtifacts()
 func (rb *RunfilesBuilder) AddTransitiveFiles(files *types.Depset) {
-	for _, v := range files.ToList() {
-		rb.files = append(rb.files, v)
-	}
+	rb.transitiveFiles = append(rb.transitiveFiles, files)
 }
 
 // AddSymlink adds a symlink.
@@ -379,8 +379,23 @@ func (rb *RunfilesBuilder) AddRootSymlink(link string, target *types.File) {
 	rb.rootSymlinks = append(rb.rootSymlinks, types.NewSymlinkEntry(link, target))
 }
 
+// AddSymlinks adds a depset of SymlinkEntry values.
+// Reference: Runfiles.Builder.addSymlinks(NestedSet)
+func (rb *RunfilesBuilder) AddSymlinks(symlinks *types.Depset) {
+	rb.transitiveSymlinks = append(rb.transitiveSymlinks, symlinks)
+}
+
+// AddRootSymlinks adds a depset of SymlinkEntry values placed at the runfiles root.
+// Reference: Runfiles.Builder.addRootSymlinks(NestedSet)
+func (rb *RunfilesBuilder) AddRootSymlinks(symlinks *types.Depset) {
+	rb.transitiveRootSymlinks = append(rb.transitiveRootSymlinks, symlinks)
+}
+
 // Merge merges another Runfiles.
 func (rb *RunfilesBuilder) Merge(other *Runfiles) {
+	if other == nil {
+		return
+	}
 	rb.transitive = append(rb.transitive, other)
 }
 
@@ -389,7 +404,7 @@ func (rb *RunfilesBuilder) Build() (*Runfiles, error) {
 	r := NewRunfiles(rb.prefix)
 
 	// Create depset of files
-	var transitiveFiles []*types.Depset
+	transitiveFiles := append([]*types.Depset(nil), rb.transitiveFiles...)
 	for _, t := range rb.transitive {
 		transitiveFiles = append(transitiveFiles, t.files)
 	}
@@ -404,7 +419,7 @@ func (rb *RunfilesBuilder) Build() (*Runfiles, error) {
 	for i, s := range rb.symlinks {
 		symlinkValues[i] = s
 	}
-	var transitiveSymlinks []*types.Depset
+	transitiveSymlinks := append([]*types.Depset(nil), rb.transitiveSymlinks...)
 	for _, t := range rb.transitive {
 		transitiveSymlinks = append(transitiveSymlinks, t.symlinks)
 	}
@@ -419,7 +434,7 @@ func (rb *RunfilesBuilder) Build() (*Runfiles, error) {
 	for i, s := range rb.rootSymlinks {
 		rootSymlinkValues[i] = s
 	}
-	var transitiveRootSymlinks []*types.Depset
+	transitiveRootSymlinks := append([]*types.Depset(nil), rb.transitiveRootSymlinks...)
 	for _, t := range rb.transitive {
 		transitiveRootSymlinks = append(transitiveRootSymlinks, t.rootSymlinks)
 	}
@@ -429,6 +444,17 @@ func (rb *RunfilesBuilder) Build() (*Runfiles, error) {
 	}
 	r.rootSymlinks = rootSymlinks
 
+	// Create depset of empty filenames
+	var transitiveEmptyFilenames []*types.Depset
+	for _, t := range rb.transitive {
+		transitiveEmptyFilenames = append(transitiveEmptyFilenames, t.emptyFilenames)
+	}
+	emptyFilenames, err := types.NewDepset(types.OrderDefault, nil, transitiveEmptyFilenames)
+	if err != nil {
+		return nil, err
+	}
+	r.emptyFilenames = emptyFilenames
+
 	return r, nil
 }
 
@@ -0,0 +1,317 @@
+// Package providers implements Bazel's built-in providers.
+//
+// Runfiles tree layout based on:
+// - bazel/src/main/java/com/google/devtools/build/lib/analysis/Runfiles.java (getRunfilesInputs)
+// - bazel/src/main/java/com/google/devtools/build/lib/analysis/SourceManifestAction.java
+// - bazel/src/main/java/com/google/devtools/build/lib/analysis/RepoMappingManifestAction.java
+// - bazel/src/main/java/com/google/devtools/build/lib/exec/SymlinkTreeHelper.java
+package providers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// DefaultWorkspaceName is the runfiles directory of the main repository when
+// no workspace name is known.
+// Reference: LabelConstants.java DEFAULT_REPOSITORY_DIRECTORY
+const DefaultWorkspaceName = "_main"
+
+// RepoMappingFilename is the name of the repo mapping manifest inside the runfiles tree.
+// Reference: RepoMappingManifestAction.java
+const RepoMappingFilename = "_repo_mapping"
+
+// RunfilesTreeOptions configures how a runfiles tree is laid out.
+type RunfilesTreeOptions struct {
+	// WorkspaceName is the directory holding the main repository's runfiles.
+	// Defaults to the runfiles prefix, then DefaultWorkspaceName.
+	WorkspaceName string
+
+	// ExecRoot is the directory exec paths are relative to. Manifest and
+	// symlink targets are ExecRoot joined with each file's exec path.
+	ExecRoot string
+
+	// RepoMapping maps a canonical repo name ("" for the main repo) to its
+	// apparent repo names and their canonical targets. When non-nil, a
+	// _repo_mapping file is added to the tree.
+	RepoMapping map[string]map[string]string
+
+	// EmptyFiles computes additional empty files from the workspace-relative
+	// runfiles paths, e.g. PythonEmptyFiles.
+	// Reference: Runfiles.java EmptyFilesSupplier
+	EmptyFiles func(paths []string) []string
+}
+
+// RunfilesTree is the resolved layout of an executable's runfiles directory:
+// every path under <executable>.runfiles/ and the file it points to.
+//
+// Reference: Runfiles.java getRunfilesInputs()
+type RunfilesTree struct {
+	workspaceName string
+	execRoot      string
+
+	// entries maps runfiles-root-relative paths to their targets. A nil
+	// target denotes an empty file.
+	entries map[string]*types.File
+
+	repoMapping map[string]map[string]string
+}
+
+// NewRunfilesTree computes the runfiles tree for r.
+//
+// Entries are added in Bazel's order: symlinks, then files (later entries
+// win on conflicts), then empty files that do not collide with existing
+// paths. Everything so far is placed under the workspace directory; root
+// symlinks are then added relative to the runfiles root.
+func NewRunfilesTree(r *Runfiles, opts RunfilesTreeOptions) *RunfilesTree {
+	ws := opts.WorkspaceName
+	if ws == "" {
+		ws = r.Prefix()
+	}
+	if ws == "" {
+		ws = DefaultWorkspaceName
+	}
+
+	// Workspace-relative manifest.
+	// Reference: Runfiles.java getRunfilesInputs() - symlinks then artifacts
+	manifest := make(map[string]*types.File)
+	for _, v := range r.Symlinks().ToList() {
+		if s, ok := v.(*types.SymlinkEntry); ok {
+			manifest[path.Clean(s.PathString())] = s.Target()
+		}
+	}
+	for _, v := range r.Files().ToList() {
+		if f, ok := v.(*types.File); ok {
+			manifest[path.Clean(f.ShortPath())] = f
+		}
+	}
+
+	// Reference: Runfiles.java getRunfilesInputs() - empty files never replace entries
+	var emptyFiles []string
+	for _, v := range r.EmptyFilenames().ToList() {
+		if s, ok := v.(starlark.String); ok {
+			emptyFiles = append(emptyFiles, string(s))
+		}
+	}
+	if opts.EmptyFiles != nil {
+		paths := make([]string, 0, len(manifest))
+		for p := range manifest {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		emptyFiles = append(emptyFiles, opts.EmptyFiles(paths)...)
+	}
+	for _, p := range emptyFiles {
+		p = path.Clean(p)
+		if _, ok := manifest[p]; !ok {
+			manifest[p] = nil
+		}
+	}
+
+	// Reference: Runfiles.java getRunfilesInputs() - prefix with the workspace
+	// name; external runfiles paths ("../repo/...") land next to it.
+	t := &RunfilesTree{
+		workspaceName: ws,
+		execRoot:      opts.ExecRoot,
+		entries:       make(map[string]*types.File, len(manifest)),
+		repoMapping:   opts.RepoMapping,
+	}
+	for p, f := range manifest {
+		t.entries[path.Join(ws, p)] = f
+	}
+	for _, v := range r.RootSymlinks().ToList() {
+		if s, ok := v.(*types.SymlinkEntry); ok {
+			t.entries[path.Clean(s.PathString())] = s.Target()
+		}
+	}
+	return t
+}
+
+// WorkspaceName returns the directory holding the main repository's runfiles.
+func (t *RunfilesTree) WorkspaceName() string { return t.workspaceName }
+
+// Paths returns the sorted runfiles-root-relative paths of the tree, not
+// including the _repo_mapping file.
+func (t *RunfilesTree) Paths() []string {
+	paths := make([]string, 0, len(t.entries))
+	for p := range t.entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Entry returns the file at the runfiles-root-relative path p. The returned
+// file is nil for empty files.
+func (t *RunfilesTree) Entry(p string) (*types.File, bool) {
+	f, ok := t.entries[path.Clean(p)]
+	return f, ok
+}
+
+// targetPath returns the on-disk location of f.
+func (t *RunfilesTree) targetPath(f *types.File) string {
+	return filepath.Join(t.execRoot, filepath.FromSlash(f.Path()))
+}
+
+// Manifest returns the contents of the runfiles MANIFEST: one line per entry,
+// sorted by path, mapping the runfiles path to an absolute target (empty for
+// empty files). repoMappingPath is the location of the _repo_mapping file and
+// is only used when the tree has a repo mapping.
+//
+// Paths containing spaces, newlines or backslashes are escaped and the line
+// is prefixed with a space.
+// Reference: SourceManifestAction.java writeEntry()
+func (t *RunfilesTree) Manifest(repoMappingPath string) []byte {
+	type line struct{ link, target string }
+	lines := make([]line, 0, len(t.entries)+1)
+	for p, f := range t.entries {
+		target := ""
+		if f != nil {
+			target = t.targetPath(f)
+		}
+		lines = append(lines, line{p, target})
+	}
+	if t.repoMapping != nil {
+		lines = append(lines, line{RepoMappingFilename, repoMappingPath})
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].link < lines[j].link })
+
+	var b strings.Builder
+	for _, l := range lines {
+		if strings.ContainsAny(l.link, " \n\\") || strings.ContainsAny(l.target, "\n\\") {
+			b.WriteByte(' ')
+			b.WriteString(escapeManifestPath(l.link, true))
+			b.WriteByte(' ')
+			b.WriteString(escapeManifestPath(l.target, false))
+		} else {
+			b.WriteString(l.link)
+			b.WriteByte(' ')
+			b.WriteString(l.target)
+		}
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// escapeManifestPath escapes a MANIFEST path. Spaces are only escaped in
+// link paths since targets extend to the end of the line.
+// Reference: SourceManifestAction.java ManifestType.SOURCE_SYMLINKS
+func escapeManifestPath(p string, escapeSpaces bool) string {
+	p = strings.ReplaceAll(p, `\`, `\b`)
+	p = strings.ReplaceAll(p, "\n", `\n`)
+	if escapeSpaces {
+		p = strings.ReplaceAll(p, " ", `\s`)
+	}
+	return p
+}
+
+// RepoMappingManifest returns the contents of the _repo_mapping file: one
+// "source,apparent,target" line per mapping of a repository that contributes
+// runfiles, sorted. The main repository's source name is empty.
+// Reference: RepoMappingManifestAction.java
+func (t *RunfilesTree) RepoMappingManifest() []byte {
+	if t.repoMapping == nil {
+		return nil
+	}
+
+	// Only repos that own runfiles need their mappings.
+	repos := map[string]bool{"": true}
+	for p := range t.entries {
+		if repo, _, ok := strings.Cut(p, "/"); ok && repo != t.workspaceName {
+			repos[repo] = true
+		}
+	}
+
+	var lines []string
+	for source, mapping := range t.repoMapping {
+		if !repos[source] {
+			continue
+		}
+		for apparent, target := range mapping {
+			lines = append(lines, source+","+apparent+","+target)
+		}
+	}
+	sort.Strings(lines)
+
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// Materialize creates the runfiles symlink forest under dir: a symlink per
+// entry pointing at its absolute target, empty regular files, the
+// _repo_mapping file and the MANIFEST.
+// Reference: SymlinkTreeHelper.java createSymlinksDirectly()
+func (t *RunfilesTree) Materialize(dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, t.workspaceName), 0o755); err != nil {
+		return err
+	}
+
+	for _, p := range t.Paths() {
+		dst := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+
+		f := t.entries[p]
+		if f == nil {
+			if err := os.WriteFile(dst, nil, 0o644); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Symlink(t.targetPath(f), dst); err != nil {
+			return fmt.Errorf("creating runfiles symlink %s: %w", p, err)
+		}
+	}
+
+	repoMappingPath := filepath.Join(dir, RepoMappingFilename)
+	if t.repoMapping != nil {
+		if err := os.WriteFile(repoMappingPath, t.RepoMappingManifest(), 0o644); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(filepath.Join(dir, "MANIFEST"), t.Manifest(repoMappingPath), 0o644)
+}
+
+// PythonEmptyFiles returns the __init__.py files Bazel adds to Python
+// runfiles: one in every directory containing a .py or .so file that lacks one.
+// Reference: PythonUtils.java GetInitPyFiles
+func PythonEmptyFiles(paths []string) []string {
+	existing := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		existing[p] = true
+	}
+
+	seen := make(map[string]bool)
+	var result []string
+	for _, p := range paths {
+		if !strings.HasSuffix(p, ".py") && !strings.HasSuffix(p, ".so") {
+			continue
+		}
+		for dir := path.Dir(p); dir != "." && dir != ".."; dir = path.Dir(dir) {
+			initPy := path.Join(dir, "__init__.py")
+			if existing[initPy] || seen[initPy] {
+				continue
+			}
+			seen[initPy] = true
+			result = append(result, initPy)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,114 @@
+package providers
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+)
+
+func testRunfiles(t *testing.T) *Runfiles {
+	t.Helper()
+	owner, _ := types.ParseLabel("//app:bin")
+	rb := NewRunfilesBuilder("")
+	rb.AddFile(types.NewSourceFile("app/lib", "util.py"))
+	rb.AddFile(types.NewDerivedFile("bazel-out/bin", "app/gen.txt", owner))
+	rb.AddFile(types.NewFile("external/rules_foo~/foo/data.txt", "../rules_foo~/foo/data.txt", types.NewFileRoot(""), nil, true))
+	rb.AddSymlink("app/alias.txt", types.NewSourceFile("app", "real.txt"))
+	rb.AddRootSymlink("top.txt", types.NewSourceFile("app", "top.txt"))
+	rf, err := rb.Build()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return rf
+}
+
+func TestRunfilesTreePaths(t *testing.T) {
+	tree := NewRunfilesTree(testRunfiles(t), RunfilesTreeOptions{
+		WorkspaceName: "my_ws",
+		ExecRoot:      "/exec",
+		EmptyFiles:    PythonEmptyFiles,
+	})
+
+	expected := []string{
+		"my_ws/app/__init__.py",
+		"my_ws/app/alias.txt",
+		"my_ws/app/gen.txt",
+		"my_ws/app/lib/__init__.py",
+		"my_ws/app/lib/util.py",
+		"rules_foo~/foo/data.txt",
+		"top.txt",
+	}
+	if got := tree.Paths(); !slices.Equal(got, expected) {
+		t.Errorf("expected paths %v, got %v", expected, got)
+	}
+
+	if f, ok := tree.Entry("my_ws/app/__init__.py"); !ok || f != nil {
+		t.Errorf("expected empty __init__.py entry, got %v, %v", f, ok)
+	}
+
+	manifest := string(tree.Manifest(""))
+	if want := "my_ws/app/gen.txt /exec/bazel-out/bin/app/gen.txt\n"; !containsLine(manifest, want) {
+		t.Errorf("expected manifest line %q in:\n%s", want, manifest)
+	}
+	if want := "my_ws/app/__init__.py \n"; !containsLine(manifest, want) {
+		t.Errorf("expected empty file line %q in:\n%s", want, manifest)
+	}
+}
+
+func TestRunfilesTreeRepoMapping(t *testing.T) {
+	tree := NewRunfilesTree(testRunfiles(t), RunfilesTreeOptions{
+		RepoMapping: map[string]map[string]string{
+			"":           {"my_ws": "_main", "foo": "rules_foo~"},
+			"rules_foo~": {"foo": "rules_foo~"},
+			"rules_bar~": {"bar": "rules_bar~"},
+		},
+	})
+
+	if tree.WorkspaceName() != DefaultWorkspaceName {
+		t.Errorf("expected workspace name %s, got %s", DefaultWorkspaceName, tree.WorkspaceName())
+	}
+
+	expected := ",foo,rules_foo~\n,my_ws,_main\nrules_foo~,foo,rules_foo~\n"
+	if got := string(tree.RepoMappingManifest()); got != expected {
+		t.Errorf("expected repo mapping %q, got %q", expected, got)
+	}
+}
+
+func TestRunfilesTreeMaterialize(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bin.runfiles")
+	tree := NewRunfilesTree(testRunfiles(t), RunfilesTreeOptions{
+		ExecRoot:    "/exec",
+		RepoMapping: map[string]map[string]string{"": {"foo": "rules_foo~"}},
+		EmptyFiles:  PythonEmptyFiles,
+	})
+	if err := tree.Materialize(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	target, err := os.Readlink(filepath.Join(dir, "_main", "app", "lib", "util.py"))
+	if err != nil {
+		t.Fatalf("expected symlink: %v", err)
+	}
+	if target != "/exec/app/lib/util.py" {
+		t.Errorf("expected symlink to /exec/app/lib/util.py, got %s", target)
+	}
+
+	info, err := os.Lstat(filepath.Join(dir, "_main", "app", "__init__.py"))
+	if err != nil || !info.Mode().IsRegular() || info.Size() != 0 {
+		t.Errorf("expected empty regular __init__.py, got %v, %v", info, err)
+	}
+
+	for _, name := range []string{"MANIFEST", RepoMappingFilename} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+}
+
+func containsLine(s, line string) bool {
+	return strings.Contains("\n"+s, "\n"+line)
+}
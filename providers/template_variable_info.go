@@ -0,0 +1,138 @@
+// Package providers implements Bazel's built-in providers.
+//
+// TemplateVariableInfo implementation based on:
+// - bazel/src/main/java/com/google/devtools/build/lib/analysis/platform/TemplateVariableInfo.java
+// - bazel/src/main/java/com/google/devtools/build/lib/starlarkbuildapi/platform/TemplateVariableInfoApi.java
+package providers
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+	"go.starlark.net/starlark"
+)
+
+// TemplateVariableInfoProvider is the singleton provider type for TemplateVariableInfo.
+// Reference: TemplateVariableInfo.java: PROVIDER field
+var TemplateVariableInfoProvider = types.NewProvider("TemplateVariableInfo", []string{
+	"variables",
+}, "Encapsulates template variables, that is, variables that can be referenced by strings like $(VARIABLE) in BUILD files and expanded by ctx.expand_make_variables.", nil)
+
+// TemplateVariableInfo carries Make variables contributed by a target listed
+// in a rule's toolchains attribute.
+//
+// Reference: TemplateVariableInfo.java
+type TemplateVariableInfo struct {
+	// variables maps variable names to their values.
+	// From TemplateVariableInfo.java: variables field
+	variables map[string]string
+
+	frozen bool
+}
+
+var (
+	_ starlark.Value    = (*TemplateVariableInfo)(nil)
+	_ starlark.HasAttrs = (*TemplateVariableInfo)(nil)
+)
+
+// NewTemplateVariableInfo creates a TemplateVariableInfo for the given variables.
+func NewTemplateVariableInfo(variables map[string]string) *TemplateVariableInfo {
+	vars := make(map[string]string, len(variables))
+	for k, v := range variables {
+		vars[k] = v
+	}
+	return &TemplateVariableInfo{variables: vars}
+}
+
+// String returns the Starlark representation.
+func (t *TemplateVariableInfo) String() string {
+	return fmt.Sprintf("TemplateVariableInfo(variables = %s)", t.variablesDict().String())
+}
+
+// Type returns "TemplateVariableInfo".
+func (t *TemplateVariableInfo) Type() string { return "TemplateVariableInfo" }
+
+// Freeze marks the info as frozen.
+func (t *TemplateVariableInfo) Freeze() { t.frozen = true }
+
+// Truth returns true.
+func (t *TemplateVariableInfo) Truth() starlark.Bool { return true }
+
+// Hash returns an error.
+func (t *TemplateVariableInfo) Hash() (uint32, error) {
+	return 0, fmt.Errorf("unhashable type: TemplateVariableInfo")
+}
+
+// Attr returns an attribute of the provider.
+// Reference: TemplateVariableInfoApi.java interface methods
+func (t *TemplateVariableInfo) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "variables":
+		// From TemplateVariableInfoApi.java: getVariables()
+		return t.variablesDict(), nil
+	default:
+		return nil, starlark.NoSuchAttrError(fmt.Sprintf("TemplateVariableInfo has no attribute %q", name))
+	}
+}
+
+// AttrNames returns the list of attribute names.
+func (t *TemplateVariableInfo) AttrNames() []string {
+	return []string{"variables"}
+}
+
+// Variables returns the variables map.
+func (t *TemplateVariableInfo) Variables() map[string]string {
+	return t.variables
+}
+
+// Provider returns the TemplateVariableInfo provider.
+func (t *TemplateVariableInfo) Provider() *types.Provider {
+	return TemplateVariableInfoProvider
+}
+
+// variablesDict returns the variables as a Starlark dict with sorted keys.
+func (t *TemplateVariableInfo) variablesDict() *starlark.Dict {
+	keys := make([]string, 0, len(t.variables))
+	for k := range t.variables {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	d := starlark.NewDict(len(keys))
+	for _, k := range keys {
+		_ = d.SetKey(starlark.String(k), starlark.String(t.variables[k]))
+	}
+	if t.frozen {
+		d.Freeze()
+	}
+	return d
+}
+
+// TemplateVariableInfoBuiltin is the Starlark constructor for TemplateVariableInfo.
+// Reference: TemplateVariableInfo.java PROVIDER constructor (platform_common.TemplateVariableInfo)
+//
+// Example:
+//
+//	platform_common.TemplateVariableInfo({"CC": "/usr/bin/gcc"})
+func TemplateVariableInfoBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var vars *starlark.Dict
+	if err := starlark.UnpackArgs("TemplateVariableInfo", args, kwargs, "vars", &vars); err != nil {
+		return nil, err
+	}
+
+	variables := make(map[string]string, vars.Len())
+	for _, item := range vars.Items() {
+		k, ok := item[0].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("TemplateVariableInfo: got %s for 'vars key', want string", item[0].Type())
+		}
+		v, ok := item[1].(starlark.String)
+		if !ok {
+			return nil, fmt.Errorf("TemplateVariableInfo: got %s for 'vars value', want string", item[1].Type())
+		}
+		variables[string(k)] = string(v)
+	}
+
+	return NewTemplateVariableInfo(variables), nil
+}
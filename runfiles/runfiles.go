@@ -0,0 +1,407 @@
+// Package runfiles locates data files of Bazel executables at run time.
+//
+// This package implements the runfiles lookup protocol shared by Bazel's
+// language-specific runfiles libraries:
+// - RUNFILES_MANIFEST_FILE: a MANIFEST mapping runfiles paths to real paths
+// - RUNFILES_DIR: a directory containing the runfiles symlink tree
+// - _repo_mapping: translation of apparent repository names to canonical ones
+//
+// It reads the files produced by providers.RunfilesTree, so tools launched
+// from a materialized runfiles tree can find their data.
+//
+// Reference: bazel/tools/runfiles (Java, C++, Python runfiles libraries)
+// Reference: https://github.com/bazelbuild/rules_go/tree/master/go/runfiles
+package runfiles
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Environment variables of the runfiles protocol.
+const (
+	ManifestFileVar = "RUNFILES_MANIFEST_FILE"
+	DirectoryVar    = "RUNFILES_DIR"
+
+	// javaRunfilesVar is exported alongside RUNFILES_DIR for Java binaries.
+	javaRunfilesVar = "JAVA_RUNFILES"
+)
+
+// repoMappingRlocation is the runfiles path of the repo mapping manifest.
+// Reference: RepoMappingManifestAction.java
+const repoMappingRlocation = "_repo_mapping"
+
+// ErrEmpty is returned by Rlocation for an empty path.
+var ErrEmpty = errors.New("runfiles: empty path")
+
+// Error is returned when a path cannot be resolved.
+type Error struct {
+	// Name is the runfiles path that failed to resolve.
+	Name string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e Error) Error() string { return fmt.Sprintf("runfiles: %s: %v", e.Name, e.Err) }
+
+func (e Error) Unwrap() error { return e.Err }
+
+// Runfiles resolves runfiles paths. Create one with New.
+type Runfiles struct {
+	// manifest maps runfiles paths to real paths; nil in directory mode.
+	manifest map[string]string
+	// manifestFile is the path of the MANIFEST in manifest mode.
+	manifestFile string
+	// dir is the runfiles directory in directory mode, or the directory
+	// next to the manifest when it exists.
+	dir string
+
+	// repoMapping maps (source canonical repo, apparent name) to canonical names.
+	repoMapping map[repoMappingKey]string
+	// sourceRepo is the canonical name of the repository Rlocation resolves
+	// apparent repository names from.
+	sourceRepo string
+}
+
+type repoMappingKey struct {
+	sourceRepo   string
+	apparentName string
+}
+
+// Option configures New.
+type Option interface {
+	apply(*options)
+}
+
+type options struct {
+	programName  string
+	manifestFile string
+	directory    string
+	sourceRepo   string
+}
+
+type optionFunc func(*options)
+
+func (f optionFunc) apply(o *options) { f(o) }
+
+// ProgramName sets the path of the running program, used to find
+// <program>.runfiles_manifest or <program>.runfiles when the environment
+// does not point at the runfiles.
+func ProgramName(name string) Option {
+	return optionFunc(func(o *options) { o.programName = name })
+}
+
+// ManifestFile uses the given MANIFEST instead of RUNFILES_MANIFEST_FILE.
+func ManifestFile(p string) Option {
+	return optionFunc(func(o *options) { o.manifestFile = p })
+}
+
+// Directory uses the given runfiles directory instead of RUNFILES_DIR.
+func Directory(p string) Option {
+	return optionFunc(func(o *options) { o.directory = p })
+}
+
+// SourceRepo sets the canonical name of the repository whose apparent
+// repository names Rlocation resolves. Defaults to the main repository ("").
+func SourceRepo(name string) Option {
+	return optionFunc(func(o *options) { o.sourceRepo = name })
+}
+
+// New locates the runfiles of the running program. Explicit options take
+// precedence over RUNFILES_MANIFEST_FILE and RUNFILES_DIR, which take
+// precedence over <program>.runfiles_manifest, <program>.runfiles/MANIFEST
+// and <program>.runfiles next to the program.
+func New(opts ...Option) (*Runfiles, error) {
+	o := options{programName: os.Args[0]}
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+
+	r := &Runfiles{sourceRepo: o.sourceRepo}
+	if err := r.locate(o); err != nil {
+		return nil, err
+	}
+	if err := r.loadRepoMapping(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// locate finds the manifest or runfiles directory.
+func (r *Runfiles) locate(o options) error {
+	manifestFile, dir := o.manifestFile, o.directory
+	if manifestFile == "" && dir == "" {
+		manifestFile, dir = os.Getenv(ManifestFileVar), os.Getenv(DirectoryVar)
+	}
+	if manifestFile == "" && dir == "" && o.programName != "" {
+		for _, candidate := range []string{o.programName + ".runfiles_manifest", filepath.Join(o.programName+".runfiles", "MANIFEST")} {
+			if isFile(candidate) {
+				manifestFile = candidate
+				break
+			}
+		}
+		if candidate := o.programName + ".runfiles"; isDir(candidate) {
+			dir = candidate
+		}
+	}
+
+	switch {
+	case manifestFile != "":
+		m, err := parseManifest(manifestFile)
+		if err != nil {
+			return err
+		}
+		r.manifest = m
+		r.manifestFile = manifestFile
+		if dir == "" {
+			// <program>.runfiles_manifest sits next to <program>.runfiles,
+			// while <program>.runfiles/MANIFEST sits inside it.
+			if d, ok := strings.CutSuffix(manifestFile, "/MANIFEST"); ok && isDir(d) {
+				dir = d
+			} else if d, ok := strings.CutSuffix(manifestFile, ".runfiles_manifest"); ok && isDir(d+".runfiles") {
+				dir = d + ".runfiles"
+			}
+		}
+		r.dir = dir
+	case dir != "":
+		r.dir = dir
+	default:
+		return errors.New("runfiles: cannot find runfiles (set RUNFILES_MANIFEST_FILE or RUNFILES_DIR)")
+	}
+	return nil
+}
+
+// Rlocation returns the absolute path of the runfile at the given runfiles
+// path, e.g. "my_workspace/pkg/data.txt". The first path segment is an
+// apparent repository name, translated through the repo mapping of the
+// source repository. Absolute paths are returned unchanged.
+//
+// In manifest mode, a path below a directory entry resolves relative to it.
+// In directory mode, the path is not checked for existence.
+func (r *Runfiles) Rlocation(p string) (string, error) {
+	if p == "" {
+		return "", ErrEmpty
+	}
+	if filepath.IsAbs(p) || strings.HasPrefix(p, `\`) {
+		return p, nil
+	}
+	if err := validatePath(p); err != nil {
+		return "", Error{p, err}
+	}
+
+	target := r.mapRepo(p)
+	if r.manifest == nil {
+		return filepath.Join(r.dir, filepath.FromSlash(target)), nil
+	}
+
+	if v, ok := r.manifest[target]; ok {
+		if v == "" {
+			// Empty files have no target in the manifest.
+			return "", Error{p, os.ErrNotExist}
+		}
+		return v, nil
+	}
+	// Resolve paths below a directory entry by their longest listed prefix.
+	for prefix := path.Dir(target); prefix != "." && prefix != "/"; prefix = path.Dir(prefix) {
+		if v, ok := r.manifest[prefix]; ok && v != "" {
+			return filepath.Join(v, filepath.FromSlash(strings.TrimPrefix(target, prefix+"/"))), nil
+		}
+	}
+	return "", Error{p, os.ErrNotExist}
+}
+
+// WithSourceRepo returns a Runfiles sharing r's manifest but resolving
+// apparent repository names from the given canonical repository.
+func (r *Runfiles) WithSourceRepo(name string) *Runfiles {
+	clone := *r
+	clone.sourceRepo = name
+	return &clone
+}
+
+// Env returns the environment variables a child process needs to find the
+// same runfiles, in "KEY=value" form.
+func (r *Runfiles) Env() []string {
+	var env []string
+	if r.manifestFile != "" {
+		env = append(env, ManifestFileVar+"="+r.manifestFile)
+	}
+	if r.dir != "" {
+		env = append(env, DirectoryVar+"="+r.dir, javaRunfilesVar+"="+r.dir)
+	}
+	return env
+}
+
+// mapRepo translates the apparent repository name in the first segment of p.
+func (r *Runfiles) mapRepo(p string) string {
+	repo, rest, ok := strings.Cut(p, "/")
+	if !ok || r.repoMapping == nil {
+		return p
+	}
+	if canonical, ok := r.repoMapping[repoMappingKey{r.sourceRepo, repo}]; ok {
+		return canonical + "/" + rest
+	}
+	return p
+}
+
+// loadRepoMapping reads the _repo_mapping file if the runfiles have one.
+// Each line is "source canonical,apparent name,target canonical".
+// Reference: RepoMappingManifestAction.java
+func (r *Runfiles) loadRepoMapping() error {
+	var mappingFile string
+	if r.manifest != nil {
+		mappingFile = r.manifest[repoMappingRlocation]
+	} else {
+		mappingFile = filepath.Join(r.dir, repoMappingRlocation)
+	}
+	if mappingFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(mappingFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("runfiles: reading repo mapping: %w", err)
+	}
+
+	r.repoMapping = make(map[repoMappingKey]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return fmt.Errorf("runfiles: %s:%d: invalid repo mapping line %q", mappingFile, i+1, line)
+		}
+		r.repoMapping[repoMappingKey{fields[0], fields[1]}] = fields[2]
+	}
+	return nil
+}
+
+// parseManifest reads a runfiles MANIFEST. Lines starting with a space use
+// escapes: \s for space, \n for newline and \b for backslash.
+// Reference: SourceManifestAction.java writeEntry()
+func parseManifest(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("runfiles: %w", err)
+	}
+	defer f.Close()
+
+	m := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		escaped := strings.HasPrefix(line, " ")
+		if escaped {
+			line = line[1:]
+		}
+		link, target, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("runfiles: %s:%d: invalid manifest line %q", file, lineNo, line)
+		}
+		if escaped {
+			link = unescape(link, true)
+			target = unescape(target, false)
+		}
+		m[link] = target
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("runfiles: %s: %w", file, err)
+	}
+	return m, nil
+}
+
+// unescape reverses the MANIFEST escaping.
+func unescape(s string, spaces bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'b':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 's':
+			if spaces {
+				b.WriteByte(' ')
+			} else {
+				b.WriteString(`\s`)
+			}
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// validatePath rejects non-normalized runfiles paths.
+func validatePath(p string) error {
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return errors.New("path is not normalized")
+		}
+	}
+	return nil
+}
+
+// Paths returns the runfiles paths listed in the manifest, sorted. It
+// returns nil in directory mode.
+func (r *Runfiles) Paths() []string {
+	if r.manifest == nil {
+		return nil
+	}
+	paths := make([]string, 0, len(r.manifest))
+	for p := range r.manifest {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func isFile(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().IsRegular()
+}
+
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultRunfiles *Runfiles
+	defaultErr      error
+)
+
+// Rlocation resolves a runfiles path using the runfiles of the running
+// program, located once with New().
+func Rlocation(p string) (string, error) {
+	defaultOnce.Do(func() {
+		defaultRunfiles, defaultErr = New()
+	})
+	if defaultErr != nil {
+		return "", defaultErr
+	}
+	return defaultRunfiles.Rlocation(p)
+}
@@ -0,0 +1,132 @@
+package runfiles
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/albertocavalcante/starlark-go-bazel/providers"
+	"github.com/albertocavalcante/starlark-go-bazel/types"
+)
+
+// materialize lays out a runfiles tree for an executable under dir/bin and
+// returns the runfiles directory and the exec root holding the real files.
+func materialize(t *testing.T) (string, string) {
+	t.Helper()
+	execRoot := t.TempDir()
+	for _, p := range []string{"pkg/data.txt", "external/rules_foo~/foo/tool.txt"} {
+		full := filepath.Join(execRoot, p)
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(p), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rb := providers.NewRunfilesBuilder("")
+	rb.AddFile(types.NewSourceFile("pkg", "data.txt"))
+	rb.AddFile(types.NewFile("external/rules_foo~/foo/tool.txt", "../rules_foo~/foo/tool.txt", types.NewFileRoot(""), nil, true))
+	rf, err := rb.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := providers.NewRunfilesTree(rf, providers.RunfilesTreeOptions{
+		ExecRoot: execRoot,
+		RepoMapping: map[string]map[string]string{
+			"":           {"my_ws": "_main", "foo": "rules_foo~"},
+			"rules_foo~": {"foo": "rules_foo~", "main": "_main"},
+		},
+	})
+	dir := filepath.Join(t.TempDir(), "bin.runfiles")
+	if err := tree.Materialize(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir, execRoot
+}
+
+func TestRlocationManifest(t *testing.T) {
+	dir, execRoot := materialize(t)
+
+	r, err := New(ManifestFile(filepath.Join(dir, "MANIFEST")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"_main/pkg/data.txt", filepath.Join(execRoot, "pkg/data.txt")},
+		{"my_ws/pkg/data.txt", filepath.Join(execRoot, "pkg/data.txt")},
+		{"foo/foo/tool.txt", filepath.Join(execRoot, "external/rules_foo~/foo/tool.txt")},
+	}
+	for _, tt := range tests {
+		got, err := r.Rlocation(tt.path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.path, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.path, tt.expected, got)
+		}
+	}
+
+	if _, err := r.Rlocation("_main/missing.txt"); err == nil {
+		t.Error("expected error for missing runfile")
+	}
+
+	// Apparent names resolve relative to the source repository.
+	got, err := r.WithSourceRepo("rules_foo~").Rlocation("main/pkg/data.txt")
+	if err != nil || got != filepath.Join(execRoot, "pkg/data.txt") {
+		t.Errorf("expected data.txt from rules_foo~, got %s, %v", got, err)
+	}
+
+	if !slices.Contains(r.Env(), ManifestFileVar+"="+filepath.Join(dir, "MANIFEST")) {
+		t.Errorf("expected %s in env, got %v", ManifestFileVar, r.Env())
+	}
+}
+
+func TestRlocationDirectory(t *testing.T) {
+	dir, _ := materialize(t)
+	t.Setenv(ManifestFileVar, "")
+	t.Setenv(DirectoryVar, dir)
+
+	r, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := r.Rlocation("foo/foo/tool.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(got)
+	if err != nil {
+		t.Fatalf("expected readable runfile: %v", err)
+	}
+	if string(data) != "external/rules_foo~/foo/tool.txt" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestRlocationInvalidPaths(t *testing.T) {
+	r, err := New(Directory(t.TempDir()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := r.Rlocation(""); err != ErrEmpty {
+		t.Errorf("expected ErrEmpty, got %v", err)
+	}
+	for _, p := range []string{"../x", "a/./b", "a//b", "a/.."} {
+		if _, err := r.Rlocation(p); err == nil {
+			t.Errorf("%s: expected error for non-normalized path", p)
+		}
+	}
+	if got, _ := r.Rlocation("/abs/path"); got != "/abs/path" {
+		t.Errorf("expected absolute path unchanged, got %s", got)
+	}
+}
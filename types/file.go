@@ -69,6 +69,9 @@ func (r *FileRoot) AttrNames() []string {
 // ExecPathString returns the execution path string.
 func (r *FileRoot) ExecPathString() string { return r.path }
 
+// Path returns the root path.
+func (r *FileRoot) Path() string { return r.path }
+
 // File represents a file in the Bazel build system.
 // This can be either a source file or a generated (derived) artifact.
 //
@@ -296,6 +299,9 @@ func (f *File) Path() string { return f.path }
 // ShortPath returns the root-relative path.
 func (f *File) ShortPath() string { return f.shortPath }
 
+// Basename returns the file name.
+func (f *File) Basename() string { return path.Base(f.path) }
+
 // Root returns the file root.
 func (f *File) Root() *FileRoot { return f.root }
 
@@ -319,6 +325,14 @@ func (f *File) SetDirectory(isDir bool) {
 	f.isDir = isDir
 }
 
+// SetOwner sets the label of the target that produces this file.
+func (f *File) SetOwner(owner *Label) {
+	if f.frozen {
+		return
+	}
+	f.owner = owner
+}
+
 // SetSymlink marks this file as a symlink.
 func (f *File) SetSymlink(isSymlink bool) {
 	if f.frozen {
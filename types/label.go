@@ -3,6 +3,7 @@ package types
 
 import (
 	"fmt"
+	"path"
 	"strings"
 
 	"go.starlark.net/starlark"
@@ -28,48 +29,106 @@ func NewLabel(repo, pkg, name string) *Label {
 	return &Label{repo: repo, pkg: pkg, name: name}
 }
 
-// ParseLabel parses a label string like "//pkg:target" or "@repo//pkg:target".
+// ParseLabel parses an absolute label string like "//pkg:target",
+// "@repo//pkg:target", "@@canonical//pkg:target" or the "@repo" shorthand for
+// "@repo//:repo". Apparent repository names are used as canonical names; use
+// ParseLabelInContext to apply a repo mapping.
 func ParseLabel(s string) (*Label, error) {
-	l := &Label{}
+	if !strings.HasPrefix(s, "@") && !strings.HasPrefix(s, "//") {
+		return nil, fmt.Errorf("invalid label %q: must start with // or @", s)
+	}
+	return ParseLabelInContext(s, LabelContext{})
+}
+
+// ParseLabelInContext parses a label string written in the given repository
+// and package. Apparent repository names are resolved through the context's
+// repo mapping; "@@name" refers to a canonical repository and "@//" to the
+// main repository.
+//
+// Reference: Label.java parseWithPackageContext()
+func ParseLabelInContext(s string, c LabelContext) (*Label, error) {
+	orig := s
+	l := &Label{repo: c.Repo, pkg: c.Pkg}
 
-	// Handle repository prefix
 	if strings.HasPrefix(s, "@") {
-		idx := strings.Index(s, "//")
-		if idx == -1 {
-			return nil, fmt.Errorf("invalid label %q: missing //", s)
+		canonical := strings.HasPrefix(s, "@@")
+		name, rest, hasPath := strings.Cut(strings.TrimLeft(s, "@"), "//")
+		if strings.ContainsAny(name, ":/") {
+			return nil, fmt.Errorf("invalid label %q: missing //", orig)
 		}
-		l.repo = s[1:idx]
-		s = s[idx:]
-	}
-
-	// Must start with //
-	if !strings.HasPrefix(s, "//") {
-		return nil, fmt.Errorf("invalid label %q: must start with // or @", s)
+		switch {
+		case canonical || name == "":
+			l.repo = name
+		default:
+			repo, ok := c.RepoMapping.Get(name)
+			if !ok {
+				return nil, c.RepoMapping.notVisibleError(name)
+			}
+			l.repo = repo
+		}
+		if !hasPath {
+			// "@repo" is shorthand for "@repo//:repo".
+			if name == "" {
+				return nil, fmt.Errorf("invalid label %q: empty repository name", orig)
+			}
+			l.pkg, l.name = "", name
+			return l, nil
+		}
+		s = "//" + rest
 	}
-	s = s[2:]
 
-	// Split package and target
-	if idx := strings.LastIndex(s, ":"); idx != -1 {
-		l.pkg = s[:idx]
-		l.name = s[idx+1:]
-	} else {
-		// No colon means target name equals last component of package
-		l.pkg = s
-		if idx := strings.LastIndex(s, "/"); idx != -1 {
+	switch {
+	case strings.HasPrefix(s, "//"):
+		s = s[2:]
+		if idx := strings.LastIndex(s, ":"); idx != -1 {
+			l.pkg = s[:idx]
 			l.name = s[idx+1:]
 		} else {
-			l.name = s
+			// No colon means target name equals last component of package
+			l.pkg = s
+			if idx := strings.LastIndex(s, "/"); idx != -1 {
+				l.name = s[idx+1:]
+			} else {
+				l.name = s
+			}
 		}
+	case strings.HasPrefix(s, ":"):
+		// Package-relative label
+		l.name = s[1:]
+	default:
+		// Bare target name, relative to the current package
+		l.name = s
 	}
-
 	return l, nil
 }
 
-// String returns the Starlark representation.
+// isApparentRepoName reports whether a canonical repository name can also
+// be written as an apparent name, i.e. with a single "@".
+// Reference: RepositoryName.java VALID_USER_PROVIDED_NAME
+func isApparentRepoName(name string) bool {
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the canonical form of the label: "//pkg:name" in the main
+// repository and "@repo//pkg:name" elsewhere. Canonical repository names that
+// are not valid apparent names (e.g. "rules_foo+") are written with "@@" so
+// the result parses back to the same label.
+// Reference: Label.java getCanonicalForm()
 func (l *Label) String() string {
 	var sb strings.Builder
 	if l.repo != "" {
 		sb.WriteString("@")
+		if !isApparentRepoName(l.repo) {
+			sb.WriteString("@")
+		}
 		sb.WriteString(l.repo)
 	}
 	sb.WriteString("//")
@@ -79,6 +138,46 @@ func (l *Label) String() string {
 	return sb.String()
 }
 
+// UnambiguousCanonicalForm returns "@@repo//pkg:name", which denotes the same
+// label from every repository. The main repository is written as "@@".
+// Reference: Label.java getUnambiguousCanonicalForm()
+func (l *Label) UnambiguousCanonicalForm() string {
+	return "@@" + l.repo + "//" + l.pkg + ":" + l.name
+}
+
+// DisplayForm returns the label as seen from the repository owning mapping:
+// "//pkg:name" in the main repository, "@apparent//pkg:name" when the
+// repository is visible under an apparent name and "@@canonical//pkg:name"
+// otherwise. A nil mapping yields String().
+// Reference: Label.java getDisplayForm()
+func (l *Label) DisplayForm(mapping *RepoMapping) string {
+	if l.repo == "" || mapping == nil {
+		return l.String()
+	}
+	if apparent, ok := mapping.InverseLookup(l.repo); ok {
+		return "@" + apparent + "//" + l.pkg + ":" + l.name
+	}
+	return l.UnambiguousCanonicalForm()
+}
+
+// ShorthandDisplayForm is DisplayForm with the target name omitted when it
+// matches the last package component ("//pkg" for "//pkg:pkg") or, at the
+// repository root, the repository name ("@repo" for "@repo//:repo").
+// Reference: Label.java getShorthandDisplayForm()
+func (l *Label) ShorthandDisplayForm(mapping *RepoMapping) string {
+	display := l.DisplayForm(mapping)
+	if l.pkg == "" {
+		if repo, _, ok := strings.Cut(display, "//"); ok && repo != "" && strings.TrimLeft(repo, "@") == l.name {
+			return repo
+		}
+		return display
+	}
+	if l.name == path.Base(l.pkg) {
+		return strings.TrimSuffix(display, ":"+l.name)
+	}
+	return display
+}
+
 // Type returns "Label".
 func (l *Label) Type() string { return "Label" }
 
@@ -122,13 +221,17 @@ func (l *Label) Attr(name string) (starlark.Value, error) {
 		return starlark.String(l.name), nil
 	case "package":
 		return starlark.String(l.pkg), nil
-	case "workspace_name":
+	case "repo_name", "workspace_name":
 		return starlark.String(l.repo), nil
 	case "workspace_root":
 		if l.repo == "" {
 			return starlark.String(""), nil
 		}
 		return starlark.String("external/" + l.repo), nil
+	case "same_package_label":
+		return starlark.NewBuiltin("same_package_label", l.samePackageLabel), nil
+	case "relative":
+		return starlark.NewBuiltin("relative", l.relative), nil
 	default:
 		return nil, starlark.NoSuchAttrError(fmt.Sprintf("Label has no attribute %q", name))
 	}
@@ -136,7 +239,33 @@ func (l *Label) Attr(name string) (starlark.Value, error) {
 
 // AttrNames returns the list of attribute names.
 func (l *Label) AttrNames() []string {
-	return []string{"name", "package", "workspace_name", "workspace_root"}
+	return []string{"name", "package", "relative", "repo_name", "same_package_label", "workspace_name", "workspace_root"}
+}
+
+// samePackageLabel implements Label.same_package_label(target_name).
+// Reference: Label.java getSamePackageLabel()
+func (l *Label) samePackageLabel(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var targetName string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "target_name", &targetName); err != nil {
+		return nil, err
+	}
+	return &Label{repo: l.repo, pkg: l.pkg, name: targetName}, nil
+}
+
+// relative implements the deprecated Label.relative(relName): relName is
+// parsed in this label's package, with apparent repository names resolved
+// through the repo mapping of the calling file.
+// Reference: Label.java getRelativeWithRemapping()
+func (l *Label) relative(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var relName string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "relName", &relName); err != nil {
+		return nil, err
+	}
+	c := LabelContext{Repo: l.repo, Pkg: l.pkg}
+	if tc := GetLabelContext(thread); tc != nil {
+		c.RepoMapping = tc.RepoMapping
+	}
+	return ParseLabelInContext(relName, c)
 }
 
 // Repo returns the repository name.
@@ -148,13 +277,25 @@ func (l *Label) Pkg() string { return l.pkg }
 // Name returns the target name.
 func (l *Label) Name() string { return l.name }
 
-// LabelBuiltin is the Label() constructor for Starlark.
-func LabelBuiltin(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	var s string
-	if err := starlark.UnpackArgs("Label", args, kwargs, "label", &s); err != nil {
+// LabelBuiltin is the Label() constructor for Starlark. Label strings are
+// resolved relative to the file calling Label(), using the thread's
+// LabelContext when one is set.
+// Reference: StarlarkBuiltinsFunction / LabelConverter in Label.java
+func LabelBuiltin(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackArgs("Label", args, kwargs, "input", &v); err != nil {
 		return nil, err
 	}
-	return ParseLabel(s)
+	switch v := v.(type) {
+	case *Label:
+		return v, nil
+	case starlark.String:
+		if c := GetLabelContext(thread); c != nil {
+			return ParseLabelInContext(string(v), *c)
+		}
+		return ParseLabel(string(v))
+	}
+	return nil, fmt.Errorf("Label: got %s for 'input', want string or Label", v.Type())
 }
 
 // ParseLabelRelative parses a label string relative to a given package context.
@@ -165,38 +306,5 @@ func LabelBuiltin(_ *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple,
 //
 // Reference: bazel/src/main/java/com/google/devtools/build/lib/cmdline/Label.java
 func ParseLabelRelative(s string, currentRepo string, currentPkg string) (*Label, error) {
-	// Handle absolute labels with repo
-	if strings.HasPrefix(s, "@") {
-		return ParseLabel(s)
-	}
-
-	// Handle absolute labels without repo
-	if strings.HasPrefix(s, "//") {
-		l, err := ParseLabel(s)
-		if err != nil {
-			return nil, err
-		}
-		// Use current repo if not specified
-		if l.repo == "" {
-			l.repo = currentRepo
-		}
-		return l, nil
-	}
-
-	// Handle package-relative labels (":target")
-	if strings.HasPrefix(s, ":") {
-		return &Label{
-			repo: currentRepo,
-			pkg:  currentPkg,
-			name: s[1:],
-		}, nil
-	}
-
-	// Handle bare target names ("target")
-	// These are relative to the current package
-	return &Label{
-		repo: currentRepo,
-		pkg:  currentPkg,
-		name: s,
-	}, nil
+	return ParseLabelInContext(s, LabelContext{Repo: currentRepo, Pkg: currentPkg})
 }
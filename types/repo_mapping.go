@@ -0,0 +1,120 @@
+// Package types provides core Starlark types for Bazel's dialect.
+package types
+
+import (
+	"fmt"
+	"sort"
+
+	"go.starlark.net/starlark"
+)
+
+// RepoMapping maps the apparent repository names visible from one repository
+// to canonical repository names. With Bzlmod every repository has its own
+// mapping, so "@foo" may mean different repositories in different places.
+//
+// A nil *RepoMapping is the identity mapping: every apparent name refers to
+// the canonical repository of the same name, as in WORKSPACE mode.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/cmdline/RepositoryMapping.java
+type RepoMapping struct {
+	owner   string
+	entries map[string]string
+}
+
+// NewRepoMapping creates the mapping of the repository with canonical name
+// owner ("" for the main repository). Names missing from entries are not
+// visible from owner.
+func NewRepoMapping(owner string, entries map[string]string) *RepoMapping {
+	m := &RepoMapping{owner: owner, entries: make(map[string]string, len(entries))}
+	for apparent, canonical := range entries {
+		m.entries[apparent] = canonical
+	}
+	return m
+}
+
+// Owner returns the canonical name of the repository the mapping belongs to.
+func (m *RepoMapping) Owner() string {
+	if m == nil {
+		return ""
+	}
+	return m.owner
+}
+
+// Get returns the canonical repository an apparent name refers to.
+func (m *RepoMapping) Get(apparent string) (string, bool) {
+	if m == nil {
+		return apparent, true
+	}
+	canonical, ok := m.entries[apparent]
+	return canonical, ok
+}
+
+// InverseLookup returns an apparent name under which the canonical repository
+// is visible. When several names map to it, the smallest is returned.
+// Reference: RepositoryMapping.java getInverse()
+func (m *RepoMapping) InverseLookup(canonical string) (string, bool) {
+	if m == nil {
+		return canonical, true
+	}
+	var names []string
+	for apparent, c := range m.entries {
+		if c == canonical {
+			names = append(names, apparent)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	sort.Strings(names)
+	return names[0], true
+}
+
+// Entries returns a copy of the mapping's entries.
+func (m *RepoMapping) Entries() map[string]string {
+	if m == nil {
+		return nil
+	}
+	entries := make(map[string]string, len(m.entries))
+	for apparent, canonical := range m.entries {
+		entries[apparent] = canonical
+	}
+	return entries
+}
+
+// notVisibleError reports an apparent repository name missing from a mapping.
+// Reference: RepositoryName.java getNameWithAt() for invalid repository names
+func (m *RepoMapping) notVisibleError(apparent string) error {
+	from := "main repository"
+	if m.Owner() != "" {
+		from = fmt.Sprintf("repository '@@%s'", m.Owner())
+	}
+	return fmt.Errorf("No repository visible as '@%s' from %s", apparent, from)
+}
+
+// LabelContext is the location label strings are parsed in: the canonical
+// repository and package of the file containing them and the repository's
+// repo mapping.
+//
+// Reference: bazel/src/main/java/com/google/devtools/build/lib/cmdline/Label.java PackageContext
+type LabelContext struct {
+	Repo        string
+	Pkg         string
+	RepoMapping *RepoMapping
+}
+
+// ThreadKeyLabelContext is the key for storing the LabelContext of the file
+// being evaluated in the thread.
+const ThreadKeyLabelContext = "starlark-go-bazel:label_context"
+
+// SetLabelContext stores the LabelContext used by Label() and Label.relative().
+func SetLabelContext(thread *starlark.Thread, c *LabelContext) {
+	thread.SetLocal(ThreadKeyLabelContext, c)
+}
+
+// GetLabelContext returns the thread's LabelContext, or nil.
+func GetLabelContext(thread *starlark.Thread) *LabelContext {
+	if c, ok := thread.Local(ThreadKeyLabelContext).(*LabelContext); ok {
+		return c
+	}
+	return nil
+}
@@ -143,6 +143,11 @@ type RuleClass struct {
 	// Reference: StarlarkRuleFunctionsApi.java - "dependency_resolution_rule" parameter
 	dependencyResolutionRule bool
 
+	// Label and transitive digest of the .bzl file that exported the rule.
+	// Reference: RuleClass.java ruleDefinitionEnvironmentLabel, ruleDefinitionEnvironmentDigest
+	definitionLabel  string
+	definitionDigest []byte
+
 	frozen bool
 }
 
@@ -492,6 +497,29 @@ func (rc *RuleClass) SetName(name string) {
 // IsExported returns whether the rule has been exported.
 func (rc *RuleClass) IsExported() bool { return rc.exported }
 
+// Export names the rule after the global it is first assigned to in a .bzl
+// file.
+// Reference: StarlarkRuleClassFunctions.StarlarkRuleFunction.export()
+func (rc *RuleClass) Export(name string) error {
+	rc.SetName(name)
+	return nil
+}
+
+// SetDefinitionEnvironment records the label and transitive digest of the
+// .bzl file that exported the rule.
+func (rc *RuleClass) SetDefinitionEnvironment(label string, digest []byte) {
+	rc.definitionLabel = label
+	rc.definitionDigest = digest
+}
+
+// DefinitionLabel returns the label of the .bzl file that exported the rule,
+// or "" if unknown.
+func (rc *RuleClass) DefinitionLabel() string { return rc.definitionLabel }
+
+// DefinitionDigest returns the transitive digest of the .bzl file that
+// exported the rule, or nil if unknown.
+func (rc *RuleClass) DefinitionDigest() []byte { return rc.definitionDigest }
+
 // Implementation returns the implementation function.
 func (rc *RuleClass) Implementation() starlark.Callable { return rc.implementation }
 
@@ -510,6 +538,10 @@ func (rc *RuleClass) Provides() []*Provider { return rc.provides }
 // Doc returns the documentation string.
 func (rc *RuleClass) Doc() string { return rc.doc }
 
+// ImplicitOutputs returns the deprecated outputs parameter of rule(): a dict
+// of output file name templates, a function, or nil.
+func (rc *RuleClass) ImplicitOutputs() starlark.Value { return rc.implicitOutputs }
+
 // CallInternal implements starlark.Callable.
 // When called in a BUILD file, it creates a RuleInstance (target).
 // Reference: StarlarkRuleClassFunctions.StarlarkRuleFunction.call (lines 1755-1807)
@@ -578,11 +610,32 @@ func (rc *RuleClass) CallInternal(thread *starlark.Thread, args starlark.Tuple,
 		attrValues: attrValues,
 	}
 
+	// Register the target in the package being evaluated.
+	// Reference: RuleFactory.createAndAddRule()
+	if register, ok := thread.Local(ThreadKeyTargetRegistrar).(func(*RuleInstance) error); ok {
+		if lc := GetLabelContext(thread); lc != nil {
+			label, err := ParseLabelInContext(":"+instance.name, *lc)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", rc.name, err)
+			}
+			instance.label = label
+		}
+		instance.location = thread.CallFrame(1).Pos.String()
+		if err := register(instance); err != nil {
+			return nil, err
+		}
+	}
+
 	return instance, nil
 }
 
 // validateAttrValue performs basic type validation for an attribute value.
 func (rc *RuleClass) validateAttrValue(attr *AttrDescriptor, value starlark.Value) error {
+	// Configurable values are checked once resolved, during analysis.
+	if value.Type() == "select" {
+		return nil
+	}
+
 	// Allow None for optional attributes
 	if value == starlark.None {
 		if attr.Mandatory {
@@ -707,11 +760,17 @@ func RuleBuiltin(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tup
 	if attrs != nil {
 		for _, item := range attrs.Items() {
 			name := string(item[0].(starlark.String))
-			// For now, we create a basic descriptor
-			// The full attr module will provide proper AttrDescriptor values
+			// Values created by an attr module carry their descriptor;
+			// others describe string attributes.
+			if d, ok := item[1].(interface{ Descriptor() *AttrDescriptor }); ok {
+				desc := *d.Descriptor()
+				desc.Name = name
+				attrMap[name] = &desc
+				continue
+			}
 			attrMap[name] = &AttrDescriptor{
 				Name: name,
-				Type: AttrTypeString, // Default, will be overridden by attr module
+				Type: AttrTypeString,
 			}
 		}
 	}
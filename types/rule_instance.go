@@ -46,6 +46,16 @@ type RuleInstance struct {
 	frozen bool
 }
 
+// ThreadKeyTargetRegistrar is the key for the function that registers the
+// targets created by rule calls during BUILD file evaluation.
+const ThreadKeyTargetRegistrar = "starlark-go-bazel:target_registrar"
+
+// SetTargetRegistrar sets the function called with every target created by
+// a rule call in the thread, after its label and location are set.
+func SetTargetRegistrar(thread *starlark.Thread, register func(*RuleInstance) error) {
+	thread.SetLocal(ThreadKeyTargetRegistrar, register)
+}
+
 var (
 	_ starlark.Value      = (*RuleInstance)(nil)
 	_ starlark.HasAttrs   = (*RuleInstance)(nil)